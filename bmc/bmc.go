@@ -1,21 +1,53 @@
 package bmc
 
 import (
+	"crypto/sha1"
+	"io"
 	"net"
 	"log"
+	"sort"
+	"sync"
+	"time"
 	"github.com/rmxymh/infra-ecosphere/vm"
 )
 
 type BMC struct {
 	Addr net.IP
 	VM vm.Instance
+	Kg []byte		// BMC key for RMCP+ two-key login, empty means one-key login
+	RMCPPlusDisabled bool
+	PerMessageAuthDisabled bool	// IPMI v1.5 packets after Activate Session may carry no authentication code
+	UserLevelAuthDisabled bool	// IPMI v1.5 packets of USER level commands may carry no authentication code
+	CipherSuites []CipherSuite	// nil means all cipher suites supported by IPMI but cipher suite 0
+	AuthTypes map[uint8][]uint8	// IPMI v1.5 authentication types enabled at each privilege level, nil means all supported types but NONE
+	SessionTimeout time.Duration	// idle timeout of sessions, 0 means the default
+	MaxSessions int			// 0 means unlimited
+	MaxUserSessions int		// activated sessions of each user, 0 means unlimited
+	Users map[string]BMCUser	// nil means the default users shared by all BMCs
+	AllowedSources []*net.IPNet	// nil means all source addresses
+	ChallengeRateLimit int		// session challenges from each source per minute, 0 means unlimited
+	BadPasswordThreshold int	// failed activations before a user is locked out, 0 means no lockout
+	AttemptCountResetInterval time.Duration	// failed activations are forgotten after it, 0 means never
+	UserLockoutInterval time.Duration	// 0 means until the password of the user is set or the user is enabled
+	SELCapacity int			// entries of the SEL, 0 means DEFAULT_SEL_CAPACITY
+	SELOverwrite bool		// overwrite the oldest SEL entries when the SEL is full
 }
 
-var BMCs map[string]BMC
+// CipherSuite is an RMCP+ cipher suite allowed by the BMC and the maximum
+// privilege level of sessions using it.
+type CipherSuite struct {
+	ID uint8
+	MaxPrivilege uint8
+}
+
+// bmcs is written by the IPMI listeners and by the goroutines saving the
+// users, so it is only accessed with bmcsLock held.
+var bmcs map[string]BMC
+var bmcsLock sync.Mutex
 
 func init() {
 	log.Println("Initialize BMC Map...")
-	BMCs = make(map[string]BMC)
+	bmcs = make(map[string]BMC)
 }
 
 func AddBMC(ip net.IP, instance vm.Instance) BMC {
@@ -24,32 +56,106 @@ func AddBMC(ip net.IP, instance vm.Instance) BMC {
 		VM: instance,
 	}
 
-	BMCs[ip.String()] = newBMC
+	bmcsLock.Lock()
+	bmcs[ip.String()] = newBMC
+	bmcsLock.Unlock()
 	log.Println("Add new BMC with IP ", ip.String())
 
 	return newBMC
 }
 
 func RemoveBMC(ip net.IP) {
-	_, ok := BMCs[ip.String()]
+	bmcsLock.Lock()
+	defer bmcsLock.Unlock()
 
-	if ok {
-		delete(BMCs, ip.String())
-	}
+	delete(bmcs, ip.String())
 }
 
 func GetBMC(ip net.IP) (BMC, bool) {
-	obj, ok := BMCs[ip.String()]
+	bmcsLock.Lock()
+	defer bmcsLock.Unlock()
+
+	obj, ok := bmcs[ip.String()]
 
 	return obj, ok
 }
 
+type bmcsByAddr []BMC
+
+func (b bmcsByAddr) Len() int { return len(b) }
+func (b bmcsByAddr) Less(i, j int) bool { return b[i].Addr.String() < b[j].Addr.String() }
+func (b bmcsByAddr) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+// GetBMCs returns a copy of all BMCs in the order of their addresses.
+func GetBMCs() []BMC {
+	bmcsLock.Lock()
+	defer bmcsLock.Unlock()
+
+	list := []BMC{}
+	for _, obj := range bmcs {
+		list = append(list, obj)
+	}
+	sort.Sort(bmcsByAddr(list))
+
+	return list
+}
+
 func (bmc *BMC)Save() {
 	if bmc != nil {
-		BMCs[bmc.Addr.String()] = *bmc
+		bmcsLock.Lock()
+		bmcs[bmc.Addr.String()] = *bmc
+		bmcsLock.Unlock()
 	}
 }
 
+func (bmc *BMC)IsRMCPPlusEnabled() bool {
+	return ! bmc.RMCPPlusDisabled
+}
+
+// IsSourceAllowed reports whether the BMC accepts packets from the address.
+func (bmc *BMC)IsSourceAllowed(ip net.IP) bool {
+	if bmc.AllowedSources == nil {
+		return true
+	}
+	for _, network := range bmc.AllowedSources {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bmc *BMC)IsPerMessageAuthEnabled() bool {
+	return ! bmc.PerMessageAuthDisabled
+}
+
+func (bmc *BMC)IsUserLevelAuthEnabled() bool {
+	return ! bmc.UserLevelAuthDisabled
+}
+
+// nameHash hashes the VM name, or the BMC address for a mock VM without a
+// name, so that the values derived from it are unique and stable across
+// restarts.
+func (bmc *BMC)nameHash(kind string) [sha1.Size]uint8 {
+	name := bmc.VM.Name
+	if len(name) == 0 {
+		name = bmc.Addr.String()
+	}
+	return sha1.Sum([]byte(kind + ":" + name))
+}
+
+// GUID is the name-based UUID of the BMC. It is also the UUID in the default
+// FRU, so the system is identified the same way by RMCP+ and by the FRU.
+func (bmc *BMC)GUID() [16]byte {
+	hash := bmc.nameHash("uuid")
+
+	var guid [16]byte
+	copy(guid[:], hash[:16])
+	guid[6] = guid[6] & 0x0F | 0x50	// version 5, name-based
+	guid[8] = guid[8] & 0x3F | 0x80	// RFC 4122 variant
+	return guid
+}
+
 func (bmc *BMC)SetBootDev(dev string) {
 	switch dev {
 	case vm.BOOT_DEVICE_PXE:
@@ -59,6 +165,7 @@ func (bmc *BMC)SetBootDev(dev string) {
 	case vm.BOOT_DEVICE_CD_DVD:
 		bmc.VM.SetBootDevice(dev)
 		bmc.Save()
+		bmc.AddSELEvent(SENSOR_TYPE_SYSTEM_EVENT, SENSOR_NUMBER_SYSTEM_EVENT, SYSTEM_EVENT_RECONFIGURED, true)
 		log.Println("BMC ", bmc.Addr.String(), " changes its boot device as ", dev)
 	case vm.BOOT_DEVICE_FLOPPY:
 		log.Println("Device Floppy is not supported.")
@@ -73,6 +180,7 @@ func (bmc *BMC)PowerOn() {
 	log.Println(bmc.VM)
 	if ! bmc.VM.IsRunning() {
 		bmc.VM.PowerOn()
+		bmc.AddSELEvent(SENSOR_TYPE_POWER_UNIT, SENSOR_NUMBER_POWER_UNIT, POWER_UNIT_POWER_OFF, false)
 	}
 }
 
@@ -80,12 +188,14 @@ func (bmc *BMC)PowerOff() {
 	log.Println(bmc.VM)
 	if bmc.VM.IsRunning() {
 		bmc.VM.PowerOff()
+		bmc.AddSELEvent(SENSOR_TYPE_POWER_UNIT, SENSOR_NUMBER_POWER_UNIT, POWER_UNIT_POWER_OFF, true)
 	}
 }
 
 func (bmc *BMC)PowerSoft() {
 	if bmc.VM.IsRunning() {
 		bmc.VM.ACPIOff()
+		bmc.AddSELEvent(SENSOR_TYPE_SYSTEM_ACPI_POWER_STATE, SENSOR_NUMBER_ACPI_POWER_STATE, ACPI_POWER_STATE_S5_SOFT_OFF, true)
 	}
 }
 
@@ -100,9 +210,14 @@ func (bmc *BMC)PowerReset() {
 	if bmc.VM.IsRunning() {
 		bmc.VM.PowerOff()
 		bmc.VM.PowerOn()
+		bmc.AddSELEvent(SENSOR_TYPE_SYSTEM_RESTART, SENSOR_NUMBER_SYSTEM_RESTART, SYSTEM_RESTART_HARD_RESET, true)
 	}
 }
 
+func (bmc *BMC)OpenConsole() (io.ReadWriteCloser, error) {
+	return bmc.VM.OpenConsole()
+}
+
 func (bmc *BMC)IsPowerOn() bool {
 	return bmc.VM.IsRunning()
 }
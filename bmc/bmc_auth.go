@@ -0,0 +1,38 @@
+package bmc
+
+import (
+	"strings"
+)
+
+// IPMI v1.5 authentication types (IPMI v2.0 Section 22.13)
+const (
+	AUTH_TYPE_NONE =		0x00
+	AUTH_TYPE_MD2 =			0x01
+	AUTH_TYPE_MD5 =			0x02
+	AUTH_TYPE_STRAIGHT_KEY =	0x04
+	AUTH_TYPE_OEM =			0x05
+)
+
+var authenticationTypeNames = map[string]uint8 {
+	"NONE": AUTH_TYPE_NONE,
+	"MD2": AUTH_TYPE_MD2,
+	"MD5": AUTH_TYPE_MD5,
+	"PASSWORD": AUTH_TYPE_STRAIGHT_KEY,
+	"OEM": AUTH_TYPE_OEM,
+}
+
+// ParseAuthenticationType converts names used in ipmitool -A, e.g. "PASSWORD", to authentication types.
+func ParseAuthenticationType(name string) (uint8, bool) {
+	authType, ok := authenticationTypeNames[strings.ToUpper(name)]
+
+	return authType, ok
+}
+
+func AuthenticationTypeName(authType uint8) string {
+	for name, value := range authenticationTypeNames {
+		if value == authType {
+			return name
+		}
+	}
+	return "UNKNOWN"
+}
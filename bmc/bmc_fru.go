@@ -0,0 +1,362 @@
+package bmc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+)
+
+// FRU Inventory Device (Platform Management FRU Information Storage
+// Definition v1.0). Each BMC has one FRU device, FRU device ID 0, whose image
+// is built from FRUInfo or read from a file, and is kept as it is. Images
+// changed by Write FRU Data are kept across restarts in SavedFRUFile.
+
+const (
+	FRU_DEVICE_ID_BMC =		0
+	MAX_FRU_SIZE =			0xFFFF		// Get FRU Inventory Area Info reports a 16-bit size
+
+	FRU_FORMAT_VERSION =		0x01
+	FRU_AREA_UNIT =			8		// offsets and lengths of areas are in multiples of 8 bytes
+	FRU_LANGUAGE_ENGLISH =		0x00
+	FRU_MFG_DATE_UNSPECIFIED =	0x000000
+
+	FRU_FIELD_TYPE_8BIT_ASCII =	0xC0
+	FRU_FIELD_MAX_LENGTH =		0x3F
+	FRU_FIELD_END =			0xC1
+
+	FRU_MULTIRECORD_FORMAT_VERSION =	0x02
+	FRU_MULTIRECORD_END_OF_LIST =		0x80
+	FRU_MULTIRECORD_MAX_LENGTH =		0xFF
+)
+
+// Chassis types (SMBIOS System Enclosure types) of the Chassis Info Area
+const (
+	FRU_CHASSIS_TYPE_RACK_MOUNT =	0x17
+)
+
+// MultiRecord types
+const (
+	FRU_MULTIRECORD_TYPE_MANAGEMENT_ACCESS =	0x03
+	FRU_MULTIRECORD_TYPE_OEM_FIRST =		0xC0
+
+	// Management Access Record sub-record type
+	FRU_MANAGEMENT_ACCESS_SYSTEM_UNIQUE_ID =	0x07
+)
+
+const (
+	DEFAULT_FRU_MANUFACTURER =	"infra-ecosphere"
+	DEFAULT_FRU_PRODUCT_NAME =	"Simulated Server"
+	DEFAULT_FRU_BOARD_NAME =	"Simulated Board"
+)
+
+// FRUInfo is the content of the Chassis, Board and Product Info Areas and the
+// MultiRecord Area of a built FRU image. Empty fields are encoded as empty
+// fields. A zero UUID leaves out the System Unique ID record.
+type FRUInfo struct {
+	ChassisType uint8
+	ChassisPartNumber string
+	ChassisSerial string
+
+	BoardManufacturer string
+	BoardProductName string
+	BoardSerial string
+	BoardPartNumber string
+
+	ProductManufacturer string
+	ProductName string
+	ProductPartNumber string
+	ProductVersion string
+	ProductSerial string
+	AssetTag string
+
+	UUID [16]uint8
+	MultiRecords []FRUMultiRecord
+}
+
+type FRUMultiRecord struct {
+	Type uint8
+	Data []uint8
+}
+
+var SavedFRUFile = "infra-ecosphere-fru.json"
+
+var frus map[string][]uint8
+var writtenFRUs map[string]bool
+var fruLock sync.Mutex
+
+func init() {
+	frus = make(map[string][]uint8)
+	writtenFRUs = make(map[string]bool)
+}
+
+// DefaultFRUInfo returns the FRU content of the BMC when the configuration
+// does not set it. The serial numbers come from the VM name, and the UUID is
+// the GUID of the BMC.
+func (bmc *BMC)DefaultFRUInfo() FRUInfo {
+	serial := bmc.nameHash("serial")
+	boardSerial := bmc.nameHash("board")
+
+	info := FRUInfo{
+		ChassisType: FRU_CHASSIS_TYPE_RACK_MOUNT,
+		ChassisSerial: fmt.Sprintf("IE%X", serial[:5]),
+		BoardManufacturer: DEFAULT_FRU_MANUFACTURER,
+		BoardProductName: DEFAULT_FRU_BOARD_NAME,
+		BoardSerial: fmt.Sprintf("IEB%X", boardSerial[:6]),
+		ProductManufacturer: DEFAULT_FRU_MANUFACTURER,
+		ProductName: DEFAULT_FRU_PRODUCT_NAME,
+		ProductSerial: fmt.Sprintf("IE%X", serial[:5]),
+	}
+	info.UUID = bmc.GUID()
+	return info
+}
+
+func fruChecksum(data []uint8) uint8 {
+	sum := uint8(0)
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
+
+func writeFRUField(buf *bytes.Buffer, field string) error {
+	if len(field) > FRU_FIELD_MAX_LENGTH {
+		return fmt.Errorf("field %s is longer than %d bytes", field, FRU_FIELD_MAX_LENGTH)
+	}
+	buf.WriteByte(FRU_FIELD_TYPE_8BIT_ASCII | uint8(len(field)))
+	buf.WriteString(field)
+	return nil
+}
+
+// encodeFRUArea adds the version and the length to the header and the fields
+// of an info area, and pads it with the checksum.
+func encodeFRUArea(header []uint8, fields []string) ([]uint8, error) {
+	buf := bytes.Buffer{}
+	buf.WriteByte(FRU_FORMAT_VERSION)
+	buf.WriteByte(0)
+	buf.Write(header)
+	for _, field := range fields {
+		if err := writeFRUField(&buf, field); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte(FRU_FIELD_END)
+	for (buf.Len() + 1) % FRU_AREA_UNIT != 0 {
+		buf.WriteByte(0)
+	}
+	buf.WriteByte(0)
+
+	area := buf.Bytes()
+	area[1] = uint8(len(area) / FRU_AREA_UNIT)
+	area[len(area) - 1] = fruChecksum(area)
+	return area, nil
+}
+
+func encodeFRUMultiRecords(records []FRUMultiRecord) ([]uint8, error) {
+	buf := bytes.Buffer{}
+	for i, record := range records {
+		if len(record.Data) > FRU_MULTIRECORD_MAX_LENGTH {
+			return nil, fmt.Errorf("multirecord of type 0x%02x is longer than %d bytes", record.Type, FRU_MULTIRECORD_MAX_LENGTH)
+		}
+		header := []uint8{record.Type, FRU_MULTIRECORD_FORMAT_VERSION, uint8(len(record.Data)), fruChecksum(record.Data), 0}
+		if i == len(records) - 1 {
+			header[1] |= FRU_MULTIRECORD_END_OF_LIST
+		}
+		header[4] = fruChecksum(header[:4])
+		buf.Write(header)
+		buf.Write(record.Data)
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode builds the FRU image of the Common Header, the Chassis, Board and
+// Product Info Areas and the MultiRecord Area.
+func (info FRUInfo)Encode() ([]uint8, error) {
+	chassis, err := encodeFRUArea([]uint8{info.ChassisType}, []string{info.ChassisPartNumber, info.ChassisSerial})
+	if err != nil {
+		return nil, err
+	}
+	board, err := encodeFRUArea([]uint8{FRU_LANGUAGE_ENGLISH, 0, 0, 0}, []string{
+		info.BoardManufacturer, info.BoardProductName, info.BoardSerial, info.BoardPartNumber, ""})
+	if err != nil {
+		return nil, err
+	}
+	product, err := encodeFRUArea([]uint8{FRU_LANGUAGE_ENGLISH}, []string{
+		info.ProductManufacturer, info.ProductName, info.ProductPartNumber, info.ProductVersion, info.ProductSerial, info.AssetTag, ""})
+	if err != nil {
+		return nil, err
+	}
+
+	records := []FRUMultiRecord{}
+	if info.UUID != [16]uint8{} {
+		records = append(records, FRUMultiRecord{
+			Type: FRU_MULTIRECORD_TYPE_MANAGEMENT_ACCESS,
+			Data: append([]uint8{FRU_MANAGEMENT_ACCESS_SYSTEM_UNIQUE_ID}, info.UUID[:]...),
+		})
+	}
+	multiRecords, err := encodeFRUMultiRecords(append(records, info.MultiRecords...))
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]uint8, FRU_AREA_UNIT)
+	header[0] = FRU_FORMAT_VERSION
+	offset := len(header)
+	for i, area := range [][]uint8{chassis, board, product} {
+		header[2 + i] = uint8(offset / FRU_AREA_UNIT)
+		offset += len(area)
+	}
+	if len(multiRecords) > 0 {
+		header[5] = uint8(offset / FRU_AREA_UNIT)
+	}
+	header[7] = fruChecksum(header[:7])
+
+	image := bytes.Join([][]uint8{header, chassis, board, product, multiRecords}, nil)
+	if offset / FRU_AREA_UNIT > 0xFF || len(image) > MAX_FRU_SIZE {
+		return nil, fmt.Errorf("FRU image of %d bytes is too large", len(image))
+	}
+	return image, nil
+}
+
+// SetFRUData replaces the FRU image of the BMC, e.g. a file of `ipmitool fru
+// read` or an image built by FRUInfo.Encode.
+func (bmc *BMC)SetFRUData(data []uint8) bool {
+	if len(data) > MAX_FRU_SIZE {
+		return false
+	}
+
+	fruLock.Lock()
+	defer fruLock.Unlock()
+
+	frus[bmc.Addr.String()] = append([]uint8{}, data...)
+	return true
+}
+
+// GetFRUSize returns the size of the FRU image, and false when the BMC has no
+// FRU device.
+func (bmc *BMC)GetFRUSize() (int, bool) {
+	fruLock.Lock()
+	defer fruLock.Unlock()
+
+	data, ok := frus[bmc.Addr.String()]
+	return len(data), ok
+}
+
+// ReadFRUData returns at most count bytes from the offset, fewer at the end
+// of the image. It fails when the offset is not in the image.
+func (bmc *BMC)ReadFRUData(offset int, count int) ([]uint8, bool) {
+	fruLock.Lock()
+	defer fruLock.Unlock()
+
+	data, ok := frus[bmc.Addr.String()]
+	if ! ok || offset >= len(data) {
+		return []uint8{}, false
+	}
+	if offset + count > len(data) {
+		count = len(data) - offset
+	}
+	return append([]uint8{}, data[offset:offset + count]...), true
+}
+
+// WriteFRUData writes the data at the offset and saves the image. The image
+// does not grow, so the write fails when it does not fit in the image.
+func (bmc *BMC)WriteFRUData(offset int, data []uint8) bool {
+	fruLock.Lock()
+	defer fruLock.Unlock()
+
+	image, ok := frus[bmc.Addr.String()]
+	if ! ok || offset + len(data) > len(image) {
+		return false
+	}
+	copy(image[offset:], data)
+	writtenFRUs[bmc.Addr.String()] = true
+	bmc.saveFRU()
+	return true
+}
+
+type SavedBMCFRU struct {
+	BMCIP	string
+	Data	[]uint8
+}
+
+type SavedFRUDatabase struct {
+	BMCs	[]SavedBMCFRU
+}
+
+func readSavedFRUDatabase() (SavedFRUDatabase, error) {
+	database := SavedFRUDatabase{}
+
+	data, err := ioutil.ReadFile(SavedFRUFile)
+	if err != nil {
+		return database, err
+	}
+	err = json.Unmarshal(data, &database)
+	return database, err
+}
+
+// LoadFRU loads the FRU images changed by Write FRU Data from SavedFRUFile,
+// which replace the configured images of the BMCs.
+func LoadFRU() {
+	if len(SavedFRUFile) == 0 {
+		return
+	}
+
+	database, err := readSavedFRUDatabase()
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Fatalf("Config: Failed to load FRU from %s: %s\n", SavedFRUFile, err.Error())
+	}
+
+	fruLock.Lock()
+	defer fruLock.Unlock()
+
+	for _, saved := range database.BMCs {
+		if _, ok := GetBMC(net.ParseIP(saved.BMCIP)); ! ok {
+			log.Printf("Config: BMC %s in %s is not found, ignore.\n", saved.BMCIP, SavedFRUFile)
+			continue
+		}
+		if len(saved.Data) > MAX_FRU_SIZE {
+			log.Fatalf("Config: FRU of BMC %s in %s should not be larger than %d bytes.\n", saved.BMCIP, SavedFRUFile, MAX_FRU_SIZE)
+		}
+
+		frus[saved.BMCIP] = saved.Data
+		writtenFRUs[saved.BMCIP] = true
+		log.Printf("Config: Load FRU of %d bytes of BMC %s\n", len(saved.Data), saved.BMCIP)
+	}
+}
+
+// saveFRU saves the written FRU images into SavedFRUFile. fruLock must be
+// held.
+func (bmc *BMC)saveFRU() {
+	if len(SavedFRUFile) == 0 {
+		return
+	}
+
+	ips := []string{}
+	for ip := range writtenFRUs {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	database := SavedFRUDatabase{}
+	for _, ip := range ips {
+		database.BMCs = append(database.BMCs, SavedBMCFRU{BMCIP: ip, Data: frus[ip]})
+	}
+
+	data, err := json.MarshalIndent(database, "", "\t")
+	if err != nil {
+		log.Printf("BMC %s: Failed to save FRU: %s\n", bmc.Addr.String(), err.Error())
+		return
+	}
+
+	err = WriteFileAtomic(SavedFRUFile, data, 0600)
+	if err != nil {
+		log.Printf("BMC %s: Failed to save FRU into %s: %s\n", bmc.Addr.String(), SavedFRUFile, err.Error())
+	}
+}
\ No newline at end of file
@@ -0,0 +1,150 @@
+package bmc
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/rmxymh/infra-ecosphere/vm"
+)
+
+func fruTestSum(data []uint8) uint8 {
+	sum := uint8(0)
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+func newFRUTestBMC(t *testing.T, ip string) (BMC, FRUInfo, []uint8) {
+	t.Helper()
+
+	obj := BMC{Addr: net.ParseIP(ip), VM: vm.Instance{Name: "fru-test"}}
+	info := obj.DefaultFRUInfo()
+	info.MultiRecords = []FRUMultiRecord{{Type: FRU_MULTIRECORD_TYPE_OEM_FIRST, Data: []uint8{0x01, 0x02, 0x03}}}
+	image, err := info.Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	return obj, info, image
+}
+
+// fruTestAreas returns the offsets of the Chassis, Board and Product Info
+// Areas and of the MultiRecord Area.
+func fruTestAreas(t *testing.T, image []uint8) []int {
+	t.Helper()
+
+	areas := []int{}
+	for _, offset := range image[2:6] {
+		if offset == 0 {
+			t.Fatalf("common header % x has a missing area", image[:FRU_AREA_UNIT])
+		}
+		areas = append(areas, int(offset)*FRU_AREA_UNIT)
+	}
+	return areas
+}
+
+func TestFRUEncodeAreas(t *testing.T) {
+	_, _, image := newFRUTestBMC(t, "127.0.10.8")
+
+	header := image[:FRU_AREA_UNIT]
+	if header[0] != FRU_FORMAT_VERSION {
+		t.Errorf("common header format version = 0x%02x, want 0x%02x", header[0], FRU_FORMAT_VERSION)
+	}
+	if header[1] != 0 {
+		t.Errorf("internal use area offset = %d, want 0", header[1])
+	}
+	if sum := fruTestSum(header); sum != 0 {
+		t.Errorf("common header % x does not sum to 0", header)
+	}
+
+	areas := fruTestAreas(t, image)
+	offset := FRU_AREA_UNIT
+	for i, start := range areas[:3] {
+		if start != offset {
+			t.Errorf("area %d starts at %d, want %d", i, start, offset)
+		}
+		length := int(image[start+1]) * FRU_AREA_UNIT
+		if length == 0 || start+length > len(image) {
+			t.Fatalf("area %d at %d has a length of %d bytes", i, start, length)
+		}
+		area := image[start : start+length]
+		if area[0] != FRU_FORMAT_VERSION {
+			t.Errorf("area %d format version = 0x%02x, want 0x%02x", i, area[0], FRU_FORMAT_VERSION)
+		}
+		if sum := fruTestSum(area); sum != 0 {
+			t.Errorf("area %d % x does not sum to 0", i, area)
+		}
+		offset += length
+	}
+	if areas[3] != offset {
+		t.Errorf("multirecord area starts at %d, want %d", areas[3], offset)
+	}
+}
+
+func TestFRUEncodeMultiRecords(t *testing.T) {
+	_, info, image := newFRUTestBMC(t, "127.0.10.8")
+
+	records := []FRUMultiRecord{}
+	offset := fruTestAreas(t, image)[3]
+	for end := false; !end; {
+		if offset+5 > len(image) {
+			t.Fatalf("multirecord header at %d is truncated, no end of list", offset)
+		}
+		header := image[offset : offset+5]
+		if sum := fruTestSum(header); sum != 0 {
+			t.Errorf("multirecord header % x does not sum to 0", header)
+		}
+		if version := header[1] &^ FRU_MULTIRECORD_END_OF_LIST; version != FRU_MULTIRECORD_FORMAT_VERSION {
+			t.Errorf("multirecord format version = 0x%02x, want 0x%02x", version, FRU_MULTIRECORD_FORMAT_VERSION)
+		}
+		data := image[offset+5 : offset+5+int(header[2])]
+		if sum := fruTestSum(data) + header[3]; sum != 0 {
+			t.Errorf("multirecord data % x does not match the checksum 0x%02x", data, header[3])
+		}
+
+		records = append(records, FRUMultiRecord{Type: header[0], Data: data})
+		end = header[1]&FRU_MULTIRECORD_END_OF_LIST != 0
+		offset += 5 + len(data)
+	}
+	if offset != len(image) {
+		t.Errorf("the end of list is at %d, the image ends at %d", offset, len(image))
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("%d multirecords, want 2", len(records))
+	}
+	uuid := append([]uint8{FRU_MANAGEMENT_ACCESS_SYSTEM_UNIQUE_ID}, info.UUID[:]...)
+	if records[0].Type != FRU_MULTIRECORD_TYPE_MANAGEMENT_ACCESS || !bytes.Equal(records[0].Data, uuid) {
+		t.Errorf("first multirecord = 0x%02x % x, want the system unique ID % x", records[0].Type, records[0].Data, uuid)
+	}
+	if records[1].Type != info.MultiRecords[0].Type || !bytes.Equal(records[1].Data, info.MultiRecords[0].Data) {
+		t.Errorf("second multirecord = 0x%02x % x, want 0x%02x % x", records[1].Type, records[1].Data, info.MultiRecords[0].Type, info.MultiRecords[0].Data)
+	}
+}
+
+func TestReadFRUDataAcrossAreas(t *testing.T) {
+	obj, _, image := newFRUTestBMC(t, "127.0.10.8")
+	if !obj.SetFRUData(image) {
+		t.Fatal("SetFRUData failed")
+	}
+	t.Cleanup(func() {
+		fruLock.Lock()
+		delete(frus, obj.Addr.String())
+		fruLock.Unlock()
+	})
+
+	for _, start := range fruTestAreas(t, image) {
+		data, ok := obj.ReadFRUData(start-3, 8)
+		if want := image[start-3 : start+5]; !ok || !bytes.Equal(data, want) {
+			t.Errorf("ReadFRUData(%d, 8) = % x, %v, want % x", start-3, data, ok, want)
+		}
+	}
+
+	if data, ok := obj.ReadFRUData(len(image)-2, 8); !ok || !bytes.Equal(data, image[len(image)-2:]) {
+		t.Errorf("ReadFRUData at the end = % x, %v, want % x", data, ok, image[len(image)-2:])
+	}
+	if _, ok := obj.ReadFRUData(len(image), 1); ok {
+		t.Error("ReadFRUData after the end succeeds")
+	}
+}
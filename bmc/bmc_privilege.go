@@ -0,0 +1,40 @@
+package bmc
+
+import (
+	"strings"
+)
+
+// Privilege levels (IPMI v2.0 Table 22-28)
+const (
+	PRIVILEGE_CALLBACK =		0x01
+	PRIVILEGE_USER =		0x02
+	PRIVILEGE_OPERATOR =		0x03
+	PRIVILEGE_ADMINISTRATOR =	0x04
+	PRIVILEGE_OEM =			0x05
+	PRIVILEGE_NO_ACCESS =		0x0F	// privilege limit of a user without access
+)
+
+var privilegeNames = map[string]uint8 {
+	"CALLBACK": PRIVILEGE_CALLBACK,
+	"USER": PRIVILEGE_USER,
+	"OPERATOR": PRIVILEGE_OPERATOR,
+	"ADMINISTRATOR": PRIVILEGE_ADMINISTRATOR,
+	"OEM": PRIVILEGE_OEM,
+	"NO_ACCESS": PRIVILEGE_NO_ACCESS,
+}
+
+// ParsePrivilegeLevel converts names used in ipmitool, e.g. "ADMINISTRATOR", to privilege levels.
+func ParsePrivilegeLevel(name string) (uint8, bool) {
+	level, ok := privilegeNames[strings.ToUpper(name)]
+
+	return level, ok
+}
+
+func PrivilegeLevelName(level uint8) string {
+	for name, value := range privilegeNames {
+		if value == level {
+			return name
+		}
+	}
+	return "UNKNOWN"
+}
@@ -0,0 +1,414 @@
+package bmc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Sensor Data Record Repository (IPMI v2.0 Section 33 and 43). Each BMC has
+// its own repository, and its sensors are derived from the full and compact
+// sensor records of the repository.
+
+const (
+	SDR_VERSION_IPMI_V2 =		0x51
+	SDR_HEADER_SIZE =		5
+	SDR_ID_STRING_LENGTH =		16
+
+	SDR_RECORD_ID_FIRST =		0x0000
+	SDR_RECORD_ID_LAST =		0xFFFF
+)
+
+// Record types
+const (
+	SDR_RECORD_TYPE_FULL_SENSOR =		0x01
+	SDR_RECORD_TYPE_COMPACT_SENSOR =	0x02
+	SDR_RECORD_TYPE_EVENT_ONLY =		0x03
+	SDR_RECORD_TYPE_FRU_DEVICE_LOCATOR =	0x11
+	SDR_RECORD_TYPE_MC_DEVICE_LOCATOR =	0x12
+)
+
+// Sensor owner of sensor records, only the sensors of the BMC itself are
+// simulated
+const (
+	SDR_OWNER_ID_BMC =			0x20
+	SDR_SENSOR_OWNER_LUN_BITMASK =		0x03
+)
+
+// Sensor Record Sharing of compact sensor records: [3:0] share count, [5:4]
+// ID string instance modifier type, [14:8] ID string instance modifier
+// offset, [15] entity instance increments for each shared sensor.
+const (
+	SDR_SHARE_COUNT_BITMASK =		0x000F
+	SDR_SHARE_MODIFIER_TYPE_BITMASK =	0x0030
+	SDR_SHARE_MODIFIER_TYPE_ALPHA =		0x0010
+	SDR_SHARE_MODIFIER_OFFSET_BITMASK =	0x7F
+	SDR_SHARE_ENTITY_INSTANCE_INCREMENTS =	0x8000
+)
+
+// Analog characteristic flags of full sensor records
+const (
+	SDR_ANALOG_FLAG_NOMINAL_READING =	0x01
+	SDR_ANALOG_FLAG_NORMAL_MAXIMUM =	0x02
+	SDR_ANALOG_FLAG_NORMAL_MINIMUM =	0x04
+)
+
+// ID String Type/Length Code [7:6]
+const (
+	SDR_ID_STRING_TYPE_8BIT_ASCII =		0xC0
+	SDR_ID_STRING_LENGTH_BITMASK =		0x1F
+)
+
+// SDRRecord is a whole record, including the record header. Records are kept
+// as they are, so that records dumped from a real BMC are served verbatim.
+type SDRRecord []uint8
+
+type SDRRecordHeader struct {
+	RecordID uint16
+	SDRVersion uint8
+	RecordType uint8
+	RecordLength uint8		// bytes after the header
+}
+
+// Record body of a full sensor record (IPMI v2.0 Table 43-1) up to the ID
+// string.
+type SDRFullSensorBody struct {
+	OwnerID uint8
+	OwnerLUN uint8
+	SensorNumber uint8
+	EntityID uint8
+	EntityInstance uint8
+	Initialization uint8
+	Capabilities uint8
+	SensorType uint8
+	EventType uint8
+	AssertionMask uint16		// [14:12] lower threshold reading mask for threshold sensors
+	DeassertionMask uint16		// [14:12] upper threshold reading mask for threshold sensors
+	ReadingMask uint16		// [13:8] settable, [5:0] readable thresholds for threshold sensors
+	Units1 uint8
+	BaseUnit uint8
+	ModifierUnit uint8
+	Linearization uint8
+	M uint8
+	MTolerance uint8		// [7:6] M MS 2 bits, [5:0] tolerance
+	B uint8
+	BAccuracy uint8			// [7:6] B MS 2 bits, [5:0] accuracy LS 6 bits
+	AccuracyDirection uint8
+	Exponents uint8			// [7:4] R exponent, [3:0] B exponent
+	AnalogFlags uint8
+	NominalReading uint8
+	NormalMaximum uint8
+	NormalMinimum uint8
+	SensorMaximum uint8
+	SensorMinimum uint8
+	UpperNonRecoverable uint8
+	UpperCritical uint8
+	UpperNonCritical uint8
+	LowerNonRecoverable uint8
+	LowerCritical uint8
+	LowerNonCritical uint8
+	PositiveHysteresis uint8
+	NegativeHysteresis uint8
+	Reserved [2]uint8
+	OEM uint8
+	IDStringTypeLength uint8
+}
+
+// Record body of a compact sensor record (IPMI v2.0 Table 43-2) up to the ID
+// string.
+type SDRCompactSensorBody struct {
+	OwnerID uint8
+	OwnerLUN uint8
+	SensorNumber uint8
+	EntityID uint8
+	EntityInstance uint8
+	Initialization uint8
+	Capabilities uint8
+	SensorType uint8
+	EventType uint8
+	AssertionMask uint16
+	DeassertionMask uint16
+	ReadingMask uint16
+	Units1 uint8
+	BaseUnit uint8
+	ModifierUnit uint8
+	RecordSharing uint16
+	PositiveHysteresis uint8
+	NegativeHysteresis uint8
+	Reserved [3]uint8
+	OEM uint8
+	IDStringTypeLength uint8
+}
+
+// Record body of an event-only record (IPMI v2.0 Table 43-3) up to the ID
+// string.
+type SDREventOnlyBody struct {
+	OwnerID uint8
+	OwnerLUN uint8
+	SensorNumber uint8
+	EntityID uint8
+	EntityInstance uint8
+	SensorType uint8
+	EventType uint8
+	RecordSharing uint16
+	Reserved uint8
+	OEM uint8
+	IDStringTypeLength uint8
+}
+
+// Record body of a management controller device locator record (IPMI v2.0
+// Table 43-8) up to the ID string.
+type SDRMCDeviceLocatorBody struct {
+	SlaveAddress uint8
+	Channel uint8
+	PowerStateGlobalInit uint8
+	Capabilities uint8
+	Reserved [3]uint8
+	EntityID uint8
+	EntityInstance uint8
+	OEM uint8
+	IDStringTypeLength uint8
+}
+
+func (record SDRRecord)Header() (SDRRecordHeader, bool) {
+	header := SDRRecordHeader{}
+	if len(record) < SDR_HEADER_SIZE {
+		return header, false
+	}
+	binary.Read(bytes.NewReader(record), binary.LittleEndian, &header)
+	return header, true
+}
+
+func (record SDRRecord)RecordID() uint16 {
+	header, _ := record.Header()
+	return header.RecordID
+}
+
+func (record SDRRecord)RecordType() uint8 {
+	header, _ := record.Header()
+	return header.RecordType
+}
+
+// Body returns the record bytes after the header.
+func (record SDRRecord)Body() []uint8 {
+	if len(record) < SDR_HEADER_SIZE {
+		return []uint8{}
+	}
+	return record[SDR_HEADER_SIZE:]
+}
+
+// ReadBody decodes the fixed part of the record body into body. A body shorter
+// than body leaves the remaining fields zero. It returns the bytes after the
+// fixed part, e.g. the ID string.
+func (record SDRRecord)ReadBody(body interface{}) []uint8 {
+	fixed := make([]uint8, binary.Size(body))
+	copy(fixed, record.Body())
+	binary.Read(bytes.NewReader(fixed), binary.LittleEndian, body)
+
+	if len(record.Body()) < len(fixed) {
+		return []uint8{}
+	}
+	return record.Body()[len(fixed):]
+}
+
+// NewSDRRecord encodes a record of the record type with the body and the ID
+// string.
+func NewSDRRecord(recordID uint16, recordType uint8, body interface{}, name string) SDRRecord {
+	if len(name) > SDR_ID_STRING_LENGTH {
+		name = name[:SDR_ID_STRING_LENGTH]
+	}
+
+	bodyBuf := bytes.Buffer{}
+	binary.Write(&bodyBuf, binary.LittleEndian, body)
+	data := bodyBuf.Bytes()
+	data[len(data) - 1] = SDR_ID_STRING_TYPE_8BIT_ASCII | uint8(len(name))
+	data = append(data, name...)
+
+	header := SDRRecordHeader{
+		RecordID: recordID,
+		SDRVersion: SDR_VERSION_IPMI_V2,
+		RecordType: recordType,
+		RecordLength: uint8(len(data)),
+	}
+	recordBuf := bytes.Buffer{}
+	binary.Write(&recordBuf, binary.LittleEndian, header)
+	recordBuf.Write(data)
+	return SDRRecord(recordBuf.Bytes())
+}
+
+// parseIDString decodes the ID string which follows the ID String Type/Length
+// Code. Only 8-bit ASCII strings are decoded, the other types keep their raw
+// bytes.
+func parseIDString(typeLength uint8, data []uint8) string {
+	length := int(typeLength & SDR_ID_STRING_LENGTH_BITMASK)
+	if length > len(data) {
+		length = len(data)
+	}
+	name := data[:length]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	return string(name)
+}
+
+// SDRRepository is the SDR repository of a BMC and the sensors derived from
+// its records.
+type SDRRepository struct {
+	Records []SDRRecord
+	LastAddition uint32
+	LastErase uint32
+	ReservationID uint16
+	Sensors map[uint8]*Sensor
+	ramps map[uint8]chan bool	// closed to stop the ramp of a sensor
+}
+
+var sdrRepositories map[string]*SDRRepository
+var sdrLock sync.Mutex
+
+func init() {
+	sdrRepositories = make(map[string]*SDRRepository)
+}
+
+func newSDRRepository(records []SDRRecord) *SDRRepository {
+	repository := SDRRepository{
+		Records: records,
+		LastAddition: uint32(time.Now().Unix()),
+		LastErase: SEL_TIMESTAMP_UNSPECIFIED,
+		Sensors: make(map[uint8]*Sensor),
+		ramps: make(map[uint8]chan bool),
+	}
+
+	// Sensors of other controllers, e.g. a management engine, are only
+	// described by the records, because their readings are not answered
+	// by this BMC.
+	for _, record := range records {
+		for _, sensor := range ParseSensors(record) {
+			if sensor.OwnerID != SDR_OWNER_ID_BMC || sensor.OwnerLUN != 0 {
+				continue
+			}
+			if _, found := repository.Sensors[sensor.Number]; found {
+				log.Printf("SDR: Sensor number 0x%02x of record 0x%04x is used by another record, ignore.\n", sensor.Number, record.RecordID())
+				continue
+			}
+			sensor := sensor
+			repository.Sensors[sensor.Number] = &sensor
+		}
+	}
+	return &repository
+}
+
+// ParseSDRRecords splits the records of an SDR repository image, e.g. a file
+// of `ipmitool sdr dump`.
+func ParseSDRRecords(data []uint8) ([]SDRRecord, error) {
+	records := []SDRRecord{}
+	for offset := 0; offset < len(data); {
+		record := SDRRecord(data[offset:])
+		header, ok := record.Header()
+		if ! ok {
+			return records, fmt.Errorf("truncated record header at offset %d", offset)
+		}
+		length := SDR_HEADER_SIZE + int(header.RecordLength)
+		if length > len(record) {
+			return records, fmt.Errorf("record 0x%04x at offset %d is truncated", header.RecordID, offset)
+		}
+		records = append(records, append(SDRRecord{}, record[:length]...))
+		offset += length
+	}
+	return records, nil
+}
+
+// getSDRRepository returns the repository of the BMC, which has the default
+// records until SetSDRRecords is called. sdrLock must be held.
+func (bmc *BMC)getSDRRepository() *SDRRepository {
+	repository, ok := sdrRepositories[bmc.Addr.String()]
+	if ! ok {
+		repository = newSDRRepository(DefaultSDRRecords())
+		sdrRepositories[bmc.Addr.String()] = repository
+	}
+	return repository
+}
+
+// SetSDRRecords replaces the records of the SDR repository of the BMC, and
+// derives its sensors from them.
+func (bmc *BMC)SetSDRRecords(records []SDRRecord) {
+	sdrLock.Lock()
+	defer sdrLock.Unlock()
+
+	sdrRepositories[bmc.Addr.String()] = newSDRRepository(records)
+}
+
+// GetSDRRepository returns a copy of the SDR repository of the BMC without
+// its sensors, see GetSensors.
+func (bmc *BMC)GetSDRRepository() SDRRepository {
+	sdrLock.Lock()
+	defer sdrLock.Unlock()
+
+	repository := *bmc.getSDRRepository()
+	repository.Records = append([]SDRRecord{}, repository.Records...)
+	repository.Sensors = nil
+	return repository
+}
+
+// ReserveSDRRepository cancels the current reservation of the repository and
+// returns a new reservation ID, which is never 0.
+func (bmc *BMC)ReserveSDRRepository() uint16 {
+	sdrLock.Lock()
+	defer sdrLock.Unlock()
+
+	repository := bmc.getSDRRepository()
+	reservationID := repository.ReservationID
+	for reservationID == 0 || reservationID == repository.ReservationID {
+		reservationID = uint16(rand.Uint32())
+	}
+	repository.ReservationID = reservationID
+	return reservationID
+}
+
+func (bmc *BMC)IsSDRReservationValid(reservationID uint16) bool {
+	sdrLock.Lock()
+	defer sdrLock.Unlock()
+
+	repository := bmc.getSDRRepository()
+	return reservationID != 0 && reservationID == repository.ReservationID
+}
+
+// GetSDRRecord returns the record of the record ID, SDR_RECORD_ID_FIRST or
+// SDR_RECORD_ID_LAST, and the record ID of the next record, which is
+// SDR_RECORD_ID_LAST for the last record.
+func (bmc *BMC)GetSDRRecord(recordID uint16) (record SDRRecord, next uint16, ok bool) {
+	sdrLock.Lock()
+	defer sdrLock.Unlock()
+
+	records := bmc.getSDRRepository().Records
+	if len(records) == 0 {
+		return record, 0, false
+	}
+
+	i := -1
+	switch recordID {
+	case SDR_RECORD_ID_FIRST:
+		i = 0
+	case SDR_RECORD_ID_LAST:
+		i = len(records) - 1
+	default:
+		for j := range records {
+			if records[j].RecordID() == recordID {
+				i = j
+				break
+			}
+		}
+	}
+	if i < 0 {
+		return record, 0, false
+	}
+
+	next = SDR_RECORD_ID_LAST
+	if i + 1 < len(records) {
+		next = records[i + 1].RecordID()
+	}
+	return records[i], next, true
+}
\ No newline at end of file
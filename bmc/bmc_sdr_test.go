@@ -0,0 +1,103 @@
+package bmc
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+// Fixed part of the record body of each record type, up to the ID String
+// Type/Length Code (IPMI v2.0 Section 43).
+var sdrTestBodyLengths = map[uint8]int{
+	SDR_RECORD_TYPE_FULL_SENSOR:       43,
+	SDR_RECORD_TYPE_COMPACT_SENSOR:    27,
+	SDR_RECORD_TYPE_EVENT_ONLY:        12,
+	SDR_RECORD_TYPE_MC_DEVICE_LOCATOR: 11,
+}
+
+func TestSDRRecordsRoundTrip(t *testing.T) {
+	records := DefaultSDRRecords()
+
+	image := []uint8{}
+	for i, record := range records {
+		header, ok := record.Header()
+		if !ok {
+			t.Fatalf("record %d has no header", i)
+		}
+		if want := uint16(i + 1); header.RecordID != want {
+			t.Errorf("record %d: record ID = 0x%04x, want 0x%04x", i, header.RecordID, want)
+		}
+		if header.SDRVersion != SDR_VERSION_IPMI_V2 {
+			t.Errorf("record 0x%04x: SDR version = 0x%02x, want 0x%02x", header.RecordID, header.SDRVersion, SDR_VERSION_IPMI_V2)
+		}
+		if len(record) != SDR_HEADER_SIZE+int(header.RecordLength) {
+			t.Errorf("record 0x%04x: %d bytes, record length %d", header.RecordID, len(record), header.RecordLength)
+		}
+
+		fixed, ok := sdrTestBodyLengths[header.RecordType]
+		if !ok {
+			t.Errorf("record 0x%04x: unexpected record type 0x%02x", header.RecordID, header.RecordType)
+			continue
+		}
+		typeLength := record.Body()[fixed-1]
+		if typeLength&^SDR_ID_STRING_LENGTH_BITMASK != SDR_ID_STRING_TYPE_8BIT_ASCII {
+			t.Errorf("record 0x%04x: ID string type/length = 0x%02x", header.RecordID, typeLength)
+		}
+		if want := fixed + int(typeLength&SDR_ID_STRING_LENGTH_BITMASK); int(header.RecordLength) != want {
+			t.Errorf("record 0x%04x: record length = %d, want %d", header.RecordID, header.RecordLength, want)
+		}
+
+		image = append(image, record...)
+	}
+
+	parsed, err := ParseSDRRecords(image)
+	if err != nil {
+		t.Fatalf("ParseSDRRecords: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, records) {
+		t.Errorf("parsed records = % x, want % x", parsed, records)
+	}
+
+	if _, err := ParseSDRRecords(image[:len(image)-1]); err == nil {
+		t.Error("ParseSDRRecords of a truncated image succeeds")
+	}
+}
+
+func TestGetSDRRecordChain(t *testing.T) {
+	obj := BMC{Addr: net.ParseIP("127.0.10.6")}
+	t.Cleanup(func() {
+		sdrLock.Lock()
+		delete(sdrRepositories, obj.Addr.String())
+		sdrLock.Unlock()
+	})
+	records := DefaultSDRRecords()
+
+	ids := []uint16{}
+	next := uint16(SDR_RECORD_ID_FIRST)
+	for i := 0; next != SDR_RECORD_ID_LAST; i += 1 {
+		if i > len(records) {
+			t.Fatalf("the next record IDs do not end in 0x%04x: %v", SDR_RECORD_ID_LAST, ids)
+		}
+		record, nextID, ok := obj.GetSDRRecord(next)
+		if !ok {
+			t.Fatalf("record 0x%04x is not found", next)
+		}
+		if next != SDR_RECORD_ID_FIRST && record.RecordID() != next {
+			t.Errorf("record ID of record 0x%04x = 0x%04x", next, record.RecordID())
+		}
+		ids = append(ids, record.RecordID())
+		next = nextID
+	}
+
+	want := []uint16{}
+	for _, record := range records {
+		want = append(want, record.RecordID())
+	}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("record IDs = %v, want %v", ids, want)
+	}
+
+	if record, _, ok := obj.GetSDRRecord(SDR_RECORD_ID_LAST); !ok || record.RecordID() != want[len(want)-1] {
+		t.Errorf("record 0x%04x = 0x%04x, want the last record 0x%04x", SDR_RECORD_ID_LAST, record.RecordID(), want[len(want)-1])
+	}
+}
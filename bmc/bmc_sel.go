@@ -0,0 +1,411 @@
+package bmc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// System Event Log (IPMI v2.0 Section 31 and 32). Each BMC has its own SEL,
+// which is kept across restarts in SELFile.
+
+const (
+	DEFAULT_SEL_CAPACITY =		512
+	MAX_SEL_CAPACITY =		4095	// the free space of the SEL is reported in 16-bit bytes
+	SEL_ENTRY_SIZE =		16
+
+	SEL_RECORD_ID_FIRST =		0x0000
+	SEL_RECORD_ID_LAST =		0xFFFF
+
+	SEL_TIMESTAMP_UNSPECIFIED =	0xFFFFFFFF
+)
+
+// Record types
+const (
+	SEL_RECORD_TYPE_SYSTEM_EVENT =			0x02
+	SEL_RECORD_TYPE_OEM_TIMESTAMPED_FIRST =		0xC0
+	SEL_RECORD_TYPE_OEM_NON_TIMESTAMPED_FIRST =	0xE0
+)
+
+// Fields of the system event records logged by the BMC itself
+const (
+	SEL_GENERATOR_ID_BMC =			0x0020	// slave address 0x20, LUN 0
+	SEL_EVM_REV_IPMI_V2 =			0x04
+	SEL_EVENT_TYPE_SENSOR_SPECIFIC =	0x6F
+	SEL_EVENT_DIR_DEASSERTION =		0x80
+	SEL_EVENT_DATA_UNSPECIFIED =		0xFF
+)
+
+// Sensor types and offsets (IPMI v2.0 Table 42-3) of the events logged by
+// the BMC itself
+const (
+	SENSOR_TYPE_POWER_UNIT =		0x09
+	SENSOR_TYPE_SYSTEM_EVENT =		0x12
+	SENSOR_TYPE_SYSTEM_RESTART =		0x1D
+	SENSOR_TYPE_SYSTEM_ACPI_POWER_STATE =	0x22
+
+	POWER_UNIT_POWER_OFF =			0x00
+	SYSTEM_EVENT_RECONFIGURED =		0x00
+	SYSTEM_RESTART_HARD_RESET =		0x01
+	ACPI_POWER_STATE_S0_WORKING =		0x00
+	ACPI_POWER_STATE_S5_SOFT_OFF =		0x05
+)
+
+// Sensor numbers of the events logged by the BMC itself
+const (
+	SENSOR_NUMBER_POWER_UNIT =		0x01
+	SENSOR_NUMBER_SYSTEM_EVENT =		0x02
+	SENSOR_NUMBER_SYSTEM_RESTART =		0x03
+	SENSOR_NUMBER_ACPI_POWER_STATE =	0x04
+)
+
+// SELEntry is one 16-byte record of the SEL in the layout of a system event
+// record. OEM records keep their bytes at the same offsets, and the Timestamp
+// of a non-timestamped OEM record is OEM data as well.
+type SELEntry struct {
+	RecordID uint16
+	RecordType uint8
+	Timestamp uint32
+	GeneratorID uint16
+	EvMRev uint8
+	SensorType uint8
+	SensorNumber uint8
+	EventType uint8		// [7] SEL_EVENT_DIR_DEASSERTION, [6:0] event/reading type
+	EventData [3]uint8
+}
+
+func (entry *SELEntry)IsTimestamped() bool {
+	return entry.RecordType < SEL_RECORD_TYPE_OEM_NON_TIMESTAMPED_FIRST
+}
+
+type SEL struct {
+	Entries []SELEntry
+	NextRecordID uint16
+	Overflow bool		// an event was dropped or overwritten since the SEL was cleared
+	LastAddition uint32
+	LastErase uint32
+	TimeOffset int64	// seconds added to the host clock by Set SEL Time
+	ReservationID uint16 `json:"-"`
+}
+
+// SELFile keeps the SEL of every BMC across restarts. An empty SELFile keeps
+// the SEL in memory only.
+var SELFile string = "infra-ecosphere-sel.json"
+
+var sels map[string]*SEL
+var selLock sync.Mutex
+
+func init() {
+	sels = make(map[string]*SEL)
+}
+
+func newSEL() *SEL {
+	return &SEL{
+		Entries: []SELEntry{},
+		NextRecordID: 1,
+		LastAddition: SEL_TIMESTAMP_UNSPECIFIED,
+		LastErase: SEL_TIMESTAMP_UNSPECIFIED,
+	}
+}
+
+// getSEL returns the SEL of the BMC. selLock must be held.
+func (bmc *BMC)getSEL() *SEL {
+	sel, ok := sels[bmc.Addr.String()]
+	if ! ok {
+		sel = newSEL()
+		sels[bmc.Addr.String()] = sel
+	}
+	return sel
+}
+
+func (sel *SEL)now() uint32 {
+	return uint32(time.Now().Unix() + sel.TimeOffset)
+}
+
+func (sel *SEL)indexOf(recordID uint16) int {
+	if len(sel.Entries) == 0 {
+		return -1
+	}
+	switch recordID {
+	case SEL_RECORD_ID_FIRST:
+		return 0
+	case SEL_RECORD_ID_LAST:
+		return len(sel.Entries) - 1
+	}
+	for i := range sel.Entries {
+		if sel.Entries[i].RecordID == recordID {
+			return i
+		}
+	}
+	return -1
+}
+
+// nextRecordID returns a record ID which is not used by any entry, skipping
+// the reserved IDs 0x0000 and 0xFFFF.
+func (sel *SEL)nextRecordID() uint16 {
+	for {
+		id := sel.NextRecordID
+		sel.NextRecordID += 1
+		if sel.NextRecordID == SEL_RECORD_ID_LAST {
+			sel.NextRecordID = 1
+		}
+		if id != SEL_RECORD_ID_FIRST && id != SEL_RECORD_ID_LAST && sel.indexOf(id) < 0 {
+			return id
+		}
+	}
+}
+
+// MaxSELEntries is the number of entries the SEL of the BMC can hold.
+func (bmc *BMC)MaxSELEntries() int {
+	if bmc.SELCapacity > 0 {
+		return bmc.SELCapacity
+	}
+	return DEFAULT_SEL_CAPACITY
+}
+
+// GetSEL returns a copy of the SEL of the BMC.
+func (bmc *BMC)GetSEL() SEL {
+	selLock.Lock()
+	defer selLock.Unlock()
+
+	sel := *bmc.getSEL()
+	sel.Entries = append([]SELEntry{}, sel.Entries...)
+	return sel
+}
+
+// ReserveSEL cancels the current reservation of the SEL and returns a new
+// reservation ID, which is never 0. Any change of the entries of the SEL
+// cancels the reservation as well (IPMI v2.0 Section 31.4).
+func (bmc *BMC)ReserveSEL() uint16 {
+	selLock.Lock()
+	defer selLock.Unlock()
+
+	sel := bmc.getSEL()
+	reservationID := sel.ReservationID
+	for reservationID == 0 || reservationID == sel.ReservationID {
+		reservationID = uint16(rand.Uint32())
+	}
+	sel.ReservationID = reservationID
+	return reservationID
+}
+
+func (bmc *BMC)IsSELReservationValid(reservationID uint16) bool {
+	selLock.Lock()
+	defer selLock.Unlock()
+
+	sel := bmc.getSEL()
+	return reservationID != 0 && reservationID == sel.ReservationID
+}
+
+// GetSELEntry returns the entry of the record ID, SEL_RECORD_ID_FIRST or
+// SEL_RECORD_ID_LAST, and the record ID of the next entry, which is
+// SEL_RECORD_ID_LAST for the last entry.
+func (bmc *BMC)GetSELEntry(recordID uint16) (entry SELEntry, next uint16, ok bool) {
+	selLock.Lock()
+	defer selLock.Unlock()
+
+	sel := bmc.getSEL()
+	i := sel.indexOf(recordID)
+	if i < 0 {
+		return entry, 0, false
+	}
+
+	next = SEL_RECORD_ID_LAST
+	if i + 1 < len(sel.Entries) {
+		next = sel.Entries[i + 1].RecordID
+	}
+	return sel.Entries[i], next, true
+}
+
+// AddSELEntry gives the entry a record ID and, when the record type has one,
+// a timestamp, and appends it to the SEL. When the SEL is full, the oldest
+// entry is overwritten if SELOverwrite is set, or the entry is dropped and ok
+// is false.
+func (bmc *BMC)AddSELEntry(entry SELEntry) (SELEntry, bool) {
+	selLock.Lock()
+	defer selLock.Unlock()
+
+	sel := bmc.getSEL()
+	if len(sel.Entries) >= bmc.MaxSELEntries() {
+		sel.Overflow = true
+		if ! bmc.SELOverwrite {
+			log.Printf("BMC %s: SEL is full, drop the event.\n", bmc.Addr.String())
+			bmc.saveSEL()
+			return entry, false
+		}
+		sel.Entries = sel.Entries[len(sel.Entries) - bmc.MaxSELEntries() + 1:]
+	}
+
+	entry.RecordID = sel.nextRecordID()
+	sel.LastAddition = sel.now()
+	if entry.IsTimestamped() {
+		entry.Timestamp = sel.LastAddition
+	}
+	sel.Entries = append(sel.Entries, entry)
+	sel.ReservationID = 0
+	bmc.saveSEL()
+
+	return entry, true
+}
+
+// AddSELEvent logs a sensor-specific event of a sensor of the BMC.
+func (bmc *BMC)AddSELEvent(sensorType uint8, sensorNumber uint8, offset uint8, asserted bool) {
+	entry := SELEntry{
+		RecordType: SEL_RECORD_TYPE_SYSTEM_EVENT,
+		GeneratorID: SEL_GENERATOR_ID_BMC,
+		EvMRev: SEL_EVM_REV_IPMI_V2,
+		SensorType: sensorType,
+		SensorNumber: sensorNumber,
+		EventType: SEL_EVENT_TYPE_SENSOR_SPECIFIC,
+		EventData: [3]uint8{offset & 0x0F, SEL_EVENT_DATA_UNSPECIFIED, SEL_EVENT_DATA_UNSPECIFIED},
+	}
+	if ! asserted {
+		entry.EventType |= SEL_EVENT_DIR_DEASSERTION
+	}
+
+	if entry, ok := bmc.AddSELEntry(entry); ok {
+		log.Printf("BMC %s: Add SEL entry 0x%04x: sensor type 0x%02x, sensor 0x%02x, offset 0x%02x, asserted %t\n", bmc.Addr.String(), entry.RecordID, sensorType, sensorNumber, offset, asserted)
+	}
+}
+
+// DeleteSELEntry removes the entry and returns its record ID. It cancels the
+// reservation, so the next entry needs a new one.
+func (bmc *BMC)DeleteSELEntry(recordID uint16) (uint16, bool) {
+	selLock.Lock()
+	defer selLock.Unlock()
+
+	sel := bmc.getSEL()
+	i := sel.indexOf(recordID)
+	if i < 0 {
+		return 0, false
+	}
+
+	recordID = sel.Entries[i].RecordID
+	sel.Entries = append(sel.Entries[:i], sel.Entries[i + 1:]...)
+	sel.LastErase = sel.now()
+	sel.ReservationID = 0
+	bmc.saveSEL()
+
+	return recordID, true
+}
+
+// ClearSEL removes all entries and cancels the reservation.
+func (bmc *BMC)ClearSEL() {
+	selLock.Lock()
+	defer selLock.Unlock()
+
+	sel := bmc.getSEL()
+	sel.Entries = []SELEntry{}
+	sel.NextRecordID = 1
+	sel.Overflow = false
+	sel.LastErase = sel.now()
+	sel.ReservationID = 0
+	bmc.saveSEL()
+
+	log.Printf("BMC %s: SEL is cleared.\n", bmc.Addr.String())
+}
+
+func (bmc *BMC)GetSELTime() uint32 {
+	selLock.Lock()
+	defer selLock.Unlock()
+
+	return bmc.getSEL().now()
+}
+
+func (bmc *BMC)SetSELTime(timestamp uint32) {
+	selLock.Lock()
+	defer selLock.Unlock()
+
+	sel := bmc.getSEL()
+	sel.TimeOffset = int64(timestamp) - time.Now().Unix()
+	bmc.saveSEL()
+}
+
+type SavedBMCSEL struct {
+	BMCIP	string
+	SEL	SEL
+}
+
+type SavedSELDatabase struct {
+	BMCs	[]SavedBMCSEL
+}
+
+func readSavedSELDatabase() (SavedSELDatabase, error) {
+	database := SavedSELDatabase{}
+
+	data, err := ioutil.ReadFile(SELFile)
+	if err != nil {
+		return database, err
+	}
+	err = json.Unmarshal(data, &database)
+	return database, err
+}
+
+// LoadSEL loads the saved SEL of the BMCs from SELFile.
+func LoadSEL() {
+	if len(SELFile) == 0 {
+		return
+	}
+
+	database, err := readSavedSELDatabase()
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Fatalf("Config: Failed to load SEL from %s: %s\n", SELFile, err.Error())
+	}
+
+	selLock.Lock()
+	defer selLock.Unlock()
+
+	for _, saved := range database.BMCs {
+		if _, ok := GetBMC(net.ParseIP(saved.BMCIP)); ! ok {
+			log.Printf("Config: BMC %s in %s is not found, ignore.\n", saved.BMCIP, SELFile)
+			continue
+		}
+
+		sel := saved.SEL
+		if sel.Entries == nil {
+			sel.Entries = []SELEntry{}
+		}
+		if sel.NextRecordID == SEL_RECORD_ID_FIRST || sel.NextRecordID == SEL_RECORD_ID_LAST {
+			sel.NextRecordID = 1
+		}
+		sels[saved.BMCIP] = &sel
+		log.Printf("Config: Load %d SEL entries of BMC %s\n", len(sel.Entries), saved.BMCIP)
+	}
+}
+
+// saveSEL saves the SEL of every BMC into SELFile. selLock must be held.
+func (bmc *BMC)saveSEL() {
+	if len(SELFile) == 0 {
+		return
+	}
+
+	ips := []string{}
+	for ip := range sels {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	database := SavedSELDatabase{}
+	for _, ip := range ips {
+		database.BMCs = append(database.BMCs, SavedBMCSEL{BMCIP: ip, SEL: *sels[ip]})
+	}
+
+	data, err := json.MarshalIndent(database, "", "\t")
+	if err != nil {
+		log.Printf("BMC %s: Failed to save SEL: %s\n", bmc.Addr.String(), err.Error())
+		return
+	}
+
+	err = WriteFileAtomic(SELFile, data, 0600)
+	if err != nil {
+		log.Printf("BMC %s: Failed to save SEL into %s: %s\n", bmc.Addr.String(), SELFile, err.Error())
+	}
+}
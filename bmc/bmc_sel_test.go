@@ -0,0 +1,141 @@
+package bmc
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rmxymh/infra-ecosphere/vm"
+)
+
+// newSELTestBMC registers a BMC whose SEL is saved into a temporary file.
+func newSELTestBMC(t *testing.T, ip string, capacity int, overwrite bool) BMC {
+	t.Helper()
+
+	savedSELFile := SELFile
+	SELFile = filepath.Join(t.TempDir(), "sel.json")
+
+	obj := AddBMC(net.ParseIP(ip), vm.Instance{})
+	obj.SELCapacity = capacity
+	obj.SELOverwrite = overwrite
+	obj.Save()
+
+	t.Cleanup(func() {
+		RemoveBMC(obj.Addr)
+		selLock.Lock()
+		delete(sels, obj.Addr.String())
+		selLock.Unlock()
+		SELFile = savedSELFile
+	})
+	return obj
+}
+
+func selTestEntry(sensorNumber uint8) SELEntry {
+	return SELEntry{
+		RecordType:   SEL_RECORD_TYPE_SYSTEM_EVENT,
+		GeneratorID:  SEL_GENERATOR_ID_BMC,
+		EvMRev:       SEL_EVM_REV_IPMI_V2,
+		SensorType:   SENSOR_TYPE_SYSTEM_EVENT,
+		SensorNumber: sensorNumber,
+		EventType:    SEL_EVENT_TYPE_SENSOR_SPECIFIC,
+		EventData:    [3]uint8{SYSTEM_EVENT_RECONFIGURED, SEL_EVENT_DATA_UNSPECIFIED, SEL_EVENT_DATA_UNSPECIFIED},
+	}
+}
+
+func selRecordIDs(sel SEL) []uint16 {
+	ids := []uint16{}
+	for _, entry := range sel.Entries {
+		ids = append(ids, entry.RecordID)
+	}
+	return ids
+}
+
+func TestSELFull(t *testing.T) {
+	tests := []struct {
+		name      string
+		ip        string
+		overwrite bool
+		wantOK    bool
+		wantIDs   []uint16
+	}{
+		{"without overwrite", "127.0.10.1", false, false, []uint16{1, 2, 3}},
+		{"with overwrite", "127.0.10.4", true, true, []uint16{2, 3, 4}},
+	}
+	for _, test := range tests {
+		obj := newSELTestBMC(t, test.ip, 3, test.overwrite)
+
+		for i := 1; i <= 3; i += 1 {
+			if _, ok := obj.AddSELEntry(selTestEntry(uint8(i))); !ok {
+				t.Fatalf("%s: entry %d is dropped", test.name, i)
+			}
+		}
+		if sel := obj.GetSEL(); sel.Overflow {
+			t.Errorf("%s: overflow before the SEL is full", test.name)
+		}
+
+		_, ok := obj.AddSELEntry(selTestEntry(4))
+		if ok != test.wantOK {
+			t.Errorf("%s: entry added to the full SEL = %v, want %v", test.name, ok, test.wantOK)
+		}
+		sel := obj.GetSEL()
+		if ids := selRecordIDs(sel); !reflect.DeepEqual(ids, test.wantIDs) {
+			t.Errorf("%s: record IDs = %v, want %v", test.name, ids, test.wantIDs)
+		}
+		if !sel.Overflow {
+			t.Errorf("%s: overflow is not set", test.name)
+		}
+
+		obj.ClearSEL()
+		if sel := obj.GetSEL(); len(sel.Entries) != 0 || sel.Overflow {
+			t.Errorf("%s: %d entries and overflow %v after Clear SEL", test.name, len(sel.Entries), sel.Overflow)
+		}
+	}
+}
+
+func TestSELReservationCancelled(t *testing.T) {
+	obj := newSELTestBMC(t, "127.0.10.2", 0, false)
+
+	changes := []struct {
+		name   string
+		change func()
+	}{
+		{"add", func() { obj.AddSELEntry(selTestEntry(1)) }},
+		{"delete", func() { obj.DeleteSELEntry(SEL_RECORD_ID_FIRST) }},
+		{"clear", func() { obj.ClearSEL() }},
+	}
+	for _, change := range changes {
+		reservationID := obj.ReserveSEL()
+		if !obj.IsSELReservationValid(reservationID) {
+			t.Fatalf("reservation 0x%04x is not valid", reservationID)
+		}
+		change.change()
+		if obj.IsSELReservationValid(reservationID) {
+			t.Errorf("reservation is valid after %s", change.name)
+		}
+	}
+}
+
+func TestSELPersistence(t *testing.T) {
+	obj := newSELTestBMC(t, "127.0.10.3", 0, false)
+
+	for i := 1; i <= 3; i += 1 {
+		obj.AddSELEntry(selTestEntry(uint8(i)))
+	}
+	obj.DeleteSELEntry(2)
+	saved := obj.GetSEL()
+
+	// Forget the SEL in memory and load it from the file.
+	selLock.Lock()
+	delete(sels, obj.Addr.String())
+	selLock.Unlock()
+	LoadSEL()
+
+	loaded := obj.GetSEL()
+	if !reflect.DeepEqual(loaded, saved) {
+		t.Errorf("loaded SEL = %+v, want %+v", loaded, saved)
+	}
+	if entry, _ := obj.AddSELEntry(selTestEntry(4)); entry.RecordID != 4 {
+		t.Errorf("record ID after loading = %d, want 4", entry.RecordID)
+	}
+}
@@ -0,0 +1,814 @@
+package bmc
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Sensors of a BMC are derived from the full and compact sensor records of
+// its SDR repository. Readings are kept as raw values, and full sensor
+// records convert them with y = (M * x + B * 10^BExp) * 10^RExp.
+
+// Sensor types (IPMI v2.0 Table 42-3) of the default sensors
+const (
+	SENSOR_TYPE_TEMPERATURE =		0x01
+	SENSOR_TYPE_VOLTAGE =			0x02
+	SENSOR_TYPE_FAN =			0x04
+)
+
+// Event/reading type codes (IPMI v2.0 Table 42-1)
+const (
+	SENSOR_EVENT_TYPE_THRESHOLD =		0x01
+	SENSOR_EVENT_TYPE_SENSOR_SPECIFIC =	SEL_EVENT_TYPE_SENSOR_SPECIFIC
+)
+
+// Entity IDs (IPMI v2.0 Table 43-13) of the default sensors
+const (
+	ENTITY_ID_PROCESSOR =		0x03
+	ENTITY_ID_SYSTEM_BOARD =	0x07
+	ENTITY_ID_POWER_SUPPLY =	0x0A
+	ENTITY_ID_POWER_UNIT =		0x13
+	ENTITY_ID_FAN =			0x1D
+	ENTITY_ID_AIR_INLET =		0x37
+)
+
+// Base units (IPMI v2.0 Table 43-15) of the default sensors
+const (
+	SENSOR_UNIT_DEGREES_C =		1
+	SENSOR_UNIT_VOLTS =		4
+	SENSOR_UNIT_RPM =		18
+)
+
+// Analog data format, Sensor Units 1 [7:6]
+const (
+	SENSOR_ANALOG_UNSIGNED =		0x00
+	SENSOR_ANALOG_ONES_COMPLEMENT =		0x40
+	SENSOR_ANALOG_TWOS_COMPLEMENT =		0x80
+	SENSOR_ANALOG_NONE =			0xC0
+	SENSOR_ANALOG_BITMASK =			0xC0
+)
+
+// Thresholds in the order of the threshold masks of the sensor commands
+const (
+	THRESHOLD_LOWER_NON_CRITICAL =		0
+	THRESHOLD_LOWER_CRITICAL =		1
+	THRESHOLD_LOWER_NON_RECOVERABLE =	2
+	THRESHOLD_UPPER_NON_CRITICAL =		3
+	THRESHOLD_UPPER_CRITICAL =		4
+	THRESHOLD_UPPER_NON_RECOVERABLE =	5
+	THRESHOLD_COUNT =			6
+
+	THRESHOLD_MASK_ALL =			0x3F
+)
+
+// Threshold events are numbered like the assertion event masks and the offsets
+// of threshold event records: going low of a lower threshold is event 2 * n,
+// and going high of an upper threshold is event 2 * n + 1.
+const (
+	SENSOR_THRESHOLD_EVENT_MASK =	0x0FFF
+	SENSOR_DISCRETE_EVENT_MASK =	0x7FFF
+
+	// Event Data 1 of threshold event records: the trigger reading is in
+	// Event Data 2 and the trigger threshold is in Event Data 3.
+	SEL_EVENT_DATA_THRESHOLD =	0x50
+)
+
+// Ramps change the reading once every SENSOR_RAMP_INTERVAL.
+const (
+	SENSOR_RAMP_INTERVAL =		time.Second
+)
+
+// Sensor initialization and capabilities of the default sensors: scanning,
+// events, thresholds, hysteresis and type enabled at initialization, auto
+// re-arm, readable and settable hysteresis and thresholds, and per-threshold
+// event control.
+const (
+	SDR_SENSOR_INITIALIZATION_DEFAULT =	0x7F
+	SDR_SENSOR_CAPABILITIES_DEFAULT =	0x68
+)
+
+type Sensor struct {
+	Number uint8
+	Name string
+	OwnerID uint8
+	OwnerLUN uint8
+	RecordID uint16
+	RecordType uint8
+	EntityID uint8
+	EntityInstance uint8
+	SensorType uint8
+	EventType uint8
+	AnalogFormat uint8
+	BaseUnit uint8
+
+	// Conversion factors, only in full sensor records
+	Linearization uint8
+	M int16
+	B int16
+	BExp int8
+	RExp int8
+	Factors [6]uint8		// bytes 25 ~ 30 of the full sensor record
+
+	ReadableThresholds uint8
+	SettableThresholds uint8
+	Thresholds [THRESHOLD_COUNT]uint8
+	PositiveHysteresis uint8
+	NegativeHysteresis uint8
+
+	AssertionEnables uint16		// events logged when they are asserted
+	DeassertionEnables uint16	// events logged when they are deasserted
+
+	Reading uint8			// raw reading of threshold sensors
+	States uint16			// asserted states of discrete sensors
+	ThresholdEvents uint16		// threshold events in effect, with the hysteresis applied
+	DeassertedEvents uint16		// events deasserted since they were last asserted
+}
+
+// signExtend interprets the lowest bits of value as a two's complement number.
+func signExtend(value int, bits uint) int {
+	if value & (1 << (bits - 1)) != 0 {
+		value -= 1 << bits
+	}
+	return value
+}
+
+// ParseSensors derives the sensors of a full or compact sensor record. A
+// compact sensor record may describe several sensors with the same settings.
+func ParseSensors(record SDRRecord) []Sensor {
+	sensor := Sensor{
+		RecordID: record.RecordID(),
+		RecordType: record.RecordType(),
+	}
+	shareCount := 1
+	sharing := uint16(0)
+
+	switch record.RecordType() {
+	case SDR_RECORD_TYPE_FULL_SENSOR:
+		body := SDRFullSensorBody{}
+		name := record.ReadBody(&body)
+		sensor.OwnerID = body.OwnerID
+		sensor.OwnerLUN = body.OwnerLUN & SDR_SENSOR_OWNER_LUN_BITMASK
+		sensor.Number = body.SensorNumber
+		sensor.Name = parseIDString(body.IDStringTypeLength, name)
+		sensor.EntityID = body.EntityID
+		sensor.EntityInstance = body.EntityInstance
+		sensor.SensorType = body.SensorType
+		sensor.EventType = body.EventType
+		sensor.AnalogFormat = body.Units1 & SENSOR_ANALOG_BITMASK
+		sensor.BaseUnit = body.BaseUnit
+		sensor.Linearization = body.Linearization
+		sensor.M = int16(signExtend(int(body.M) | int(body.MTolerance & 0xC0) << 2, 10))
+		sensor.B = int16(signExtend(int(body.B) | int(body.BAccuracy & 0xC0) << 2, 10))
+		sensor.RExp = int8(signExtend(int(body.Exponents >> 4), 4))
+		sensor.BExp = int8(signExtend(int(body.Exponents & 0x0F), 4))
+		sensor.Factors = [6]uint8{body.M, body.MTolerance, body.B, body.BAccuracy, body.AccuracyDirection, body.Exponents}
+		sensor.Thresholds = [THRESHOLD_COUNT]uint8{
+			body.LowerNonCritical, body.LowerCritical, body.LowerNonRecoverable,
+			body.UpperNonCritical, body.UpperCritical, body.UpperNonRecoverable,
+		}
+		sensor.PositiveHysteresis = body.PositiveHysteresis
+		sensor.NegativeHysteresis = body.NegativeHysteresis
+		sensor.setEventMasks(body.AssertionMask, body.DeassertionMask, body.ReadingMask)
+		sensor.Reading = sensor.initialReading(body)
+	case SDR_RECORD_TYPE_COMPACT_SENSOR:
+		body := SDRCompactSensorBody{}
+		name := record.ReadBody(&body)
+		sensor.OwnerID = body.OwnerID
+		sensor.OwnerLUN = body.OwnerLUN & SDR_SENSOR_OWNER_LUN_BITMASK
+		sensor.Number = body.SensorNumber
+		sensor.Name = parseIDString(body.IDStringTypeLength, name)
+		sensor.EntityID = body.EntityID
+		sensor.EntityInstance = body.EntityInstance
+		sensor.SensorType = body.SensorType
+		sensor.EventType = body.EventType
+		sensor.AnalogFormat = SENSOR_ANALOG_NONE
+		sensor.BaseUnit = body.BaseUnit
+		sensor.PositiveHysteresis = body.PositiveHysteresis
+		sensor.NegativeHysteresis = body.NegativeHysteresis
+		sensor.setEventMasks(body.AssertionMask, body.DeassertionMask, body.ReadingMask)
+		sharing = body.RecordSharing
+		shareCount = int(sharing & SDR_SHARE_COUNT_BITMASK)
+		if shareCount == 0 {
+			shareCount = 1
+		}
+	default:
+		return []Sensor{}
+	}
+
+	sensors := []Sensor{}
+	for i := 0; i < shareCount; i++ {
+		shared := sensor
+		if shareCount > 1 {
+			shared.Number += uint8(i)
+			shared.Name += idStringInstanceModifier(sharing, i)
+			if sharing & SDR_SHARE_ENTITY_INSTANCE_INCREMENTS != 0 {
+				shared.EntityInstance += uint8(i)
+			}
+		}
+		shared.updateThresholdEvents()
+		sensors = append(sensors, shared)
+	}
+	return sensors
+}
+
+// idStringInstanceModifier returns the suffix of the ID string of the i-th
+// sensor which shares a compact sensor record, numeric (e.g. "Fan 1") or
+// alpha (e.g. "Fan A").
+func idStringInstanceModifier(sharing uint16, i int) string {
+	n := int(sharing >> 8 & SDR_SHARE_MODIFIER_OFFSET_BITMASK) + i
+	if sharing & SDR_SHARE_MODIFIER_TYPE_BITMASK == SDR_SHARE_MODIFIER_TYPE_ALPHA {
+		suffix := ""
+		for {
+			suffix = string(rune('A' + n % 26)) + suffix
+			n = n / 26 - 1
+			if n < 0 {
+				return suffix
+			}
+		}
+	}
+	return strconv.Itoa(n)
+}
+
+// initialReading is the nominal reading of a full sensor record, or the middle
+// of its normal range, or the middle of its readable thresholds, so that a
+// sensor does not start beyond its thresholds.
+func (sensor *Sensor)initialReading(body SDRFullSensorBody) uint8 {
+	if body.AnalogFlags & SDR_ANALOG_FLAG_NOMINAL_READING != 0 {
+		return body.NominalReading
+	}
+	if body.AnalogFlags & (SDR_ANALOG_FLAG_NORMAL_MAXIMUM | SDR_ANALOG_FLAG_NORMAL_MINIMUM) == SDR_ANALOG_FLAG_NORMAL_MAXIMUM | SDR_ANALOG_FLAG_NORMAL_MINIMUM {
+		return sensor.encodeRaw((sensor.rawValue(body.NormalMaximum) + sensor.rawValue(body.NormalMinimum)) / 2)
+	}
+
+	lower, upper := sensor.rawValue(body.SensorMinimum), sensor.rawValue(body.SensorMaximum)
+	if lower >= upper {
+		lower, upper = sensor.rawMinimum(), sensor.rawMaximum()
+	}
+	for i := 0; i < THRESHOLD_COUNT; i++ {
+		if sensor.ReadableThresholds & (1 << uint(i)) == 0 {
+			continue
+		}
+		threshold := sensor.rawValue(sensor.Thresholds[i])
+		if i < THRESHOLD_UPPER_NON_CRITICAL && threshold >= lower {
+			lower = threshold + 1
+		}
+		if i >= THRESHOLD_UPPER_NON_CRITICAL && threshold <= upper {
+			upper = threshold - 1
+		}
+	}
+	return sensor.encodeRaw((lower + upper) / 2)
+}
+
+// rawMinimum and rawMaximum are the range of raw values in the analog data
+// format of the sensor.
+func (sensor *Sensor)rawMinimum() int {
+	if sensor.AnalogFormat == SENSOR_ANALOG_ONES_COMPLEMENT || sensor.AnalogFormat == SENSOR_ANALOG_TWOS_COMPLEMENT {
+		return -127
+	}
+	return 0
+}
+
+func (sensor *Sensor)rawMaximum() int {
+	if sensor.AnalogFormat == SENSOR_ANALOG_ONES_COMPLEMENT || sensor.AnalogFormat == SENSOR_ANALOG_TWOS_COMPLEMENT {
+		return 127
+	}
+	return 255
+}
+
+// setEventMasks decodes the event and reading masks of a sensor record, which
+// also carry the readable and settable thresholds of threshold sensors.
+func (sensor *Sensor)setEventMasks(assertionMask uint16, deassertionMask uint16, readingMask uint16) {
+	if ! sensor.IsThreshold() {
+		sensor.AssertionEnables = assertionMask & SENSOR_DISCRETE_EVENT_MASK
+		sensor.DeassertionEnables = deassertionMask & SENSOR_DISCRETE_EVENT_MASK
+		return
+	}
+
+	sensor.AssertionEnables = assertionMask & SENSOR_THRESHOLD_EVENT_MASK
+	sensor.DeassertionEnables = deassertionMask & SENSOR_THRESHOLD_EVENT_MASK
+	sensor.ReadableThresholds = uint8(readingMask) & THRESHOLD_MASK_ALL
+	sensor.SettableThresholds = uint8(readingMask >> 8) & THRESHOLD_MASK_ALL
+}
+
+func (sensor *Sensor)IsThreshold() bool {
+	return sensor.EventType == SENSOR_EVENT_TYPE_THRESHOLD
+}
+
+// rawValue interprets a raw value in the analog data format of the sensor.
+func (sensor *Sensor)rawValue(raw uint8) int {
+	switch sensor.AnalogFormat {
+	case SENSOR_ANALOG_ONES_COMPLEMENT:
+		if raw & 0x80 != 0 {
+			return -int(^raw)
+		}
+	case SENSOR_ANALOG_TWOS_COMPLEMENT:
+		return int(int8(raw))
+	}
+	return int(raw)
+}
+
+// encodeRaw is the inverse of rawValue, and clamps the value to the range of
+// the analog data format.
+func (sensor *Sensor)encodeRaw(value int) uint8 {
+	switch sensor.AnalogFormat {
+	case SENSOR_ANALOG_ONES_COMPLEMENT, SENSOR_ANALOG_TWOS_COMPLEMENT:
+		if value > 127 {
+			value = 127
+		}
+		if value < -127 {
+			value = -127
+		}
+		if value < 0 && sensor.AnalogFormat == SENSOR_ANALOG_ONES_COMPLEMENT {
+			return ^uint8(-value)
+		}
+		return uint8(int8(value))
+	}
+
+	if value > 255 {
+		value = 255
+	}
+	if value < 0 {
+		value = 0
+	}
+	return uint8(value)
+}
+
+// Convert returns the value of a raw reading or threshold in the base unit.
+// Only linear sensors are converted.
+func (sensor *Sensor)Convert(raw uint8) float64 {
+	value := float64(sensor.M) * float64(sensor.rawValue(raw)) + float64(sensor.B) * math.Pow10(int(sensor.BExp))
+	return value * math.Pow10(int(sensor.RExp))
+}
+
+// RawValue returns the raw reading or threshold closest to the value in the
+// base unit.
+func (sensor *Sensor)RawValue(value float64) uint8 {
+	if sensor.M == 0 {
+		return 0
+	}
+	raw := (value / math.Pow10(int(sensor.RExp)) - float64(sensor.B) * math.Pow10(int(sensor.BExp))) / float64(sensor.M)
+	return sensor.encodeRaw(int(math.Max(-256, math.Min(256, math.Floor(raw + 0.5)))))
+}
+
+// ThresholdStatus returns the threshold comparison status of the reading in
+// the layout of the Get Sensor Reading response, bit 0 for at or below the
+// lower non-critical threshold ~ bit 5 for at or above the upper
+// non-recoverable threshold. Only readable thresholds are compared.
+func (sensor *Sensor)ThresholdStatus() uint8 {
+	status := uint8(0)
+	reading := sensor.rawValue(sensor.Reading)
+	for i := 0; i < THRESHOLD_COUNT; i++ {
+		if sensor.ReadableThresholds & (1 << uint(i)) == 0 {
+			continue
+		}
+		threshold := sensor.rawValue(sensor.Thresholds[i])
+		if i < THRESHOLD_UPPER_NON_CRITICAL && reading <= threshold {
+			status |= 1 << uint(i)
+		}
+		if i >= THRESHOLD_UPPER_NON_CRITICAL && reading >= threshold {
+			status |= 1 << uint(i)
+		}
+	}
+	return status
+}
+
+// thresholdEvent returns the event of the threshold, going low for a lower
+// threshold and going high for an upper threshold.
+func thresholdEvent(threshold int) uint8 {
+	if threshold < THRESHOLD_UPPER_NON_CRITICAL {
+		return uint8(2 * threshold)
+	}
+	return uint8(2 * threshold + 1)
+}
+
+// updateThresholdEvents compares the reading with the readable thresholds. An
+// event is asserted when the reading reaches its threshold, and deasserted when
+// the reading goes back past the threshold by more than the hysteresis. It
+// returns the SEL records of the enabled events which change.
+func (sensor *Sensor)updateThresholdEvents() []SELEntry {
+	entries := []SELEntry{}
+	if ! sensor.IsThreshold() {
+		return entries
+	}
+
+	reading := sensor.rawValue(sensor.Reading)
+	for i := 0; i < THRESHOLD_COUNT; i++ {
+		event := thresholdEvent(i)
+		bit := uint16(1) << event
+		inEffect := sensor.ThresholdEvents & bit != 0
+
+		if sensor.ReadableThresholds & (1 << uint(i)) == 0 {
+			inEffect = false
+		} else if threshold := sensor.rawValue(sensor.Thresholds[i]); i < THRESHOLD_UPPER_NON_CRITICAL {
+			if reading <= threshold {
+				inEffect = true
+			} else if reading > threshold + int(sensor.NegativeHysteresis) {
+				inEffect = false
+			}
+		} else {
+			if reading >= threshold {
+				inEffect = true
+			} else if reading < threshold - int(sensor.PositiveHysteresis) {
+				inEffect = false
+			}
+		}
+
+		if inEffect == (sensor.ThresholdEvents & bit != 0) {
+			continue
+		}
+		if inEffect {
+			sensor.ThresholdEvents |= bit
+			sensor.DeassertedEvents &^= bit
+			if sensor.AssertionEnables & bit != 0 {
+				entries = append(entries, sensor.thresholdEventEntry(i, true))
+			}
+		} else {
+			sensor.ThresholdEvents &^= bit
+			sensor.DeassertedEvents |= bit
+			if sensor.DeassertionEnables & bit != 0 {
+				entries = append(entries, sensor.thresholdEventEntry(i, false))
+			}
+		}
+	}
+	return entries
+}
+
+func (sensor *Sensor)thresholdEventEntry(threshold int, asserted bool) SELEntry {
+	entry := SELEntry{
+		RecordType: SEL_RECORD_TYPE_SYSTEM_EVENT,
+		GeneratorID: SEL_GENERATOR_ID_BMC,
+		EvMRev: SEL_EVM_REV_IPMI_V2,
+		SensorType: sensor.SensorType,
+		SensorNumber: sensor.Number,
+		EventType: SENSOR_EVENT_TYPE_THRESHOLD,
+		EventData: [3]uint8{SEL_EVENT_DATA_THRESHOLD | thresholdEvent(threshold), sensor.Reading, sensor.Thresholds[threshold]},
+	}
+	if ! asserted {
+		entry.EventType |= SEL_EVENT_DIR_DEASSERTION
+	}
+	return entry
+}
+
+// EventStatus returns the enabled events which are asserted, and those which
+// have been deasserted, in the layout of the Get Sensor Event Status response.
+func (sensor *Sensor)EventStatus() (asserted uint16, deasserted uint16) {
+	if sensor.IsThreshold() {
+		return sensor.ThresholdEvents & sensor.AssertionEnables, sensor.DeassertedEvents & sensor.DeassertionEnables
+	}
+	return sensor.States & sensor.AssertionEnables, 0
+}
+
+// updatePowerStates asserts the states of the sensors which follow the power
+// of the VM.
+func (sensor *Sensor)updatePowerStates(powerOn bool) {
+	if sensor.EventType != SENSOR_EVENT_TYPE_SENSOR_SPECIFIC {
+		return
+	}
+
+	switch sensor.SensorType {
+	case SENSOR_TYPE_POWER_UNIT:
+		sensor.States &^= 1 << POWER_UNIT_POWER_OFF
+		if ! powerOn {
+			sensor.States |= 1 << POWER_UNIT_POWER_OFF
+		}
+	case SENSOR_TYPE_SYSTEM_ACPI_POWER_STATE:
+		sensor.States = 1 << ACPI_POWER_STATE_S0_WORKING
+		if ! powerOn {
+			sensor.States = 1 << ACPI_POWER_STATE_S5_SOFT_OFF
+		}
+	}
+}
+
+// GetSensor returns a copy of the sensor of the sensor number.
+func (bmc *BMC)GetSensor(number uint8) (Sensor, bool) {
+	powerOn := bmc.IsPowerOn()
+
+	sdrLock.Lock()
+	defer sdrLock.Unlock()
+
+	sensor, ok := bmc.getSDRRepository().Sensors[number]
+	if ! ok {
+		return Sensor{}, false
+	}
+	sensor.updatePowerStates(powerOn)
+	return *sensor, true
+}
+
+// GetSensors returns copies of all sensors ordered by sensor number.
+func (bmc *BMC)GetSensors() []Sensor {
+	powerOn := bmc.IsPowerOn()
+
+	sdrLock.Lock()
+	defer sdrLock.Unlock()
+
+	sensors := []Sensor{}
+	for _, sensor := range bmc.getSDRRepository().Sensors {
+		sensor.updatePowerStates(powerOn)
+		sensors = append(sensors, *sensor)
+	}
+	sort.Slice(sensors, func(i, j int) bool {
+		return sensors[i].Number < sensors[j].Number
+	})
+	return sensors
+}
+
+// updateSensor changes the sensor with update while sdrLock is held, and logs
+// the threshold events which the change asserts or deasserts. update returns
+// false to leave the sensor as it is.
+func (bmc *BMC)updateSensor(number uint8, update func(repository *SDRRepository, sensor *Sensor) bool) bool {
+	sdrLock.Lock()
+	repository := bmc.getSDRRepository()
+	sensor, ok := repository.Sensors[number]
+	if ! ok || ! update(repository, sensor) {
+		sdrLock.Unlock()
+		return false
+	}
+	entries := sensor.updateThresholdEvents()
+	sdrLock.Unlock()
+
+	for _, entry := range entries {
+		if entry, ok := bmc.AddSELEntry(entry); ok {
+			log.Printf("BMC %s: Add SEL entry 0x%04x: sensor 0x%02x, threshold event 0x%02x, reading 0x%02x, asserted %t\n", bmc.Addr.String(), entry.RecordID, number, entry.EventData[0] & 0x0F, entry.EventData[1], entry.EventType & SEL_EVENT_DIR_DEASSERTION == 0)
+		}
+	}
+	return true
+}
+
+// SetSensorThresholds sets the thresholds of the mask. It fails if the sensor
+// does not exist or a threshold of the mask is not settable.
+func (bmc *BMC)SetSensorThresholds(number uint8, mask uint8, thresholds [THRESHOLD_COUNT]uint8) bool {
+	return bmc.updateSensor(number, func(repository *SDRRepository, sensor *Sensor) bool {
+		if mask & ^sensor.SettableThresholds != 0 {
+			return false
+		}
+		for i := 0; i < THRESHOLD_COUNT; i++ {
+			if mask & (1 << uint(i)) != 0 {
+				sensor.Thresholds[i] = thresholds[i]
+			}
+		}
+		return true
+	})
+}
+
+func (bmc *BMC)SetSensorHysteresis(number uint8, positive uint8, negative uint8) bool {
+	return bmc.updateSensor(number, func(repository *SDRRepository, sensor *Sensor) bool {
+		sensor.PositiveHysteresis = positive
+		sensor.NegativeHysteresis = negative
+		return true
+	})
+}
+
+// stopRamp stops the ramp of the sensor, if any. sdrLock must be held.
+func (repository *SDRRepository)stopRamp(number uint8) {
+	if stop, ok := repository.ramps[number]; ok {
+		close(stop)
+		delete(repository.ramps, number)
+	}
+}
+
+// SetSensorReading sets the raw reading of a threshold sensor and stops its
+// ramp.
+func (bmc *BMC)SetSensorReading(number uint8, reading uint8) bool {
+	return bmc.updateSensor(number, func(repository *SDRRepository, sensor *Sensor) bool {
+		if ! sensor.IsThreshold() {
+			return false
+		}
+		repository.stopRamp(number)
+		sensor.Reading = reading
+		return true
+	})
+}
+
+// RampSensorReading changes the reading of a threshold sensor linearly to the
+// raw reading over the duration, and replaces the ramp of the sensor, if any.
+func (bmc *BMC)RampSensorReading(number uint8, reading uint8, duration time.Duration) bool {
+	if duration <= 0 {
+		return bmc.SetSensorReading(number, reading)
+	}
+
+	stop := make(chan bool)
+	sensor := Sensor{}
+	ok := bmc.updateSensor(number, func(repository *SDRRepository, current *Sensor) bool {
+		if ! current.IsThreshold() {
+			return false
+		}
+		repository.stopRamp(number)
+		repository.ramps[number] = stop
+		sensor = *current
+		return true
+	})
+	if ok {
+		go bmc.rampSensor(sensor, reading, duration, stop)
+	}
+	return ok
+}
+
+func (bmc *BMC)rampSensor(sensor Sensor, reading uint8, duration time.Duration, stop chan bool) {
+	from := sensor.rawValue(sensor.Reading)
+	to := sensor.rawValue(reading)
+	begin := time.Now()
+
+	ticker := time.NewTicker(SENSOR_RAMP_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			progress := math.Min(1, float64(now.Sub(begin)) / float64(duration))
+			done := progress >= 1
+			raw := sensor.encodeRaw(from + int(math.Floor(float64(to - from) * progress + 0.5)))
+
+			ok := bmc.updateSensor(sensor.Number, func(repository *SDRRepository, current *Sensor) bool {
+				if repository.ramps[sensor.Number] != stop {
+					return false
+				}
+				if done {
+					delete(repository.ramps, sensor.Number)
+				}
+				current.Reading = raw
+				return true
+			})
+			if ! ok || done {
+				return
+			}
+		}
+	}
+}
+
+// defaultThresholdSensor describes a linear threshold sensor of the default
+// sensor set in its base unit.
+type defaultThresholdSensor struct {
+	number uint8
+	name string
+	entityID uint8
+	entityInstance uint8
+	sensorType uint8
+	unit uint8
+	m int16
+	rExp int8
+	reading float64
+	thresholds map[int]float64
+}
+
+// defaultDiscreteSensor describes a sensor-specific discrete sensor of the
+// default sensor set.
+type defaultDiscreteSensor struct {
+	number uint8
+	name string
+	entityID uint8
+	sensorType uint8
+	states uint16
+}
+
+var defaultThresholdSensors = []defaultThresholdSensor{
+	{0x10, "CPU1 Temp", ENTITY_ID_PROCESSOR, 1, SENSOR_TYPE_TEMPERATURE, SENSOR_UNIT_DEGREES_C, 1, 0, 45, map[int]float64{
+		THRESHOLD_UPPER_NON_CRITICAL: 85, THRESHOLD_UPPER_CRITICAL: 90, THRESHOLD_UPPER_NON_RECOVERABLE: 95}},
+	{0x11, "CPU2 Temp", ENTITY_ID_PROCESSOR, 2, SENSOR_TYPE_TEMPERATURE, SENSOR_UNIT_DEGREES_C, 1, 0, 43, map[int]float64{
+		THRESHOLD_UPPER_NON_CRITICAL: 85, THRESHOLD_UPPER_CRITICAL: 90, THRESHOLD_UPPER_NON_RECOVERABLE: 95}},
+	{0x12, "Inlet Temp", ENTITY_ID_AIR_INLET, 1, SENSOR_TYPE_TEMPERATURE, SENSOR_UNIT_DEGREES_C, 1, 0, 24, map[int]float64{
+		THRESHOLD_LOWER_CRITICAL: 3, THRESHOLD_LOWER_NON_CRITICAL: 8, THRESHOLD_UPPER_NON_CRITICAL: 42, THRESHOLD_UPPER_CRITICAL: 47}},
+	{0x20, "Fan1", ENTITY_ID_FAN, 1, SENSOR_TYPE_FAN, SENSOR_UNIT_RPM, 60, 0, 6000, map[int]float64{
+		THRESHOLD_LOWER_NON_RECOVERABLE: 300, THRESHOLD_LOWER_CRITICAL: 600, THRESHOLD_LOWER_NON_CRITICAL: 1200}},
+	{0x21, "Fan2", ENTITY_ID_FAN, 2, SENSOR_TYPE_FAN, SENSOR_UNIT_RPM, 60, 0, 6060, map[int]float64{
+		THRESHOLD_LOWER_NON_RECOVERABLE: 300, THRESHOLD_LOWER_CRITICAL: 600, THRESHOLD_LOWER_NON_CRITICAL: 1200}},
+	{0x22, "Fan3", ENTITY_ID_FAN, 3, SENSOR_TYPE_FAN, SENSOR_UNIT_RPM, 60, 0, 5940, map[int]float64{
+		THRESHOLD_LOWER_NON_RECOVERABLE: 300, THRESHOLD_LOWER_CRITICAL: 600, THRESHOLD_LOWER_NON_CRITICAL: 1200}},
+	{0x23, "Fan4", ENTITY_ID_FAN, 4, SENSOR_TYPE_FAN, SENSOR_UNIT_RPM, 60, 0, 6000, map[int]float64{
+		THRESHOLD_LOWER_NON_RECOVERABLE: 300, THRESHOLD_LOWER_CRITICAL: 600, THRESHOLD_LOWER_NON_CRITICAL: 1200}},
+	{0x30, "PSU1 12V", ENTITY_ID_POWER_SUPPLY, 1, SENSOR_TYPE_VOLTAGE, SENSOR_UNIT_VOLTS, 6, -2, 12, map[int]float64{
+		THRESHOLD_LOWER_CRITICAL: 10.8, THRESHOLD_LOWER_NON_CRITICAL: 11.4, THRESHOLD_UPPER_NON_CRITICAL: 12.6, THRESHOLD_UPPER_CRITICAL: 13.2}},
+	{0x31, "PSU2 12V", ENTITY_ID_POWER_SUPPLY, 2, SENSOR_TYPE_VOLTAGE, SENSOR_UNIT_VOLTS, 6, -2, 12.06, map[int]float64{
+		THRESHOLD_LOWER_CRITICAL: 10.8, THRESHOLD_LOWER_NON_CRITICAL: 11.4, THRESHOLD_UPPER_NON_CRITICAL: 12.6, THRESHOLD_UPPER_CRITICAL: 13.2}},
+	{0x32, "PSU1 5V", ENTITY_ID_POWER_SUPPLY, 1, SENSOR_TYPE_VOLTAGE, SENSOR_UNIT_VOLTS, 3, -2, 5.01, map[int]float64{
+		THRESHOLD_LOWER_CRITICAL: 4.5, THRESHOLD_LOWER_NON_CRITICAL: 4.74, THRESHOLD_UPPER_NON_CRITICAL: 5.25, THRESHOLD_UPPER_CRITICAL: 5.49}},
+	{0x33, "PSU1 3.3V", ENTITY_ID_POWER_SUPPLY, 1, SENSOR_TYPE_VOLTAGE, SENSOR_UNIT_VOLTS, 2, -2, 3.3, map[int]float64{
+		THRESHOLD_LOWER_CRITICAL: 2.98, THRESHOLD_LOWER_NON_CRITICAL: 3.14, THRESHOLD_UPPER_NON_CRITICAL: 3.46, THRESHOLD_UPPER_CRITICAL: 3.64}},
+}
+
+var defaultDiscreteSensors = []defaultDiscreteSensor{
+	{SENSOR_NUMBER_POWER_UNIT, "Power Unit", ENTITY_ID_POWER_UNIT, SENSOR_TYPE_POWER_UNIT,
+		1 << POWER_UNIT_POWER_OFF},
+	{SENSOR_NUMBER_ACPI_POWER_STATE, "ACPI State", ENTITY_ID_SYSTEM_BOARD, SENSOR_TYPE_SYSTEM_ACPI_POWER_STATE,
+		1 << ACPI_POWER_STATE_S0_WORKING | 1 << ACPI_POWER_STATE_S5_SOFT_OFF},
+}
+
+// Sensors which only generate events, named for the SEL
+var defaultEventOnlySensors = []defaultDiscreteSensor{
+	{SENSOR_NUMBER_SYSTEM_EVENT, "System Event", ENTITY_ID_SYSTEM_BOARD, SENSOR_TYPE_SYSTEM_EVENT,
+		1 << SYSTEM_EVENT_RECONFIGURED},
+	{SENSOR_NUMBER_SYSTEM_RESTART, "System Restart", ENTITY_ID_SYSTEM_BOARD, SENSOR_TYPE_SYSTEM_RESTART,
+		1 << SYSTEM_RESTART_HARD_RESET},
+}
+
+func newFullSensorBody(sensor defaultThresholdSensor) SDRFullSensorBody {
+	converter := Sensor{M: sensor.m, RExp: sensor.rExp, AnalogFormat: SENSOR_ANALOG_UNSIGNED}
+
+	thresholds := [THRESHOLD_COUNT]uint8{}
+	mask := uint8(0)
+	for i, value := range sensor.thresholds {
+		thresholds[i] = converter.RawValue(value)
+		mask |= 1 << uint(i)
+	}
+
+	// Each threshold asserts and deasserts the going-low event of a lower
+	// threshold or the going-high event of an upper threshold.
+	events := uint16(0)
+	for i := 0; i < THRESHOLD_COUNT; i++ {
+		if mask & (1 << uint(i)) == 0 {
+			continue
+		}
+		if i < THRESHOLD_UPPER_NON_CRITICAL {
+			events |= 1 << uint(2 * i)
+		} else {
+			events |= 1 << uint(2 * i + 1)
+		}
+	}
+
+	lowerMask := uint16(mask & 0x07)
+	upperMask := uint16(mask >> 3)
+	return SDRFullSensorBody{
+		OwnerID: SDR_OWNER_ID_BMC,
+		SensorNumber: sensor.number,
+		EntityID: sensor.entityID,
+		EntityInstance: sensor.entityInstance,
+		Initialization: SDR_SENSOR_INITIALIZATION_DEFAULT,
+		Capabilities: SDR_SENSOR_CAPABILITIES_DEFAULT,
+		SensorType: sensor.sensorType,
+		EventType: SENSOR_EVENT_TYPE_THRESHOLD,
+		AssertionMask: events | lowerMask << 12,
+		DeassertionMask: events | upperMask << 12,
+		ReadingMask: uint16(mask) << 8 | uint16(mask),
+		Units1: SENSOR_ANALOG_UNSIGNED,
+		BaseUnit: sensor.unit,
+		M: uint8(sensor.m),
+		MTolerance: uint8(sensor.m >> 2) & 0xC0,
+		Exponents: uint8(sensor.rExp) << 4,
+		AnalogFlags: SDR_ANALOG_FLAG_NOMINAL_READING,
+		NominalReading: converter.RawValue(sensor.reading),
+		SensorMaximum: 0xFF,
+		UpperNonRecoverable: thresholds[THRESHOLD_UPPER_NON_RECOVERABLE],
+		UpperCritical: thresholds[THRESHOLD_UPPER_CRITICAL],
+		UpperNonCritical: thresholds[THRESHOLD_UPPER_NON_CRITICAL],
+		LowerNonRecoverable: thresholds[THRESHOLD_LOWER_NON_RECOVERABLE],
+		LowerCritical: thresholds[THRESHOLD_LOWER_CRITICAL],
+		LowerNonCritical: thresholds[THRESHOLD_LOWER_NON_CRITICAL],
+	}
+}
+
+func newCompactSensorBody(sensor defaultDiscreteSensor) SDRCompactSensorBody {
+	return SDRCompactSensorBody{
+		OwnerID: SDR_OWNER_ID_BMC,
+		SensorNumber: sensor.number,
+		EntityID: sensor.entityID,
+		EntityInstance: 1,
+		Initialization: SDR_SENSOR_INITIALIZATION_DEFAULT,
+		Capabilities: 0x40,		// auto re-arm
+		SensorType: sensor.sensorType,
+		EventType: SENSOR_EVENT_TYPE_SENSOR_SPECIFIC,
+		AssertionMask: sensor.states,
+		DeassertionMask: sensor.states,
+		ReadingMask: sensor.states,
+		Units1: SENSOR_ANALOG_NONE,
+	}
+}
+
+func newEventOnlyBody(sensor defaultDiscreteSensor) SDREventOnlyBody {
+	return SDREventOnlyBody{
+		OwnerID: SDR_OWNER_ID_BMC,
+		SensorNumber: sensor.number,
+		EntityID: sensor.entityID,
+		EntityInstance: 1,
+		SensorType: sensor.sensorType,
+		EventType: SENSOR_EVENT_TYPE_SENSOR_SPECIFIC,
+	}
+}
+
+// DefaultSDRRecords returns the records of the default sensor set: the BMC
+// itself, the power state, CPU and inlet temperatures, fans and PSU voltages.
+func DefaultSDRRecords() []SDRRecord {
+	records := []SDRRecord{}
+	add := func(recordType uint8, body interface{}, name string) {
+		records = append(records, NewSDRRecord(uint16(len(records) + 1), recordType, body, name))
+	}
+
+	add(SDR_RECORD_TYPE_MC_DEVICE_LOCATOR, &SDRMCDeviceLocatorBody{
+		SlaveAddress: SDR_OWNER_ID_BMC,
+		Capabilities: 0x87,		// chassis device, SEL, SDR repository and sensor device
+		EntityID: ENTITY_ID_SYSTEM_BOARD,
+		EntityInstance: 1,
+	}, "BMC")
+	for _, sensor := range defaultDiscreteSensors {
+		add(SDR_RECORD_TYPE_COMPACT_SENSOR, newCompactSensorBody(sensor), sensor.name)
+	}
+	for _, sensor := range defaultEventOnlySensors {
+		add(SDR_RECORD_TYPE_EVENT_ONLY, newEventOnlyBody(sensor), sensor.name)
+	}
+	for _, sensor := range defaultThresholdSensors {
+		add(SDR_RECORD_TYPE_FULL_SENSOR, newFullSensorBody(sensor), sensor.name)
+	}
+	return records
+}
\ No newline at end of file
@@ -0,0 +1,114 @@
+package bmc
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSensorLinearConversion(t *testing.T) {
+	tests := []struct {
+		name   string
+		sensor Sensor
+		raw    uint8
+		value  float64
+	}{
+		{"temperature", Sensor{M: 1}, 45, 45},
+		{"fan", Sensor{M: 60}, 100, 6000},
+		{"12V", Sensor{M: 6, RExp: -2}, 200, 12},
+		{"3.3V", Sensor{M: 2, RExp: -2}, 165, 3.3},
+		{"offset", Sensor{M: 1, B: 5, BExp: 1}, 10, 60},
+		{"negative offset", Sensor{M: 5, B: -20, RExp: -1}, 100, 48},
+		{"two's complement", Sensor{M: 2, B: -50, RExp: -1, AnalogFormat: SENSOR_ANALOG_TWOS_COMPLEMENT}, 0xF6, -7},
+		{"one's complement", Sensor{M: 1, AnalogFormat: SENSOR_ANALOG_ONES_COMPLEMENT}, 0xF5, -10},
+	}
+	for _, test := range tests {
+		if value := test.sensor.Convert(test.raw); math.Abs(value-test.value) > 1e-9 {
+			t.Errorf("%s: Convert(0x%02x) = %v, want %v", test.name, test.raw, value, test.value)
+		}
+		if raw := test.sensor.RawValue(test.value); raw != test.raw {
+			t.Errorf("%s: RawValue(%v) = 0x%02x, want 0x%02x", test.name, test.value, raw, test.raw)
+		}
+	}
+}
+
+func TestSensorRawValueClamped(t *testing.T) {
+	sensor := Sensor{M: 60}
+	if raw := sensor.RawValue(100000); raw != 0xFF {
+		t.Errorf("RawValue above the range = 0x%02x, want 0xff", raw)
+	}
+	if raw := sensor.RawValue(-600); raw != 0x00 {
+		t.Errorf("RawValue below the range = 0x%02x, want 0x00", raw)
+	}
+}
+
+// The upper non-critical threshold of CPU1 Temp of the default sensor set is
+// 85 degrees C, with M = 1.
+const (
+	sensorTestNumber = 0x10
+	sensorTestUNC    = 85
+)
+
+func sensorTestEvents(t *testing.T, obj BMC) uint16 {
+	t.Helper()
+
+	sdrLock.Lock()
+	defer sdrLock.Unlock()
+
+	sensor, ok := obj.getSDRRepository().Sensors[sensorTestNumber]
+	if !ok {
+		t.Fatalf("sensor 0x%02x is not found", sensorTestNumber)
+	}
+	return sensor.ThresholdEvents
+}
+
+func TestSensorThresholdHysteresis(t *testing.T) {
+	obj := newSELTestBMC(t, "127.0.10.7", 0, false)
+	t.Cleanup(func() {
+		sdrLock.Lock()
+		delete(sdrRepositories, obj.Addr.String())
+		sdrLock.Unlock()
+	})
+	if !obj.SetSensorHysteresis(sensorTestNumber, 2, 2) {
+		t.Fatal("SetSensorHysteresis failed")
+	}
+
+	event := uint16(1) << thresholdEvent(THRESHOLD_UPPER_NON_CRITICAL)
+	steps := []struct {
+		reading     uint8
+		asserted    bool
+		wantEntries int
+	}{
+		{sensorTestUNC - 1, false, 0},
+		{sensorTestUNC, true, 1},     // going high
+		{sensorTestUNC + 1, true, 1}, // still above
+		{sensorTestUNC - 1, true, 1}, // within the hysteresis
+		{sensorTestUNC - 2, true, 1},
+		{sensorTestUNC - 3, false, 2}, // past the hysteresis
+		{sensorTestUNC - 2, false, 2},
+		{sensorTestUNC, true, 3},
+	}
+	for _, step := range steps {
+		if !obj.SetSensorReading(sensorTestNumber, step.reading) {
+			t.Fatalf("SetSensorReading(%d) failed", step.reading)
+		}
+		if asserted := sensorTestEvents(t, obj)&event != 0; asserted != step.asserted {
+			t.Errorf("reading %d: upper non-critical asserted = %v, want %v", step.reading, asserted, step.asserted)
+		}
+		if entries := len(obj.GetSEL().Entries); entries != step.wantEntries {
+			t.Errorf("reading %d: %d SEL entries, want %d", step.reading, entries, step.wantEntries)
+		}
+	}
+
+	entries := obj.GetSEL().Entries
+	wantEventData := SEL_EVENT_DATA_THRESHOLD | thresholdEvent(THRESHOLD_UPPER_NON_CRITICAL)
+	for i, entry := range entries {
+		if entry.SensorNumber != sensorTestNumber || entry.EventData[0] != wantEventData {
+			t.Errorf("SEL entry %d: sensor 0x%02x, event data 1 0x%02x, want sensor 0x%02x, event data 1 0x%02x",
+				i, entry.SensorNumber, entry.EventData[0], sensorTestNumber, wantEventData)
+		}
+		deasserted := entry.EventType&SEL_EVENT_DIR_DEASSERTION != 0
+		if want := i == 1; deasserted != want {
+			t.Errorf("SEL entry %d: deassertion = %v, want %v", i, deasserted, want)
+		}
+	}
+}
@@ -2,11 +2,26 @@ package bmc
 
 import (
 	"log"
+	"sort"
+)
+
+// User IDs (IPMI v2.0 Section 22.26): user ID 1 is the null user, whose name
+// is fixed, and the other users take IDs 2 ~ MAX_USER_ID.
+const (
+	NULL_USER_ID =		1
+	FIRST_USER_ID =		2
+	MAX_USER_ID =		16
 )
 
 type BMCUser struct {
+	ID uint8
 	Username string
 	Password string
+	MaxPrivilege uint8	// privilege limit on the LAN channel, PRIVILEGE_NO_ACCESS means no access
+	Enabled bool
+	IPMIMessaging bool
+	LinkAuthentication bool
+	CallbackOnly bool
 }
 
 var bmcUsers map[string]BMCUser
@@ -16,13 +31,62 @@ func init() {
 	bmcUsers = make(map[string]BMCUser)
 }
 
-func AddBMCUser(name string, password string) {
-	newUser := BMCUser{
+// nextUserID returns the lowest free user ID, or 0 when all of them are used.
+func nextUserID(users map[string]BMCUser) uint8 {
+	used := make(map[uint8]bool)
+	for _, user := range users {
+		used[user.ID] = true
+	}
+	for id := uint8(FIRST_USER_ID); id <= MAX_USER_ID; id++ {
+		if ! used[id] {
+			return id
+		}
+	}
+	return 0
+}
+
+func newBMCUser(users map[string]BMCUser, name string, password string, maxPrivilege uint8) (BMCUser, bool) {
+	id := nextUserID(users)
+	if id == 0 {
+		log.Printf("BMCUser: No user ID is available for user %s\n", name)
+		return BMCUser{}, false
+	}
+
+	return BMCUser{
+		ID: id,
 		Username: name,
 		Password: password,
+		MaxPrivilege: maxPrivilege,
+		Enabled: true,
+		IPMIMessaging: true,
+	}, true
+}
+
+func AddBMCUser(name string, password string, maxPrivilege uint8) bool {
+	newUser, ok := newBMCUser(bmcUsers, name, password, maxPrivilege)
+	if ! ok {
+		return false
 	}
 	bmcUsers[name] = newUser
 	log.Printf("BMCUSer: Add user %s\n", name)
+	return true
+}
+
+// NewNullUser returns the null user (user ID 1), whose username is empty. It
+// allows anonymous login when its password is empty too.
+func NewNullUser(password string, maxPrivilege uint8) BMCUser {
+	return BMCUser{
+		ID: NULL_USER_ID,
+		Password: password,
+		MaxPrivilege: maxPrivilege,
+		Enabled: true,
+		IPMIMessaging: true,
+	}
+}
+
+func SetBMCNullUser(user BMCUser) {
+	bmcUsers[user.Username] = user
+	log.Println("BMCUSer: Set null user")
 }
 
 func RemoveBMCUser(name string) {
@@ -37,4 +101,99 @@ func GetBMCUser(name string) (BMCUser, bool) {
 	obj, ok := bmcUsers[name]
 
 	return obj, ok
-}
\ No newline at end of file
+}
+
+func (user *BMCUser)IsNullUser() bool {
+	return user.ID == NULL_USER_ID
+}
+
+// CanLogin reports whether the user is allowed to establish LAN sessions.
+func (user *BMCUser)CanLogin() bool {
+	return user.Enabled && user.IPMIMessaging && user.MaxPrivilege != PRIVILEGE_NO_ACCESS
+}
+
+// AddUser adds a user of this BMC only. Once a BMC has its own users, the
+// default users are not accepted by it any more.
+func (bmc *BMC)AddUser(name string, password string, maxPrivilege uint8) bool {
+	if bmc.Users == nil {
+		bmc.Users = make(map[string]BMCUser)
+	}
+	newUser, ok := newBMCUser(bmc.Users, name, password, maxPrivilege)
+	if ! ok {
+		return false
+	}
+	bmc.Users[name] = newUser
+	log.Printf("BMCUSer: Add user %s to BMC %s\n", name, bmc.Addr.String())
+	return true
+}
+
+func (bmc *BMC)users() map[string]BMCUser {
+	if bmc.Users == nil {
+		return bmcUsers
+	}
+	return bmc.Users
+}
+
+// GetUser looks up a user of this BMC, or a default user when the BMC does
+// not have its own users.
+func (bmc *BMC)GetUser(name string) (BMCUser, bool) {
+	obj, ok := bmc.users()[name]
+
+	return obj, ok
+}
+
+func (bmc *BMC)GetUserByID(id uint8) (BMCUser, bool) {
+	for _, user := range bmc.users() {
+		if user.ID == id {
+			return user, true
+		}
+	}
+	return BMCUser{}, false
+}
+
+type usersByID []BMCUser
+
+func (s usersByID) Len() int { return len(s) }
+func (s usersByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+func (s usersByID) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// GetUsers returns the users of this BMC in the order of their IDs.
+func (bmc *BMC)GetUsers() []BMCUser {
+	users := []BMCUser{}
+	for _, user := range bmc.users() {
+		users = append(users, user)
+	}
+	sort.Sort(usersByID(users))
+	return users
+}
+
+// ownUsers gives the BMC a copy of the default users before they are changed,
+// so that the change does not affect the other BMCs.
+func (bmc *BMC)ownUsers() {
+	if bmc.Users != nil {
+		return
+	}
+	bmc.Users = make(map[string]BMCUser)
+	for name, user := range bmcUsers {
+		bmc.Users[name] = user
+	}
+}
+
+// SetUser stores the user into its user ID slot of this BMC, replacing the
+// user which had the ID. Call Save() to keep the change.
+func (bmc *BMC)SetUser(user BMCUser) {
+	bmc.ownUsers()
+	bmc.RemoveUserByID(user.ID)
+	bmc.Users[user.Username] = user
+}
+
+// RemoveUserByID empties the user ID slot of this BMC. Call Save() to keep
+// the change.
+func (bmc *BMC)RemoveUserByID(id uint8) {
+	bmc.ownUsers()
+	for name, user := range bmc.Users {
+		if user.ID == id {
+			delete(bmc.Users, name)
+		}
+	}
+}
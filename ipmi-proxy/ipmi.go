@@ -11,7 +11,6 @@ import (
 )
 
 import (
-	"github.com/rmxymh/infra-ecosphere/utils"
 	"github.com/rmxymh/infra-ecosphere/ipmi"
 	"github.com/rmxymh/infra-ecosphere/web"
 	"github.com/rmxymh/infra-ecosphere/bmc"
@@ -20,45 +19,45 @@ import (
 var EcosphereIP string = "10.0.2.2"
 var EcospherePort int = 9090
 
-func SetBootDevice(addr *net.UDPAddr, server *net.UDPConn, wrapper ipmi.IPMISessionWrapper, message ipmi.IPMIMessage, selector ipmi.IPMIChassisBootOptionParameterSelector) {
-	localIP := utils.GetLocalIP(server)
+func SetBootDevice(ctx *ipmi.IPMIContext, request ipmi.IPMIRequest, selector ipmi.IPMIChassisBootOptionParameterSelector) (uint8, []uint8) {
+	localIP := ctx.BMCIP
 
 	buf := bytes.NewBuffer(selector.Parameters)
-	request := ipmi.IPMIChassisSetBootOptionBootFlags{}
-	binary.Read(buf, binary.BigEndian, &request)
+	bootFlags := ipmi.IPMIChassisSetBootOptionBootFlags{}
+	binary.Read(buf, binary.BigEndian, &bootFlags)
 
 	// Simulate: We just dump log but do nothing here.
-	if request.BootParam & ipmi.BOOT_PARAM_BITMASK_VALID != 0 {
+	if bootFlags.BootParam & ipmi.BOOT_PARAM_BITMASK_VALID != 0 {
 		log.Println("        IPMI CHASSIS BOOT FLAG: Valid")
 	}
-	if request.BootParam & ipmi.BOOT_PARAM_BITMASK_PERSISTENT != 0 {
+	if bootFlags.BootParam & ipmi.BOOT_PARAM_BITMASK_PERSISTENT != 0 {
 		log.Println("        IPMI CHASSIS BOOT FLAG: Persistent")
 	} else {
 		log.Println("        IPMI CHASSIS BOOT FLAG: Only on the next boot")
 	}
-	if request.BootParam & ipmi.BOOT_PARAM_BITMASK_BOOT_TYPE_EFI != 0 {
+	if bootFlags.BootParam & ipmi.BOOT_PARAM_BITMASK_BOOT_TYPE_EFI != 0 {
 		log.Println("        IPMI CHASSIS BOOT FLAG: Boot Type = EFI")
 	} else {
 		log.Println("        IPMI CHASSIS BOOT FLAG: Boot Type = PC Compatible (Legacy)")
 	}
 
 	// Simulate: We just dump log but do nothing here
-	if request.BootDevice & ipmi.BOOT_DEVICE_BITMASK_CMOS_CLEAR != 0 {
+	if bootFlags.BootDevice & ipmi.BOOT_DEVICE_BITMASK_CMOS_CLEAR != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: CMOS Clear")
 	}
-	if request.BootDevice & ipmi.BOOT_DEVICE_BITMASK_LOCK_KEYBOARD != 0 {
+	if bootFlags.BootDevice & ipmi.BOOT_DEVICE_BITMASK_LOCK_KEYBOARD != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: Lock Keyboard")
 	}
-	if request.BootDevice & ipmi.BOOT_DEVICE_BITMASK_SCREEN_BLANK != 0 {
+	if bootFlags.BootDevice & ipmi.BOOT_DEVICE_BITMASK_SCREEN_BLANK != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: Screen Blank")
 	}
-	if request.BootDevice & ipmi.BOOT_DEVICE_BITMASK_LOCK_RESET != 0 {
+	if bootFlags.BootDevice & ipmi.BOOT_DEVICE_BITMASK_LOCK_RESET != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: Lock RESET Buttons")
 	}
 
 	// This part contains some options that we only support: PXE, CD, HDD
 	//   Maybe there is another way to simulate remote device.
-	device := (request.BootDevice & ipmi.BOOT_DEVICE_BITMASK_DEVICE) >> 2
+	device := (bootFlags.BootDevice & ipmi.BOOT_DEVICE_BITMASK_DEVICE) >> 2
 
 	bootdevReq := web.WebReqBootDev{}
 	bootdevResp := web.WebRespBootDev{}
@@ -104,19 +103,19 @@ func SetBootDevice(addr *net.UDPAddr, server *net.UDPConn, wrapper ipmi.IPMISess
 	}
 
 	// Simulate: We just dump log but do nothing here.
-	if request.BIOSVerbosity & ipmi.BOOT_BIOS_BITMASK_LOCK_VIA_POWER != 0 {
+	if bootFlags.BIOSVerbosity & ipmi.BOOT_BIOS_BITMASK_LOCK_VIA_POWER != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: Lock out (power off / sleep request) via Power Button")
 	}
-	if request.BIOSVerbosity & ipmi.BOOT_BIOS_BITMASK_EVENT_TRAP != 0 {
+	if bootFlags.BIOSVerbosity & ipmi.BOOT_BIOS_BITMASK_EVENT_TRAP != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: Force Progress Event Trap (Only for IPMI 2.0)")
 	}
-	if request.BIOSVerbosity & ipmi.BOOT_BIOS_BITMASK_PASSWORD_BYPASS != 0 {
+	if bootFlags.BIOSVerbosity & ipmi.BOOT_BIOS_BITMASK_PASSWORD_BYPASS != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: User password bypass")
 	}
-	if request.BIOSVerbosity & ipmi.BOOT_BIOS_BITMASK_LOCK_SLEEP != 0 {
+	if bootFlags.BIOSVerbosity & ipmi.BOOT_BIOS_BITMASK_LOCK_SLEEP != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: Lock out Sleep Button")
 	}
-	verbosity := (request.BIOSVerbosity & ipmi.BOOT_BIOS_BITMASK_FIRMWARE) >> 5
+	verbosity := (bootFlags.BIOSVerbosity & ipmi.BOOT_BIOS_BITMASK_FIRMWARE) >> 5
 	switch verbosity {
 	case ipmi.BOOT_BIOS_FIRMWARE_SYSTEM_DEFAULT:
 		log.Println("        IPMI CHASSIS BOOT BIOS: BOOT_BIOS_FIRMWARE_SYSTEM_DEFAULT")
@@ -125,7 +124,7 @@ func SetBootDevice(addr *net.UDPAddr, server *net.UDPConn, wrapper ipmi.IPMISess
 	case ipmi.BOOT_BIOS_FIRMWARE_REQUEST_VERBOSE:
 		log.Println("        IPMI CHASSIS BOOT BIOS: BOOT_BIOS_FIRMWARE_REQUEST_VERBOSE")
 	}
-	console_redirect := (request.BIOSVerbosity & ipmi.BOOT_BIOS_BITMASK_CONSOLE_REDIRECT)
+	console_redirect := (bootFlags.BIOSVerbosity & ipmi.BOOT_BIOS_BITMASK_CONSOLE_REDIRECT)
 	switch console_redirect {
 	case ipmi.BOOT_BIOS_CONSOLE_REDIRECT_OCCURS_PER_BIOS_SETTING:
 		log.Println("        IPMI CHASSIS BOOT BIOS: BOOT_BIOS_CONSOLE_REDIRECT_OCCURS_PER_BIOS_SETTING")
@@ -136,10 +135,10 @@ func SetBootDevice(addr *net.UDPAddr, server *net.UDPConn, wrapper ipmi.IPMISess
 	}
 
 	// Simulate: We just dump log but do nothing here.
-	if request.BIOSSharedMode & ipmi.BOOT_BIOS_SHARED_BITMASK_OVERRIDE != 0 {
+	if bootFlags.BIOSSharedMode & ipmi.BOOT_BIOS_SHARED_BITMASK_OVERRIDE != 0 {
 		log.Println("        IPMI CHASSIS BOOT BIOS: BOOT_BIOS_SHARED_BITMASK_OVERRIDE")
 	}
-	mux_control := request.BIOSSharedMode & ipmi.BOOT_BIOS_SHARED_BITMASK_MUX_CONTROL_OVERRIDE
+	mux_control := bootFlags.BIOSSharedMode & ipmi.BOOT_BIOS_SHARED_BITMASK_MUX_CONTROL_OVERRIDE
 	switch mux_control {
 	case ipmi.BOOT_BIOS_SHARED_MUX_RECOMMENDED:
 		log.Println("        IPMI CHASSIS BOOT BIOS: BOOT_BIOS_SHARED_MUX_RECOMMENDED")
@@ -149,7 +148,7 @@ func SetBootDevice(addr *net.UDPAddr, server *net.UDPConn, wrapper ipmi.IPMISess
 		log.Println("        IPMI CHASSIS BOOT BIOS: BOOT_BIOS_SHARED_MUX_TO_BMC")
 	}
 
-	ipmi.SendIPMIChassisSetBootOptionResponseBack(addr, server, wrapper, message);
+	return ipmi.COMPLETION_CODE_OK, nil
 }
 
 func DoPowerOperationRestCall(powerOpReq web.WebReqPowerOp, bmcIP string) (powerOpResp web.WebRespPowerOp, err error) {
@@ -165,127 +164,82 @@ func DoPowerOperationRestCall(powerOpReq web.WebReqPowerOp, bmcIP string) (power
 	return powerOpResp, err
 }
 
-func HandleIPMIChassisControl(addr *net.UDPAddr, server *net.UDPConn, wrapper ipmi.IPMISessionWrapper, message ipmi.IPMIMessage) {
-	buf := bytes.NewBuffer(message.Data)
-	request := ipmi.IPMIChassisControlRequest{}
-	binary.Read(buf, binary.BigEndian, &request)
+func HandleIPMIChassisControl(ctx *ipmi.IPMIContext, request ipmi.IPMIRequest) (uint8, []uint8) {
+	buf := bytes.NewBuffer(request.Data)
+	control := ipmi.IPMIChassisControlRequest{}
+	binary.Read(buf, binary.BigEndian, &control)
 
-	session, ok := ipmi.GetSession(wrapper.SessionId)
+	localIP := ctx.BMCIP
+	_, ok := bmc.GetBMC(net.ParseIP(localIP))
 	if ! ok {
-		log.Printf("Unable to find session 0x%08x\n", wrapper.SessionId)
-	} else {
-		bmcUser := session.User
-		code := ipmi.GetAuthenticationCode(wrapper.AuthenticationType, bmcUser.Password, wrapper.SessionId, message, wrapper.SequenceNumber)
-		if bytes.Compare(wrapper.AuthenticationCode[:], code[:]) == 0 {
-			log.Println("      IPMI Authentication Pass.")
-		} else {
-			log.Println("      IPMI Authentication Failed.")
-		}
+		log.Printf("BMC %s is not found\n", localIP)
+		return ipmi.COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
 
-		localIP := utils.GetLocalIP(server)
-		powerOpReq := web.WebReqPowerOp{
-			Operation: "ON",
-		}
+	powerOpReq := web.WebReqPowerOp{
+		Operation: "ON",
+	}
 
-		var err error = nil
-		_, ok := bmc.GetBMC(net.ParseIP(localIP))
-		if ! ok {
-			log.Printf("BMC %s is not found\n", localIP)
-		} else {
-			switch request.ChassisControl {
-			case ipmi.CHASSIS_CONTROL_POWER_DOWN:
-				powerOpReq.Operation = "OFF"
-				_, err = DoPowerOperationRestCall(powerOpReq, localIP)
-
-			case ipmi.CHASSIS_CONTROL_POWER_UP:
-				powerOpReq.Operation = "ON"
-				_, err = DoPowerOperationRestCall(powerOpReq, localIP)
-
-			case ipmi.CHASSIS_CONTROL_POWER_CYCLE:
-				powerOpReq.Operation = "CYCLE"
-				_, err = DoPowerOperationRestCall(powerOpReq, localIP)
-
-			case ipmi.CHASSIS_CONTROL_HARD_RESET:
-				powerOpReq.Operation = "RESET"
-				_, err = DoPowerOperationRestCall(powerOpReq, localIP)
-			case ipmi.CHASSIS_CONTROL_PULSE:
-			// do nothing
-			case ipmi.CHASSIS_CONTROL_POWER_SOFT:
-				powerOpReq.Operation = "SOFT"
-				_, err = DoPowerOperationRestCall(powerOpReq, localIP)
-			}
-
-			session.Inc()
-
-			responseWrapper, responseMessage := ipmi.BuildResponseMessageTemplate(wrapper, message, (ipmi.IPMI_NETFN_CHASSIS | ipmi.IPMI_NETFN_RESPONSE), ipmi.IPMI_CMD_CHASSIS_CONTROL)
-			if err != nil {
-				responseMessage.CompletionCode = 0xD3
-			}
-
-			responseWrapper.SessionId = wrapper.SessionId
-			responseWrapper.SequenceNumber = session.RemoteSessionSequenceNumber
-			rmcp := ipmi.BuildUpRMCPForIPMI()
-
-			obuf := bytes.Buffer{}
-			ipmi.SerializeRMCP(&obuf, rmcp)
-			ipmi.SerializeIPMI(&obuf, responseWrapper, responseMessage, bmcUser.Password)
-			server.WriteToUDP(obuf.Bytes(), addr)
-		}
+	var err error = nil
+	switch control.ChassisControl {
+	case ipmi.CHASSIS_CONTROL_POWER_DOWN:
+		powerOpReq.Operation = "OFF"
+		_, err = DoPowerOperationRestCall(powerOpReq, localIP)
+
+	case ipmi.CHASSIS_CONTROL_POWER_UP:
+		powerOpReq.Operation = "ON"
+		_, err = DoPowerOperationRestCall(powerOpReq, localIP)
+
+	case ipmi.CHASSIS_CONTROL_POWER_CYCLE:
+		powerOpReq.Operation = "CYCLE"
+		_, err = DoPowerOperationRestCall(powerOpReq, localIP)
+
+	case ipmi.CHASSIS_CONTROL_HARD_RESET:
+		powerOpReq.Operation = "RESET"
+		_, err = DoPowerOperationRestCall(powerOpReq, localIP)
+	case ipmi.CHASSIS_CONTROL_PULSE:
+	// do nothing
+	case ipmi.CHASSIS_CONTROL_POWER_SOFT:
+		powerOpReq.Operation = "SOFT"
+		_, err = DoPowerOperationRestCall(powerOpReq, localIP)
+	default:
+		log.Printf("      IPMI Chassis: Chassis control 0x%02x is invalid, reject.\n", control.ChassisControl)
+		return ipmi.COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+
+	if err != nil {
+		return 0xD3, nil
 	}
+	return ipmi.COMPLETION_CODE_OK, nil
 }
 
-func HandleIPMIGetChassisStatus(addr *net.UDPAddr, server *net.UDPConn, wrapper ipmi.IPMISessionWrapper, message ipmi.IPMIMessage) {
-	session, ok := ipmi.GetSession(wrapper.SessionId)
+func HandleIPMIGetChassisStatus(ctx *ipmi.IPMIContext, request ipmi.IPMIRequest) (uint8, []uint8) {
+	localIP := ctx.BMCIP
+	_, ok := bmc.GetBMC(net.ParseIP(localIP))
 	if ! ok {
-		log.Printf("Unable to find session 0x%08x\n", wrapper.SessionId)
-	} else {
-		bmcUser := session.User
-		code := ipmi.GetAuthenticationCode(wrapper.AuthenticationType, bmcUser.Password, wrapper.SessionId, message, wrapper.SequenceNumber)
-		if bytes.Compare(wrapper.AuthenticationCode[:], code[:]) == 0 {
-			log.Println("      IPMI Authentication Pass.")
-		} else {
-			log.Println("      IPMI Authentication Failed.")
-		}
+		log.Printf("BMC %s is not found\n", localIP)
+		return ipmi.COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
 
-		localIP := utils.GetLocalIP(server)
-		_, ok := bmc.GetBMC(net.ParseIP(localIP))
-		if ! ok {
-			log.Printf("BMC %s is not found\n", localIP)
-		} else {
-			session.Inc()
-
-			vmstat := web.WebRespBMC{}
-			baseAPI := fmt.Sprintf("http://%s:%d/api/BMCs/%s", EcosphereIP, EcospherePort, localIP)
-
-			resp, err := napping.Get(baseAPI, nil, &vmstat, nil)
-			if err != nil {
-				log.Println("Failed to call ecophsere Web API for getting power status: ", err.Error())
-			} else if resp.Status() != 200 {
-				log.Println("Failed to call ecosphere Web API for getting power status: ", vmstat.PowerStatus)
-			}
-
-			response := ipmi.IPMIGetChassisStatusResponse{}
-			if vmstat.PowerStatus == "ON" {
-				response.CurrentPowerState |= ipmi.CHASSIS_POWER_STATE_BITMASK_POWER_ON
-			}
-			response.LastPowerEvent = 0
-			response.MiscChassisState = 0
-			response.FrontPanelButtonCapabilities = 0
-
-			dataBuf := bytes.Buffer{}
-			binary.Write(&dataBuf, binary.LittleEndian, response)
-
-			responseWrapper, responseMessage := ipmi.BuildResponseMessageTemplate(wrapper, message, (ipmi.IPMI_NETFN_CHASSIS | ipmi.IPMI_NETFN_RESPONSE), ipmi.IPMI_CMD_GET_CHASSIS_STATUS)
-			responseMessage.Data = dataBuf.Bytes()
-
-			responseWrapper.SessionId = wrapper.SessionId
-			responseWrapper.SequenceNumber = session.RemoteSessionSequenceNumber
-			rmcp := ipmi.BuildUpRMCPForIPMI()
-
-			obuf := bytes.Buffer{}
-			ipmi.SerializeRMCP(&obuf, rmcp)
-			ipmi.SerializeIPMI(&obuf, responseWrapper, responseMessage, bmcUser.Password)
-			server.WriteToUDP(obuf.Bytes(), addr)
-		}
+	vmstat := web.WebRespBMC{}
+	baseAPI := fmt.Sprintf("http://%s:%d/api/BMCs/%s", EcosphereIP, EcospherePort, localIP)
+
+	resp, err := napping.Get(baseAPI, nil, &vmstat, nil)
+	if err != nil {
+		log.Println("Failed to call ecophsere Web API for getting power status: ", err.Error())
+	} else if resp.Status() != 200 {
+		log.Println("Failed to call ecosphere Web API for getting power status: ", vmstat.PowerStatus)
+	}
+
+	response := ipmi.IPMIGetChassisStatusResponse{}
+	if vmstat.PowerStatus == "ON" {
+		response.CurrentPowerState |= ipmi.CHASSIS_POWER_STATE_BITMASK_POWER_ON
 	}
-}
\ No newline at end of file
+	response.LastPowerEvent = 0
+	response.MiscChassisState = 0
+	response.FrontPanelButtonCapabilities = 0
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return ipmi.COMPLETION_CODE_OK, dataBuf.Bytes()
+}
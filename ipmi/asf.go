@@ -27,25 +27,37 @@ const (
 	ASF_TYPE_PONG	= 0x40
 )
 
-func DeserializeASF(buf io.Reader)  (length uint32, header AlertStandardFormat) {
+func DeserializeASF(buf io.Reader)  (length uint32, header AlertStandardFormat, err error) {
 	length = 0
 
-	binary.Read(buf, binary.LittleEndian, &header.IANA)
+	if err = readField(buf, "ASF", "IANA Enterprise Number", &header.IANA); err != nil {
+		return 0, header, err
+	}
 	length += uint32(unsafe.Sizeof(header.IANA))
-	binary.Read(buf, binary.LittleEndian, &header.MessageType)
+	if err = readField(buf, "ASF", "Message Type", &header.MessageType); err != nil {
+		return 0, header, err
+	}
 	length += uint32(unsafe.Sizeof(header.MessageType))
-	binary.Read(buf, binary.LittleEndian, &header.MessageTag)
+	if err = readField(buf, "ASF", "Message Tag", &header.MessageTag); err != nil {
+		return 0, header, err
+	}
 	length += uint32(unsafe.Sizeof(header.MessageTag))
-	binary.Read(buf, binary.LittleEndian, &header.Reserved)
+	if err = readField(buf, "ASF", "Reserved", &header.Reserved); err != nil {
+		return 0, header, err
+	}
 	length += uint32(unsafe.Sizeof(header.Reserved))
-	binary.Read(buf, binary.LittleEndian, &header.DataLen)
+	if err = readField(buf, "ASF", "Data Length", &header.DataLen); err != nil {
+		return 0, header, err
+	}
 	length += uint32(unsafe.Sizeof(header.DataLen))
 
 	header.Data = make([]uint8, header.DataLen, header.DataLen)
-	binary.Read(buf, binary.LittleEndian, &header.Data)
+	if err = readField(buf, "ASF", "Data", &header.Data); err != nil {
+		return 0, header, err
+	}
 	length += uint32(header.DataLen)
 
-	return length, header
+	return length, header, nil
 }
 
 func SerializeASF(buf *bytes.Buffer, header AlertStandardFormat) {
@@ -87,7 +99,11 @@ func HandleASFPong(pingHeader AlertStandardFormat, addr *net.UDPAddr, server *ne
 
 // Comamand Analyzer and Executor
 func ASFDeserializeAndExecute(buf io.Reader, addr *net.UDPAddr, server *net.UDPConn) {
-	_, asf := DeserializeASF(buf)
+	_, asf, err := DeserializeASF(buf)
+	if err != nil {
+		log.Printf("    ASF: Drop malformed packet, %s\n", err)
+		return
+	}
 
 	switch asf.MessageType {
 	case ASF_TYPE_PING:
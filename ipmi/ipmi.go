@@ -1,6 +1,7 @@
 package ipmi
 
 import (
+	"bufio"
 	"io"
 	"encoding/binary"
 	"bytes"
@@ -8,6 +9,9 @@ import (
 	"log"
 	"unsafe"
 )
+import (
+	"github.com/rmxymh/infra-ecosphere/utils"
+)
 
 // port from OpenIPMI
 // Network Functions
@@ -32,6 +36,7 @@ type IPMISessionWrapper struct {
 	SessionId uint32
 	AuthenticationCode [16]byte
 	MessageLen uint8
+	BMCIP string		// BMC which owns the session, not on the wire
 }
 
 type IPMIMessage struct {
@@ -46,53 +51,104 @@ type IPMIMessage struct {
 	DataChecksum uint8
 }
 
-func DeserializeIPMI(buf io.Reader)  (length uint32, wrapper IPMISessionWrapper, message IPMIMessage) {
+// IPMI message header (rsAddr, netFn/rsLUN, checksum, rqAddr, rqSeq/rqLUN and
+// cmd) and the data checksum.
+const (
+	IPMI_MESSAGE_HEADER_LENGTH =	6
+	IPMI_MESSAGE_MIN_LENGTH =	IPMI_MESSAGE_HEADER_LENGTH + 1
+)
+
+// DeserializeIPMI decodes an IPMI v1.5 session wrapper and the IPMI message
+// it carries. A *ChecksumError is returned together with the whole message.
+func DeserializeIPMI(buf io.Reader)  (length uint32, wrapper IPMISessionWrapper, message IPMIMessage, err error) {
 	length = 0
 	wrapperLength := uint32(0)
 
-	binary.Read(buf, binary.LittleEndian, &wrapper.AuthenticationType)
+	if err = readField(buf, "IPMI session wrapper", "Authentication Type", &wrapper.AuthenticationType); err != nil {
+		return length, wrapper, message, err
+	}
 	wrapperLength += uint32(unsafe.Sizeof(wrapper.AuthenticationType))
-	binary.Read(buf, binary.LittleEndian, &wrapper.SequenceNumber)
+	if err = readField(buf, "IPMI session wrapper", "Session Sequence Number", &wrapper.SequenceNumber); err != nil {
+		return length, wrapper, message, err
+	}
 	wrapperLength += uint32(unsafe.Sizeof(wrapper.SequenceNumber))
-	binary.Read(buf, binary.LittleEndian, &wrapper.SessionId)
+	if err = readField(buf, "IPMI session wrapper", "Session ID", &wrapper.SessionId); err != nil {
+		return length, wrapper, message, err
+	}
 	wrapperLength += uint32(unsafe.Sizeof(wrapper.SessionId))
-	if wrapper.SessionId != 0x00 {
-		binary.Read(buf, binary.LittleEndian, &wrapper.AuthenticationCode)
+	if wrapper.SessionId != 0x00 && wrapper.AuthenticationType != AUTH_NONE {
+		if err = readField(buf, "IPMI session wrapper", "Authentication Code", &wrapper.AuthenticationCode); err != nil {
+			return length, wrapper, message, err
+		}
 		wrapperLength += uint32(unsafe.Sizeof(wrapper.AuthenticationCode))
 	}
-	binary.Read(buf, binary.LittleEndian, &wrapper.MessageLen)
+	if err = readField(buf, "IPMI session wrapper", "Message Length", &wrapper.MessageLen); err != nil {
+		return length, wrapper, message, err
+	}
 	wrapperLength += uint32(unsafe.Sizeof(wrapper.MessageLen))
 	length += wrapperLength
 
+	log.Println("    IPMI Session Wrapper Length = ", wrapperLength)
+	log.Println("    IPMI Session Wrapper Message Length = ", wrapper.MessageLen)
+
+	messageLength, message, err := DeserializeIPMIMessage(buf, uint32(wrapper.MessageLen))
+	length += messageLength
+
+	return length, wrapper, message, err
+}
+
+// DeserializeIPMIMessage decodes an IPMI message whose total length (header,
+// data and data checksum) is messageLen. It is shared by the IPMI v1.5 session
+// wrapper and the RMCP+ IPMI payload. A *ChecksumError is returned together
+// with the whole message, so that the request can be answered.
+func DeserializeIPMIMessage(buf io.Reader, messageLen uint32) (length uint32, message IPMIMessage, err error) {
+	if messageLen < IPMI_MESSAGE_MIN_LENGTH {
+		return 0, message, &PacketLengthError{Layer: "IPMI message", Field: "Message Length", Length: int(messageLen), Min: IPMI_MESSAGE_MIN_LENGTH, Max: 0xff}
+	}
+
 	messageHeaderLength := uint32(0)
-	binary.Read(buf, binary.LittleEndian, &message.TargetAddress)
+	if err = readField(buf, "IPMI message", "Target Address", &message.TargetAddress); err != nil {
+		return 0, message, err
+	}
 	messageHeaderLength += uint32(unsafe.Sizeof(message.TargetAddress))
-	binary.Read(buf, binary.LittleEndian, &message.TargetLun)
+	if err = readField(buf, "IPMI message", "NetFn / Target LUN", &message.TargetLun); err != nil {
+		return 0, message, err
+	}
 	messageHeaderLength += uint32(unsafe.Sizeof(message.TargetLun))
-	binary.Read(buf, binary.LittleEndian, &message.Checksum)
+	if err = readField(buf, "IPMI message", "Header Checksum", &message.Checksum); err != nil {
+		return 0, message, err
+	}
 	messageHeaderLength += uint32(unsafe.Sizeof(message.Checksum))
-	binary.Read(buf, binary.LittleEndian, &message.SourceAddress)
+	if err = readField(buf, "IPMI message", "Source Address", &message.SourceAddress); err != nil {
+		return 0, message, err
+	}
 	messageHeaderLength += uint32(unsafe.Sizeof(message.SourceAddress))
-	binary.Read(buf, binary.LittleEndian, &message.SourceLun)
+	if err = readField(buf, "IPMI message", "Sequence / Source LUN", &message.SourceLun); err != nil {
+		return 0, message, err
+	}
 	messageHeaderLength += uint32(unsafe.Sizeof(message.SourceLun))
-	binary.Read(buf, binary.LittleEndian, &message.Command)
+	if err = readField(buf, "IPMI message", "Command", &message.Command); err != nil {
+		return 0, message, err
+	}
 	messageHeaderLength += uint32(unsafe.Sizeof(message.Command))
 
-	dataLen := wrapper.MessageLen - uint8(messageHeaderLength) - 1
+	dataLen := messageLen - messageHeaderLength - 1
 	if dataLen > 0 {
 		message.Data = make([]uint8, dataLen, dataLen)
-		binary.Read(buf, binary.LittleEndian, &message.Data)
+		if err = readField(buf, "IPMI message", "Data", &message.Data); err != nil {
+			return 0, message, err
+		}
+	}
+	if err = readField(buf, "IPMI message", "Data Checksum", &message.DataChecksum); err != nil {
+		return 0, message, err
 	}
-	binary.Read(buf, binary.LittleEndian, &message.DataChecksum)
 	messageHeaderLength += uint32(unsafe.Sizeof(message.DataChecksum))
-	length += uint32(wrapper.MessageLen)
+	length = messageLen
 
-	log.Println("    IPMI Session Wrapper Length = ", wrapperLength)
-	log.Println("    IPMI Session Wrapper Message Length = ", wrapper.MessageLen)
 	log.Println("    IPMI Message Header Length = ", messageHeaderLength)
 	log.Println("    IPMI Message Data Length = ", dataLen)
 
-	return length, wrapper, message
+	return length, message, verifyIPMIChecksums(message)
 }
 
 func isNetFunctionResponse(targetLun uint8) bool {
@@ -103,7 +159,7 @@ func SerializeIPMISessionWrapper(buf *bytes.Buffer, wrapper IPMISessionWrapper)
 	binary.Write(buf, binary.LittleEndian, wrapper.AuthenticationType)
 	binary.Write(buf, binary.LittleEndian, wrapper.SequenceNumber)
 	binary.Write(buf, binary.LittleEndian, wrapper.SessionId)
-	if wrapper.SessionId != 0 {
+	if wrapper.SessionId != 0 && wrapper.AuthenticationType != AUTH_NONE {
 		binary.Write(buf, binary.LittleEndian, wrapper.AuthenticationCode)
 	}
 	binary.Write(buf, binary.LittleEndian, wrapper.MessageLen)
@@ -123,12 +179,17 @@ func SerializeIPMIMessage(buf *bytes.Buffer, message IPMIMessage) {
 	binary.Write(buf, binary.LittleEndian, message.DataChecksum)
 }
 
-func SerializeIPMI(buf *bytes.Buffer, wrapper IPMISessionWrapper, message IPMIMessage, bmcpass string) {
+// FinalizeIPMIMessage fills in both checksums of the message and returns it
+// together with its serialized length.
+func FinalizeIPMIMessage(message IPMIMessage) (IPMIMessage, uint32) {
 	// Calculate data checksum
 	sum := uint32(0)
 	sum += uint32(message.SourceAddress)
 	sum += uint32(message.SourceLun)
 	sum += uint32(message.Command)
+	if isNetFunctionResponse(message.TargetLun) {
+		sum += uint32(message.CompletionCode)
+	}
 	for i := 0; i < len(message.Data) ; i+=1 {
 		sum += uint32(message.Data[i])
 	}
@@ -151,14 +212,30 @@ func SerializeIPMI(buf *bytes.Buffer, wrapper IPMISessionWrapper, message IPMIMe
 	}
 	length += uint32(len(message.Data))
 	length += uint32(unsafe.Sizeof(message.DataChecksum))
-	wrapper.MessageLen = uint8(length)
 
-	if len(bmcpass) > 0 {
-		wrapper.AuthenticationCode = GetAuthenticationCode(wrapper.AuthenticationType, bmcpass, wrapper.SessionId, message, wrapper.SequenceNumber)
+	return message, length
+}
+
+func SerializeIPMI(buf *bytes.Buffer, wrapper IPMISessionWrapper, message IPMIMessage, bmcpass string) {
+	message, length := FinalizeIPMIMessage(message)
+
+	// IPMI v2.0 sessions carry the message as an RMCP+ payload instead.
+	if wrapper.AuthenticationType == AUTH_RMCP_PLUS {
+		SerializeRMCPPlusIPMI(buf, wrapper, message)
+	} else {
+		wrapper.MessageLen = uint8(length)
+
+		if len(bmcpass) > 0 {
+			wrapper.AuthenticationCode = GetAuthenticationCode(wrapper.AuthenticationType, bmcpass, wrapper.SessionId, message, wrapper.SequenceNumber)
+		}
+		// output
+		SerializeIPMISessionWrapper(buf, wrapper)
+		SerializeIPMIMessage(buf, message)
 	}
-	// output
-	SerializeIPMISessionWrapper(buf, wrapper)
-	SerializeIPMIMessage(buf, message)
+
+	// The buffer holds the whole response packet, which is sent again if the
+	// request is retransmitted.
+	cacheIPMIResponse(wrapper, message, buf.Bytes())
 }
 
 func BuildUpRMCPForIPMI() (rmcp RemoteManagementControlProtocol) {
@@ -171,38 +248,39 @@ func BuildUpRMCPForIPMI() (rmcp RemoteManagementControlProtocol) {
 }
 
 func IPMIDeserializeAndExecute(buf io.Reader, addr *net.UDPAddr, server *net.UDPConn) {
-	_, wrapper, message := DeserializeIPMI(buf)
-
-	netFunction := (message.TargetLun & 0xFC) >> 2;
-
-	switch netFunction {
-	case IPMI_NETFN_CHASSIS:
-		log.Println("    IPMI: NetFunction = CHASSIS")
-		IPMI_CHASSIS_DeserializeAndExecute(addr, server, wrapper, message)
-	case IPMI_NETFN_BRIDGE:
-		log.Println("    IPMI: NetFunction = BRIDGE")
-	case IPMI_NETFN_SENSOR_EVENT:
-		log.Println("    IPMI: NetFunction = SENSOR / EVENT")
-	case IPMI_NETFN_APP:
-		log.Println("    IPMI: NetFunction = APP")
-		IPMI_APP_DeserializeAndExecute(addr, server, wrapper, message)
-	case IPMI_NETFN_FIRMWARE:
-		log.Println("    IPMI: NetFunction = FIRMWARE")
-	case IPMI_NETFN_STORAGE:
-		log.Println("    IPMI: NetFunction = STORAGE")
-	case IPMI_NETFN_TRANSPORT:
-		log.Println("    IPMI: NetFunction = TRANSPORT")
-	case IPMI_NETFN_GROUP_EXTENSION:
-		log.Println("    IPMI: NetFunction = GROUP EXTENSION")
-		IPMI_GROUPEXT_DeserializeAndExecute(addr, server, wrapper, message)
-	case IPMI_NETFN_OEM_GROUP:
-		log.Println("    IPMI: NetFunction = OEM GROUP")
-	default:
-		log.Println("    IPMI: NetFunction = Unknown NetFunction", netFunction)
-		log.Println(wrapper)
-		log.Println(message)
-		wbuf := bytes.Buffer{}
-		SerializeIPMI(&wbuf, wrapper, message, "")
-		dumpByteBuffer(wbuf)
-	}
-}
\ No newline at end of file
+	reader := bufio.NewReader(buf)
+	authType, err := reader.Peek(1)
+	if err == nil && authType[0] == AUTH_RMCP_PLUS {
+		log.Println("    IPMI: Session Wrapper = RMCP+ (IPMI v2.0)")
+		RMCPPlusDeserializeAndExecute(reader, addr, server)
+		return
+	}
+
+	_, wrapper, message, err := DeserializeIPMI(reader)
+	checksumErr, badChecksum := err.(*ChecksumError)
+	if err != nil && ! badChecksum {
+		log.Printf("    IPMI: Drop malformed packet, %s\n", err)
+		return
+	}
+	wrapper.BMCIP = utils.GetLocalIP(server)
+	if ! AuthenticateIPMIRequest(addr, server, wrapper, message) {
+		return
+	}
+	if badChecksum {
+		// The authentication code covers the checksums, so the response
+		// can be signed for the session of the request.
+		log.Printf("    IPMI: Reject request, %s\n", checksumErr)
+		RejectIPMIRequest(NewIPMIContext(addr, server, wrapper, message), COMPLETION_CODE_INVALID_DATA_FIELD)
+		return
+	}
+	if session, ok := GetSession(wrapper.BMCIP, wrapper.SessionId); ok && session.Activated {
+		CacheIPMIRequest(session, wrapper.SequenceNumber, message)
+	}
+	IPMIExecute(addr, server, wrapper, message)
+}
+
+// IPMIExecute dispatches a decoded IPMI message to its handler through the
+// middleware chain, no matter which session wrapper it arrived in.
+func IPMIExecute(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
+	ServeIPMIRequest(NewIPMIContext(addr, server, wrapper, message))
+}
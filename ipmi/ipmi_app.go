@@ -74,238 +74,96 @@ const (
 	IPMI_CMD_GET_SYSTEM_INTERFACE_CAPABILITIES = 	0x57
 )
 
-type IPMI_App_Handler func(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage)
-
-type IPMIAppHandlerSet struct {
-	GetDeviceIDHandler			IPMI_App_Handler
-	BroadcastGetDeviceIDHandler		IPMI_App_Handler
-	ColdResetHandler			IPMI_App_Handler
-	WarmResetHandler			IPMI_App_Handler
-	GetSelfTestResultHandler		IPMI_App_Handler
-	ManufacturingTestOnHandler		IPMI_App_Handler
-	SetACPIPowerStateHandler		IPMI_App_Handler
-	GetACPIPowerStateHandler		IPMI_App_Handler
-	GetDeviceGUIDHandler			IPMI_App_Handler
-	ResetWatchdogTimerHandler		IPMI_App_Handler
-	SetWatchdogTimerHandler			IPMI_App_Handler
-	GetWatchdogTimerHandler			IPMI_App_Handler
-	SetBMCGlobalEnablesHandler		IPMI_App_Handler
-	GetBMCGlobalEnablesHandler		IPMI_App_Handler
-	ClearMsgFlagsHandler			IPMI_App_Handler
-	GetMsgFlagsHandler			IPMI_App_Handler
-	EnableMessageChannelRcvHandler		IPMI_App_Handler
-	GetMsgHandler				IPMI_App_Handler
-	SendMsgHandler				IPMI_App_Handler
-	ReadEventMsgBufferHandler		IPMI_App_Handler
-	GetBTInterfaceCapabilitiesHandler	IPMI_App_Handler
-	GetSystemGUIDHandler			IPMI_App_Handler
-	GetChannelAuthCapabilitiesHandler	IPMI_App_Handler
-	GetSessionChallengeHandler		IPMI_App_Handler
-	ActivateSessionHandler			IPMI_App_Handler
-	SetSessionPrivilegeHandler		IPMI_App_Handler
-	CloseSessionHandler			IPMI_App_Handler
-	GetSessionInfoHandler			IPMI_App_Handler
-
-	GetAuthCodeHandler			IPMI_App_Handler
-	SetChannelAccessHandler			IPMI_App_Handler
-	GetChannelAccessHandler			IPMI_App_Handler
-	GetChannelInfoHandler			IPMI_App_Handler
-	SetUserAccessHandler			IPMI_App_Handler
-	GetUserAccessHandler			IPMI_App_Handler
-	SetUserNameHandler			IPMI_App_Handler
-	GetUserNameHandler			IPMI_App_Handler
-	SetUserPasswordHandler			IPMI_App_Handler
-	ActivatePayloadHandler			IPMI_App_Handler
-	DeactivatePayloadHandler		IPMI_App_Handler
-	GetPayloadActivationStatusHandler	IPMI_App_Handler
-	GetPayloadInstanceInfoHandler		IPMI_App_Handler
-	SetUserPayloadAccessHandler		IPMI_App_Handler
-	GetUserPayloadAccessHandler		IPMI_App_Handler
-	GetChannelPayloadSupportHandler		IPMI_App_Handler
-	GetChannelPayloadVersionHandler		IPMI_App_Handler
-	GetChannelOEMPayloadInfoHandler		IPMI_App_Handler
-
-	MasterReadWriteHandler			IPMI_App_Handler
-
-	GetChannelCipherSuiteHandler		IPMI_App_Handler
-	SuspendResumePayloadEncryptionHandler	IPMI_App_Handler
-	SetChannelSecurityKeyHandler		IPMI_App_Handler
-	GetSystemInterfaceCapabilitiesHandler	IPMI_App_Handler
-	
-	Unsupported				IPMI_App_Handler
+var ipmiAppCommandNames = map[uint8]string {
+	IPMI_CMD_GET_DEVICE_ID:				"IPMI_CMD_GET_DEVICE_ID",
+	IPMI_CMD_COLD_RESET:				"IPMI_CMD_COLD_RESET",
+	IPMI_CMD_WARM_RESET:				"IPMI_CMD_WARM_RESET",
+	IPMI_CMD_GET_SELF_TEST_RESULTS:			"IPMI_CMD_GET_SELF_TEST_RESULTS",
+	IPMI_CMD_MANUFACTURING_TEST_ON:			"IPMI_CMD_MANUFACTURING_TEST_ON",
+	IPMI_CMD_SET_ACPI_POWER_STATE:			"IPMI_CMD_SET_ACPI_POWER_STATE",
+	IPMI_CMD_GET_ACPI_POWER_STATE:			"IPMI_CMD_GET_ACPI_POWER_STATE",
+	IPMI_CMD_GET_DEVICE_GUID:			"IPMI_CMD_GET_DEVICE_GUID",
+	IPMI_CMD_RESET_WATCHDOG_TIMER:			"IPMI_CMD_RESET_WATCHDOG_TIMER",
+	IPMI_CMD_SET_WATCHDOG_TIMER:			"IPMI_CMD_SET_WATCHDOG_TIMER",
+	IPMI_CMD_GET_WATCHDOG_TIMER:			"IPMI_CMD_GET_WATCHDOG_TIMER",
+	IPMI_CMD_SET_BMC_GLOBAL_ENABLES:		"IPMI_CMD_SET_BMC_GLOBAL_ENABLES",
+	IPMI_CMD_GET_BMC_GLOBAL_ENABLES:		"IPMI_CMD_GET_BMC_GLOBAL_ENABLES",
+	IPMI_CMD_CLEAR_MSG_FLAGS:			"IPMI_CMD_CLEAR_MSG_FLAGS",
+	IPMI_CMD_GET_MSG_FLAGS:				"IPMI_CMD_GET_MSG_FLAGS",
+	IPMI_CMD_ENABLE_MESSAGE_CHANNEL_RCV:		"IPMI_CMD_ENABLE_MESSAGE_CHANNEL_RCV",
+	IPMI_CMD_GET_MSG:				"IPMI_CMD_GET_MSG",
+	IPMI_CMD_SEND_MSG:				"IPMI_CMD_SEND_MSG",
+	IPMI_CMD_READ_EVENT_MSG_BUFFER:			"IPMI_CMD_READ_EVENT_MSG_BUFFER",
+	IPMI_CMD_GET_BT_INTERFACE_CAPABILITIES:		"IPMI_CMD_GET_BT_INTERFACE_CAPABILITIES",
+	IPMI_CMD_GET_SYSTEM_GUID:			"IPMI_CMD_GET_SYSTEM_GUID",
+	IPMI_CMD_GET_CHANNEL_AUTH_CAPABILITIES:		"IPMI_CMD_GET_CHANNEL_AUTH_CAPABILITIES",
+	IPMI_CMD_GET_SESSION_CHALLENGE:			"IPMI_CMD_GET_SESSION_CHALLENGE",
+	IPMI_CMD_ACTIVATE_SESSION:			"IPMI_CMD_ACTIVATE_SESSION",
+	IPMI_CMD_SET_SESSION_PRIVILEGE:			"IPMI_CMD_SET_SESSION_PRIVILEGE",
+	IPMI_CMD_CLOSE_SESSION:				"IPMI_CMD_CLOSE_SESSION",
+	IPMI_CMD_GET_SESSION_INFO:			"IPMI_CMD_GET_SESSION_INFO",
+	IPMI_CMD_GET_AUTHCODE:				"IPMI_CMD_GET_AUTHCODE",
+	IPMI_CMD_SET_CHANNEL_ACCESS:			"IPMI_CMD_SET_CHANNEL_ACCESS",
+	IPMI_CMD_GET_CHANNEL_ACCESS:			"IPMI_CMD_GET_CHANNEL_ACCESS",
+	IPMI_CMD_GET_CHANNEL_INFO:			"IPMI_CMD_GET_CHANNEL_INFO",
+	IPMI_CMD_SET_USER_ACCESS:			"IPMI_CMD_SET_USER_ACCESS",
+	IPMI_CMD_GET_USER_ACCESS:			"IPMI_CMD_GET_USER_ACCESS",
+	IPMI_CMD_SET_USER_NAME:				"IPMI_CMD_SET_USER_NAME",
+	IPMI_CMD_GET_USER_NAME:				"IPMI_CMD_GET_USER_NAME",
+	IPMI_CMD_SET_USER_PASSWORD:			"IPMI_CMD_SET_USER_PASSWORD",
+	IPMI_CMD_ACTIVATE_PAYLOAD:			"IPMI_CMD_ACTIVATE_PAYLOAD",
+	IPMI_CMD_DEACTIVATE_PAYLOAD:			"IPMI_CMD_DEACTIVATE_PAYLOAD",
+	IPMI_CMD_GET_PAYLOAD_ACTIVATION_STATUS:		"IPMI_CMD_GET_PAYLOAD_ACTIVATION_STATUS",
+	IPMI_CMD_GET_PAYLOAD_INSTANCE_INFO:		"IPMI_CMD_GET_PAYLOAD_INSTANCE_INFO",
+	IPMI_CMD_SET_USER_PAYLOAD_ACCESS:		"IPMI_CMD_SET_USER_PAYLOAD_ACCESS",
+	IPMI_CMD_GET_USER_PAYLOAD_ACCESS:		"IPMI_CMD_GET_USER_PAYLOAD_ACCESS",
+	IPMI_CMD_GET_CHANNEL_PAYLOAD_SUPPORT:		"IPMI_CMD_GET_CHANNEL_PAYLOAD_SUPPORT",
+	IPMI_CMD_GET_CHANNEL_PAYLOAD_VERSION:		"IPMI_CMD_GET_CHANNEL_PAYLOAD_VERSION",
+	IPMI_CMD_GET_CHANNEL_OEM_PAYLOAD_INFO:		"IPMI_CMD_GET_CHANNEL_OEM_PAYLOAD_INFO",
+	IPMI_CMD_MASTER_READ_WRITE:			"IPMI_CMD_MASTER_READ_WRITE",
+	IPMI_CMD_GET_CHANNEL_CIPHER_SUITES:		"IPMI_CMD_GET_CHANNEL_CIPHER_SUITES",
+	IPMI_CMD_SUSPEND_RESUME_PAYLOAD_ENCRYPTION:	"IPMI_CMD_SUSPEND_RESUME_PAYLOAD_ENCRYPTION",
+	IPMI_CMD_SET_CHANNEL_SECURITY_KEY:		"IPMI_CMD_SET_CHANNEL_SECURITY_KEY",
+	IPMI_CMD_GET_SYSTEM_INTERFACE_CAPABILITIES:	"IPMI_CMD_GET_SYSTEM_INTERFACE_CAPABILITIES",
 }
 
-var IPMIAppHandler IPMIAppHandlerSet = IPMIAppHandlerSet{}
+type IPMI_App_Handler func(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage)
 
+// IPMI_APP_SetHandler registers the handler of an App command which sends its
+// response by itself, see RegisterIPMIHandler.
 func IPMI_APP_SetHandler(command int, handler IPMI_App_Handler) {
-	switch command {
-	case IPMI_CMD_GET_DEVICE_ID:
-		IPMIAppHandler.GetDeviceIDHandler = handler
-		IPMIAppHandler.BroadcastGetDeviceIDHandler = handler
-	case IPMI_CMD_COLD_RESET:
-		IPMIAppHandler.ColdResetHandler = handler
-	case IPMI_CMD_WARM_RESET:
-		IPMIAppHandler.WarmResetHandler = handler
-	case IPMI_CMD_GET_SELF_TEST_RESULTS:
-		IPMIAppHandler.GetSelfTestResultHandler = handler
-	case IPMI_CMD_MANUFACTURING_TEST_ON:
-		IPMIAppHandler.ManufacturingTestOnHandler = handler
-	case IPMI_CMD_SET_ACPI_POWER_STATE:
-		IPMIAppHandler.SetACPIPowerStateHandler = handler
-	case IPMI_CMD_GET_ACPI_POWER_STATE:
-		IPMIAppHandler.GetACPIPowerStateHandler = handler
-	case IPMI_CMD_GET_DEVICE_GUID:
-		IPMIAppHandler.GetDeviceGUIDHandler = handler
-	case IPMI_CMD_RESET_WATCHDOG_TIMER:
-		IPMIAppHandler.ResetWatchdogTimerHandler = handler
-	case IPMI_CMD_SET_WATCHDOG_TIMER:
-		IPMIAppHandler.SetWatchdogTimerHandler = handler
-	case IPMI_CMD_GET_WATCHDOG_TIMER:
-		IPMIAppHandler.GetWatchdogTimerHandler = handler
-	case IPMI_CMD_SET_BMC_GLOBAL_ENABLES:
-		IPMIAppHandler.SetBMCGlobalEnablesHandler = handler
-	case IPMI_CMD_GET_BMC_GLOBAL_ENABLES:
-		IPMIAppHandler.GetBMCGlobalEnablesHandler = handler
-	case IPMI_CMD_CLEAR_MSG_FLAGS:
-		IPMIAppHandler.ClearMsgFlagsHandler = handler
-	case IPMI_CMD_GET_MSG_FLAGS:
-		IPMIAppHandler.GetMsgFlagsHandler = handler
-	case IPMI_CMD_ENABLE_MESSAGE_CHANNEL_RCV:
-		IPMIAppHandler.EnableMessageChannelRcvHandler = handler
-	case IPMI_CMD_GET_MSG:
-		IPMIAppHandler.GetMsgHandler = handler
-	case IPMI_CMD_SEND_MSG:
-		IPMIAppHandler.SendMsgHandler = handler
-	case IPMI_CMD_READ_EVENT_MSG_BUFFER:
-		IPMIAppHandler.ReadEventMsgBufferHandler = handler
-	case IPMI_CMD_GET_BT_INTERFACE_CAPABILITIES:
-		IPMIAppHandler.GetBTInterfaceCapabilitiesHandler = handler
-	case IPMI_CMD_GET_SYSTEM_GUID:
-		IPMIAppHandler.GetSystemGUIDHandler = handler
-	case IPMI_CMD_GET_CHANNEL_AUTH_CAPABILITIES:
-		IPMIAppHandler.GetChannelAuthCapabilitiesHandler = handler
-	case IPMI_CMD_GET_SESSION_CHALLENGE:
-		IPMIAppHandler.GetSessionChallengeHandler = handler
-	case IPMI_CMD_ACTIVATE_SESSION:
-		IPMIAppHandler.ActivateSessionHandler = handler
-	case IPMI_CMD_SET_SESSION_PRIVILEGE:
-		IPMIAppHandler.SetSessionPrivilegeHandler = handler
-	case IPMI_CMD_CLOSE_SESSION:
-		IPMIAppHandler.CloseSessionHandler = handler
-	case IPMI_CMD_GET_SESSION_INFO:
-		IPMIAppHandler.GetSessionInfoHandler = handler
-	case IPMI_CMD_GET_AUTHCODE:
-		IPMIAppHandler.GetAuthCodeHandler = handler
-	case IPMI_CMD_SET_CHANNEL_ACCESS:
-		IPMIAppHandler.SetChannelAccessHandler = handler
-	case IPMI_CMD_GET_CHANNEL_ACCESS:
-		IPMIAppHandler.GetChannelAccessHandler = handler
-	case IPMI_CMD_GET_CHANNEL_INFO:
-		IPMIAppHandler.GetChannelInfoHandler = handler
-	case IPMI_CMD_SET_USER_ACCESS:
-		IPMIAppHandler.SetUserAccessHandler = handler
-	case IPMI_CMD_GET_USER_ACCESS:
-		IPMIAppHandler.GetUserAccessHandler = handler
-	case IPMI_CMD_SET_USER_NAME:
-		IPMIAppHandler.SetUserNameHandler = handler
-	case IPMI_CMD_GET_USER_NAME:
-		IPMIAppHandler.GetUserNameHandler = handler
-	case IPMI_CMD_SET_USER_PASSWORD:
-		IPMIAppHandler.SetUserPasswordHandler = handler
-	case IPMI_CMD_ACTIVATE_PAYLOAD:
-		IPMIAppHandler.ActivatePayloadHandler = handler
-	case IPMI_CMD_DEACTIVATE_PAYLOAD:
-		IPMIAppHandler.DeactivatePayloadHandler = handler
-	case IPMI_CMD_GET_PAYLOAD_ACTIVATION_STATUS:
-		IPMIAppHandler.GetPayloadActivationStatusHandler = handler
-	case IPMI_CMD_GET_PAYLOAD_INSTANCE_INFO:
-		IPMIAppHandler.GetPayloadInstanceInfoHandler = handler
-	case IPMI_CMD_SET_USER_PAYLOAD_ACCESS:
-		IPMIAppHandler.SetUserPayloadAccessHandler = handler
-	case IPMI_CMD_GET_USER_PAYLOAD_ACCESS:
-		IPMIAppHandler.GetUserPayloadAccessHandler = handler
-	case IPMI_CMD_GET_CHANNEL_PAYLOAD_SUPPORT:
-		IPMIAppHandler.GetChannelPayloadSupportHandler = handler
-	case IPMI_CMD_GET_CHANNEL_PAYLOAD_VERSION:
-		IPMIAppHandler.GetChannelPayloadVersionHandler = handler
-	case IPMI_CMD_GET_CHANNEL_OEM_PAYLOAD_INFO:
-		IPMIAppHandler.GetChannelOEMPayloadInfoHandler = handler
-	case IPMI_CMD_MASTER_READ_WRITE:
-		IPMIAppHandler.MasterReadWriteHandler = handler
-	case IPMI_CMD_GET_CHANNEL_CIPHER_SUITES:
-		IPMIAppHandler.GetChannelCipherSuiteHandler = handler
-	case IPMI_CMD_SUSPEND_RESUME_PAYLOAD_ENCRYPTION:
-		IPMIAppHandler.SuspendResumePayloadEncryptionHandler = handler
-	case IPMI_CMD_SET_CHANNEL_SECURITY_KEY:
-		IPMIAppHandler.SetChannelSecurityKeyHandler = handler
-	case IPMI_CMD_GET_SYSTEM_INTERFACE_CAPABILITIES:
-		IPMIAppHandler.GetSystemInterfaceCapabilitiesHandler = handler
-	}
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, uint8(command)), "", WrapIPMIHandler(IPMI_Handler(handler)))
 }
 
 func init() {
-	IPMIAppHandler.Unsupported = HandleIPMIUnsupportedAppCommand
-
-	IPMI_APP_SetHandler(IPMI_CMD_GET_DEVICE_ID, HandleIPMIGetDeviceID)
-	IPMI_APP_SetHandler(IPMI_CMD_BROADCAST_GET_DEVICE_ID, HandleIPMIGetDeviceID)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_CHANNEL_AUTH_CAPABILITIES, HandleIPMIAuthenticationCapabilities)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_SESSION_CHALLENGE, HandleIPMIGetSessionChallenge)
-	IPMI_APP_SetHandler(IPMI_CMD_ACTIVATE_SESSION, HandleIPMIActivateSession)
-	IPMI_APP_SetHandler(IPMI_CMD_SET_SESSION_PRIVILEGE, HandleIPMISetSessionPrivilegeLevel)
-	IPMI_APP_SetHandler(IPMI_CMD_CLOSE_SESSION, HandleIPMICloseSession)
-	
-	IPMI_APP_SetHandler(IPMI_CMD_COLD_RESET, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_WARM_RESET, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_SELF_TEST_RESULTS, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_MANUFACTURING_TEST_ON, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_SET_ACPI_POWER_STATE, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_ACPI_POWER_STATE, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_DEVICE_GUID, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_RESET_WATCHDOG_TIMER, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_SET_WATCHDOG_TIMER, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_WATCHDOG_TIMER, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_SET_BMC_GLOBAL_ENABLES, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_BMC_GLOBAL_ENABLES, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_CLEAR_MSG_FLAGS, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_MSG_FLAGS, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_ENABLE_MESSAGE_CHANNEL_RCV, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_MSG, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_SEND_MSG, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_READ_EVENT_MSG_BUFFER, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_BT_INTERFACE_CAPABILITIES, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_SYSTEM_GUID, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_SESSION_INFO, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_AUTHCODE, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_SET_CHANNEL_ACCESS, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_CHANNEL_ACCESS, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_CHANNEL_INFO, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_SET_USER_ACCESS, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_USER_ACCESS, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_SET_USER_NAME, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_USER_NAME, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_SET_USER_PASSWORD, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_ACTIVATE_PAYLOAD, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_DEACTIVATE_PAYLOAD, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_PAYLOAD_ACTIVATION_STATUS, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_PAYLOAD_INSTANCE_INFO, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_SET_USER_PAYLOAD_ACCESS, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_USER_PAYLOAD_ACCESS, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_CHANNEL_PAYLOAD_SUPPORT, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_CHANNEL_PAYLOAD_VERSION, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_CHANNEL_OEM_PAYLOAD_INFO, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_MASTER_READ_WRITE, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_CHANNEL_CIPHER_SUITES, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_SUSPEND_RESUME_PAYLOAD_ENCRYPTION, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_SET_CHANNEL_SECURITY_KEY, HandleIPMIUnsupportedAppCommand)
-	IPMI_APP_SetHandler(IPMI_CMD_GET_SYSTEM_INTERFACE_CAPABILITIES, HandleIPMIUnsupportedAppCommand)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_GET_DEVICE_ID), "", HandleIPMIGetDeviceID)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_GET_DEVICE_GUID), "", HandleIPMIGetDeviceGUID)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_GET_CHANNEL_AUTH_CAPABILITIES), "", HandleIPMIAuthenticationCapabilities)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_GET_SESSION_CHALLENGE), "", HandleIPMIGetSessionChallenge)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_ACTIVATE_SESSION), "", HandleIPMIActivateSession)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_SET_SESSION_PRIVILEGE), "", HandleIPMISetSessionPrivilegeLevel)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_CLOSE_SESSION), "", HandleIPMICloseSession)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_GET_SESSION_INFO), "", HandleIPMIGetSessionInfo)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_SET_USER_ACCESS), "", HandleIPMISetUserAccess)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_GET_USER_ACCESS), "", HandleIPMIGetUserAccess)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_SET_USER_NAME), "", HandleIPMISetUserName)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_GET_USER_NAME), "", HandleIPMIGetUserName)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_SET_USER_PASSWORD), "", HandleIPMISetUserPassword)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_ACTIVATE_PAYLOAD), "", HandleIPMIActivatePayload)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_DEACTIVATE_PAYLOAD), "", HandleIPMIDeactivatePayload)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_GET_PAYLOAD_ACTIVATION_STATUS), "", HandleIPMIGetPayloadActivationStatus)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_APP, IPMI_CMD_GET_CHANNEL_CIPHER_SUITES), "", HandleIPMIGetChannelCipherSuites)
 }
 
 
 // Default Handler Implementation
 const (
-	AUTH_NONE =	0x00
-	AUTH_MD2 =	0x01
-	AUTH_MD5 =	0x02
+	AUTH_NONE =		bmc.AUTH_TYPE_NONE
+	AUTH_MD2 =		bmc.AUTH_TYPE_MD2
+	AUTH_MD5 =		bmc.AUTH_TYPE_MD5
+	AUTH_STRAIGHT_KEY =	bmc.AUTH_TYPE_STRAIGHT_KEY
+	AUTH_OEM =		bmc.AUTH_TYPE_OEM
+	AUTH_RMCP_PLUS =	0x06
 )
 
 const (
@@ -321,14 +179,40 @@ const (
 	AUTH_STATUS_ANONYMOUS =		0x01
 	AUTH_STATUS_NULL_USER =		0x02
 	AUTH_STATUS_NON_NULL_USER =	0x04
-	AUTH_STATUS_USER_LEVEL = 	0x08
-	AUTH_STATUS_PER_MESSAGE = 	0x10
+	AUTH_STATUS_USER_LEVEL = 	0x08	// user level authentication is disabled
+	AUTH_STATUS_PER_MESSAGE = 	0x10	// per-message authentication is disabled
 	AUTH_STATUS_KG =		0x20
 )
 
+const (
+	PRIVILEGE_HIGHEST =		0x00
+	PRIVILEGE_CALLBACK =		bmc.PRIVILEGE_CALLBACK
+	PRIVILEGE_USER =		bmc.PRIVILEGE_USER
+	PRIVILEGE_OPERATOR =		bmc.PRIVILEGE_OPERATOR
+	PRIVILEGE_ADMINISTRATOR =	bmc.PRIVILEGE_ADMINISTRATOR
+	PRIVILEGE_OEM =			bmc.PRIVILEGE_OEM
+	PRIVILEGE_NO_ACCESS =		bmc.PRIVILEGE_NO_ACCESS
+)
+
 const (
 	COMPLETION_CODE_OK = 			0x00
-	COMPLETION_CODE_INVALID_USERNAME =	0x81
+	COMPLETION_CODE_INVALID_USERNAME =	0x81	// Get Session Challenge
+	COMPLETION_CODE_NULL_USER_DISABLED =	0x82	// Get Session Challenge
+	COMPLETION_CODE_NO_SESSION_SLOT =	0x81	// Activate Session
+	COMPLETION_CODE_NO_SESSION_SLOT_FOR_USER =	0x82	// Activate Session
+	COMPLETION_CODE_INVALID_SESSION_ID =	0x85	// Activate Session
+	COMPLETION_CODE_PRIVILEGE_EXCEEDS_USER_LIMIT =	0x86	// Activate Session
+	COMPLETION_CODE_NODE_BUSY =		0xC0
+	COMPLETION_CODE_INVALID_COMMAND =	0xC1
+	COMPLETION_CODE_OUT_OF_SPACE =		0xC4
+	COMPLETION_CODE_RESERVATION_CANCELLED =	0xC5
+	COMPLETION_CODE_REQUEST_DATA_LENGTH_INVALID =	0xC7
+	COMPLETION_CODE_REQUEST_DATA_LENGTH_EXCEEDED =	0xC8
+	COMPLETION_CODE_PARAMETER_OUT_OF_RANGE =	0xC9
+	COMPLETION_CODE_NOT_PRESENT =		0xCB
+	COMPLETION_CODE_INVALID_DATA_FIELD =	0xCC
+	COMPLETION_CODE_INSUFFICIENT_PRIVILEGE =	0xD4
+	COMPLETION_CODE_NOT_SUPPORTED_IN_STATE =	0xD5
 )
 
 func dumpByteBuffer(buf bytes.Buffer) {
@@ -355,21 +239,18 @@ func BuildResponseMessageTemplate(requestWrapper IPMISessionWrapper, requestMess
 	responseWrapper.AuthenticationType = requestWrapper.AuthenticationType
 	responseWrapper.SequenceNumber = 0xff
 	responseWrapper.SessionId = requestWrapper.SessionId
+	responseWrapper.BMCIP = requestWrapper.BMCIP
 
 	return responseWrapper, responseMessage
 }
 
-func HandleIPMIUnsupportedAppCommand(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	log.Println("      IPMI App: This command is not supported currently, ignore.")
-}
-
 const (
 	FAKE_DEVICE_ID =		0xF0
-	FAKE_DEVICE_HAS_SDR =		0
+	FAKE_DEVICE_HAS_SDR =		0	// device SDRs, the sensors are described by the SDR repository
 	FAKE_DEVICE_REVISION =		0x01
 	FAKE_FW_REVISION = 		0x01
 	FAKE_FW_MINOR_REVISION =	0x00
-	FAKE_IPMI_VERSION =		0x51	// 1.5
+	FAKE_IPMI_VERSION =		0x02	// 2.0, [7:4] minor and [3:0] major revision
 )
 
 const (
@@ -395,7 +276,7 @@ type IPMIGetDeviceIDResponse struct {
 	AuxiliaryFWRevisionInfo	[3]uint8
 }
 
-func HandleIPMIGetDeviceID(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
+func HandleIPMIGetDeviceID(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
 	// prepare for response data
 	// We don't simulate OEM related behavior
 	response := IPMIGetDeviceIDResponse{}
@@ -404,21 +285,29 @@ func HandleIPMIGetDeviceID(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMIS
 	response.FirmwareRevision = FAKE_FW_REVISION
 	response.FirmwareMinorRev = FAKE_FW_MINOR_REVISION
 	response.IPMIVersion = FAKE_IPMI_VERSION
-	response.AdditionalDevSupport |= (ADDITIONAL_DEV_BITMASK_CHASSIS)
+	response.AdditionalDevSupport |= (ADDITIONAL_DEV_BITMASK_CHASSIS | ADDITIONAL_DEV_BITMASK_SEL | ADDITIONAL_DEV_BITMASK_SDR_REPOSITORY | ADDITIONAL_DEV_BITMASK_SENSOR)
+	if localBMC, ok := ctx.GetBMC(); ok {
+		if _, ok := localBMC.GetFRUSize(); ok {
+			response.AdditionalDevSupport |= ADDITIONAL_DEV_BITMASK_FRU_INVENTORY
+		}
+	}
 
 	dataBuf := bytes.Buffer{}
 	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
 
-	responseWrapper, responseMessage := BuildResponseMessageTemplate(wrapper, message, (IPMI_NETFN_APP | IPMI_NETFN_RESPONSE), IPMI_CMD_GET_DEVICE_ID)
-	responseMessage.Data = dataBuf.Bytes()
-	rmcp := BuildUpRMCPForIPMI()
-
-	// serialize and send back
-	obuf := bytes.Buffer{}
-	SerializeRMCP(&obuf, rmcp)
-	SerializeIPMI(&obuf, responseWrapper, responseMessage, "")
+// HandleIPMIGetDeviceGUID answers the GUID of the BMC, in the byte order of
+// the System Unique ID of the default FRU.
+func HandleIPMIGetDeviceGUID(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
 
-	server.WriteToUDP(obuf.Bytes(), addr)
+	guid := localBMC.GUID()
+	return COMPLETION_CODE_OK, guid[:]
 }
 
 
@@ -427,6 +316,16 @@ type IPMIAuthenticationCapabilitiesRequest struct {
 	RequestedPrivilegeLevel uint8
 }
 
+const (
+	AUTH_CAPABILITIES_BITMASK_IPMI_V2 =	0x80	// Request: get IPMI v2.0 extended data
+	AUTH_CAPABILITIES_BITMASK_CHANNEL =	0x0f
+)
+
+const (
+	AUTH_EXT_CAPABILITIES_IPMI_V1_5 =	0x01
+	AUTH_EXT_CAPABILITIES_IPMI_V2 =		0x02
+)
+
 type IPMIAuthenticationCapabilitiesResponse struct {
 	Channel uint8
 	AuthenticationTypeSupport uint8
@@ -436,33 +335,66 @@ type IPMIAuthenticationCapabilitiesResponse struct {
 	OEMAuxiliaryData uint8
 }
 
-func HandleIPMIAuthenticationCapabilities(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	buf := bytes.NewBuffer(message.Data)
-	request := IPMIAuthenticationCapabilitiesRequest{}
-	binary.Read(buf, binary.LittleEndian, &request)
+// userAuthenticationStatus reports the kinds of users which can log in to
+// the BMC: anonymous login (null user with an empty password), the null user
+// with a password, and users with names.
+func userAuthenticationStatus(obj bmc.BMC) uint8 {
+	status := uint8(0)
+	for _, user := range obj.GetUsers() {
+		if ! user.CanLogin() {
+			continue
+		}
+		if ! user.IsNullUser() {
+			status |= AUTH_STATUS_NON_NULL_USER
+		} else if len(user.Password) == 0 {
+			status |= AUTH_STATUS_ANONYMOUS
+		} else {
+			status |= AUTH_STATUS_NULL_USER
+		}
+	}
+	return status
+}
+
+func HandleIPMIAuthenticationCapabilities(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	buf := bytes.NewBuffer(request.Data)
+	capabilitiesRequest := IPMIAuthenticationCapabilitiesRequest{}
+	binary.Read(buf, binary.LittleEndian, &capabilitiesRequest)
 
 	// prepare for response data
 	// We don't simulate OEM related behavior
+	privilege := capabilitiesRequest.RequestedPrivilegeLevel & 0x0f
+	if privilege < PRIVILEGE_CALLBACK || privilege > PRIVILEGE_OEM {
+		log.Printf("      IPMI App: Privilege level 0x%02x is invalid.\n", privilege)
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+
 	response := IPMIAuthenticationCapabilitiesResponse{}
 	response.Channel = 1
-	response.AuthenticationTypeSupport = AUTH_BITMASK_MD5 | AUTH_BITMASK_MD2 | AUTH_BITMASK_NONE
-	response.AuthenticationStatus = AUTH_STATUS_NON_NULL_USER | AUTH_STATUS_NULL_USER
+	response.AuthenticationTypeSupport = authenticationTypeBitmask(defaultAuthenticationTypes)
 	response.ExtCapabilities = 0
+	if obj, ok := ctx.GetBMC(); ok {
+		// Authentication types enabled at the requested privilege level
+		response.AuthenticationTypeSupport = authenticationTypeBitmask(GetBMCAuthenticationTypes(obj, privilege))
+		response.AuthenticationStatus = userAuthenticationStatus(obj)
+		if capabilitiesRequest.AutnticationTypeSupport & AUTH_CAPABILITIES_BITMASK_IPMI_V2 != 0 && obj.IsRMCPPlusEnabled() {
+			response.AuthenticationTypeSupport |= AUTH_BITMASK_IPMI_V2
+			response.ExtCapabilities = AUTH_EXT_CAPABILITIES_IPMI_V1_5 | AUTH_EXT_CAPABILITIES_IPMI_V2
+		}
+		if len(obj.Kg) > 0 {
+			response.AuthenticationStatus |= AUTH_STATUS_KG
+		}
+		if ! obj.IsPerMessageAuthEnabled() {
+			response.AuthenticationStatus |= AUTH_STATUS_PER_MESSAGE
+		}
+		if ! obj.IsUserLevelAuthEnabled() {
+			response.AuthenticationStatus |= AUTH_STATUS_USER_LEVEL
+		}
+	}
 	response.OEMAuxiliaryData = 0
 
 	dataBuf := bytes.Buffer{}
 	binary.Write(&dataBuf, binary.LittleEndian, response)
-
-	responseWrapper, responseMessage := BuildResponseMessageTemplate(wrapper, message, (IPMI_NETFN_APP | IPMI_NETFN_RESPONSE), IPMI_CMD_GET_CHANNEL_AUTH_CAPABILITIES)
-	responseMessage.Data = dataBuf.Bytes()
-	rmcp := BuildUpRMCPForIPMI()
-
-	// serialize and send back
-	obuf := bytes.Buffer{}
-	SerializeRMCP(&obuf, rmcp)
-	SerializeIPMI(&obuf, responseWrapper, responseMessage, "")
-
-	server.WriteToUDP(obuf.Bytes(), addr)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
 }
 
 type IPMIGetSessionChallengeRequest struct {
@@ -475,53 +407,65 @@ type IPMIGetSessionChallengeResponse struct {
 	Challenge [16]byte
 }
 
-func HandleIPMIGetSessionChallenge(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	buf := bytes.NewBuffer(message.Data)
-	request := IPMIGetSessionChallengeRequest{}
-	binary.Read(buf, binary.LittleEndian, &request)
-
-	obuf := bytes.Buffer{}
+func HandleIPMIGetSessionChallenge(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	buf := bytes.NewBuffer(request.Data)
+	challengeRequest := IPMIGetSessionChallengeRequest{}
+	binary.Read(buf, binary.LittleEndian, &challengeRequest)
 
-	nameLength := len(request.Username)
-	for i := range request.Username {
-		if request.Username[i] == 0 {
+	nameLength := len(challengeRequest.Username)
+	for i := range challengeRequest.Username {
+		if challengeRequest.Username[i] == 0 {
 			nameLength = i
 			break
 		}
 	}
-	username := string(request.Username[:nameLength])
+	username := string(challengeRequest.Username[:nameLength])
 
-	user, found := bmc.GetBMCUser(username)
-	if ! found {
-		responseWrapper, responseMessage := BuildResponseMessageTemplate(wrapper, message, (IPMI_NETFN_APP | IPMI_NETFN_RESPONSE), IPMI_CMD_GET_SESSION_CHALLENGE)
-		responseMessage.CompletionCode = COMPLETION_CODE_INVALID_USERNAME
-		rmcp := BuildUpRMCPForIPMI()
-
-		SerializeRMCP(&obuf, rmcp)
-		SerializeIPMI(&obuf, responseWrapper, responseMessage, "")
-	} else {
-		session := GetNewSession(user)
-		var challengeCode [16]uint8
-
-		for i := range challengeCode {
-			challengeCode[i] = uint8(rand.Uint32() % 0xff)
-		}
-
-		responseChallenge := IPMIGetSessionChallengeResponse{}
-		responseChallenge.TempSessionID = session.SessionID
-		responseChallenge.Challenge = challengeCode
-		dataBuf := bytes.Buffer{}
-		binary.Write(&dataBuf, binary.LittleEndian, responseChallenge)
+	if ! AllowSessionChallenge(ctx.BMCIP, ctx.RemoteIP()) {
+		return COMPLETION_CODE_NODE_BUSY, nil
+	}
 
-		responseWrapper, responseMessage := BuildResponseMessageTemplate(wrapper, message, (IPMI_NETFN_APP | IPMI_NETFN_RESPONSE), IPMI_CMD_GET_SESSION_CHALLENGE)
-		responseMessage.Data = dataBuf.Bytes()
-		rmcp := BuildUpRMCPForIPMI()
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+	user, found := localBMC.GetUser(username)
+	if found && ! user.CanLogin() {
+		log.Printf("      IPMI App: User %s is disabled or has no access.\n", username)
+		found = false
+	}
+	if found && IsUserLockedOut(ctx.BMCIP, username) {
+		log.Printf("      IPMI App: User %s is locked out.\n", username)
+		found = false
+	}
+	if ! isAuthenticationTypeEnabled(localBMC, challengeRequest.AuthenticationType) {
+		log.Printf("      IPMI App: Authentication type 0x%02x is not enabled.\n", challengeRequest.AuthenticationType)
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	} else if ! found && len(username) == 0 {
+		return COMPLETION_CODE_NULL_USER_DISABLED, nil
+	} else if ! found {
+		return COMPLETION_CODE_INVALID_USERNAME, nil
+	}
 
-		SerializeRMCP(&obuf, rmcp)
-		SerializeIPMI(&obuf, responseWrapper, responseMessage, user.Password)
+	session, ok := GetNewSession(ctx.BMCIP, ctx.Addr, user)
+	if ! ok {
+		return COMPLETION_CODE_OUT_OF_SPACE, nil
 	}
+	var challengeCode [16]uint8
+	for i := range challengeCode {
+		challengeCode[i] = uint8(rand.Uint32() % 0xff)
+	}
+	session.AuthenticationType = challengeRequest.AuthenticationType
+	session.Challenge = challengeCode
+	session.Save()
 
-	server.WriteToUDP(obuf.Bytes(), addr)
+	responseChallenge := IPMIGetSessionChallengeResponse{}
+	responseChallenge.TempSessionID = session.SessionID
+	responseChallenge.Challenge = challengeCode
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, responseChallenge)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
 }
 
 type IPMIActivateSessionRequest struct {
@@ -549,76 +493,99 @@ func GetAuthenticationCode(authenticationType uint8, password string, sessionID
 	binary.Write(&context, binary.LittleEndian, sessionSeq)
 	binary.Write(&context, binary.LittleEndian, passwordBytes)
 
+	return computeAuthenticationCode(authenticationType, passwordBytes, context.Bytes())
+}
+
+// computeAuthenticationCode signs the context (password, session ID, message,
+// sequence number and password again) with the authentication type. Straight
+// password authentication sends the password itself.
+func computeAuthenticationCode(authenticationType uint8, passwordBytes [16]byte, context []byte) [16]byte {
 	var code [16]byte
 	switch authenticationType {
 	case AUTH_MD5:
-		code = md5.Sum(context.Bytes())
+		code = md5.Sum(context)
 	case AUTH_MD2:
 		hash := md2.New()
-		md2Code := hash.Sum(context.Bytes())
-		for i := range md2Code {
-			if i >= len(code) {
-				break
-			}
-			code[i] = md2Code[i]
-		}
+		hash.Write(context)
+		copy(code[:], hash.Sum(nil))
+	case AUTH_STRAIGHT_KEY:
+		code = passwordBytes
 	}
 
 	return code
 }
 
-func HandleIPMIActivateSession(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	buf := bytes.NewBuffer(message.Data)
-	request := IPMIActivateSessionRequest{}
-	binary.Read(buf, binary.LittleEndian, &request.AuthenticationType)
-	binary.Read(buf, binary.LittleEndian, &request.RequestMaxPrivilegeLevel)
-	binary.Read(buf, binary.LittleEndian, &request.Challenge)
-	binary.Read(buf, binary.LittleEndian, &request.InitialOutboundSeq)
-
-	binary.Read(buf, binary.LittleEndian, &request)
-
-	//obuf := bytes.Buffer{}
+func HandleIPMIActivateSession(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	buf := bytes.NewBuffer(request.Data)
+	activateRequest := IPMIActivateSessionRequest{}
+	binary.Read(buf, binary.LittleEndian, &activateRequest.AuthenticationType)
+	binary.Read(buf, binary.LittleEndian, &activateRequest.RequestMaxPrivilegeLevel)
+	binary.Read(buf, binary.LittleEndian, &activateRequest.Challenge)
+	binary.Read(buf, binary.LittleEndian, &activateRequest.InitialOutboundSeq)
+
+	if ! ctx.HasSession {
+		log.Println("      IPMI App: Activate Session needs the session of Get Session Challenge.")
+		return COMPLETION_CODE_INVALID_SESSION_ID, nil
+	}
 
-	session, ok := GetSession(wrapper.SessionId)
-	if ! ok {
-		log.Printf("Unable to find session 0x%08x\n", wrapper.SessionId)
-	} else {
-		bmcUser := session.User
-		code := GetAuthenticationCode(wrapper.AuthenticationType, bmcUser.Password, wrapper.SessionId, message, wrapper.SequenceNumber)
-		if bytes.Compare(wrapper.AuthenticationCode[:], code[:]) == 0 {
-			log.Println("      IPMI Authentication Pass.")
-		} else {
-			log.Println("      IPMI Authentication Failed.")
+	session := ctx.Session
+	privilege := activateRequest.RequestMaxPrivilegeLevel & 0x0f
+	if activateRequest.Challenge != session.Challenge {
+		// Only the remote console which got the challenge may activate the
+		// session, so a wrong challenge fails the activation like a wrong
+		// authentication code.
+		log.Printf("      IPMI App: Challenge of session 0x%08x does not match.\n", session.SessionID)
+		if ! session.Activated {
+			RecordLoginFailure(session.BMCIP, session.User.Username)
+			RemoveSession(session.BMCIP, session.SessionID)
 		}
+		return COMPLETION_CODE_INVALID_SESSION_ID, nil
+	} else if activateRequest.AuthenticationType != session.AuthenticationType {
+		log.Printf("      IPMI App: Authentication type 0x%02x does not match the challenge.\n", activateRequest.AuthenticationType)
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	} else if privilege < PRIVILEGE_CALLBACK || privilege > PRIVILEGE_OEM {
+		log.Printf("      IPMI App: Privilege level 0x%02x is invalid.\n", privilege)
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	} else if privilege > session.User.MaxPrivilege {
+		log.Printf("      IPMI App: Privilege level 0x%02x exceeds the limit 0x%02x of user %s.\n", privilege, session.User.MaxPrivilege, session.User.Username)
+		return COMPLETION_CODE_PRIVILEGE_EXCEEDS_USER_LIMIT, nil
+	} else if localBMC, ok := ctx.GetBMC(); ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	} else if ! IsAuthenticationTypeAllowed(localBMC, session.AuthenticationType, privilege) {
+		log.Printf("      IPMI App: Authentication type 0x%02x is not enabled at privilege level 0x%02x.\n", session.AuthenticationType, privilege)
+		return COMPLETION_CODE_PRIVILEGE_EXCEEDS_USER_LIMIT, nil
+	} else if ! session.Activated && ! HasUserSessionSlot(session.BMCIP, session.User.Username) {
+		log.Printf("      IPMI App: No session slot is available for user %s.\n", session.User.Username)
+		RemoveSession(session.BMCIP, session.SessionID)
+		return COMPLETION_CODE_NO_SESSION_SLOT_FOR_USER, nil
+	}
 
-		session.Inc()
-
-		response := IPMIActivateSessionResponse{}
-		response.AuthenticationType = request.AuthenticationType
-		response.SessionId = wrapper.SessionId
-		session.LocalSessionSequenceNumber += 1
-		response.InitialOutboundSeq = session.LocalSessionSequenceNumber
-		response.MaxPrivilegeLevel = request.RequestMaxPrivilegeLevel
-
-		dataBuf := bytes.Buffer{}
-		binary.Write(&dataBuf, binary.LittleEndian, response.AuthenticationType)
-		binary.Write(&dataBuf, binary.LittleEndian, response.SessionId)
-		binary.Write(&dataBuf, binary.LittleEndian, response.InitialOutboundSeq)
-		binary.Write(&dataBuf, binary.LittleEndian, response.MaxPrivilegeLevel)
-		//binary.Write(&dataBuf, binary.LittleEndian, response)
-
-		responseWrapper, responseMessage := BuildResponseMessageTemplate(wrapper, message, (IPMI_NETFN_APP | IPMI_NETFN_RESPONSE), IPMI_CMD_ACTIVATE_SESSION)
-		responseMessage.Data = dataBuf.Bytes()
-
-		responseWrapper.SessionId = response.SessionId
-		responseWrapper.SequenceNumber = session.RemoteSessionSequenceNumber
-		rmcp := BuildUpRMCPForIPMI()
+	session.MaxPrivilegeLevel = privilege
+	session.PrivilegeLevel = initialPrivilegeLevel(privilege)
+	session.Activated = true
+
+	// The remote console chooses the sequence numbers of the BMC, starting
+	// from this response, and the BMC chooses the ones of the remote console.
+	session.RemoteSessionSequenceNumber = activateRequest.InitialOutboundSeq - 1
+	inboundSeq := nextSequenceNumber(rand.Uint32())
+	session.LocalSessionSequenceNumber = inboundSeq - 1
+	session.InboundSequenceWindow = 0
+	session.Save()
+	ResetLoginFailures(session.BMCIP, session.User.Username)
+
+	response := IPMIActivateSessionResponse{}
+	response.AuthenticationType = activateRequest.AuthenticationType
+	response.SessionId = session.SessionID
+	response.InitialOutboundSeq = inboundSeq
+	response.MaxPrivilegeLevel = privilege
 
-		obuf := bytes.Buffer{}
-		SerializeRMCP(&obuf, rmcp)
-		SerializeIPMI(&obuf, responseWrapper, responseMessage, bmcUser.Password)
-		server.WriteToUDP(obuf.Bytes(), addr)
-	}
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response.AuthenticationType)
+	binary.Write(&dataBuf, binary.LittleEndian, response.SessionId)
+	binary.Write(&dataBuf, binary.LittleEndian, response.InitialOutboundSeq)
+	binary.Write(&dataBuf, binary.LittleEndian, response.MaxPrivilegeLevel)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
 }
 
 type IPMISetSessionPrivilegeLevelRequest struct {
@@ -629,282 +596,236 @@ type IPMISetSessionPrivilegeLevelResponse struct {
 	NewPrivilegeLevel uint8
 }
 
-func HandleIPMISetSessionPrivilegeLevel(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	buf := bytes.NewBuffer(message.Data)
-	request := IPMISetSessionPrivilegeLevelRequest{}
-	binary.Read(buf, binary.LittleEndian, &request)
-
-	//obuf := bytes.Buffer{}
-
-	session, ok := GetSession(wrapper.SessionId)
-	if ! ok {
-		log.Printf("Unable to find session 0x%08x\n", wrapper.SessionId)
-	} else {
-		bmcUser := session.User
-		code := GetAuthenticationCode(wrapper.AuthenticationType, bmcUser.Password, wrapper.SessionId, message, wrapper.SequenceNumber)
-		if bytes.Compare(wrapper.AuthenticationCode[:], code[:]) == 0 {
-			log.Println("      IPMI Authentication Pass.")
-		} else {
-			log.Println("      IPMI Authentication Failed.")
-		}
+const (
+	COMPLETION_CODE_PRIVILEGE_NOT_AVAILABLE =	0x80
+	COMPLETION_CODE_PRIVILEGE_EXCEEDS_LIMIT =	0x81
+)
 
-		session.Inc()
+func HandleIPMISetSessionPrivilegeLevel(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	buf := bytes.NewBuffer(request.Data)
+	privilegeRequest := IPMISetSessionPrivilegeLevelRequest{}
+	binary.Read(buf, binary.LittleEndian, &privilegeRequest)
 
-		response := IPMISetSessionPrivilegeLevelResponse{}
-		response.NewPrivilegeLevel = request.RequestPrivilegeLevel
+	if ! ctx.HasSession {
+		log.Println("      IPMI App: Set Session Privilege Level needs a session.")
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
 
-		dataBuf := bytes.Buffer{}
-		binary.Write(&dataBuf, binary.LittleEndian, response)
+	session := ctx.Session
+	privilege := privilegeRequest.RequestPrivilegeLevel & 0x0f
+	// A session cannot be switched below USER privilege level (IPMI v2.0
+	// Section 22.18).
+	if privilege > PRIVILEGE_OEM || (privilege != PRIVILEGE_HIGHEST && privilege < PRIVILEGE_USER) {
+		log.Printf("      IPMI App: Privilege level 0x%02x is invalid.\n", privilege)
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	} else if privilege > session.User.MaxPrivilege {
+		log.Printf("      IPMI App: Privilege level 0x%02x is not available for user %s.\n", privilege, session.User.Username)
+		return COMPLETION_CODE_PRIVILEGE_NOT_AVAILABLE, nil
+	} else if privilege > SessionPrivilegeLimit(session) {
+		log.Printf("      IPMI App: Privilege level 0x%02x exceeds the limit of session 0x%08x.\n", privilege, session.SessionID)
+		return COMPLETION_CODE_PRIVILEGE_EXCEEDS_LIMIT, nil
+	} else if localBMC, ok := ctx.GetBMC(); ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	} else if privilege != PRIVILEGE_HIGHEST && ! session.IsRMCPPlus() && ! IsAuthenticationTypeAllowed(localBMC, session.AuthenticationType, privilege) {
+		log.Printf("      IPMI App: Authentication type 0x%02x is not enabled at privilege level 0x%02x.\n", session.AuthenticationType, privilege)
+		return COMPLETION_CODE_PRIVILEGE_NOT_AVAILABLE, nil
+	}
 
-		responseWrapper, responseMessage := BuildResponseMessageTemplate(wrapper, message, (IPMI_NETFN_APP | IPMI_NETFN_RESPONSE), IPMI_CMD_SET_SESSION_PRIVILEGE)
-		responseMessage.Data = dataBuf.Bytes()
+	// 0 means "no change": report the present privilege level.
+	if privilege != PRIVILEGE_HIGHEST {
+		session.PrivilegeLevel = privilege
+		session.Save()
+	}
 
-		responseWrapper.SessionId = wrapper.SessionId
-		responseWrapper.SequenceNumber = session.RemoteSessionSequenceNumber
-		rmcp := BuildUpRMCPForIPMI()
+	response := IPMISetSessionPrivilegeLevelResponse{}
+	response.NewPrivilegeLevel = session.PrivilegeLevel
 
-		obuf := bytes.Buffer{}
-		SerializeRMCP(&obuf, rmcp)
-		SerializeIPMI(&obuf, responseWrapper, responseMessage, bmcUser.Password)
-		server.WriteToUDP(obuf.Bytes(), addr)
-	}
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
 }
 
 type IPMICloseSessionRequest struct {
 	SessionID uint32
+	SessionHandle uint8			// Only when SessionID is 0
 }
 
-func HandleIPMICloseSession(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	buf := bytes.NewBuffer(message.Data)
-	request := IPMICloseSessionRequest{}
-	binary.Read(buf, binary.LittleEndian, &request)
+const (
+	COMPLETION_CODE_INVALID_SESSION_ID_IN_REQUEST =		0x87	// Close Session
+	COMPLETION_CODE_INVALID_SESSION_HANDLE_IN_REQUEST =	0x88	// Close Session
+)
 
-	//obuf := bytes.Buffer{}
+// findCloseSession looks up the session named by Close Session, either by its
+// ID or, when the ID is 0, by its handle.
+func findCloseSession(bmcIP string, data []uint8, closeRequest IPMICloseSessionRequest) (IPMISession, uint8) {
+	if closeRequest.SessionID != 0 {
+		if session, ok := GetSession(bmcIP, closeRequest.SessionID); ok {
+			return session, COMPLETION_CODE_OK
+		}
+		return IPMISession{}, COMPLETION_CODE_INVALID_SESSION_ID_IN_REQUEST
+	}
 
-	session, ok := GetSession(wrapper.SessionId)
-	if ! ok {
-		log.Printf("Unable to find session 0x%08x\n", wrapper.SessionId)
-	} else {
-		bmcUser := session.User
-		code := GetAuthenticationCode(wrapper.AuthenticationType, bmcUser.Password, wrapper.SessionId, message, wrapper.SequenceNumber)
-		if bytes.Compare(wrapper.AuthenticationCode[:], code[:]) == 0 {
-			log.Println("      IPMI Authentication Pass.")
-		} else {
-			log.Println("      IPMI Authentication Failed.")
+	if len(data) < 5 {
+		return IPMISession{}, COMPLETION_CODE_INVALID_SESSION_ID_IN_REQUEST
+	}
+	for _, session := range GetSessions(bmcIP) {
+		if session.Handle == closeRequest.SessionHandle {
+			return session, COMPLETION_CODE_OK
 		}
+	}
+	return IPMISession{}, COMPLETION_CODE_INVALID_SESSION_HANDLE_IN_REQUEST
+}
+
+func HandleIPMICloseSession(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	buf := bytes.NewBuffer(request.Data)
+	closeRequest := IPMICloseSessionRequest{}
+	binary.Read(buf, binary.LittleEndian, &closeRequest.SessionID)
+	binary.Read(buf, binary.LittleEndian, &closeRequest.SessionHandle)
+
+	if ! ctx.HasSession {
+		log.Println("      IPMI App: Close Session needs a session.")
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	session, code := findCloseSession(ctx.BMCIP, request.Data, closeRequest)
+	if code != COMPLETION_CODE_OK {
+		log.Printf("      IPMI App: Session 0x%08x (handle 0x%02x) to close is not found.\n", closeRequest.SessionID, closeRequest.SessionHandle)
+		return code, nil
+	}
+
+	// A session may always close itself, but only an administrator may
+	// close another session.
+	if session.SessionID != ctx.Session.SessionID && ctx.Session.PrivilegeLevel < PRIVILEGE_ADMINISTRATOR {
+		log.Printf("      IPMI App: Closing session 0x%08x needs the administrator privilege.\n", session.SessionID)
+		return COMPLETION_CODE_INSUFFICIENT_PRIVILEGE, nil
+	}
+
+	DeactivateSOLBySession(ctx.BMCIP, session.SessionID)
+	RemoveSession(ctx.BMCIP, session.SessionID)
+	return COMPLETION_CODE_OK, nil
+}
 
-		session.Inc()
+// Session index of Get Session Info Request
+const (
+	SESSION_INFO_CURRENT_SESSION =	0x00
+	SESSION_INFO_BY_HANDLE =	0xFE
+	SESSION_INFO_BY_ID =		0xFF
+)
 
-		responseWrapper, responseMessage := BuildResponseMessageTemplate(wrapper, message, (IPMI_NETFN_APP | IPMI_NETFN_RESPONSE), IPMI_CMD_CLOSE_SESSION)
+const (
+	LAN_CHANNEL_NUMBER =		0x01
+	SESSION_PROTOCOL_IPMI_V1_5 =	0x00
+	SESSION_PROTOCOL_IPMI_V2 =	0x10
+)
 
-		responseWrapper.SessionId = wrapper.SessionId
-		responseWrapper.SequenceNumber = session.RemoteSessionSequenceNumber
-		rmcp := BuildUpRMCPForIPMI()
+type IPMIGetSessionInfoResponse struct {
+	SessionHandle uint8			// 0 means no active session is found
+	PossibleActiveSessions uint8
+	ActiveSessions uint8
+}
 
-		obuf := bytes.Buffer{}
-		SerializeRMCP(&obuf, rmcp)
-		SerializeIPMI(&obuf, responseWrapper, responseMessage, bmcUser.Password)
-		server.WriteToUDP(obuf.Bytes(), addr)
-		RemoveSession(request.SessionID)
+// IPMIGetSessionInfoLANResponse follows IPMIGetSessionInfoResponse when an
+// active session is found.
+type IPMIGetSessionInfoLANResponse struct {
+	UserID uint8
+	PrivilegeLevel uint8
+	ProtocolAndChannel uint8
+	RemoteIP [4]uint8
+	RemoteMAC [6]uint8
+	RemotePort uint16
+}
+
+// isSessionInfoRequestValid checks the length of Get Session Info Request,
+// which depends on the session index.
+func isSessionInfoRequestValid(data []uint8) bool {
+	if len(data) < 1 {
+		return false
 	}
+	switch data[0] {
+	case SESSION_INFO_BY_HANDLE:
+		return len(data) >= 2
+	case SESSION_INFO_BY_ID:
+		return len(data) >= 5
+	}
+	return true
 }
 
-func IPMI_APP_DeserializeAndExecute(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	switch message.Command {
-	case IPMI_CMD_GET_DEVICE_ID:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_DEVICE_ID")
-		IPMIAppHandler.GetDeviceIDHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_COLD_RESET:
-		log.Println("      IPMI APP: Command = IPMI_CMD_COLD_RESET")
-		IPMIAppHandler.ColdResetHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_WARM_RESET:
-		log.Println("      IPMI APP: Command = IPMI_CMD_WARM_RESET")
-		IPMIAppHandler.WarmResetHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_SELF_TEST_RESULTS:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_SELF_TEST_RESULTS")
-		IPMIAppHandler.GetSelfTestResultHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_MANUFACTURING_TEST_ON:
-		log.Println("      IPMI APP: Command = IPMI_CMD_MANUFACTURING_TEST_ON")
-		IPMIAppHandler.ManufacturingTestOnHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_SET_ACPI_POWER_STATE:
-		log.Println("      IPMI APP: Command = IPMI_CMD_SET_ACPI_POWER_STATE")
-		IPMIAppHandler.SetACPIPowerStateHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_ACPI_POWER_STATE:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_ACPI_POWER_STATE")
-		IPMIAppHandler.GetACPIPowerStateHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_DEVICE_GUID:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_DEVICE_GUID")
-		IPMIAppHandler.GetDeviceGUIDHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_RESET_WATCHDOG_TIMER:
-		log.Println("      IPMI APP: Command = IPMI_CMD_RESET_WATCHDOG_TIMER")
-		IPMIAppHandler.ResetWatchdogTimerHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_SET_WATCHDOG_TIMER:
-		log.Println("      IPMI APP: Command = IPMI_CMD_SET_WATCHDOG_TIMER")
-		IPMIAppHandler.SetWatchdogTimerHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_WATCHDOG_TIMER:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_WATCHDOG_TIMER")
-		IPMIAppHandler.GetWatchdogTimerHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_SET_BMC_GLOBAL_ENABLES:
-		log.Println("      IPMI APP: Command = IPMI_CMD_SET_BMC_GLOBAL_ENABLES")
-		IPMIAppHandler.SetBMCGlobalEnablesHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_BMC_GLOBAL_ENABLES:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_BMC_GLOBAL_ENABLES")
-		IPMIAppHandler.GetBMCGlobalEnablesHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_CLEAR_MSG_FLAGS:
-		log.Println("      IPMI APP: Command =IPMI_CMD_CLEAR_MSG_FLAGS")
-		IPMIAppHandler.ClearMsgFlagsHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_MSG_FLAGS:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_MSG_FLAGS")
-		IPMIAppHandler.GetMsgFlagsHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_ENABLE_MESSAGE_CHANNEL_RCV:
-		log.Println("      IPMI APP: Command = IPMI_CMD_ENABLE_MESSAGE_CHANNEL_RCV")
-		IPMIAppHandler.EnableMessageChannelRcvHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_MSG:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_MSG")
-		IPMIAppHandler.GetMsgHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_SEND_MSG:
-		log.Println("      IPMI APP: Command = IPMI_CMD_SEND_MSG")
-		IPMIAppHandler.SendMsgHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_READ_EVENT_MSG_BUFFER:
-		log.Println("      IPMI APP: Command = IPMI_CMD_READ_EVENT_MSG_BUFFER")
-		IPMIAppHandler.ReadEventMsgBufferHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_BT_INTERFACE_CAPABILITIES:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_BT_INTERFACE_CAPABILITIES")
-		IPMIAppHandler.GetBTInterfaceCapabilitiesHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_SYSTEM_GUID:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_SYSTEM_GUID")
-		IPMIAppHandler.GetSystemGUIDHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_CHANNEL_AUTH_CAPABILITIES:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_CHANNEL_AUTH_CAPABILITIES")
-		IPMIAppHandler.GetChannelAuthCapabilitiesHandler(addr, server, wrapper, message)
-	case IPMI_CMD_GET_SESSION_CHALLENGE:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_SESSION_CHALLENGE")
-		IPMIAppHandler.GetSessionChallengeHandler(addr, server, wrapper, message)
-	case IPMI_CMD_ACTIVATE_SESSION:
-		log.Println("      IPMI APP: Command = IPMI_CMD_ACTIVATE_SESSION")
-		IPMIAppHandler.ActivateSessionHandler(addr, server, wrapper, message)
-	case IPMI_CMD_SET_SESSION_PRIVILEGE:
-		log.Println("      IPMI APP: Command = IPMI_CMD_SET_SESSION_PRIVILEGE")
-		IPMIAppHandler.SetSessionPrivilegeHandler(addr, server, wrapper, message)
-	case IPMI_CMD_CLOSE_SESSION:
-		log.Println("      IPMI APP: Command = IPMI_CMD_CLOSE_SESSION")
-		IPMIAppHandler.CloseSessionHandler(addr, server, wrapper, message)
-	case IPMI_CMD_GET_SESSION_INFO:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_SESSION_INFO")
-		IPMIAppHandler.GetSessionInfoHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_AUTHCODE:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_AUTHCODE")
-		IPMIAppHandler.GetAuthCodeHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_SET_CHANNEL_ACCESS:
-		log.Println("      IPMI APP: Command = IPMI_CMD_SET_CHANNEL_ACCESS")
-		IPMIAppHandler.SetChannelAccessHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_CHANNEL_ACCESS:
-		log.Println("      IPMI APP: Command =IPMI_CMD_GET_CHANNEL_ACCESS")
-		IPMIAppHandler.GetChannelAccessHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_CHANNEL_INFO:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_CHANNEL_INFO")
-		IPMIAppHandler.GetChannelInfoHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_SET_USER_ACCESS:
-		log.Println("      IPMI APP: Command = IPMI_CMD_SET_USER_ACCESS")
-		IPMIAppHandler.SetUserAccessHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_USER_ACCESS:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_USER_ACCESS")
-		IPMIAppHandler.GetUserAccessHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_SET_USER_NAME:
-		log.Println("      IPMI APP: Command = IPMI_CMD_SET_USER_NAME")
-		IPMIAppHandler.SetUserNameHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_USER_NAME:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_USER_NAME")
-		IPMIAppHandler.GetUserNameHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_SET_USER_PASSWORD:
-		log.Println("      IPMI APP: Command = IPMI_CMD_SET_USER_PASSWORD")
-		IPMIAppHandler.SetUserPasswordHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_ACTIVATE_PAYLOAD:
-		log.Println("      IPMI APP: Command = IPMI_CMD_ACTIVATE_PAYLOAD")
-		IPMIAppHandler.ActivatePayloadHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_DEACTIVATE_PAYLOAD:
-		log.Println("      IPMI APP: Command = IPMI_CMD_DEACTIVATE_PAYLOAD")
-		IPMIAppHandler.DeactivatePayloadHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_PAYLOAD_ACTIVATION_STATUS:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_PAYLOAD_ACTIVATION_STATUS")
-		IPMIAppHandler.GetPayloadActivationStatusHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_PAYLOAD_INSTANCE_INFO:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_PAYLOAD_INSTANCE_INFO")
-		IPMIAppHandler.GetPayloadInstanceInfoHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_SET_USER_PAYLOAD_ACCESS:
-		log.Println("      IPMI APP: Command = IPMI_CMD_SET_USER_PAYLOAD_ACCESS")
-		IPMIAppHandler.SetUserPayloadAccessHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_USER_PAYLOAD_ACCESS:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_USER_PAYLOAD_ACCESS")
-		IPMIAppHandler.GetUserPayloadAccessHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_CHANNEL_PAYLOAD_SUPPORT:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_CHANNEL_PAYLOAD_SUPPORT")
-		IPMIAppHandler.GetChannelPayloadSupportHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_CHANNEL_PAYLOAD_VERSION:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_CHANNEL_PAYLOAD_VERSION")
-		IPMIAppHandler.GetChannelPayloadVersionHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_CHANNEL_OEM_PAYLOAD_INFO:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_CHANNEL_OEM_PAYLOAD_INFO")
-		IPMIAppHandler.GetChannelOEMPayloadInfoHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_MASTER_READ_WRITE:
-		log.Println("      IPMI APP: Command = IPMI_CMD_MASTER_READ_WRITE")
-		IPMIAppHandler.MasterReadWriteHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_CHANNEL_CIPHER_SUITES:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_CHANNEL_CIPHER_SUITES")
-		IPMIAppHandler.GetChannelCipherSuiteHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_SUSPEND_RESUME_PAYLOAD_ENCRYPTION:
-		log.Println("      IPMI APP: Command = IPMI_CMD_SUSPEND_RESUME_PAYLOAD_ENCRYPTION")
-		IPMIAppHandler.SuspendResumePayloadEncryptionHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_SET_CHANNEL_SECURITY_KEY:
-		log.Println("      IPMI APP: Command = IPMI_CMD_SET_CHANNEL_SECURITY_KEY")
-		IPMIAppHandler.SetChannelSecurityKeyHandler(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_SYSTEM_INTERFACE_CAPABILITIES:
-		log.Println("      IPMI APP: Command = IPMI_CMD_GET_SYSTEM_INTERFACE_CAPABILITIES")
-		IPMIAppHandler.GetSystemInterfaceCapabilitiesHandler(addr, server, wrapper, message)
+// findSessionInfo looks up the active session requested by Get Session Info.
+func findSessionInfo(current IPMISession, active []IPMISession, data []uint8) (IPMISession, bool) {
+	switch index := data[0]; index {
+	case SESSION_INFO_CURRENT_SESSION:
+		return current, true
+	case SESSION_INFO_BY_HANDLE:
+		for _, session := range active {
+			if session.Handle == data[1] {
+				return session, true
+			}
+		}
+	case SESSION_INFO_BY_ID:
+		id := binary.LittleEndian.Uint32(data[1:5])
+		for _, session := range active {
+			if session.SessionID == id {
+				return session, true
+			}
+		}
+	default:
+		if int(index) <= len(active) {
+			return active[index - 1], true
+		}
+	}
+	return IPMISession{}, false
+}
+
+func HandleIPMIGetSessionInfo(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	if ! ctx.HasSession {
+		log.Println("      IPMI App: Get Session Info needs a session.")
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+	session := ctx.Session
+
+	if ! isSessionInfoRequestValid(request.Data) {
+		return COMPLETION_CODE_REQUEST_DATA_LENGTH_INVALID, nil
+	}
+
+	active := []IPMISession{}
+	for _, obj := range GetSessions(session.BMCIP) {
+		if obj.Activated {
+			active = append(active, obj)
+		}
+	}
+	found, ok := findSessionInfo(session, active, request.Data)
 
+	response := IPMIGetSessionInfoResponse{}
+	response.PossibleActiveSessions = MAX_SESSION_HANDLE
+	if obj, ok := bmc.GetBMC(net.ParseIP(session.BMCIP)); ok && obj.MaxSessions > 0 && obj.MaxSessions < MAX_SESSION_HANDLE {
+		response.PossibleActiveSessions = uint8(obj.MaxSessions)
 	}
-}
\ No newline at end of file
+	response.ActiveSessions = uint8(len(active))
+
+	dataBuf := bytes.Buffer{}
+	if ok {
+		response.SessionHandle = found.Handle
+		binary.Write(&dataBuf, binary.LittleEndian, response)
+
+		info := IPMIGetSessionInfoLANResponse{}
+		info.UserID = found.User.ID
+		info.PrivilegeLevel = found.PrivilegeLevel
+		info.ProtocolAndChannel = SESSION_PROTOCOL_IPMI_V1_5 | LAN_CHANNEL_NUMBER
+		if found.IsRMCPPlus() {
+			info.ProtocolAndChannel = SESSION_PROTOCOL_IPMI_V2 | LAN_CHANNEL_NUMBER
+		}
+		if found.RemoteAddr != nil {
+			if ip := found.RemoteAddr.IP.To4(); ip != nil {
+				copy(info.RemoteIP[:], ip)
+			}
+			info.RemotePort = uint16(found.RemoteAddr.Port)
+		}
+		binary.Write(&dataBuf, binary.LittleEndian, info)
+	} else {
+		log.Println("      IPMI App: Requested session is not active.")
+		binary.Write(&dataBuf, binary.LittleEndian, response)
+	}
+
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
@@ -0,0 +1,101 @@
+package ipmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Test vectors of RFC 1319, appendix A.5.
+var md2TestVectors = []struct {
+	input  string
+	digest string
+}{
+	{"", "8350e5a3e24c153df2275c9f80692773"},
+	{"a", "32ec01ec4a6dac72c0ab96fb34c0b5d1"},
+	{"abc", "da853b0d3f88d99b30283a69e6ded6bb"},
+	{"message digest", "ab4f496bfb2a530b219ff33031fe06b0"},
+	{"abcdefghijklmnopqrstuvwxyz", "4e8ddff3650292ab5a4108c3aa47940b"},
+}
+
+func TestComputeAuthenticationCodeMD2(t *testing.T) {
+	for _, vector := range md2TestVectors {
+		code := computeAuthenticationCode(AUTH_MD2, [16]byte{}, []byte(vector.input))
+		if digest := hex.EncodeToString(code[:]); digest != vector.digest {
+			t.Errorf("MD2(%q) = %s, want %s", vector.input, digest, vector.digest)
+		}
+	}
+}
+
+// Get Device ID, the message of seedIPMISessionRequest.
+var getDeviceIDMessage = IPMIMessage{
+	TargetAddress: 0x20,
+	TargetLun:     0x18,
+	Checksum:      0xc8,
+	SourceAddress: 0x81,
+	SourceLun:     0x04,
+	Command:       0x01,
+	DataChecksum:  0x7a,
+}
+
+func TestGetAuthenticationCode(t *testing.T) {
+	tests := []struct {
+		name               string
+		authenticationType uint8
+		code               string
+	}{
+		{"MD2", AUTH_MD2, "ef84960d8b51182c5c6ae71ce3e03d24"},
+		{"MD5", AUTH_MD5, "9558ab955d4f1fd232149ee29658ffa2"},
+		{"straight password", AUTH_STRAIGHT_KEY, "61646d696e0000000000000000000000"},
+		{"none", AUTH_NONE, "00000000000000000000000000000000"},
+	}
+
+	for _, test := range tests {
+		code := GetAuthenticationCode(test.authenticationType, "admin", 0x12345678, getDeviceIDMessage, 1)
+		if got := hex.EncodeToString(code[:]); got != test.code {
+			t.Errorf("%s: authentication code = %s, want %s", test.name, got, test.code)
+		}
+	}
+}
+
+func TestSetSessionPrivilegeLevel(t *testing.T) {
+	ctx := newUserTestBMC(t, "127.0.12.6")
+	user, _ := getTestUser(t, ctx, 2)
+	session, ok := GetNewSession(ctx.BMCIP, nil, user)
+	if !ok {
+		t.Fatal("GetNewSession failed")
+	}
+	session.AuthenticationType = AUTH_MD5
+	session.Activated = true
+	session.PrivilegeLevel = PRIVILEGE_USER
+	session.MaxPrivilegeLevel = PRIVILEGE_ADMINISTRATOR
+	session.Save()
+	t.Cleanup(func() { RemoveSession(ctx.BMCIP, session.SessionID) })
+	ctx.HasSession = true
+
+	tests := []struct {
+		name      string
+		privilege uint8
+		want      uint8
+		level     uint8
+	}{
+		{"raise to administrator", PRIVILEGE_ADMINISTRATOR, COMPLETION_CODE_OK, PRIVILEGE_ADMINISTRATOR},
+		{"no change", PRIVILEGE_HIGHEST, COMPLETION_CODE_OK, PRIVILEGE_ADMINISTRATOR},
+		{"callback", PRIVILEGE_CALLBACK, COMPLETION_CODE_INVALID_DATA_FIELD, PRIVILEGE_ADMINISTRATOR},
+		{"lower to user", PRIVILEGE_USER, COMPLETION_CODE_OK, PRIVILEGE_USER},
+		{"reserved", PRIVILEGE_OEM + 1, COMPLETION_CODE_INVALID_DATA_FIELD, PRIVILEGE_USER},
+	}
+	for _, test := range tests {
+		ctx.Session, _ = GetSession(ctx.BMCIP, session.SessionID)
+		request := IPMIRequest{NetFunction: IPMI_NETFN_APP, Command: IPMI_CMD_SET_SESSION_PRIVILEGE, Data: []uint8{test.privilege}}
+		code, data := HandleIPMISetSessionPrivilegeLevel(ctx, request)
+		if code != test.want {
+			t.Errorf("%s: completion code = 0x%02x, want 0x%02x", test.name, code, test.want)
+		} else if code == COMPLETION_CODE_OK && !bytes.Equal(data, []uint8{test.level}) {
+			t.Errorf("%s: new privilege level = % x, want %02x", test.name, data, test.level)
+		}
+		if saved, _ := GetSession(ctx.BMCIP, session.SessionID); saved.PrivilegeLevel != test.level {
+			t.Errorf("%s: session privilege level = 0x%02x, want 0x%02x", test.name, saved.PrivilegeLevel, test.level)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package ipmi
+
+import (
+	"bytes"
+	"log"
+	"net"
+)
+import (
+	"github.com/rmxymh/infra-ecosphere/bmc"
+	"github.com/rmxymh/infra-ecosphere/utils"
+)
+
+// IPMI v1.5 authentication types offered in Get Channel Authentication Capabilities
+var supportedAuthenticationTypes = []uint8 {
+	AUTH_NONE,
+	AUTH_MD2,
+	AUTH_MD5,
+	AUTH_STRAIGHT_KEY,
+}
+
+// IPMI v1.5 authentication types enabled when the BMC does not configure them.
+// AUTH_NONE logs in without a password, so it must be configured explicitly.
+var defaultAuthenticationTypes = []uint8 {
+	AUTH_MD2,
+	AUTH_MD5,
+	AUTH_STRAIGHT_KEY,
+}
+
+func IsAuthenticationTypeSupported(authenticationType uint8) bool {
+	for _, supported := range supportedAuthenticationTypes {
+		if supported == authenticationType {
+			return true
+		}
+	}
+	return false
+}
+
+// GetBMCAuthenticationTypes returns the authentication types enabled by the
+// BMC at the privilege level. Types which are not supported are left out.
+func GetBMCAuthenticationTypes(obj bmc.BMC, privilege uint8) []uint8 {
+	if obj.AuthTypes == nil {
+		return defaultAuthenticationTypes
+	}
+
+	types := []uint8{}
+	for _, authenticationType := range obj.AuthTypes[privilege] {
+		if IsAuthenticationTypeSupported(authenticationType) {
+			types = append(types, authenticationType)
+		}
+	}
+	return types
+}
+
+// IsAuthenticationTypeAllowed reports whether the BMC enables the
+// authentication type at the privilege level.
+func IsAuthenticationTypeAllowed(obj bmc.BMC, authenticationType uint8, privilege uint8) bool {
+	for _, allowed := range GetBMCAuthenticationTypes(obj, privilege) {
+		if allowed == authenticationType {
+			return true
+		}
+	}
+	return false
+}
+
+// isAuthenticationTypeEnabled reports whether the BMC enables the
+// authentication type at any privilege level.
+func isAuthenticationTypeEnabled(obj bmc.BMC, authenticationType uint8) bool {
+	for privilege := uint8(PRIVILEGE_CALLBACK); privilege <= PRIVILEGE_OEM; privilege++ {
+		if IsAuthenticationTypeAllowed(obj, authenticationType, privilege) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticationTypeBitmask converts authentication types to the bitmask of
+// Get Channel Authentication Capabilities, e.g. AUTH_MD5 to AUTH_BITMASK_MD5.
+func authenticationTypeBitmask(types []uint8) uint8 {
+	bitmask := uint8(0)
+	for _, authenticationType := range types {
+		bitmask |= 1 << authenticationType
+	}
+	return bitmask
+}
+
+func isActivateSessionRequest(message IPMIMessage) bool {
+	netFunction := (message.TargetLun & 0xFC) >> 2
+	return netFunction == IPMI_NETFN_APP && message.Command == IPMI_CMD_ACTIVATE_SESSION
+}
+
+// isUnauthenticatedRequestAllowed reports whether the request of an activated
+// session can carry AUTH_NONE instead of the authentication type of the
+// session: every request when per-message authentication is disabled, and
+// requests of USER level commands when user level authentication is disabled.
+func isUnauthenticatedRequestAllowed(wrapper IPMISessionWrapper, message IPMIMessage, session IPMISession) bool {
+	if wrapper.AuthenticationType != AUTH_NONE || ! session.Activated {
+		return false
+	}
+
+	obj, ok := bmc.GetBMC(net.ParseIP(session.BMCIP))
+	if ! ok {
+		return false
+	}
+	if ! obj.IsPerMessageAuthEnabled() {
+		return true
+	}
+
+	netFunction := (message.TargetLun & 0xFC) >> 2
+	return ! obj.IsUserLevelAuthEnabled() && GetCommandPrivilege(netFunction, message.Command) <= PRIVILEGE_USER
+}
+
+// AuthenticateIPMIRequest validates the IPMI v1.5 session wrapper of a request
+// before it is dispatched. Rejected requests are answered with a completion
+// code, and false is returned. Replayed requests are dropped silently.
+func AuthenticateIPMIRequest(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) bool {
+	// Sessionless requests, e.g. Get Session Challenge, are not authenticated.
+	if wrapper.SessionId == 0 {
+		return true
+	}
+
+	// Rejections are sent outside of the session and are not signed, because
+	// the request could not be authenticated.
+	reject := NewIPMIContext(addr, server, wrapper, message)
+
+	session, ok := GetSession(utils.GetLocalIP(server), wrapper.SessionId)
+	if ! ok || session.IsRMCPPlus() {
+		log.Printf("    IPMI: Session 0x%08x is not found, reject.\n", wrapper.SessionId)
+		if isActivateSessionRequest(message) {
+			reject.Respond(COMPLETION_CODE_INVALID_SESSION_ID, nil)
+		} else {
+			reject.Respond(COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil)
+		}
+		return false
+	}
+
+	if ! IsAuthenticationTypeSupported(wrapper.AuthenticationType) || (wrapper.AuthenticationType != session.AuthenticationType && ! isUnauthenticatedRequestAllowed(wrapper, message, session)) {
+		log.Printf("    IPMI: Authentication type 0x%02x is not allowed in session 0x%08x, reject.\n", wrapper.AuthenticationType, wrapper.SessionId)
+		reject.Respond(COMPLETION_CODE_INSUFFICIENT_PRIVILEGE, nil)
+		return false
+	}
+
+	if wrapper.AuthenticationType != AUTH_NONE {
+		code := GetAuthenticationCode(wrapper.AuthenticationType, session.User.Password, wrapper.SessionId, message, wrapper.SequenceNumber)
+		if bytes.Compare(wrapper.AuthenticationCode[:], code[:]) != 0 {
+			log.Println("    IPMI: Authentication Failed.")
+			reject.Respond(COMPLETION_CODE_INSUFFICIENT_PRIVILEGE, nil)
+			// A failed activation does not keep its session slot.
+			if ! session.Activated && isActivateSessionRequest(message) {
+				RecordLoginFailure(session.BMCIP, session.User.Username)
+				RemoveSession(session.BMCIP, session.SessionID)
+			}
+			return false
+		}
+		log.Println("    IPMI: Authentication Pass.")
+	}
+
+	if session.Activated && ResendCachedResponse(addr, server, session, wrapper.SequenceNumber, message) {
+		return false
+	}
+
+	if session.Activated && ! AcceptInboundSequenceNumber(session.BMCIP, session.SessionID, wrapper.SequenceNumber) {
+		log.Printf("    IPMI: Sequence number 0x%08x is out of the window of session 0x%08x, ignore.\n", wrapper.SequenceNumber, wrapper.SessionId)
+		return false
+	}
+
+	return true
+}
@@ -4,8 +4,6 @@ import (
 	"net"
 	"log"
 	"bytes"
-	"github.com/rmxymh/infra-ecosphere/bmc"
-	"github.com/rmxymh/infra-ecosphere/utils"
 	"encoding/binary"
 )
 
@@ -24,67 +22,33 @@ const (
 	IPMI_CMD_GET_POH_COUNTER =		0x0f
 )
 
-type IPMI_Chassis_Handler func(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage)
-
-type IPMIChassisHandlerSet struct {
-	GetChassisCapabilities	IPMI_Chassis_Handler
-	GetChassisStatus	IPMI_Chassis_Handler
-	ChassisControl		IPMI_Chassis_Handler
-	ChassisReset		IPMI_Chassis_Handler
-	ChassisIdentify		IPMI_Chassis_Handler
-	SetChassisCapabilities	IPMI_Chassis_Handler
-	SetPowerRestorePolicy	IPMI_Chassis_Handler
-	GetSystemRestartCause	IPMI_Chassis_Handler
-	SetSystemBootOptions	IPMI_Chassis_Handler
-	GetSystemBootOptions	IPMI_Chassis_Handler
-	GetPOHCounter		IPMI_Chassis_Handler
-	Unsupported		IPMI_Chassis_Handler
+var ipmiChassisCommandNames = map[uint8]string {
+	IPMI_CMD_GET_CHASSIS_CAPABILITIES:	"IPMI_CMD_GET_CHASSIS_CAPABILITIES",
+	IPMI_CMD_GET_CHASSIS_STATUS:		"IPMI_CMD_GET_CHASSIS_STATUS",
+	IPMI_CMD_CHASSIS_CONTROL:		"IPMI_CMD_CHASSIS_CONTROL",
+	IPMI_CMD_CHASSIS_RESET:			"IPMI_CMD_CHASSIS_RESET",
+	IPMI_CMD_CHASSIS_IDENTIFY:		"IPMI_CMD_CHASSIS_IDENTIFY",
+	IPMI_CMD_SET_CHASSIS_CAPABILITIES:	"IPMI_CMD_SET_CHASSIS_CAPABILITIES",
+	IPMI_CMD_SET_POWER_RESTORE_POLICY:	"IPMI_CMD_SET_POWER_RESTORE_POLICY",
+	IPMI_CMD_GET_SYSTEM_RESTART_CAUSE:	"IPMI_CMD_GET_SYSTEM_RESTART_CAUSE",
+	IPMI_CMD_SET_SYSTEM_BOOT_OPTIONS:	"IPMI_CMD_SET_SYSTEM_BOOT_OPTIONS",
+	IPMI_CMD_GET_SYSTEM_BOOT_OPTIONS:	"IPMI_CMD_GET_SYSTEM_BOOT_OPTIONS",
+	IPMI_CMD_GET_POH_COUNTER:		"IPMI_CMD_GET_POH_COUNTER",
 }
 
-var IPMIChassisHandler IPMIChassisHandlerSet = IPMIChassisHandlerSet{}
+type IPMI_Chassis_Handler func(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage)
 
+// IPMI_CHASSIS_SetHandler registers the handler of a Chassis command which
+// sends its response by itself, see RegisterIPMIHandler.
 func IPMI_CHASSIS_SetHandler(command int, handler IPMI_Chassis_Handler) {
-	switch command {
-	case IPMI_CMD_GET_CHASSIS_CAPABILITIES:
-		IPMIChassisHandler.GetChassisCapabilities = handler
-	case IPMI_CMD_GET_CHASSIS_STATUS:
-		IPMIChassisHandler.GetChassisStatus = handler
-	case IPMI_CMD_CHASSIS_CONTROL:
-		IPMIChassisHandler.ChassisControl = handler
-	case IPMI_CMD_CHASSIS_RESET:
-		IPMIChassisHandler.ChassisReset = handler
-	case IPMI_CMD_CHASSIS_IDENTIFY:
-		IPMIChassisHandler.ChassisIdentify = handler
-	case IPMI_CMD_SET_CHASSIS_CAPABILITIES:
-		IPMIChassisHandler.SetChassisCapabilities = handler
-	case IPMI_CMD_SET_POWER_RESTORE_POLICY:
-		IPMIChassisHandler.SetPowerRestorePolicy = handler
-	case IPMI_CMD_GET_SYSTEM_RESTART_CAUSE:
-		IPMIChassisHandler.GetSystemRestartCause = handler
-	case IPMI_CMD_SET_SYSTEM_BOOT_OPTIONS:
-		IPMIChassisHandler.SetSystemBootOptions = handler
-	case IPMI_CMD_GET_SYSTEM_BOOT_OPTIONS:
-		IPMIChassisHandler.GetSystemBootOptions = handler
-	case IPMI_CMD_GET_POH_COUNTER:
-		IPMIChassisHandler.GetPOHCounter = handler
-	}
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_CHASSIS, uint8(command)), "", WrapIPMIHandler(IPMI_Handler(handler)))
 }
 
 func init() {
-	IPMIChassisHandler.Unsupported = HandleIPMIUnsupportedChassisCommand
-
-	IPMI_CHASSIS_SetHandler(IPMI_CMD_GET_CHASSIS_STATUS, HandleIPMIGetChassisStatus)
-	IPMI_CHASSIS_SetHandler(IPMI_CMD_CHASSIS_CONTROL, HandleIPMIChassisControl)
-	IPMI_CHASSIS_SetHandler(IPMI_CMD_SET_SYSTEM_BOOT_OPTIONS, IPMI_CHASSIS_SetBootOption_DeserializeAndExecute)
-	IPMI_CHASSIS_SetHandler(IPMI_CMD_GET_SYSTEM_BOOT_OPTIONS, IPMI_CHASSIS_GetBootOption_DeserializeAndExecute)
-
-	IPMI_CHASSIS_SetHandler(IPMI_CMD_GET_CHASSIS_CAPABILITIES, HandleIPMIUnsupportedChassisCommand)
-	IPMI_CHASSIS_SetHandler(IPMI_CMD_CHASSIS_RESET, HandleIPMIUnsupportedChassisCommand)
-	IPMI_CHASSIS_SetHandler(IPMI_CMD_CHASSIS_IDENTIFY, HandleIPMIUnsupportedChassisCommand)
-	IPMI_CHASSIS_SetHandler(IPMI_CMD_SET_CHASSIS_CAPABILITIES, HandleIPMIUnsupportedChassisCommand)
-	IPMI_CHASSIS_SetHandler(IPMI_CMD_SET_POWER_RESTORE_POLICY, HandleIPMIUnsupportedChassisCommand)
-	IPMI_CHASSIS_SetHandler(IPMI_CMD_GET_SYSTEM_RESTART_CAUSE, HandleIPMIUnsupportedChassisCommand)
-	IPMI_CHASSIS_SetHandler(IPMI_CMD_GET_POH_COUNTER, HandleIPMIUnsupportedChassisCommand)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_CHASSIS, IPMI_CMD_GET_CHASSIS_STATUS), "", HandleIPMIGetChassisStatus)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_CHASSIS, IPMI_CMD_CHASSIS_CONTROL), "", HandleIPMIChassisControl)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_CHASSIS, IPMI_CMD_SET_SYSTEM_BOOT_OPTIONS), "", IPMI_CHASSIS_SetBootOption_DeserializeAndExecute)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_CHASSIS, IPMI_CMD_GET_SYSTEM_BOOT_OPTIONS), "", IPMI_CHASSIS_GetBootOption_DeserializeAndExecute)
 }
 
 
@@ -92,11 +56,6 @@ func init() {
 
 
 
-// Default Handler Implementation
-func HandleIPMIUnsupportedChassisCommand(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	log.Println("      IPMI Chassis: This command is not supported currently, ignore.")
-}
-
 type IPMIGetChassisStatusResponse struct {
 	CurrentPowerState uint8
 	LastPowerEvent uint8
@@ -140,50 +99,24 @@ const (
 	CHASSIS_MISC_IDENTIFY_SUPPORTED =	0x40
 )
 
-func HandleIPMIGetChassisStatus(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	session, ok := GetSession(wrapper.SessionId)
+func HandleIPMIGetChassisStatus(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	bmc, ok := ctx.GetBMC()
 	if ! ok {
-		log.Printf("Unable to find session 0x%08x\n", wrapper.SessionId)
-	} else {
-		bmcUser := session.User
-		code := GetAuthenticationCode(wrapper.AuthenticationType, bmcUser.Password, wrapper.SessionId, message, wrapper.SequenceNumber)
-		if bytes.Compare(wrapper.AuthenticationCode[:], code[:]) == 0 {
-			log.Println("      IPMI Authentication Pass.")
-		} else {
-			log.Println("      IPMI Authentication Failed.")
-		}
-
-		localIP := utils.GetLocalIP(server)
-		bmc, ok := bmc.GetBMC(net.ParseIP(localIP))
-		if ! ok {
-			log.Printf("BMC %s is not found\n", localIP)
-		} else {
-			session.Inc()
-
-			response := IPMIGetChassisStatusResponse{}
-			if bmc.VM.IsRunning() {
-				response.CurrentPowerState |= CHASSIS_POWER_STATE_BITMASK_POWER_ON
-			}
-			response.LastPowerEvent = 0
-			response.MiscChassisState = 0
-			response.FrontPanelButtonCapabilities = 0
-
-			dataBuf := bytes.Buffer{}
-			binary.Write(&dataBuf, binary.LittleEndian, response)
-
-			responseWrapper, responseMessage := BuildResponseMessageTemplate(wrapper, message, (IPMI_NETFN_CHASSIS | IPMI_NETFN_RESPONSE), IPMI_CMD_GET_CHASSIS_STATUS)
-			responseMessage.Data = dataBuf.Bytes()
-
-			responseWrapper.SessionId = wrapper.SessionId
-			responseWrapper.SequenceNumber = session.RemoteSessionSequenceNumber
-			rmcp := BuildUpRMCPForIPMI()
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
 
-			obuf := bytes.Buffer{}
-			SerializeRMCP(&obuf, rmcp)
-			SerializeIPMI(&obuf, responseWrapper, responseMessage, bmcUser.Password)
-			server.WriteToUDP(obuf.Bytes(), addr)
-		}
+	response := IPMIGetChassisStatusResponse{}
+	if bmc.VM.IsRunning() {
+		response.CurrentPowerState |= CHASSIS_POWER_STATE_BITMASK_POWER_ON
 	}
+	response.LastPowerEvent = 0
+	response.MiscChassisState = 0
+	response.FrontPanelButtonCapabilities = 0
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
 }
 
 type IPMIChassisControlRequest struct {
@@ -199,108 +132,37 @@ const (
 	CHASSIS_CONTROL_POWER_SOFT =	0x05
 )
 
-func HandleIPMIChassisControl(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	buf := bytes.NewBuffer(message.Data)
-	request := IPMIChassisControlRequest{}
-	binary.Read(buf, binary.LittleEndian, &request)
+func HandleIPMIChassisControl(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	buf := bytes.NewBuffer(request.Data)
+	control := IPMIChassisControlRequest{}
+	binary.Read(buf, binary.LittleEndian, &control)
 
-	session, ok := GetSession(wrapper.SessionId)
+	bmc, ok := ctx.GetBMC()
 	if ! ok {
-		log.Printf("Unable to find session 0x%08x\n", wrapper.SessionId)
-	} else {
-		bmcUser := session.User
-		code := GetAuthenticationCode(wrapper.AuthenticationType, bmcUser.Password, wrapper.SessionId, message, wrapper.SequenceNumber)
-		if bytes.Compare(wrapper.AuthenticationCode[:], code[:]) == 0 {
-			log.Println("      IPMI Authentication Pass.")
-		} else {
-			log.Println("      IPMI Authentication Failed.")
-		}
-
-		localIP := utils.GetLocalIP(server)
-		bmc, ok := bmc.GetBMC(net.ParseIP(localIP))
-		if ! ok {
-			log.Printf("BMC %s is not found\n", localIP)
-		} else {
-			switch request.ChassisControl {
-			case CHASSIS_CONTROL_POWER_DOWN:
-				bmc.PowerOff()
-			case CHASSIS_CONTROL_POWER_UP:
-				bmc.PowerOn()
-			case CHASSIS_CONTROL_POWER_CYCLE:
-				bmc.PowerOff()
-				bmc.PowerOn()
-			case CHASSIS_CONTROL_HARD_RESET:
-				bmc.PowerOff()
-				bmc.PowerOn()
-			case CHASSIS_CONTROL_PULSE:
-				// do nothing
-			case CHASSIS_CONTROL_POWER_SOFT:
-				bmc.PowerSoft()
-			}
-
-			session.Inc()
-
-			responseWrapper, responseMessage := BuildResponseMessageTemplate(wrapper, message, (IPMI_NETFN_CHASSIS | IPMI_NETFN_RESPONSE), IPMI_CMD_CHASSIS_CONTROL)
-
-			responseWrapper.SessionId = wrapper.SessionId
-			responseWrapper.SequenceNumber = session.RemoteSessionSequenceNumber
-			rmcp := BuildUpRMCPForIPMI()
-
-			obuf := bytes.Buffer{}
-			SerializeRMCP(&obuf, rmcp)
-			SerializeIPMI(&obuf, responseWrapper, responseMessage, bmcUser.Password)
-			server.WriteToUDP(obuf.Bytes(), addr)
-		}
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
 	}
-}
-
-
-func IPMI_CHASSIS_DeserializeAndExecute(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	switch message.Command {
-	case IPMI_CMD_GET_CHASSIS_CAPABILITIES:
-		log.Println("      IPMI CHASSIS: Command = IPMI_CMD_GET_CHASSIS_CAPABILITIES")
-		IPMIChassisHandler.GetChassisCapabilities(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_CHASSIS_STATUS:
-		log.Println("      IPMI CHASSIS: Command = IPMI_CMD_GET_CHASSIS_STATUS")
-		IPMIChassisHandler.GetChassisStatus(addr, server, wrapper, message)
-
-	case IPMI_CMD_CHASSIS_CONTROL:
-		log.Println("      IPMI CHASSIS: Command = IPMI_CMD_CHASSIS_CONTROL")
-		IPMIChassisHandler.ChassisControl(addr, server, wrapper, message)
-
-	case IPMI_CMD_CHASSIS_RESET:
-		log.Println("      IPMI CHASSIS: Command = IPMI_CMD_CHASSIS_RESET")
-		IPMIChassisHandler.ChassisReset(addr, server, wrapper, message)
-
-	case IPMI_CMD_CHASSIS_IDENTIFY:
-		log.Println("      IPMI CHASSIS: Command = IPMI_CMD_CHASSIS_IDENTIFY")
-		IPMIChassisHandler.ChassisIdentify(addr, server, wrapper, message)
-
-	case IPMI_CMD_SET_CHASSIS_CAPABILITIES:
-		log.Println("      IPMI CHASSIS: Command = IPMI_CMD_SET_CHASSIS_CAPABILITIES")
-		IPMIChassisHandler.SetChassisCapabilities(addr, server, wrapper, message)
-
-	case IPMI_CMD_SET_POWER_RESTORE_POLICY:
-		log.Println("      IPMI CHASSIS: Command = IPMI_CMD_SET_POWER_RESTORE_POLICY")
-		IPMIChassisHandler.SetPowerRestorePolicy(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_SYSTEM_RESTART_CAUSE:
-		log.Println("      IPMI CHASSIS: Command = IPMI_CMD_GET_SYSTEM_RESTART_CAUSE")
-		IPMIChassisHandler.GetSystemRestartCause(addr, server, wrapper, message)
-
-	case IPMI_CMD_SET_SYSTEM_BOOT_OPTIONS:
-		log.Println("      IPMI CHASSIS: Command = IPMI_CMD_SET_SYSTEM_BOOT_OPTIONS")
-		IPMIChassisHandler.SetSystemBootOptions(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_SYSTEM_BOOT_OPTIONS:
-		log.Println("      IPMI CHASSIS: Command = IPMI_CMD_GET_SYSTEM_BOOT_OPTIONS")
-		IPMIChassisHandler.GetSystemBootOptions(addr, server, wrapper, message)
-
-	case IPMI_CMD_GET_POH_COUNTER:
-		log.Println("      IPMI CHASSIS: Command = IPMI_CMD_GET_POH_COUNTER")
-		IPMIChassisHandler.GetPOHCounter(addr, server, wrapper, message)
 
+	switch control.ChassisControl {
+	case CHASSIS_CONTROL_POWER_DOWN:
+		bmc.PowerOff()
+	case CHASSIS_CONTROL_POWER_UP:
+		bmc.PowerOn()
+	case CHASSIS_CONTROL_POWER_CYCLE:
+		bmc.PowerOff()
+		bmc.PowerOn()
+	case CHASSIS_CONTROL_HARD_RESET:
+		bmc.PowerOff()
+		bmc.PowerOn()
+	case CHASSIS_CONTROL_PULSE:
+		// do nothing
+	case CHASSIS_CONTROL_POWER_SOFT:
+		bmc.PowerSoft()
+	default:
+		log.Printf("      IPMI Chassis: Chassis control 0x%02x is invalid, reject.\n", control.ChassisControl)
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
 	}
+
+	return COMPLETION_CODE_OK, nil
 }
 
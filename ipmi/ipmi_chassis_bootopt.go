@@ -1,12 +1,9 @@
 package ipmi
 
 import (
-	"net"
 	"bytes"
 	"encoding/binary"
 	"log"
-	"github.com/rmxymh/infra-ecosphere/bmc"
-	"github.com/rmxymh/infra-ecosphere/utils"
 	"github.com/rmxymh/infra-ecosphere/vm"
 )
 
@@ -21,7 +18,22 @@ const (
 	BOOT_INITIATOR_MAILBOX =		7
 )
 
-type IPMI_Chassis_BootOpt_Handler func(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage, selector IPMIChassisBootOptionParameterSelector)
+const (
+	COMPLETION_CODE_BOOT_OPTION_PARAMETER_NOT_SUPPORTED =	0x80
+)
+
+// Least length of the parameter data of Set System Boot Options, for the
+// parameters we support.
+var setBootOptionParameterLengths = map[uint8]int {
+	BOOT_SET_IN_PROGRESS:			1,
+	BOOT_BMC_BOOT_FLAG_VALID_BIT_CLEARING:	1,
+	BOOT_INFO_ACK:				2,
+	BOOT_FLAG:				5,
+}
+
+// IPMI_Chassis_BootOpt_Handler handles one boot option parameter of Set or
+// Get System Boot Options, like IPMICommandHandler.
+type IPMI_Chassis_BootOpt_Handler func(ctx *IPMIContext, request IPMIRequest, selector IPMIChassisBootOptionParameterSelector) (uint8, []uint8)
 
 type IPMIChassisSetBootOptHandlerSet struct {
 	SetInProgressHandler			IPMI_Chassis_BootOpt_Handler
@@ -119,38 +131,10 @@ func init() {
 
 
 
-// Utility
-func SendIPMIChassisSetBootOptionResponseBack(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	session, ok := GetSession(wrapper.SessionId)
-	if ! ok {
-		log.Printf("        IPMI CHASSIS SET BOOT OPTION: Unable to find session 0x%08x\n", wrapper.SessionId)
-	} else {
-		bmcUser := session.User
-		code := GetAuthenticationCode(wrapper.AuthenticationType, bmcUser.Password, wrapper.SessionId, message, wrapper.SequenceNumber)
-		if bytes.Compare(wrapper.AuthenticationCode[:], code[:]) == 0 {
-			log.Println("        IPMI Authentication Pass.")
-		} else {
-			log.Println("        IPMI Authentication Failed.")
-		}
-
-		session.Inc()
-
-		responseWrapper, responseMessage := BuildResponseMessageTemplate(wrapper, message, (IPMI_NETFN_CHASSIS | IPMI_NETFN_RESPONSE), IPMI_CMD_SET_SYSTEM_BOOT_OPTIONS)
-
-		responseWrapper.SessionId = wrapper.SessionId
-		responseWrapper.SequenceNumber = session.RemoteSessionSequenceNumber
-		rmcp := BuildUpRMCPForIPMI()
-
-		obuf := bytes.Buffer{}
-		SerializeRMCP(&obuf, rmcp)
-		SerializeIPMI(&obuf, responseWrapper, responseMessage, bmcUser.Password)
-		server.WriteToUDP(obuf.Bytes(), addr)
-	}
-}
-
 // Default Handler Implementation
-func HandleIPMIChassisBootOptionNotSupport(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage, selector IPMIChassisBootOptionParameterSelector) {
-	log.Printf("        IPMI BootOption %s is not supported currently.", GetBootOptionParameterSelectorString(int(selector.BootOptionParameterSelector)))
+func HandleIPMIChassisBootOptionNotSupport(ctx *IPMIContext, request IPMIRequest, selector IPMIChassisBootOptionParameterSelector) (uint8, []uint8) {
+	log.Printf("        IPMI BootOption %s is not supported currently, reject.", GetBootOptionParameterSelectorString(int(selector.BootOptionParameterSelector)))
+	return COMPLETION_CODE_BOOT_OPTION_PARAMETER_NOT_SUPPORTED, nil
 }
 
 const (
@@ -163,15 +147,15 @@ type IPMIChassisBootOptionSetInProgressRequest struct {
 	SetInProgressParameter	uint8
 }
 
-func HandleIPMIChassisSetBootOptionSetInProgress(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage, selector IPMIChassisBootOptionParameterSelector) {
+func HandleIPMIChassisSetBootOptionSetInProgress(ctx *IPMIContext, request IPMIRequest, selector IPMIChassisBootOptionParameterSelector) (uint8, []uint8) {
 	buf := bytes.NewBuffer(selector.Parameters)
 	param := uint8(0)
 	binary.Read(buf, binary.LittleEndian, &param)
-	request := IPMIChassisBootOptionSetInProgressRequest{}
-	request.SetInProgressParameter = param & 0x03
+	setInProgress := IPMIChassisBootOptionSetInProgressRequest{}
+	setInProgress.SetInProgressParameter = param & 0x03
 
 	// Simulate: We just dump log but do nothing here.
-	switch request.SetInProgressParameter {
+	switch setInProgress.SetInProgressParameter {
 	case BOOT_SET_IN_PROGRESS_SET_COMPLETE:
 		log.Println("        IPMI CHASSIS BOOT SET_IN_PROGRESS: BOOT_SET_IN_PROGRESS_SET_COMPLETE")
 	case BOOT_SET_IN_PROGRESS_SET_IN_PROTRESS:
@@ -180,7 +164,7 @@ func HandleIPMIChassisSetBootOptionSetInProgress(addr *net.UDPAddr, server *net.
 		log.Println("        IPMI CHASSIS BOOT SET_IN_PROGRESS: BOOT_SET_IN_PROGRESS_COMMIT_WRITE")
 	}
 
-	SendIPMIChassisSetBootOptionResponseBack(addr, server, wrapper, message);
+	return COMPLETION_CODE_OK, nil
 }
 
 const (
@@ -207,55 +191,55 @@ type IPMIChassisBootOptionBootInfoReuqest struct {
 	BootInitiatorAckData	uint8
 }
 
-func HandleIPMIChassisSetBootOptionBootInfoAck(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage, selector IPMIChassisBootOptionParameterSelector) {
+func HandleIPMIChassisSetBootOptionBootInfoAck(ctx *IPMIContext, request IPMIRequest, selector IPMIChassisBootOptionParameterSelector) (uint8, []uint8) {
 	buf := bytes.NewBuffer(selector.Parameters)
-	request := IPMIChassisBootOptionBootInfoReuqest{}
-	binary.Read(buf, binary.LittleEndian, &request)
+	bootInfo := IPMIChassisBootOptionBootInfoReuqest{}
+	binary.Read(buf, binary.LittleEndian, &bootInfo)
 
 	// Simulate: We just dump log but do nothing here.
-	if request.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_0 != 0 {
+	if bootInfo.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_0 != 0 {
 		log.Printf("        IPMI CHASSIS BOOT INFO ACK: Enable Write to Bit 0")
 	}
-	if request.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_1 != 0 {
+	if bootInfo.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_1 != 0 {
 		log.Printf("        IPMI CHASSIS BOOT INFO ACK: Enable Write to Bit 1")
 	}
-	if request.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_2 != 0 {
+	if bootInfo.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_2 != 0 {
 		log.Printf("        IPMI CHASSIS BOOT INFO ACK: Enable Write to Bit 2")
 	}
-	if request.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_3 != 0 {
+	if bootInfo.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_3 != 0 {
 		log.Printf("        IPMI CHASSIS BOOT INFO ACK: Enable Write to Bit 3")
 	}
-	if request.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_4 != 0 {
+	if bootInfo.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_4 != 0 {
 		log.Printf("        IPMI CHASSIS BOOT INFO ACK: Enable Write to Bit 4")
 	}
-	if request.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_5 != 0 {
+	if bootInfo.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_5 != 0 {
 		log.Printf("        IPMI CHASSIS BOOT INFO ACK: Enable Write to Bit 5")
 	}
-	if request.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_6 != 0 {
+	if bootInfo.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_6 != 0 {
 		log.Printf("        IPMI CHASSIS BOOT INFO ACK: Enable Write to Bit 6")
 	}
-	if request.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_7 != 0 {
+	if bootInfo.WriteMask & BOOT_INFO_ACK_BITMASK_WRITE_MASK_7 != 0 {
 		log.Printf("        IPMI CHASSIS BOOT INFO ACK: Enable Write to Bit 7")
 	}
 
 	// Simulate: We just dump log but do nothing here.
-	if request.BootInitiatorAckData & BOOT_INFO_ACK_BITMASK_BIOS_POST_HANDLED != 0 {
+	if bootInfo.BootInitiatorAckData & BOOT_INFO_ACK_BITMASK_BIOS_POST_HANDLED != 0 {
 		log.Printf("        IPMI CHASSIS BOOT INFO ACK: BIOS/POST has handled boot info")
 	}
-	if request.BootInitiatorAckData & BOOT_INFO_ACK_BITMASK_OS_LOADER_HANDLED != 0 {
+	if bootInfo.BootInitiatorAckData & BOOT_INFO_ACK_BITMASK_OS_LOADER_HANDLED != 0 {
 		log.Printf("        IPMI CHASSIS BOOT INFO ACK: OS Loader has handled boot info")
 	}
-	if request.BootInitiatorAckData & BOOT_INFO_ACK_BITMASK_OS_SERVICE_HANDLED != 0 {
+	if bootInfo.BootInitiatorAckData & BOOT_INFO_ACK_BITMASK_OS_SERVICE_HANDLED != 0 {
 		log.Printf("        IPMI CHASSIS BOOT INFO ACK: OS / service partition has handled boot info")
 	}
-	if request.BootInitiatorAckData & BOOT_INFO_ACK_BITMASK_SMS_HANDLED != 0 {
+	if bootInfo.BootInitiatorAckData & BOOT_INFO_ACK_BITMASK_SMS_HANDLED != 0 {
 		log.Printf("        IPMI CHASSIS BOOT INFO ACK: SMS has handled boot info")
 	}
-	if request.BootInitiatorAckData & BOOT_INFO_ACK_BITMASK_OEM_HANDLED != 0 {
+	if bootInfo.BootInitiatorAckData & BOOT_INFO_ACK_BITMASK_OEM_HANDLED != 0 {
 		log.Printf("        IPMI CHASSIS BOOT INFO ACK: OEM has handled boot info")
 	}
 
-	SendIPMIChassisSetBootOptionResponseBack(addr, server, wrapper, message);
+	return COMPLETION_CODE_OK, nil
 }
 
 // BootParam
@@ -331,50 +315,49 @@ type IPMIChassisSetBootOptionBootFlags struct {
 	Reserved	uint8
 }
 
-func HandleIPMIChassisSetBootOptionBootFlags(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage, selector IPMIChassisBootOptionParameterSelector) {
-	localIP := utils.GetLocalIP(server)
-	bmc, ok := bmc.GetBMC(net.ParseIP(localIP))
+func HandleIPMIChassisSetBootOptionBootFlags(ctx *IPMIContext, request IPMIRequest, selector IPMIChassisBootOptionParameterSelector) (uint8, []uint8) {
+	bmc, ok := ctx.GetBMC()
 	if ! ok {
-		log.Println("        IPMI CHASSIS BOOT DEVICE: BMC", localIP, " is not found, skip this request.")
-		return
+		log.Println("        IPMI CHASSIS BOOT DEVICE: BMC", ctx.BMCIP, " is not found, skip this request.")
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
 	}
 
 	buf := bytes.NewBuffer(selector.Parameters)
-	request := IPMIChassisSetBootOptionBootFlags{}
-	binary.Read(buf, binary.LittleEndian, &request)
+	bootFlags := IPMIChassisSetBootOptionBootFlags{}
+	binary.Read(buf, binary.LittleEndian, &bootFlags)
 
 	// Simulate: We just dump log but do nothing here.
-	if request.BootParam & BOOT_PARAM_BITMASK_VALID != 0 {
+	if bootFlags.BootParam & BOOT_PARAM_BITMASK_VALID != 0 {
 		log.Println("        IPMI CHASSIS BOOT FLAG: Valid")
 	}
-	if request.BootParam & BOOT_PARAM_BITMASK_PERSISTENT != 0 {
+	if bootFlags.BootParam & BOOT_PARAM_BITMASK_PERSISTENT != 0 {
 		log.Println("        IPMI CHASSIS BOOT FLAG: Persistent")
 	} else {
 		log.Println("        IPMI CHASSIS BOOT FLAG: Only on the next boot")
 	}
-	if request.BootParam & BOOT_PARAM_BITMASK_BOOT_TYPE_EFI != 0 {
+	if bootFlags.BootParam & BOOT_PARAM_BITMASK_BOOT_TYPE_EFI != 0 {
 		log.Println("        IPMI CHASSIS BOOT FLAG: Boot Type = EFI")
 	} else {
 		log.Println("        IPMI CHASSIS BOOT FLAG: Boot Type = PC Compatible (Legacy)")
 	}
 
 	// Simulate: We just dump log but do nothing here
-	if request.BootDevice & BOOT_DEVICE_BITMASK_CMOS_CLEAR != 0 {
+	if bootFlags.BootDevice & BOOT_DEVICE_BITMASK_CMOS_CLEAR != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: CMOS Clear")
 	}
-	if request.BootDevice & BOOT_DEVICE_BITMASK_LOCK_KEYBOARD != 0 {
+	if bootFlags.BootDevice & BOOT_DEVICE_BITMASK_LOCK_KEYBOARD != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: Lock Keyboard")
 	}
-	if request.BootDevice & BOOT_DEVICE_BITMASK_SCREEN_BLANK != 0 {
+	if bootFlags.BootDevice & BOOT_DEVICE_BITMASK_SCREEN_BLANK != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: Screen Blank")
 	}
-	if request.BootDevice & BOOT_DEVICE_BITMASK_LOCK_RESET != 0 {
+	if bootFlags.BootDevice & BOOT_DEVICE_BITMASK_LOCK_RESET != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: Lock RESET Buttons")
 	}
 
 	// This part contains some options that we only support: PXE, CD, HDD
 	//   Maybe there is another way to simulate remote device.
-	device := (request.BootDevice & BOOT_DEVICE_BITMASK_DEVICE) >> 2
+	device := (bootFlags.BootDevice & BOOT_DEVICE_BITMASK_DEVICE) >> 2
 	switch device {
 	case BOOT_DEVICE_FORCE_PXE:
 		log.Println("        IPMI CHASSIS BOOT DEVICE: BOOT_DEVICE_FORCE_PXE")
@@ -402,19 +385,19 @@ func HandleIPMIChassisSetBootOptionBootFlags(addr *net.UDPAddr, server *net.UDPC
 	}
 
 	// Simulate: We just dump log but do nothing here.
-	if request.BIOSVerbosity & BOOT_BIOS_BITMASK_LOCK_VIA_POWER != 0 {
+	if bootFlags.BIOSVerbosity & BOOT_BIOS_BITMASK_LOCK_VIA_POWER != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: Lock out (power off / sleep request) via Power Button")
 	}
-	if request.BIOSVerbosity & BOOT_BIOS_BITMASK_EVENT_TRAP != 0 {
+	if bootFlags.BIOSVerbosity & BOOT_BIOS_BITMASK_EVENT_TRAP != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: Force Progress Event Trap (Only for IPMI 2.0)")
 	}
-	if request.BIOSVerbosity & BOOT_BIOS_BITMASK_PASSWORD_BYPASS != 0 {
+	if bootFlags.BIOSVerbosity & BOOT_BIOS_BITMASK_PASSWORD_BYPASS != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: User password bypass")
 	}
-	if request.BIOSVerbosity & BOOT_BIOS_BITMASK_LOCK_SLEEP != 0 {
+	if bootFlags.BIOSVerbosity & BOOT_BIOS_BITMASK_LOCK_SLEEP != 0 {
 		log.Println("        IPMI CHASSIS BOOT DEVICE: Lock out Sleep Button")
 	}
-	verbosity := (request.BIOSVerbosity & BOOT_BIOS_BITMASK_FIRMWARE) >> 5
+	verbosity := (bootFlags.BIOSVerbosity & BOOT_BIOS_BITMASK_FIRMWARE) >> 5
 	switch verbosity {
 	case BOOT_BIOS_FIRMWARE_SYSTEM_DEFAULT:
 		log.Println("        IPMI CHASSIS BOOT BIOS: BOOT_BIOS_FIRMWARE_SYSTEM_DEFAULT")
@@ -423,7 +406,7 @@ func HandleIPMIChassisSetBootOptionBootFlags(addr *net.UDPAddr, server *net.UDPC
 	case BOOT_BIOS_FIRMWARE_REQUEST_VERBOSE:
 		log.Println("        IPMI CHASSIS BOOT BIOS: BOOT_BIOS_FIRMWARE_REQUEST_VERBOSE")
 	}
-	console_redirect := (request.BIOSVerbosity & BOOT_BIOS_BITMASK_CONSOLE_REDIRECT)
+	console_redirect := (bootFlags.BIOSVerbosity & BOOT_BIOS_BITMASK_CONSOLE_REDIRECT)
 	switch console_redirect {
 	case BOOT_BIOS_CONSOLE_REDIRECT_OCCURS_PER_BIOS_SETTING:
 		log.Println("        IPMI CHASSIS BOOT BIOS: BOOT_BIOS_CONSOLE_REDIRECT_OCCURS_PER_BIOS_SETTING")
@@ -434,10 +417,10 @@ func HandleIPMIChassisSetBootOptionBootFlags(addr *net.UDPAddr, server *net.UDPC
 	}
 
 	// Simulate: We just dump log but do nothing here.
-	if request.BIOSSharedMode & BOOT_BIOS_SHARED_BITMASK_OVERRIDE != 0 {
+	if bootFlags.BIOSSharedMode & BOOT_BIOS_SHARED_BITMASK_OVERRIDE != 0 {
 		log.Println("        IPMI CHASSIS BOOT BIOS: BOOT_BIOS_SHARED_BITMASK_OVERRIDE")
 	}
-	mux_control := request.BIOSSharedMode & BOOT_BIOS_SHARED_BITMASK_MUX_CONTROL_OVERRIDE
+	mux_control := bootFlags.BIOSSharedMode & BOOT_BIOS_SHARED_BITMASK_MUX_CONTROL_OVERRIDE
 	switch mux_control {
 	case BOOT_BIOS_SHARED_MUX_RECOMMENDED:
 		log.Println("        IPMI CHASSIS BOOT BIOS: BOOT_BIOS_SHARED_MUX_RECOMMENDED")
@@ -447,7 +430,7 @@ func HandleIPMIChassisSetBootOptionBootFlags(addr *net.UDPAddr, server *net.UDPC
 		log.Println("        IPMI CHASSIS BOOT BIOS: BOOT_BIOS_SHARED_MUX_TO_BMC")
 	}
 
-	SendIPMIChassisSetBootOptionResponseBack(addr, server, wrapper, message);
+	return COMPLETION_CODE_OK, nil
 }
 
 const (
@@ -458,12 +441,10 @@ const (
 	BOOT_FLAG_DONT_CLEAR_BITMASK_POWER_UP_VIA_PUSHBUTTON = 				0x01
 )
 
-func HandleIPMIChassisSetBootOptionValidBitClearing(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage, selector IPMIChassisBootOptionParameterSelector) {
-	localIP := utils.GetLocalIP(server)
-	_, ok := bmc.GetBMC(net.ParseIP(localIP))
-	if ! ok {
-		log.Println("        IPMI CHASSIS BOOT DEVICE: BMC", localIP, " is not found, skip this request.")
-		return
+func HandleIPMIChassisSetBootOptionValidBitClearing(ctx *IPMIContext, request IPMIRequest, selector IPMIChassisBootOptionParameterSelector) (uint8, []uint8) {
+	if _, ok := ctx.GetBMC(); ! ok {
+		log.Println("        IPMI CHASSIS BOOT DEVICE: BMC", ctx.BMCIP, " is not found, skip this request.")
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
 	}
 
 	validBitDontClearOn := selector.Parameters[0]
@@ -485,7 +466,7 @@ func HandleIPMIChassisSetBootOptionValidBitClearing(addr *net.UDPAddr, server *n
 		log.Println("        IPMI CHASSIS BOOT FLAG Don't Clear On: Power up via pushbutton or wake event")
 	}
 
-	SendIPMIChassisSetBootOptionResponseBack(addr, server, wrapper, message);
+	return COMPLETION_CODE_OK, nil
 }
 
 type IPMIChassisBootOptionParameterSelector struct {
@@ -516,34 +497,40 @@ func GetBootOptionParameterSelectorString(selector int) (string) {
 	return "UNKNOWN"
 }
 
-func IPMI_CHASSIS_SetBootOption_DeserializeAndExecute(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	buf := bytes.NewBuffer(message.Data)
-	request := IPMIChassisBootOptionParameterSelector{}
-	selector := uint8(0x00)
-	binary.Read(buf, binary.LittleEndian, &selector)
+func IPMI_CHASSIS_SetBootOption_DeserializeAndExecute(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	buf := bytes.NewBuffer(request.Data)
+	selector := IPMIChassisBootOptionParameterSelector{}
+	parameter := uint8(0x00)
+	binary.Read(buf, binary.LittleEndian, &parameter)
+
+	selector.Validity = ((parameter & 0x80) >> 7 != 0)
+	selector.BootOptionParameterSelector = parameter & 0x7f
+	selector.Parameters = request.Data[1:]
 
-	request.Validity = ((selector & 0x80) >> 7 != 0)
-	request.BootOptionParameterSelector = selector & 0x7f
-	request.Parameters = message.Data[1:]
+	if length, ok := setBootOptionParameterLengths[selector.BootOptionParameterSelector]; ok && len(selector.Parameters) < length {
+		log.Printf("        IPMI BootOption %s needs %d bytes of data, reject.", GetBootOptionParameterSelectorString(int(selector.BootOptionParameterSelector)), length)
+		return COMPLETION_CODE_REQUEST_DATA_LENGTH_INVALID, nil
+	}
 
-	switch request.BootOptionParameterSelector {
+	switch selector.BootOptionParameterSelector {
 	case BOOT_SET_IN_PROGRESS:
-		IPMIChassisSetBootOptHandler.SetInProgressHandler(addr, server, wrapper, message, request)
+		return IPMIChassisSetBootOptHandler.SetInProgressHandler(ctx, request, selector)
 	case BOOT_SERVICE_PARTITION_SELECTOR:
-		IPMIChassisSetBootOptHandler.ServicePartitionSelectorHandler(addr, server, wrapper, message, request)
+		return IPMIChassisSetBootOptHandler.ServicePartitionSelectorHandler(ctx, request, selector)
 	case BOOT_SERVICE_PARTITION_SCAN:
-		IPMIChassisSetBootOptHandler.ServicePartitionScanHandler(addr, server, wrapper, message, request)
+		return IPMIChassisSetBootOptHandler.ServicePartitionScanHandler(ctx, request, selector)
 	case BOOT_BMC_BOOT_FLAG_VALID_BIT_CLEARING:
-		IPMIChassisSetBootOptHandler.BMCBootFlagValidBitClearingHandler(addr, server, wrapper, message, request)
+		return IPMIChassisSetBootOptHandler.BMCBootFlagValidBitClearingHandler(ctx, request, selector)
 	case BOOT_INFO_ACK:
-		IPMIChassisSetBootOptHandler.BootInfoAcknowledgementHandler(addr, server, wrapper, message, request)
+		return IPMIChassisSetBootOptHandler.BootInfoAcknowledgementHandler(ctx, request, selector)
 	case BOOT_FLAG:
-		IPMIChassisSetBootOptHandler.BootFlagHandler(addr, server, wrapper, message, request)
+		return IPMIChassisSetBootOptHandler.BootFlagHandler(ctx, request, selector)
 	case BOOT_INITIATOR_INFO:
-		IPMIChassisSetBootOptHandler.BootInitiatorInfoHandler(addr, server, wrapper, message, request)
+		return IPMIChassisSetBootOptHandler.BootInitiatorInfoHandler(ctx, request, selector)
 	case BOOT_INITIATOR_MAILBOX:
-		IPMIChassisSetBootOptHandler.BootInitiatorMailbox(addr, server, wrapper, message, request)
+		return IPMIChassisSetBootOptHandler.BootInitiatorMailbox(ctx, request, selector)
 	}
+	return IPMIChassisSetBootOptHandler.Unsupported(ctx, request, selector)
 }
 
 type IPMIChassisGetBootOptionBootFlags struct {
@@ -556,73 +543,48 @@ type IPMIChassisGetBootOptionBootFlags struct {
 	Reserved	uint8
 }
 
-func HandleIPMIChassisGetBootOptionBootFlags(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage, selector IPMIChassisBootOptionParameterSelector) {
-	session, ok := GetSession(wrapper.SessionId)
-	if ! ok {
-		log.Printf("        IPMI CHASSIS SET BOOT OPTION: Unable to find session 0x%08x\n", wrapper.SessionId)
-	} else {
-		bmcUser := session.User
-		code := GetAuthenticationCode(wrapper.AuthenticationType, bmcUser.Password, wrapper.SessionId, message, wrapper.SequenceNumber)
-		if bytes.Compare(wrapper.AuthenticationCode[:], code[:]) == 0 {
-			log.Println("        IPMI Authentication Pass.")
-		} else {
-			log.Println("        IPMI Authentication Failed.")
-		}
-
-		session.Inc()
-
-		data := IPMIChassisGetBootOptionBootFlags{}
-		data.ParamVersion = 0x01
-		data.BootOptSelector = BOOT_FLAG
-		data.BootParam = 0
-		data.BootDevice = 0
-		data.BIOSVerbosity = 0
-		data.BIOSSharedMode = 0
-		data.Reserved = 0
-
-		dbuf := bytes.Buffer{}
-		binary.Write(&dbuf, binary.LittleEndian, data)
-
-		responseWrapper, responseMessage := BuildResponseMessageTemplate(wrapper, message, (IPMI_NETFN_CHASSIS | IPMI_NETFN_RESPONSE), IPMI_CMD_SET_SYSTEM_BOOT_OPTIONS)
-		responseMessage.Data = dbuf.Bytes()
-
-		responseWrapper.SessionId = wrapper.SessionId
-		responseWrapper.SequenceNumber = session.RemoteSessionSequenceNumber
-		rmcp := BuildUpRMCPForIPMI()
-
-		obuf := bytes.Buffer{}
-		SerializeRMCP(&obuf, rmcp)
-		SerializeIPMI(&obuf, responseWrapper, responseMessage, bmcUser.Password)
-		server.WriteToUDP(obuf.Bytes(), addr)
-	}
+func HandleIPMIChassisGetBootOptionBootFlags(ctx *IPMIContext, request IPMIRequest, selector IPMIChassisBootOptionParameterSelector) (uint8, []uint8) {
+	data := IPMIChassisGetBootOptionBootFlags{}
+	data.ParamVersion = 0x01
+	data.BootOptSelector = BOOT_FLAG
+	data.BootParam = 0
+	data.BootDevice = 0
+	data.BIOSVerbosity = 0
+	data.BIOSSharedMode = 0
+	data.Reserved = 0
+
+	dbuf := bytes.Buffer{}
+	binary.Write(&dbuf, binary.LittleEndian, data)
+	return COMPLETION_CODE_OK, dbuf.Bytes()
 }
 
-func IPMI_CHASSIS_GetBootOption_DeserializeAndExecute(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	buf := bytes.NewBuffer(message.Data)
-	request := IPMIChassisBootOptionParameterSelector{}
-	selector := uint8(0x00)
-	binary.Read(buf, binary.LittleEndian, &selector)
+func IPMI_CHASSIS_GetBootOption_DeserializeAndExecute(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	buf := bytes.NewBuffer(request.Data)
+	selector := IPMIChassisBootOptionParameterSelector{}
+	parameter := uint8(0x00)
+	binary.Read(buf, binary.LittleEndian, &parameter)
 
-	request.Validity = ((selector & 0x80) >> 7 != 0)
-	request.BootOptionParameterSelector = selector & 0x7f
-	request.Parameters = message.Data[1:]
+	selector.Validity = ((parameter & 0x80) >> 7 != 0)
+	selector.BootOptionParameterSelector = parameter & 0x7f
+	selector.Parameters = request.Data[1:]
 
-	switch request.BootOptionParameterSelector {
+	switch selector.BootOptionParameterSelector {
 	case BOOT_SET_IN_PROGRESS:
-		IPMIChassisGetBootOptHandler.SetInProgressHandler(addr, server, wrapper, message, request)
+		return IPMIChassisGetBootOptHandler.SetInProgressHandler(ctx, request, selector)
 	case BOOT_SERVICE_PARTITION_SELECTOR:
-		IPMIChassisGetBootOptHandler.ServicePartitionSelectorHandler(addr, server, wrapper, message, request)
+		return IPMIChassisGetBootOptHandler.ServicePartitionSelectorHandler(ctx, request, selector)
 	case BOOT_SERVICE_PARTITION_SCAN:
-		IPMIChassisGetBootOptHandler.ServicePartitionScanHandler(addr, server, wrapper, message, request)
+		return IPMIChassisGetBootOptHandler.ServicePartitionScanHandler(ctx, request, selector)
 	case BOOT_BMC_BOOT_FLAG_VALID_BIT_CLEARING:
-		IPMIChassisGetBootOptHandler.BMCBootFlagValidBitClearingHandler(addr, server, wrapper, message, request)
+		return IPMIChassisGetBootOptHandler.BMCBootFlagValidBitClearingHandler(ctx, request, selector)
 	case BOOT_INFO_ACK:
-		IPMIChassisGetBootOptHandler.BootInfoAcknowledgementHandler(addr, server, wrapper, message, request)
+		return IPMIChassisGetBootOptHandler.BootInfoAcknowledgementHandler(ctx, request, selector)
 	case BOOT_FLAG:
-		IPMIChassisGetBootOptHandler.BootFlagHandler(addr, server, wrapper, message, request)
+		return IPMIChassisGetBootOptHandler.BootFlagHandler(ctx, request, selector)
 	case BOOT_INITIATOR_INFO:
-		IPMIChassisGetBootOptHandler.BootInitiatorInfoHandler(addr, server, wrapper, message, request)
+		return IPMIChassisGetBootOptHandler.BootInitiatorInfoHandler(ctx, request, selector)
 	case BOOT_INITIATOR_MAILBOX:
-		IPMIChassisGetBootOptHandler.BootInitiatorMailbox(addr, server, wrapper, message, request)
+		return IPMIChassisGetBootOptHandler.BootInitiatorMailbox(ctx, request, selector)
 	}
-}
+	return IPMIChassisGetBootOptHandler.Unsupported(ctx, request, selector)
+}
\ No newline at end of file
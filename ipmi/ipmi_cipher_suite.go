@@ -0,0 +1,174 @@
+package ipmi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+)
+
+import (
+	"github.com/rmxymh/infra-ecosphere/bmc"
+)
+
+type IPMICipherSuite struct {
+	ID uint8
+	AuthenticationAlgorithm uint8
+	IntegrityAlgorithm uint8
+	ConfidentialityAlgorithm uint8
+}
+
+// Cipher suites which can be negotiated (IPMI v2.0 Table 22-20)
+var ipmiCipherSuites = []IPMICipherSuite {
+	{ 0, AUTH_ALG_RAKP_NONE, INTEGRITY_ALG_NONE, CONFIDENTIALITY_ALG_NONE },
+	{ 1, AUTH_ALG_RAKP_HMAC_SHA1, INTEGRITY_ALG_NONE, CONFIDENTIALITY_ALG_NONE },
+	{ 2, AUTH_ALG_RAKP_HMAC_SHA1, INTEGRITY_ALG_HMAC_SHA1_96, CONFIDENTIALITY_ALG_NONE },
+	{ 3, AUTH_ALG_RAKP_HMAC_SHA1, INTEGRITY_ALG_HMAC_SHA1_96, CONFIDENTIALITY_ALG_AES_CBC_128 },
+	{ 6, AUTH_ALG_RAKP_HMAC_MD5, INTEGRITY_ALG_NONE, CONFIDENTIALITY_ALG_NONE },
+	{ 7, AUTH_ALG_RAKP_HMAC_MD5, INTEGRITY_ALG_HMAC_MD5_128, CONFIDENTIALITY_ALG_NONE },
+	{ 8, AUTH_ALG_RAKP_HMAC_MD5, INTEGRITY_ALG_HMAC_MD5_128, CONFIDENTIALITY_ALG_AES_CBC_128 },
+	{ 15, AUTH_ALG_RAKP_HMAC_SHA256, INTEGRITY_ALG_NONE, CONFIDENTIALITY_ALG_NONE },
+	{ 16, AUTH_ALG_RAKP_HMAC_SHA256, INTEGRITY_ALG_HMAC_SHA256_128, CONFIDENTIALITY_ALG_NONE },
+	{ 17, AUTH_ALG_RAKP_HMAC_SHA256, INTEGRITY_ALG_HMAC_SHA256_128, CONFIDENTIALITY_ALG_AES_CBC_128 },
+}
+
+func GetIPMICipherSuite(id uint8) (IPMICipherSuite, bool) {
+	for _, suite := range ipmiCipherSuites {
+		if suite.ID == id {
+			return suite, true
+		}
+	}
+	return IPMICipherSuite{}, false
+}
+
+// GetBMCCipherSuites returns the cipher suites allowed by the BMC. Suites which
+// are not supported here are skipped. Without configured cipher suites, all
+// supported suites but RAKP-none are allowed: cipher suite 0 logs in without
+// a password, so it must be configured explicitly.
+func GetBMCCipherSuites(obj bmc.BMC) []bmc.CipherSuite {
+	if ! obj.IsRMCPPlusEnabled() {
+		return []bmc.CipherSuite{}
+	}
+
+	if obj.CipherSuites == nil {
+		suites := []bmc.CipherSuite{}
+		for _, suite := range ipmiCipherSuites {
+			if suite.AuthenticationAlgorithm == AUTH_ALG_RAKP_NONE {
+				continue
+			}
+			suites = append(suites, bmc.CipherSuite{ID: suite.ID, MaxPrivilege: PRIVILEGE_ADMINISTRATOR})
+		}
+		return suites
+	}
+
+	suites := []bmc.CipherSuite{}
+	for _, suite := range obj.CipherSuites {
+		if _, ok := GetIPMICipherSuite(suite.ID); ! ok {
+			log.Printf("      BMC %s: Cipher suite %d is not supported, skip.\n", obj.Addr.String(), suite.ID)
+			continue
+		}
+		suites = append(suites, suite)
+	}
+	return suites
+}
+
+// MatchBMCCipherSuite finds the cipher suite allowed by the BMC for the proposed algorithms.
+func MatchBMCCipherSuite(obj bmc.BMC, authAlgorithm uint8, integrityAlgorithm uint8, confidentialityAlgorithm uint8) (bmc.CipherSuite, bool) {
+	for _, allowed := range GetBMCCipherSuites(obj) {
+		suite, _ := GetIPMICipherSuite(allowed.ID)
+		if suite.AuthenticationAlgorithm == authAlgorithm &&
+			suite.IntegrityAlgorithm == integrityAlgorithm &&
+			suite.ConfidentialityAlgorithm == confidentialityAlgorithm {
+			return allowed, true
+		}
+	}
+	return bmc.CipherSuite{}, false
+}
+
+const (
+	CIPHER_SUITE_RECORD_START =		0xc0
+	CIPHER_SUITE_TAG_AUTHENTICATION =	0x00
+	CIPHER_SUITE_TAG_INTEGRITY =		0x40
+	CIPHER_SUITE_TAG_CONFIDENTIALITY =	0x80
+)
+
+const (
+	CIPHER_SUITE_LIST_BITMASK_BY_SUITE =	0x80
+	CIPHER_SUITE_LIST_BITMASK_INDEX =	0x3f
+	CIPHER_SUITE_LIST_RECORD_SIZE =		16
+)
+
+const (
+	IPMI_CHANNEL_LAN =	0x01
+	IPMI_CHANNEL_CURRENT =	0x0e
+)
+
+type IPMIGetChannelCipherSuitesRequest struct {
+	Channel uint8
+	PayloadType uint8
+	ListIndex uint8
+}
+
+func buildCipherSuiteRecords(suites []bmc.CipherSuite, bySuite bool) []byte {
+	records := bytes.Buffer{}
+	if bySuite {
+		for _, allowed := range suites {
+			suite, _ := GetIPMICipherSuite(allowed.ID)
+			records.WriteByte(CIPHER_SUITE_RECORD_START)
+			records.WriteByte(suite.ID)
+			records.WriteByte(CIPHER_SUITE_TAG_AUTHENTICATION | suite.AuthenticationAlgorithm)
+			records.WriteByte(CIPHER_SUITE_TAG_INTEGRITY | suite.IntegrityAlgorithm)
+			records.WriteByte(CIPHER_SUITE_TAG_CONFIDENTIALITY | suite.ConfidentialityAlgorithm)
+		}
+		return records.Bytes()
+	}
+
+	// List supported algorithms only, each one appears once.
+	listed := make(map[uint8]bool)
+	for _, allowed := range suites {
+		suite, _ := GetIPMICipherSuite(allowed.ID)
+		tags := []uint8{
+			CIPHER_SUITE_TAG_AUTHENTICATION | suite.AuthenticationAlgorithm,
+			CIPHER_SUITE_TAG_INTEGRITY | suite.IntegrityAlgorithm,
+			CIPHER_SUITE_TAG_CONFIDENTIALITY | suite.ConfidentialityAlgorithm,
+		}
+		for _, tag := range tags {
+			if ! listed[tag] {
+				listed[tag] = true
+				records.WriteByte(tag)
+			}
+		}
+	}
+	return records.Bytes()
+}
+
+func HandleIPMIGetChannelCipherSuites(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	buf := bytes.NewBuffer(request.Data)
+	suitesRequest := IPMIGetChannelCipherSuitesRequest{}
+	binary.Read(buf, binary.LittleEndian, &suitesRequest)
+
+	channel := suitesRequest.Channel & 0x0f
+	if channel != IPMI_CHANNEL_LAN && channel != IPMI_CHANNEL_CURRENT {
+		log.Printf("      IPMI App: Channel %d does not exist.\n", channel)
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+
+	suites := []bmc.CipherSuite{}
+	if obj, ok := ctx.GetBMC(); ok {
+		suites = GetBMCCipherSuites(obj)
+	}
+
+	records := buildCipherSuiteRecords(suites, suitesRequest.ListIndex & CIPHER_SUITE_LIST_BITMASK_BY_SUITE != 0)
+	start := int(suitesRequest.ListIndex & CIPHER_SUITE_LIST_BITMASK_INDEX) * CIPHER_SUITE_LIST_RECORD_SIZE
+	end := start + CIPHER_SUITE_LIST_RECORD_SIZE
+	if start > len(records) {
+		start = len(records)
+	}
+	if end > len(records) {
+		end = len(records)
+	}
+
+	dataBuf := bytes.Buffer{}
+	dataBuf.WriteByte(IPMI_CHANNEL_LAN)
+	dataBuf.Write(records[start:end])
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
\ No newline at end of file
@@ -0,0 +1,80 @@
+package ipmi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// TruncatedPacketError reports a packet which ends before Field of Layer,
+// e.g. the Command of an IPMI message.
+type TruncatedPacketError struct {
+	Layer string
+	Field string
+}
+
+func (err *TruncatedPacketError)Error() string {
+	return fmt.Sprintf("%s: packet is truncated at %s", err.Layer, err.Field)
+}
+
+// PacketLengthError reports a length field which is inconsistent with the
+// packet, e.g. an IPMI message length shorter than the message header.
+type PacketLengthError struct {
+	Layer string
+	Field string
+	Length int
+	Min int
+	Max int
+}
+
+func (err *PacketLengthError)Error() string {
+	return fmt.Sprintf("%s: %s %d is out of range [%d, %d]", err.Layer, err.Field, err.Length, err.Min, err.Max)
+}
+
+// ChecksumError reports an IPMI message whose header or data checksum is
+// wrong. The message itself is decoded completely, so that the request can
+// still be answered with a completion code.
+type ChecksumError struct {
+	Field string
+	Checksum uint8
+	Expected uint8
+}
+
+func (err *ChecksumError)Error() string {
+	return fmt.Sprintf("IPMI message: %s 0x%02x is wrong, expected 0x%02x", err.Field, err.Checksum, err.Expected)
+}
+
+// readField decodes one field of a packet and reports a short read as a
+// TruncatedPacketError.
+func readField(buf io.Reader, layer string, field string, data interface{}) error {
+	err := binary.Read(buf, binary.LittleEndian, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &TruncatedPacketError{Layer: layer, Field: field}
+	}
+	return err
+}
+
+// ipmiChecksum is the 2's complement checksum of the IPMI message: the sum of
+// the checksummed bytes and the checksum itself is 0.
+func ipmiChecksum(data ...uint8) uint8 {
+	sum := uint8(0)
+	for _, value := range data {
+		sum += value
+	}
+	return -sum
+}
+
+// verifyIPMIChecksums checks both checksums of a decoded request message.
+func verifyIPMIChecksums(message IPMIMessage) error {
+	expected := ipmiChecksum(message.TargetAddress, message.TargetLun)
+	if message.Checksum != expected {
+		return &ChecksumError{Field: "Header Checksum", Checksum: message.Checksum, Expected: expected}
+	}
+
+	expected = ipmiChecksum(append([]uint8{message.SourceAddress, message.SourceLun, message.Command}, message.Data...)...)
+	if message.DataChecksum != expected {
+		return &ChecksumError{Field: "Data Checksum", Checksum: message.DataChecksum, Expected: expected}
+	}
+
+	return nil
+}
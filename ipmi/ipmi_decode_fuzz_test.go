@@ -0,0 +1,128 @@
+//go:build go1.18
+// +build go1.18
+
+package ipmi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+// Get Channel Authentication Capabilities in an IPMI v1.5 session wrapper,
+// without the RMCP header.
+var seedIPMIRequest = []byte{
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x09,
+	0x20, 0x18, 0xc8, 0x81, 0x00, 0x38, 0x0e, 0x04, 0x35,
+}
+
+// Get Device ID in an MD5 authenticated session.
+var seedIPMISessionRequest = []byte{
+	0x02, 0x01, 0x00, 0x00, 0x00, 0x78, 0x56, 0x34, 0x12,
+	0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+	0x07,
+	0x20, 0x18, 0xc8, 0x81, 0x04, 0x01, 0x7a,
+}
+
+// checkDecodeError fails the test when a decoder returns an error which is
+// not one of the decoding errors.
+func checkDecodeError(t *testing.T, err error) {
+	switch err.(type) {
+	case nil, *TruncatedPacketError, *PacketLengthError, *ChecksumError:
+	default:
+		t.Fatalf("unexpected error type %T: %s", err, err)
+	}
+}
+
+func FuzzDeserializeRMCP(f *testing.F) {
+	log.SetOutput(ioutil.Discard)
+	f.Add([]byte{0x06, 0x00, 0xff, 0x07})
+	f.Add([]byte{0x06, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		length, _, err := DeserializeRMCP(bytes.NewBuffer(data))
+		checkDecodeError(t, err)
+		if err == nil && int(length) > len(data) {
+			t.Fatalf("decoded %d bytes from a %d bytes packet", length, len(data))
+		}
+	})
+}
+
+func FuzzDeserializeASF(f *testing.F) {
+	log.SetOutput(ioutil.Discard)
+	f.Add([]byte{0x00, 0x00, 0x11, 0xbe, 0x80, 0x00, 0x00, 0x00})
+	f.Add([]byte{0x00, 0x00, 0x11, 0xbe, 0x80, 0x00, 0x00, 0x10, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		length, header, err := DeserializeASF(bytes.NewBuffer(data))
+		checkDecodeError(t, err)
+		if err != nil {
+			return
+		}
+		if int(length) > len(data) || len(header.Data) != int(header.DataLen) {
+			t.Fatalf("decoded %d bytes with %d data bytes from a %d bytes packet", length, len(header.Data), len(data))
+		}
+	})
+}
+
+func FuzzDeserializeIPMI(f *testing.F) {
+	log.SetOutput(ioutil.Discard)
+	f.Add(seedIPMIRequest)
+	f.Add(seedIPMISessionRequest)
+	f.Add(seedIPMIRequest[:12])
+	f.Add([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x20, 0x18})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		length, wrapper, message, err := DeserializeIPMI(bytes.NewBuffer(data))
+		checkDecodeError(t, err)
+		if _, ok := err.(*ChecksumError); err != nil && ! ok {
+			return
+		}
+		if int(length) > len(data) {
+			t.Fatalf("decoded %d bytes from a %d bytes packet", length, len(data))
+		}
+		if len(message.Data) != int(wrapper.MessageLen) - IPMI_MESSAGE_MIN_LENGTH {
+			t.Fatalf("decoded %d data bytes from message length %d", len(message.Data), wrapper.MessageLen)
+		}
+	})
+}
+
+func FuzzDeserializeIPMIMessage(f *testing.F) {
+	log.SetOutput(ioutil.Discard)
+	f.Add(seedIPMIRequest[10:])
+	f.Add(seedIPMISessionRequest[26:])
+	f.Add([]byte{0x20, 0x18, 0xc8, 0x81, 0x04, 0x01, 0x00})
+	f.Add([]byte{0x20, 0x18, 0x00, 0x81, 0x04, 0x01, 0x7a})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		length, message, err := DeserializeIPMIMessage(bytes.NewBuffer(data), uint32(len(data)))
+		checkDecodeError(t, err)
+		if _, ok := err.(*ChecksumError); err != nil && ! ok {
+			return
+		}
+		if int(length) != len(data) || len(message.Data) != len(data) - IPMI_MESSAGE_MIN_LENGTH {
+			t.Fatalf("decoded %d bytes with %d data bytes from a %d bytes message", length, len(message.Data), len(data))
+		}
+		if err != nil || isNetFunctionResponse(message.TargetLun) {
+			return
+		}
+
+		// A request with valid checksums is serialized back to the same bytes.
+		buf := bytes.Buffer{}
+		SerializeIPMIMessage(&buf, message)
+		if ! bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("message % x is serialized to % x", data, buf.Bytes())
+		}
+	})
+}
+
+func FuzzDeserializeRMCPPlusSessionWrapper(f *testing.F) {
+	log.SetOutput(ioutil.Discard)
+	f.Add([]byte{0x06, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00})
+	f.Add([]byte{0x06, 0xc0, 0x01, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x20, 0x00})
+	f.Add([]byte{0x06, 0x02, 0x00, 0x00, 0x11, 0xbe, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		length, _, err := DeserializeRMCPPlusSessionWrapper(bytes.NewBuffer(data))
+		checkDecodeError(t, err)
+		if err == nil && int(length) > len(data) {
+			t.Fatalf("decoded %d bytes from a %d bytes packet", length, len(data))
+		}
+	})
+}
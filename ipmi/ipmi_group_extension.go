@@ -3,122 +3,45 @@ package ipmi
 import (
 	"net"
 	"log"
-	"bytes"
-	"encoding/binary"
-	"crypto/md5"
-	"github.com/htruong/go-md2"
-	"unsafe"
 )
 
+// Defining Body Codes, the group ID in the first data byte of Group Extension
+// requests.
 const (
-	GROUP_EXT_CMD_ATCA_GET_PICMG_PROP =	0x00
+	GROUP_EXT_PICMG =	0x00
+	GROUP_EXT_DMTF =	0x01
+	GROUP_EXT_SSI =		0x02
+	GROUP_EXT_VSO =		0x03
+	GROUP_EXT_DCMI =	0xDC
 )
 
-type IPMI_GroupExt_Handler func(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage)
+const (
+	GROUP_EXT_CMD_ATCA_GET_PICMG_PROP =	0x00
+)
 
-type IPMIGroupExtHandlerSet struct {
-	GetPICMGPropHandler	IPMI_GroupExt_Handler
-	UnsupportedHandler	IPMI_GroupExt_Handler
+var ipmiGroupExtPICMGCommandNames = map[uint8]string {
+	GROUP_EXT_CMD_ATCA_GET_PICMG_PROP:	"GROUP_EXT_CMD_ATCA_GET_PICMG_PROP",
 }
 
-var IPMIGroupExtHandler IPMIGroupExtHandlerSet = IPMIGroupExtHandlerSet{}
+type IPMI_GroupExt_Handler func(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage)
 
+// IPMI_GROUPEXT_SetHandler registers the handler of a PICMG Group Extension
+// command which sends its response by itself, see RegisterIPMIHandler.
 func IPMI_GROUPEXT_SetHandler(command int, handler IPMI_GroupExt_Handler) {
-	switch command {
-	case GROUP_EXT_CMD_ATCA_GET_PICMG_PROP:
-		IPMIGroupExtHandler.GetPICMGPropHandler = handler
-	}
+	RegisterIPMIHandler(IPMIGroupExtensionKey(GROUP_EXT_PICMG, uint8(command)), "", WrapIPMIHandler(IPMI_Handler(handler)))
 }
 
 func init() {
-	IPMIGroupExtHandler.UnsupportedHandler = HandleIPMIUnsupportedGroupExtCommand
-
-	IPMI_GROUPEXT_SetHandler(GROUP_EXT_CMD_ATCA_GET_PICMG_PROP, HandleIPMIGroupExtATCAGetPICMGPropHandler)
-}
-
-
-// Default Handler Implementation
-func HandleIPMIUnsupportedGroupExtCommand(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	log.Println("      IPMI GroupExt: This command is not supported currently, ignore.")
+	RegisterIPMIHandler(IPMIGroupExtensionKey(GROUP_EXT_PICMG, GROUP_EXT_CMD_ATCA_GET_PICMG_PROP), "", HandleIPMIGroupExtATCAGetPICMGPropHandler)
 }
 
 type IPMIGroupExtGetPICMGPropertiesRequest struct {
 	Signature	uint8
 }
 
-type PICMGData struct {
-	data		uint64
-}
-
-func GetAuthenticationCodePICMG(authenticationType uint8, password string, sessionID uint32, message PICMGData, sessionSeq uint32) [16]byte {
-	var passwordBytes [16]byte
-	copy(passwordBytes[:], password)
-
-	context := bytes.Buffer{}
-	binary.Write(&context, binary.LittleEndian, passwordBytes)
-	binary.Write(&context, binary.LittleEndian, sessionID)
-	binary.Write(&context, binary.LittleEndian, message)
-	binary.Write(&context, binary.LittleEndian, sessionSeq)
-	binary.Write(&context, binary.LittleEndian, passwordBytes)
-
-	var code [16]byte
-	switch authenticationType {
-	case AUTH_MD5:
-		code = md5.Sum(context.Bytes())
-	case AUTH_MD2:
-		hash := md2.New()
-		md2Code := hash.Sum(context.Bytes())
-		for i := range md2Code {
-			if i >= len(code) {
-				break
-			}
-			code[i] = md2Code[i]
-		}
-	}
-
-	return code
-}
-
-func HandleIPMIGroupExtATCAGetPICMGPropHandler(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	buf := bytes.NewBuffer(message.Data)
-	request := IPMIChassisControlRequest{}
-	binary.Read(buf, binary.LittleEndian, &request)
-
-	session, ok := GetSession(wrapper.SessionId)
-	if ! ok {
-		log.Printf("Unable to find session 0x%08x\n", wrapper.SessionId)
-	} else {
-		bmcUser := session.User
-		responseMessage := PICMGData{
-			data: 0x81b4cb201800c107,
-		}
-
-		session.LocalSessionSequenceNumber += 1
-		session.RemoteSessionSequenceNumber += 1
-
-		responseWrapper := IPMISessionWrapper{}
-		responseWrapper.AuthenticationType = wrapper.AuthenticationType
-		responseWrapper.SequenceNumber = 0xff
-		responseWrapper.SessionId = wrapper.SessionId
-		responseWrapper.SequenceNumber = session.RemoteSessionSequenceNumber
-		responseWrapper.AuthenticationCode = GetAuthenticationCodePICMG(wrapper.AuthenticationType, bmcUser.Password, responseWrapper.SessionId, responseMessage, responseWrapper.SequenceNumber)
-		responseWrapper.MessageLen = uint8(unsafe.Sizeof(responseMessage))
-		rmcp := BuildUpRMCPForIPMI()
-
-		obuf := bytes.Buffer{}
-		SerializeRMCP(&obuf, rmcp)
-		SerializeIPMISessionWrapper(&obuf, responseWrapper)
-		binary.Write(&obuf, binary.LittleEndian, responseMessage)
-		server.WriteToUDP(obuf.Bytes(), addr)
-	}
-}
-
-func IPMI_GROUPEXT_DeserializeAndExecute(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) {
-	switch message.Command {
-	case GROUP_EXT_CMD_ATCA_GET_PICMG_PROP:
-		log.Println("      IPMI CHASSIS: Command = GROUP_EXT_CMD_ATCA_GET_PICMG_PROP")
-		IPMIGroupExtHandler.GetPICMGPropHandler(addr, server, wrapper, message)
-	default:
-		IPMIGroupExtHandler.UnsupportedHandler(addr, server, wrapper, message)
-	}
+// HandleIPMIGroupExtATCAGetPICMGPropHandler answers the probe of ipmitool for
+// an ATCA board the way a non-ATCA BMC does.
+func HandleIPMIGroupExtATCAGetPICMGPropHandler(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	log.Println("      IPMI GroupExt: This BMC is not an ATCA board, reject.")
+	return COMPLETION_CODE_INVALID_COMMAND, nil
 }
\ No newline at end of file
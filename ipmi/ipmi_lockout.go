@@ -0,0 +1,115 @@
+package ipmi
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+import (
+	"github.com/rmxymh/infra-ecosphere/bmc"
+)
+
+// Failed activations of a user on a BMC, like the Bad Password Threshold of
+// the LAN configuration (IPMI v2.0 Table 23-4, parameter 26).
+type loginFailureKey struct {
+	BMCIP string
+	Username string
+}
+
+type loginFailure struct {
+	Count int
+	LastFailure time.Time
+	Locked bool
+	LockedAt time.Time
+}
+
+var loginFailures map[loginFailureKey]loginFailure
+var loginFailuresLock sync.Mutex
+
+// timeNow is the clock of the lockouts and the challenge rate limits, so that
+// tests can move it.
+var timeNow = time.Now
+
+func init() {
+	loginFailures = make(map[loginFailureKey]loginFailure)
+}
+
+// expired reports whether the failures have been forgotten: the lockout is
+// over, or the user is not locked and the attempt count is reset.
+func (failure *loginFailure)expired(obj bmc.BMC, now time.Time) bool {
+	if failure.Locked {
+		return obj.UserLockoutInterval > 0 && now.Sub(failure.LockedAt) >= obj.UserLockoutInterval
+	}
+	return obj.AttemptCountResetInterval > 0 && now.Sub(failure.LastFailure) >= obj.AttemptCountResetInterval
+}
+
+// RecordLoginFailure counts a failed activation of the user. The user is
+// locked out when the count reaches the threshold of the BMC.
+func RecordLoginFailure(bmcIP string, username string) {
+	obj, ok := bmc.GetBMC(net.ParseIP(bmcIP))
+	if ! ok || obj.BadPasswordThreshold == 0 {
+		return
+	}
+
+	loginFailuresLock.Lock()
+	defer loginFailuresLock.Unlock()
+
+	now := timeNow()
+	key := loginFailureKey{bmcIP, username}
+	failure := loginFailures[key]
+	if failure.expired(obj, now) {
+		failure = loginFailure{}
+	}
+	if failure.Locked {
+		return
+	}
+
+	failure.Count += 1
+	failure.LastFailure = now
+	if failure.Count >= obj.BadPasswordThreshold {
+		log.Printf("    Lockout: User %s of BMC %s fails to activate sessions %d times, lock it out.\n", username, bmcIP, failure.Count)
+		failure.Locked = true
+		failure.LockedAt = now
+	}
+	loginFailures[key] = failure
+}
+
+// ResetLoginFailures forgets the failed activations of the user, and unlocks
+// it. It is called when the user activates a session, or when its password
+// is set or it is enabled.
+func ResetLoginFailures(bmcIP string, username string) {
+	loginFailuresLock.Lock()
+	defer loginFailuresLock.Unlock()
+
+	delete(loginFailures, loginFailureKey{bmcIP, username})
+}
+
+func IsUserLockedOut(bmcIP string, username string) bool {
+	obj, ok := bmc.GetBMC(net.ParseIP(bmcIP))
+	if ! ok {
+		return false
+	}
+
+	loginFailuresLock.Lock()
+	defer loginFailuresLock.Unlock()
+
+	failure, ok := loginFailures[loginFailureKey{bmcIP, username}]
+	return ok && failure.Locked && ! failure.expired(obj, timeNow())
+}
+
+// RemoveExpiredLoginFailures forgets the failures which have expired.
+func RemoveExpiredLoginFailures(now time.Time) {
+	loginFailuresLock.Lock()
+	defer loginFailuresLock.Unlock()
+
+	for key, failure := range loginFailures {
+		obj, ok := bmc.GetBMC(net.ParseIP(key.BMCIP))
+		if ! ok || failure.expired(obj, now) {
+			if failure.Locked {
+				log.Printf("    Lockout: User %s of BMC %s is unlocked.\n", key.Username, key.BMCIP)
+			}
+			delete(loginFailures, key)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package ipmi
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rmxymh/infra-ecosphere/bmc"
+	"github.com/rmxymh/infra-ecosphere/vm"
+)
+
+// setTestClock replaces the clock of the lockouts and the rate limits with
+// one which only moves when the test moves it.
+func setTestClock(t *testing.T) *time.Time {
+	t.Helper()
+
+	clock := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	savedTimeNow := timeNow
+	timeNow = func() time.Time { return clock }
+	t.Cleanup(func() { timeNow = savedTimeNow })
+	return &clock
+}
+
+// newLockoutTestBMC registers a BMC which locks a user out for a minute after
+// 3 failures, and forgets the failures of an unlocked user after 30 seconds.
+func newLockoutTestBMC(t *testing.T, ip string) *time.Time {
+	t.Helper()
+
+	clock := setTestClock(t)
+	obj := bmc.AddBMC(net.ParseIP(ip), vm.Instance{})
+	obj.BadPasswordThreshold = 3
+	obj.AttemptCountResetInterval = 30 * time.Second
+	obj.UserLockoutInterval = time.Minute
+	obj.Save()
+	t.Cleanup(func() {
+		ResetLoginFailures(ip, "admin")
+		bmc.RemoveBMC(obj.Addr)
+	})
+	return clock
+}
+
+func TestLockoutAfterFailures(t *testing.T) {
+	ip := "127.0.12.1"
+	newLockoutTestBMC(t, ip)
+
+	for i := 1; i < 3; i += 1 {
+		RecordLoginFailure(ip, "admin")
+		if IsUserLockedOut(ip, "admin") {
+			t.Fatalf("user is locked out after %d failures", i)
+		}
+	}
+	RecordLoginFailure(ip, "admin")
+	if !IsUserLockedOut(ip, "admin") {
+		t.Fatal("user is not locked out after 3 failures")
+	}
+	if IsUserLockedOut(ip, "operator") {
+		t.Error("another user is locked out")
+	}
+}
+
+func TestLockoutExpires(t *testing.T) {
+	ip := "127.0.12.2"
+	clock := newLockoutTestBMC(t, ip)
+
+	for i := 0; i < 3; i += 1 {
+		RecordLoginFailure(ip, "admin")
+	}
+	*clock = clock.Add(time.Minute - time.Second)
+	// Failures while locked do not extend the lockout.
+	RecordLoginFailure(ip, "admin")
+	if !IsUserLockedOut(ip, "admin") {
+		t.Fatal("user is unlocked before the lockout interval")
+	}
+
+	*clock = clock.Add(time.Second)
+	if IsUserLockedOut(ip, "admin") {
+		t.Fatal("user is still locked out after the lockout interval")
+	}
+
+	// The count starts over after the lockout.
+	RecordLoginFailure(ip, "admin")
+	if IsUserLockedOut(ip, "admin") {
+		t.Error("user is locked out again by one failure")
+	}
+
+	RemoveExpiredLoginFailures(clock.Add(30 * time.Second))
+	loginFailuresLock.Lock()
+	_, found := loginFailures[loginFailureKey{ip, "admin"}]
+	loginFailuresLock.Unlock()
+	if found {
+		t.Error("expired failures are not removed")
+	}
+}
+
+func TestLockoutAttemptCountReset(t *testing.T) {
+	ip := "127.0.12.3"
+	clock := newLockoutTestBMC(t, ip)
+
+	RecordLoginFailure(ip, "admin")
+	RecordLoginFailure(ip, "admin")
+	*clock = clock.Add(30 * time.Second)
+	RecordLoginFailure(ip, "admin")
+	if IsUserLockedOut(ip, "admin") {
+		t.Error("user is locked out by failures older than the attempt count reset interval")
+	}
+}
+
+func TestLockoutResetByLogin(t *testing.T) {
+	ip := "127.0.12.4"
+	newLockoutTestBMC(t, ip)
+
+	RecordLoginFailure(ip, "admin")
+	RecordLoginFailure(ip, "admin")
+	// A session activated by the user resets the count.
+	ResetLoginFailures(ip, "admin")
+	RecordLoginFailure(ip, "admin")
+	RecordLoginFailure(ip, "admin")
+	if IsUserLockedOut(ip, "admin") {
+		t.Fatal("user is locked out by failures before a successful login")
+	}
+	RecordLoginFailure(ip, "admin")
+	if !IsUserLockedOut(ip, "admin") {
+		t.Fatal("user is not locked out after 3 failures")
+	}
+
+	ResetLoginFailures(ip, "admin")
+	if IsUserLockedOut(ip, "admin") {
+		t.Error("user is still locked out after the reset")
+	}
+}
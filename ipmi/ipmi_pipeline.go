@@ -0,0 +1,287 @@
+package ipmi
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"sync"
+)
+import (
+	"github.com/rmxymh/infra-ecosphere/bmc"
+	"github.com/rmxymh/infra-ecosphere/utils"
+)
+
+// IPMIContext carries a request through the middleware chain: where it came
+// from, the session it belongs to and how the response is sent. Handlers only
+// need BMCIP, Session and HasSession, so they can be run without a socket.
+type IPMIContext struct {
+	Addr *net.UDPAddr
+	Server *net.UDPConn
+	Wrapper IPMISessionWrapper
+	Message IPMIMessage
+	BMCIP string
+	Name string			// command name of the registered handler
+
+	Session IPMISession		// valid when HasSession is true
+	HasSession bool
+
+	// Responded is set once the response has been sent.
+	Responded bool
+
+	// AfterResponse is run once the response has been sent, e.g. to start a
+	// payload which must not reach the remote console before the response.
+	AfterResponse func()
+
+	// Send writes the response packet. The packet is sent to Addr via Server
+	// when Send is nil.
+	Send func(packet []byte)
+}
+
+// IPMIRequest is the part of a request a command handler works on.
+type IPMIRequest struct {
+	Key IPMIHandlerKey
+	NetFunction uint8
+	Command uint8
+	Data []uint8
+}
+
+// IPMICommandHandler handles the request of one command and returns the
+// completion code and the response data. The response is signed and sent by
+// the middleware chain.
+type IPMICommandHandler func(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8)
+
+// IPMIMiddleware wraps a handler with the work shared by all commands.
+type IPMIMiddleware func(next IPMICommandHandler) IPMICommandHandler
+
+func NewIPMIContext(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage) *IPMIContext {
+	ctx := IPMIContext{}
+	ctx.Addr = addr
+	ctx.Server = server
+	ctx.Wrapper = wrapper
+	ctx.Message = message
+	ctx.BMCIP = wrapper.BMCIP
+	if ctx.BMCIP == "" && server != nil {
+		ctx.BMCIP = utils.GetLocalIP(server)
+	}
+	return &ctx
+}
+
+// NewIPMIRequest builds the request of a message. ok is false when a Group
+// Extension or OEM/Group request is too short to carry its group ID or IANA.
+func NewIPMIRequest(message IPMIMessage) (request IPMIRequest, ok bool) {
+	request.Key, ok = GetIPMIHandlerKey(message)
+	request.NetFunction = (message.TargetLun & 0xFC) >> 2
+	request.Command = message.Command
+	request.Data = message.Data
+	return request, ok
+}
+
+func (ctx *IPMIContext)GetBMC() (bmc.BMC, bool) {
+	return bmc.GetBMC(net.ParseIP(ctx.BMCIP))
+}
+
+// RemoteIP is the address of the remote console, or nil when the request did
+// not come from a socket.
+func (ctx *IPMIContext)RemoteIP() net.IP {
+	if ctx.Addr == nil {
+		return nil
+	}
+	return ctx.Addr.IP
+}
+
+// Respond signs the response for the session of the request, or sends it
+// outside of a session when the request is sessionless, and marks the request
+// as responded.
+func (ctx *IPMIContext)Respond(completionCode uint8, data []uint8) {
+	ctx.Responded = true
+
+	netFunction := (ctx.Message.TargetLun & 0xFC) >> 2
+	responseWrapper, responseMessage := BuildResponseMessageTemplate(ctx.Wrapper, ctx.Message, (netFunction | IPMI_NETFN_RESPONSE), ctx.Message.Command)
+	responseMessage.CompletionCode = completionCode
+	responseMessage.Data = data
+	responseWrapper.BMCIP = ctx.BMCIP
+
+	password := ""
+	if ctx.HasSession {
+		responseWrapper.SequenceNumber = ctx.Session.RemoteSessionSequenceNumber
+		password = ctx.Session.User.Password
+	} else {
+		if responseWrapper.AuthenticationType != AUTH_RMCP_PLUS {
+			responseWrapper.AuthenticationType = AUTH_NONE
+		}
+		responseWrapper.SessionId = 0
+		responseWrapper.SequenceNumber = 0
+	}
+	rmcp := BuildUpRMCPForIPMI()
+
+	obuf := bytes.Buffer{}
+	SerializeRMCP(&obuf, rmcp)
+	SerializeIPMI(&obuf, responseWrapper, responseMessage, password)
+
+	if ctx.Send != nil {
+		ctx.Send(obuf.Bytes())
+	} else if ctx.Server != nil {
+		ctx.Server.WriteToUDP(obuf.Bytes(), ctx.Addr)
+	}
+}
+
+// RejectIPMIRequest answers a request which is not dispatched to a handler,
+// e.g. one with a bad checksum, with a completion code only. The response is
+// signed for the session of the request, or sent outside of a session when
+// the request is sessionless.
+func RejectIPMIRequest(ctx *IPMIContext, completionCode uint8) {
+	if ctx.Wrapper.SessionId != 0 {
+		if session, ok := GetSession(ctx.BMCIP, ctx.Wrapper.SessionId); ok {
+			session.Inc()
+			ctx.Session = session
+			ctx.HasSession = true
+		}
+	}
+	ctx.Respond(completionCode, nil)
+}
+
+// IPMIResponseMiddleware sends the completion code and data returned by the
+// handler, unless the request has been answered already, and then runs
+// AfterResponse.
+func IPMIResponseMiddleware(next IPMICommandHandler) IPMICommandHandler {
+	return func(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+		completionCode, data := next(ctx, request)
+		if ! ctx.Responded {
+			ctx.Respond(completionCode, data)
+		}
+		if ctx.AfterResponse != nil {
+			ctx.AfterResponse()
+		}
+		return completionCode, data
+	}
+}
+
+// IPMISequenceMiddleware takes the outbound session sequence number of the
+// response. The session is read again, because the handler may have changed
+// it, e.g. Set Session Privilege Level.
+func IPMISequenceMiddleware(next IPMICommandHandler) IPMICommandHandler {
+	return func(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+		completionCode, data := next(ctx, request)
+		if ctx.Responded || ! ctx.HasSession {
+			return completionCode, data
+		}
+
+		if session, ok := GetSession(ctx.BMCIP, ctx.Session.SessionID); ok {
+			session.Inc()
+			ctx.Session = session
+		} else {
+			// The handler has removed the session, e.g. Close Session.
+			ctx.Session.RemoteSessionSequenceNumber = nextSequenceNumber(ctx.Session.RemoteSessionSequenceNumber)
+		}
+		return completionCode, data
+	}
+}
+
+// IPMILogMiddleware logs the command and the completion code of rejected
+// requests.
+func IPMILogMiddleware(next IPMICommandHandler) IPMICommandHandler {
+	return func(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+		log.Printf("    IPMI: NetFunction = %s (0x%02x)\n", IPMINetFunctionName(request.NetFunction), request.NetFunction)
+		if len(ctx.Name) > 0 {
+			log.Printf("      IPMI %s: Command = %s\n", IPMINetFunctionName(request.NetFunction), ctx.Name)
+		} else {
+			log.Printf("      IPMI: Command = %s\n", request.Key)
+		}
+
+		completionCode, data := next(ctx, request)
+		if ! ctx.Responded && completionCode != COMPLETION_CODE_OK {
+			log.Printf("      IPMI: Completion Code = 0x%02x\n", completionCode)
+		}
+		return completionCode, data
+	}
+}
+
+// IPMISessionMiddleware finds the session of the request. The session wrapper
+// has been authenticated when the request was decoded; a request whose session
+// has gone in the meantime is answered with
+// COMPLETION_CODE_NOT_SUPPORTED_IN_STATE.
+func IPMISessionMiddleware(next IPMICommandHandler) IPMICommandHandler {
+	return func(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+		if ctx.Wrapper.SessionId == 0 {
+			return next(ctx, request)
+		}
+
+		session, ok := GetSession(ctx.BMCIP, ctx.Wrapper.SessionId)
+		if ! ok {
+			log.Printf("    IPMI: Session 0x%08x is not found, reject.\n", ctx.Wrapper.SessionId)
+			return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+		}
+		ctx.Session = session
+		ctx.HasSession = true
+		return next(ctx, request)
+	}
+}
+
+var ipmiMiddlewares = []IPMIMiddleware {
+	IPMIResponseMiddleware,
+	IPMISequenceMiddleware,
+	IPMILogMiddleware,
+	IPMISessionMiddleware,
+	IPMIPrivilegeMiddleware,
+	IPMIRequestLengthMiddleware,
+}
+var ipmiMiddlewaresLock sync.RWMutex
+
+// UseIPMIMiddleware appends a middleware to the chain. It runs after the
+// built-in middlewares have accepted the request, right before the handler.
+func UseIPMIMiddleware(middleware IPMIMiddleware) {
+	ipmiMiddlewaresLock.Lock()
+	defer ipmiMiddlewaresLock.Unlock()
+
+	ipmiMiddlewares = append(ipmiMiddlewares, middleware)
+}
+
+// ChainIPMIHandler wraps the handler with all middlewares, the first
+// middleware outermost.
+func ChainIPMIHandler(handler IPMICommandHandler) IPMICommandHandler {
+	ipmiMiddlewaresLock.RLock()
+	defer ipmiMiddlewaresLock.RUnlock()
+
+	for i := len(ipmiMiddlewares) - 1; i >= 0; i -= 1 {
+		handler = ipmiMiddlewares[i](handler)
+	}
+	return handler
+}
+
+// WrapIPMIHandler adapts a handler which sends its response by itself to the
+// middleware chain.
+func WrapIPMIHandler(handler IPMI_Handler) IPMICommandHandler {
+	return func(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+		handler(ctx.Addr, ctx.Server, ctx.Wrapper, ctx.Message)
+		ctx.Responded = true
+		return COMPLETION_CODE_OK, nil
+	}
+}
+
+// ServeIPMIRequest runs the registered handler of the request through the
+// middleware chain. Requests of unregistered commands are answered with
+// COMPLETION_CODE_INVALID_COMMAND.
+func ServeIPMIRequest(ctx *IPMIContext) {
+	request, ok := NewIPMIRequest(ctx.Message)
+	handler := IPMICommandHandler(HandleIPMIUnsupportedCommand)
+	if ! ok {
+		handler = handleIPMIMissingBodyCode
+	} else if entry, found := LookupIPMIHandler(request.Key); found {
+		ctx.Name = entry.Name
+		handler = entry.Handler
+	}
+
+	ChainIPMIHandler(handler)(ctx, request)
+}
+
+// HandleIPMIUnsupportedCommand answers the commands we don't simulate with
+// COMPLETION_CODE_INVALID_COMMAND.
+func HandleIPMIUnsupportedCommand(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	log.Printf("      IPMI %s: Command 0x%02x is not supported currently, reject.\n", IPMINetFunctionName(request.NetFunction), request.Command)
+	return COMPLETION_CODE_INVALID_COMMAND, nil
+}
+
+func handleIPMIMissingBodyCode(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	log.Println("      IPMI: Request has no group ID or IANA, reject.")
+	return COMPLETION_CODE_REQUEST_DATA_LENGTH_INVALID, nil
+}
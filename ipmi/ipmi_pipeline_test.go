@@ -0,0 +1,147 @@
+package ipmi
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rmxymh/infra-ecosphere/bmc"
+)
+
+// The BMC of the pipeline tests. It is not registered, the commands under
+// test don't need one.
+const pipelineTestBMCIP = "127.0.9.1"
+
+func newPipelineTestSession(t *testing.T, privilege uint8) IPMISession {
+	t.Helper()
+
+	user := bmc.BMCUser{
+		ID:            2,
+		Username:      "admin",
+		Password:      "admin",
+		MaxPrivilege:  PRIVILEGE_ADMINISTRATOR,
+		Enabled:       true,
+		IPMIMessaging: true,
+	}
+	session, ok := GetNewSession(pipelineTestBMCIP, nil, user)
+	if !ok {
+		t.Fatal("GetNewSession failed")
+	}
+	session.AuthenticationType = AUTH_MD5
+	session.Activated = true
+	session.PrivilegeLevel = privilege
+	session.MaxPrivilegeLevel = PRIVILEGE_ADMINISTRATOR
+	session.Save()
+	t.Cleanup(func() { RemoveSession(pipelineTestBMCIP, session.SessionID) })
+	return session
+}
+
+// serveTestRequest runs a request of the session through ServeIPMIRequest and
+// decodes the response it sends.
+func serveTestRequest(t *testing.T, session IPMISession, netFunction uint8, command uint8, data []uint8) (IPMISessionWrapper, IPMIMessage) {
+	t.Helper()
+
+	wrapper := IPMISessionWrapper{
+		AuthenticationType: AUTH_MD5,
+		SequenceNumber:     session.LocalSessionSequenceNumber + 1,
+		SessionId:          session.SessionID,
+		BMCIP:              pipelineTestBMCIP,
+	}
+	message := IPMIMessage{
+		TargetAddress: 0x20,
+		TargetLun:     netFunction << 2,
+		SourceAddress: 0x81,
+		SourceLun:     0x04,
+		Command:       command,
+		Data:          data,
+	}
+
+	var packets [][]byte
+	ctx := NewIPMIContext(nil, nil, wrapper, message)
+	ctx.Send = func(packet []byte) {
+		packets = append(packets, packet)
+	}
+	ServeIPMIRequest(ctx)
+
+	if len(packets) != 1 {
+		t.Fatalf("%d responses are sent, want 1", len(packets))
+	}
+	// Skip the RMCP header.
+	_, responseWrapper, responseMessage, err := DeserializeIPMI(bytes.NewBuffer(packets[0][4:]))
+	if err != nil {
+		t.Fatalf("DeserializeIPMI of the response: %v", err)
+	}
+	if len(responseMessage.Data) == 0 {
+		t.Fatal("the response has no completion code")
+	}
+	return responseWrapper, responseMessage
+}
+
+// checkCompletionCode checks the completion code, the first byte of the data
+// of a decoded response.
+func checkCompletionCode(t *testing.T, message IPMIMessage, want uint8) {
+	t.Helper()
+
+	if got := message.Data[0]; got != want {
+		t.Errorf("completion code = 0x%02x, want 0x%02x", got, want)
+	}
+}
+
+func TestPipelineInsufficientPrivilege(t *testing.T) {
+	session := newPipelineTestSession(t, PRIVILEGE_USER)
+
+	// Chassis Control needs OPERATOR.
+	_, message := serveTestRequest(t, session, IPMI_NETFN_CHASSIS, IPMI_CMD_CHASSIS_CONTROL, []uint8{0x02})
+	checkCompletionCode(t, message, COMPLETION_CODE_INSUFFICIENT_PRIVILEGE)
+}
+
+func TestPipelineRequestTooShort(t *testing.T) {
+	session := newPipelineTestSession(t, PRIVILEGE_ADMINISTRATOR)
+
+	_, message := serveTestRequest(t, session, IPMI_NETFN_CHASSIS, IPMI_CMD_CHASSIS_CONTROL, nil)
+	checkCompletionCode(t, message, COMPLETION_CODE_REQUEST_DATA_LENGTH_INVALID)
+}
+
+func TestPipelineRequestTooLong(t *testing.T) {
+	session := newPipelineTestSession(t, PRIVILEGE_ADMINISTRATOR)
+
+	_, message := serveTestRequest(t, session, IPMI_NETFN_CHASSIS, IPMI_CMD_GET_CHASSIS_STATUS, []uint8{0x00})
+	checkCompletionCode(t, message, COMPLETION_CODE_REQUEST_DATA_LENGTH_EXCEEDED)
+}
+
+func TestPipelineUnregisteredCommand(t *testing.T) {
+	session := newPipelineTestSession(t, PRIVILEGE_ADMINISTRATOR)
+
+	key := IPMICommandKey(IPMI_NETFN_APP, 0x5e)
+	if _, ok := LookupIPMIHandler(key); ok {
+		t.Fatalf("%s is registered", key)
+	}
+	_, message := serveTestRequest(t, session, IPMI_NETFN_APP, 0x5e, nil)
+	checkCompletionCode(t, message, COMPLETION_CODE_INVALID_COMMAND)
+}
+
+func TestPipelineHandlerCompletionCode(t *testing.T) {
+	session := newPipelineTestSession(t, PRIVILEGE_ADMINISTRATOR)
+
+	key := IPMICommandKey(IPMI_NETFN_APP, 0x5f)
+	RegisterIPMIHandler(key, "Pipeline Test", func(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+		return COMPLETION_CODE_OUT_OF_SPACE, []uint8{0x01, 0x02}
+	})
+	t.Cleanup(func() { UnregisterIPMIHandler(key) })
+
+	wrapper, message := serveTestRequest(t, session, IPMI_NETFN_APP, 0x5f, nil)
+	if want := []uint8{COMPLETION_CODE_OUT_OF_SPACE, 0x01, 0x02}; !bytes.Equal(message.Data, want) {
+		t.Errorf("response data = % x, want % x", message.Data, want)
+	}
+	if wrapper.SessionId != session.SessionID {
+		t.Errorf("response session ID = 0x%08x, want 0x%08x", wrapper.SessionId, session.SessionID)
+	}
+
+	// The completion code is signed as part of the response.
+	signed := message
+	signed.CompletionCode = message.Data[0]
+	signed.Data = message.Data[1:]
+	code := GetAuthenticationCode(AUTH_MD5, "admin", session.SessionID, signed, wrapper.SequenceNumber)
+	if code != wrapper.AuthenticationCode {
+		t.Errorf("response auth code = % x, want % x", wrapper.AuthenticationCode, code)
+	}
+}
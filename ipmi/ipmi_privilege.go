@@ -0,0 +1,152 @@
+package ipmi
+
+import (
+	"log"
+)
+
+type ipmiCommandKey struct {
+	NetFunction uint8
+	Command uint8
+}
+
+// PRIVILEGE_NONE marks commands which can be sent before a session is active.
+const (
+	PRIVILEGE_NONE =	0x00
+)
+
+// Privilege level required by each command (IPMI v2.0 Appendix G). Commands
+// which are not listed here need ADMINISTRATOR.
+var ipmiCommandPrivileges = map[ipmiCommandKey]uint8 {
+	// App
+	{IPMI_NETFN_APP, IPMI_CMD_GET_DEVICE_ID}:			PRIVILEGE_USER,
+	{IPMI_NETFN_APP, IPMI_CMD_COLD_RESET}:				PRIVILEGE_ADMINISTRATOR,
+	{IPMI_NETFN_APP, IPMI_CMD_WARM_RESET}:				PRIVILEGE_ADMINISTRATOR,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_SELF_TEST_RESULTS}:		PRIVILEGE_USER,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_ACPI_POWER_STATE}:		PRIVILEGE_USER,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_DEVICE_GUID}:			PRIVILEGE_USER,
+	{IPMI_NETFN_APP, IPMI_CMD_RESET_WATCHDOG_TIMER}:		PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_APP, IPMI_CMD_SET_WATCHDOG_TIMER}:			PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_WATCHDOG_TIMER}:			PRIVILEGE_USER,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_SYSTEM_GUID}:			PRIVILEGE_NONE,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_CHANNEL_AUTH_CAPABILITIES}:	PRIVILEGE_NONE,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_SESSION_CHALLENGE}:		PRIVILEGE_NONE,
+	{IPMI_NETFN_APP, IPMI_CMD_ACTIVATE_SESSION}:			PRIVILEGE_NONE,
+	{IPMI_NETFN_APP, IPMI_CMD_SET_SESSION_PRIVILEGE}:		PRIVILEGE_USER,
+	{IPMI_NETFN_APP, IPMI_CMD_CLOSE_SESSION}:			PRIVILEGE_CALLBACK,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_SESSION_INFO}:			PRIVILEGE_USER,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_AUTHCODE}:			PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_CHANNEL_ACCESS}:			PRIVILEGE_USER,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_CHANNEL_INFO}:			PRIVILEGE_USER,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_USER_ACCESS}:			PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_USER_NAME}:			PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_APP, IPMI_CMD_ACTIVATE_PAYLOAD}:			PRIVILEGE_USER,
+	{IPMI_NETFN_APP, IPMI_CMD_DEACTIVATE_PAYLOAD}:			PRIVILEGE_USER,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_PAYLOAD_ACTIVATION_STATUS}:	PRIVILEGE_USER,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_PAYLOAD_INSTANCE_INFO}:		PRIVILEGE_USER,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_USER_PAYLOAD_ACCESS}:		PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_CHANNEL_PAYLOAD_SUPPORT}:		PRIVILEGE_USER,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_CHANNEL_PAYLOAD_VERSION}:		PRIVILEGE_USER,
+	{IPMI_NETFN_APP, IPMI_CMD_GET_CHANNEL_CIPHER_SUITES}:		PRIVILEGE_NONE,
+
+	// Chassis
+	{IPMI_NETFN_CHASSIS, IPMI_CMD_GET_CHASSIS_CAPABILITIES}:	PRIVILEGE_USER,
+	{IPMI_NETFN_CHASSIS, IPMI_CMD_GET_CHASSIS_STATUS}:		PRIVILEGE_USER,
+	{IPMI_NETFN_CHASSIS, IPMI_CMD_CHASSIS_CONTROL}:			PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_CHASSIS, IPMI_CMD_CHASSIS_RESET}:			PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_CHASSIS, IPMI_CMD_CHASSIS_IDENTIFY}:		PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_CHASSIS, IPMI_CMD_SET_POWER_RESTORE_POLICY}:	PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_CHASSIS, IPMI_CMD_GET_SYSTEM_RESTART_CAUSE}:	PRIVILEGE_USER,
+	{IPMI_NETFN_CHASSIS, IPMI_CMD_SET_SYSTEM_BOOT_OPTIONS}:		PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_CHASSIS, IPMI_CMD_GET_SYSTEM_BOOT_OPTIONS}:		PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_CHASSIS, IPMI_CMD_GET_POH_COUNTER}:			PRIVILEGE_USER,
+
+	// Sensor/Event
+	{IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_GET_SENSOR_READING_FACTORS}:	PRIVILEGE_USER,
+	{IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_SET_SENSOR_HYSTERESIS}:	PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_GET_SENSOR_HYSTERESIS}:	PRIVILEGE_USER,
+	{IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_SET_SENSOR_THRESHOLD}:	PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_GET_SENSOR_THRESHOLD}:	PRIVILEGE_USER,
+	{IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_GET_SENSOR_EVENT_STATUS}:	PRIVILEGE_USER,
+	{IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_GET_SENSOR_READING}:		PRIVILEGE_USER,
+
+	// Storage
+	{IPMI_NETFN_STORAGE, IPMI_CMD_GET_FRU_INVENTORY_AREA_INFO}:	PRIVILEGE_USER,
+	{IPMI_NETFN_STORAGE, IPMI_CMD_READ_FRU_DATA}:			PRIVILEGE_USER,
+	{IPMI_NETFN_STORAGE, IPMI_CMD_WRITE_FRU_DATA}:			PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_STORAGE, IPMI_CMD_GET_SDR_REPOSITORY_INFO}:		PRIVILEGE_USER,
+	{IPMI_NETFN_STORAGE, IPMI_CMD_RESERVE_SDR_REPOSITORY}:		PRIVILEGE_USER,
+	{IPMI_NETFN_STORAGE, IPMI_CMD_GET_SDR}:				PRIVILEGE_USER,
+	{IPMI_NETFN_STORAGE, IPMI_CMD_GET_SEL_INFO}:			PRIVILEGE_USER,
+	{IPMI_NETFN_STORAGE, IPMI_CMD_GET_SEL_ALLOCATION_INFO}:		PRIVILEGE_USER,
+	{IPMI_NETFN_STORAGE, IPMI_CMD_RESERVE_SEL}:			PRIVILEGE_USER,
+	{IPMI_NETFN_STORAGE, IPMI_CMD_GET_SEL_ENTRY}:			PRIVILEGE_USER,
+	{IPMI_NETFN_STORAGE, IPMI_CMD_ADD_SEL_ENTRY}:			PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_STORAGE, IPMI_CMD_PARTIAL_ADD_SEL_ENTRY}:		PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_STORAGE, IPMI_CMD_DELETE_SEL_ENTRY}:		PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_STORAGE, IPMI_CMD_CLEAR_SEL}:			PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_STORAGE, IPMI_CMD_GET_SEL_TIME}:			PRIVILEGE_USER,
+	{IPMI_NETFN_STORAGE, IPMI_CMD_SET_SEL_TIME}:			PRIVILEGE_OPERATOR,
+
+	// Transport
+	{IPMI_NETFN_TRANSPORT, IPMI_CMD_GET_LAN_CONFIG_PARMS}:		PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_TRANSPORT, IPMI_CMD_SUSPEND_BMC_ARPS}:		PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_TRANSPORT, IPMI_CMD_GET_IP_UDP_RMCP_STATS}:		PRIVILEGE_USER,
+	{IPMI_NETFN_TRANSPORT, IPMI_CMD_GET_SERIAL_MODEM_CONFIG}:	PRIVILEGE_OPERATOR,
+	{IPMI_NETFN_TRANSPORT, IPMI_CMD_GET_SOL_CONFIGURATION_PARAMETERS}:	PRIVILEGE_USER,
+
+	// Group Extension
+	{IPMI_NETFN_GROUP_EXTENSION, GROUP_EXT_CMD_ATCA_GET_PICMG_PROP}:	PRIVILEGE_USER,
+}
+
+func GetCommandPrivilege(netFunction uint8, command uint8) uint8 {
+	privilege, ok := ipmiCommandPrivileges[ipmiCommandKey{netFunction, command}]
+	if ! ok {
+		return PRIVILEGE_ADMINISTRATOR
+	}
+	return privilege
+}
+
+// SessionPrivilegeLimit is the highest privilege level the session can be
+// raised to: the limit negotiated for the session and the limit of the user.
+func SessionPrivilegeLimit(session IPMISession) uint8 {
+	limit := session.MaxPrivilegeLevel
+	if session.User.MaxPrivilege < limit {
+		limit = session.User.MaxPrivilege
+	}
+	return limit
+}
+
+// IPMIPrivilegeMiddleware checks the operating privilege level of the session
+// against the privilege level required by the command. Only the commands of
+// PRIVILEGE_NONE can be sent outside of a session. Rejected requests are
+// answered with COMPLETION_CODE_INSUFFICIENT_PRIVILEGE.
+func IPMIPrivilegeMiddleware(next IPMICommandHandler) IPMICommandHandler {
+	return func(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+		required := GetCommandPrivilege(request.NetFunction, request.Command)
+		if required == PRIVILEGE_NONE {
+			return next(ctx, request)
+		}
+
+		if ! ctx.HasSession {
+			log.Printf("    IPMI: Command 0x%02x:0x%02x needs privilege 0x%02x and can not be sent outside of a session, reject.\n",
+				request.NetFunction, request.Command, required)
+			return COMPLETION_CODE_INSUFFICIENT_PRIVILEGE, nil
+		}
+		if ctx.Session.PrivilegeLevel < required {
+			log.Printf("    IPMI: Command 0x%02x:0x%02x needs privilege 0x%02x, session 0x%08x is at 0x%02x, reject.\n",
+				request.NetFunction, request.Command, required, ctx.Session.SessionID, ctx.Session.PrivilegeLevel)
+			return COMPLETION_CODE_INSUFFICIENT_PRIVILEGE, nil
+		}
+
+		return next(ctx, request)
+	}
+}
+
+// initialPrivilegeLevel is the privilege level of a newly activated session:
+// USER, or the maximum privilege level of the session when it is lower.
+func initialPrivilegeLevel(maxPrivilegeLevel uint8) uint8 {
+	if maxPrivilegeLevel < PRIVILEGE_USER {
+		return maxPrivilegeLevel
+	}
+	return PRIVILEGE_USER
+}
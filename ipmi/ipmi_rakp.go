@@ -0,0 +1,557 @@
+package ipmi
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"log"
+	"net"
+)
+
+import (
+	"github.com/rmxymh/infra-ecosphere/bmc"
+	"github.com/rmxymh/infra-ecosphere/utils"
+)
+
+// RMCP+ Authentication Algorithms (IPMI v2.0 Table 13-17)
+const (
+	AUTH_ALG_RAKP_NONE =		0x00
+	AUTH_ALG_RAKP_HMAC_SHA1 =	0x01
+	AUTH_ALG_RAKP_HMAC_MD5 =	0x02
+	AUTH_ALG_RAKP_HMAC_SHA256 =	0x03
+)
+
+// RMCP+ Integrity Algorithms (IPMI v2.0 Table 13-18)
+const (
+	INTEGRITY_ALG_NONE =		0x00
+	INTEGRITY_ALG_HMAC_SHA1_96 =	0x01
+	INTEGRITY_ALG_HMAC_MD5_128 =	0x02
+	INTEGRITY_ALG_MD5_128 =		0x03
+	INTEGRITY_ALG_HMAC_SHA256_128 =	0x04
+)
+
+// RMCP+ Confidentiality Algorithms (IPMI v2.0 Table 13-19)
+const (
+	CONFIDENTIALITY_ALG_NONE =		0x00
+	CONFIDENTIALITY_ALG_AES_CBC_128 =	0x01
+	CONFIDENTIALITY_ALG_XRC4_128 =		0x02
+	CONFIDENTIALITY_ALG_XRC4_40 =		0x03
+)
+
+// RMCP+ and RAKP Message Status Codes (IPMI v2.0 Table 13-15)
+const (
+	RMCP_PLUS_STATUS_NO_ERRORS =				0x00
+	RMCP_PLUS_STATUS_INSUFFICIENT_RESOURCES =		0x01
+	RMCP_PLUS_STATUS_INVALID_SESSION_ID =			0x02
+	RMCP_PLUS_STATUS_INVALID_PAYLOAD_TYPE =			0x03
+	RMCP_PLUS_STATUS_INVALID_AUTH_ALGORITHM =		0x04
+	RMCP_PLUS_STATUS_INVALID_INTEGRITY_ALGORITHM =		0x05
+	RMCP_PLUS_STATUS_NO_MATCHING_AUTH_PAYLOAD =		0x06
+	RMCP_PLUS_STATUS_NO_MATCHING_INTEGRITY_PAYLOAD =	0x07
+	RMCP_PLUS_STATUS_INACTIVE_SESSION_ID =			0x08
+	RMCP_PLUS_STATUS_INVALID_ROLE =				0x09
+	RMCP_PLUS_STATUS_UNAUTHORIZED_ROLE =			0x0a
+	RMCP_PLUS_STATUS_INSUFFICIENT_RESOURCES_FOR_ROLE =	0x0b
+	RMCP_PLUS_STATUS_INVALID_NAME_LENGTH =			0x0c
+	RMCP_PLUS_STATUS_UNAUTHORIZED_NAME =			0x0d
+	RMCP_PLUS_STATUS_UNAUTHORIZED_GUID =			0x0e
+	RMCP_PLUS_STATUS_INVALID_INTEGRITY_CHECK_VALUE =	0x0f
+	RMCP_PLUS_STATUS_INVALID_CONFIDENTIALITY_ALGORITHM =	0x10
+	RMCP_PLUS_STATUS_NO_CIPHER_SUITE_MATCH =		0x11
+	RMCP_PLUS_STATUS_ILLEGAL_PARAMETER =			0x12
+)
+
+const (
+	RAKP_ROLE_BITMASK_NAME_ONLY_LOOKUP =	0x10
+	RAKP_ROLE_BITMASK_PRIVILEGE_LEVEL =	0x0f
+)
+
+type RMCPPlusAlgorithmPayload struct {
+	PayloadType uint8
+	Reserved1 uint16
+	PayloadLength uint8
+	Algorithm uint8
+	Reserved2 [3]uint8
+}
+
+type RMCPPlusOpenSessionRequest struct {
+	MessageTag uint8
+	RequestedMaxPrivilegeLevel uint8
+	Reserved uint16
+	RemoteConsoleSessionID uint32
+	Authentication RMCPPlusAlgorithmPayload
+	Integrity RMCPPlusAlgorithmPayload
+	Confidentiality RMCPPlusAlgorithmPayload
+}
+
+type RMCPPlusOpenSessionResponse struct {
+	MessageTag uint8
+	StatusCode uint8
+	MaxPrivilegeLevel uint8
+	Reserved uint8
+	RemoteConsoleSessionID uint32
+	ManagedSystemSessionID uint32
+	Authentication RMCPPlusAlgorithmPayload
+	Integrity RMCPPlusAlgorithmPayload
+	Confidentiality RMCPPlusAlgorithmPayload
+}
+
+type RMCPPlusRAKPMessage1 struct {
+	MessageTag uint8
+	Reserved1 [3]uint8
+	ManagedSystemSessionID uint32
+	RemoteConsoleRandom [16]byte
+	RequestedRole uint8
+	Reserved2 uint16
+	UsernameLength uint8
+}
+
+type RMCPPlusRAKPMessage2 struct {
+	MessageTag uint8
+	StatusCode uint8
+	Reserved uint16
+	RemoteConsoleSessionID uint32
+	ManagedSystemRandom [16]byte
+	ManagedSystemGUID [16]byte
+}
+
+type RMCPPlusRAKPMessage3 struct {
+	MessageTag uint8
+	StatusCode uint8
+	Reserved uint16
+	ManagedSystemSessionID uint32
+}
+
+type RMCPPlusRAKPMessage4 struct {
+	MessageTag uint8
+	StatusCode uint8
+	Reserved uint16
+	RemoteConsoleSessionID uint32
+}
+
+// Utility
+func rakpHashFunction(authAlgorithm uint8) func() hash.Hash {
+	switch authAlgorithm {
+	case AUTH_ALG_RAKP_HMAC_SHA1:
+		return sha1.New
+	case AUTH_ALG_RAKP_HMAC_MD5:
+		return md5.New
+	case AUTH_ALG_RAKP_HMAC_SHA256:
+		return sha256.New
+	}
+	return nil
+}
+
+func IsRAKPAuthenticationAlgorithmSupported(authAlgorithm uint8) bool {
+	return authAlgorithm == AUTH_ALG_RAKP_NONE || rakpHashFunction(authAlgorithm) != nil
+}
+
+// RAKPHMAC returns HMAC_key(data) with the hash of the negotiated
+// authentication algorithm. RAKP-none yields an empty code.
+func RAKPHMAC(authAlgorithm uint8, key []byte, data []byte) []byte {
+	hashFunc := rakpHashFunction(authAlgorithm)
+	if hashFunc == nil {
+		return []byte{}
+	}
+
+	mac := hmac.New(hashFunc, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// RAKPIntegrityCheckValueLength is the length of the RAKP Message 4 ICV.
+func RAKPIntegrityCheckValueLength(authAlgorithm uint8) int {
+	switch authAlgorithm {
+	case AUTH_ALG_RAKP_HMAC_SHA1:
+		return 12
+	case AUTH_ALG_RAKP_HMAC_MD5:
+		return 16
+	case AUTH_ALG_RAKP_HMAC_SHA256:
+		return 16
+	}
+	return 0
+}
+
+func rakpUsername(session IPMISession) []byte {
+	return []byte(session.User.Username)
+}
+
+// SIK = HMAC_Kg(Rm | Rc | ROLEm | ULENGTHm | UNAMEm), Kg is Kuid when no BMC key is set.
+func GenerateSessionIntegrityKey(session IPMISession, kg []byte) []byte {
+	username := rakpUsername(session)
+
+	context := bytes.Buffer{}
+	context.Write(session.RemoteConsoleRandom[:])
+	context.Write(session.ManagedSystemRandom[:])
+	binary.Write(&context, binary.LittleEndian, session.RequestedRole)
+	binary.Write(&context, binary.LittleEndian, uint8(len(username)))
+	context.Write(username)
+
+	return RAKPHMAC(session.AuthenticationAlgorithm, kg, context.Bytes())
+}
+
+// rakp2KeyExchangeAuthCode is HMAC_Kuid(SIDm | SIDc | Rm | Rc | GUIDc | ROLEm | ULENGTHm | UNAMEm).
+func rakp2KeyExchangeAuthCode(session IPMISession, guid [16]byte) []byte {
+	username := rakpUsername(session)
+
+	context := bytes.Buffer{}
+	binary.Write(&context, binary.LittleEndian, session.RemoteConsoleSessionID)
+	binary.Write(&context, binary.LittleEndian, session.SessionID)
+	context.Write(session.RemoteConsoleRandom[:])
+	context.Write(session.ManagedSystemRandom[:])
+	context.Write(guid[:])
+	binary.Write(&context, binary.LittleEndian, session.RequestedRole)
+	binary.Write(&context, binary.LittleEndian, uint8(len(username)))
+	context.Write(username)
+
+	return RAKPHMAC(session.AuthenticationAlgorithm, []byte(session.User.Password), context.Bytes())
+}
+
+// rakp3KeyExchangeAuthCode is HMAC_Kuid(Rc | SIDm | ROLEm | ULENGTHm | UNAMEm).
+func rakp3KeyExchangeAuthCode(session IPMISession) []byte {
+	username := rakpUsername(session)
+
+	context := bytes.Buffer{}
+	context.Write(session.ManagedSystemRandom[:])
+	binary.Write(&context, binary.LittleEndian, session.RemoteConsoleSessionID)
+	binary.Write(&context, binary.LittleEndian, session.RequestedRole)
+	binary.Write(&context, binary.LittleEndian, uint8(len(username)))
+	context.Write(username)
+
+	return RAKPHMAC(session.AuthenticationAlgorithm, []byte(session.User.Password), context.Bytes())
+}
+
+// rakp4IntegrityCheckValue is HMAC_SIK(Rm | SIDc | GUIDc), truncated by the
+// authentication algorithm.
+func rakp4IntegrityCheckValue(session IPMISession, guid [16]byte) []byte {
+	context := bytes.Buffer{}
+	context.Write(session.RemoteConsoleRandom[:])
+	binary.Write(&context, binary.LittleEndian, session.SessionID)
+	context.Write(guid[:])
+
+	icv := RAKPHMAC(session.AuthenticationAlgorithm, session.SIK, context.Bytes())
+	return icv[:RAKPIntegrityCheckValueLength(session.AuthenticationAlgorithm)]
+}
+
+func getLocalBMC(server *net.UDPConn) (bmc.BMC, bool) {
+	localIP := utils.GetLocalIP(server)
+	obj, ok := bmc.GetBMC(net.ParseIP(localIP))
+	if ! ok {
+		log.Printf("      RMCP+: BMC %s is not found.\n", localIP)
+	}
+	return obj, ok
+}
+
+func getLocalBMCGUID(server *net.UDPConn) [16]byte {
+	obj, ok := getLocalBMC(server)
+	if ! ok {
+		return [16]byte{}
+	}
+	return obj.GUID()
+}
+
+// getLocalBMCKey returns Kg of the BMC, or Kuid of the session user when the BMC key is not set.
+func getLocalBMCKey(server *net.UDPConn, session IPMISession) []byte {
+	obj, ok := getLocalBMC(server)
+	if ok && len(obj.Kg) > 0 {
+		return obj.Kg
+	}
+	return []byte(session.User.Password)
+}
+
+// Handlers
+func sendRMCPPlusOpenSessionResponse(addr *net.UDPAddr, server *net.UDPConn, response RMCPPlusOpenSessionResponse) {
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	payload := dataBuf.Bytes()
+	if response.StatusCode != RMCP_PLUS_STATUS_NO_ERRORS {
+		// Only message tag, status code and the remote console session ID are returned on error.
+		payload = payload[:8]
+	}
+
+	wrapper := RMCPPlusSessionWrapper{}
+	wrapper.PayloadType = RMCP_PLUS_PAYLOAD_TYPE_OPEN_SESSION_RESPONSE
+	SendRMCPPlusPayload(addr, server, wrapper, payload)
+}
+
+func HandleRMCPPlusOpenSession(addr *net.UDPAddr, server *net.UDPConn, wrapper RMCPPlusSessionWrapper, payload []uint8) {
+	request := RMCPPlusOpenSessionRequest{}
+	err := binary.Read(bytes.NewBuffer(payload), binary.LittleEndian, &request)
+
+	response := RMCPPlusOpenSessionResponse{}
+	response.MessageTag = request.MessageTag
+	response.RemoteConsoleSessionID = request.RemoteConsoleSessionID
+
+	if err != nil || request.RemoteConsoleSessionID == 0 {
+		log.Println("      RMCP+ Open Session: Malformed request: ", err)
+		response.StatusCode = RMCP_PLUS_STATUS_ILLEGAL_PARAMETER
+		sendRMCPPlusOpenSessionResponse(addr, server, response)
+		return
+	}
+
+	privilege := request.RequestedMaxPrivilegeLevel & RAKP_ROLE_BITMASK_PRIVILEGE_LEVEL
+	if privilege > PRIVILEGE_OEM {
+		response.StatusCode = RMCP_PLUS_STATUS_INVALID_ROLE
+		sendRMCPPlusOpenSessionResponse(addr, server, response)
+		return
+	}
+
+	authAlgorithm := request.Authentication.Algorithm & 0x3f
+	integrityAlgorithm := request.Integrity.Algorithm & 0x3f
+	confidentialityAlgorithm := request.Confidentiality.Algorithm & 0x3f
+	log.Printf("      RMCP+ Open Session: Auth = 0x%02x, Integrity = 0x%02x, Confidentiality = 0x%02x\n", authAlgorithm, integrityAlgorithm, confidentialityAlgorithm)
+
+	if ! IsRAKPAuthenticationAlgorithmSupported(authAlgorithm) {
+		response.StatusCode = RMCP_PLUS_STATUS_INVALID_AUTH_ALGORITHM
+		sendRMCPPlusOpenSessionResponse(addr, server, response)
+		return
+	}
+	if ! IsIntegrityAlgorithmSupported(integrityAlgorithm) {
+		response.StatusCode = RMCP_PLUS_STATUS_INVALID_INTEGRITY_ALGORITHM
+		sendRMCPPlusOpenSessionResponse(addr, server, response)
+		return
+	}
+	if ! IsConfidentialityAlgorithmSupported(confidentialityAlgorithm) {
+		response.StatusCode = RMCP_PLUS_STATUS_INVALID_CONFIDENTIALITY_ALGORITHM
+		sendRMCPPlusOpenSessionResponse(addr, server, response)
+		return
+	}
+
+	obj, ok := getLocalBMC(server)
+	if ! ok {
+		response.StatusCode = RMCP_PLUS_STATUS_INSUFFICIENT_RESOURCES
+		sendRMCPPlusOpenSessionResponse(addr, server, response)
+		return
+	}
+	suite, ok := MatchBMCCipherSuite(obj, authAlgorithm, integrityAlgorithm, confidentialityAlgorithm)
+	if ! ok {
+		log.Println("      RMCP+ Open Session: Proposed algorithms do not match any cipher suite allowed by BMC.")
+		response.StatusCode = RMCP_PLUS_STATUS_NO_CIPHER_SUITE_MATCH
+		sendRMCPPlusOpenSessionResponse(addr, server, response)
+		return
+	}
+	if privilege == PRIVILEGE_HIGHEST {
+		privilege = suite.MaxPrivilege
+	} else if privilege > suite.MaxPrivilege {
+		log.Printf("      RMCP+ Open Session: Privilege 0x%02x exceeds 0x%02x allowed by cipher suite %d.\n", privilege, suite.MaxPrivilege, suite.ID)
+		response.StatusCode = RMCP_PLUS_STATUS_UNAUTHORIZED_ROLE
+		sendRMCPPlusOpenSessionResponse(addr, server, response)
+		return
+	}
+
+	if ! AllowSessionChallenge(utils.GetLocalIP(server), addr.IP) {
+		response.StatusCode = RMCP_PLUS_STATUS_INSUFFICIENT_RESOURCES
+		sendRMCPPlusOpenSessionResponse(addr, server, response)
+		return
+	}
+
+	// The user is not known until RAKP Message 1 arrives.
+	session, ok := GetNewSession(utils.GetLocalIP(server), addr, bmc.BMCUser{})
+	if ! ok {
+		response.StatusCode = RMCP_PLUS_STATUS_INSUFFICIENT_RESOURCES
+		sendRMCPPlusOpenSessionResponse(addr, server, response)
+		return
+	}
+	session.RemoteConsoleSessionID = request.RemoteConsoleSessionID
+	session.MaxPrivilegeLevel = privilege
+	session.AuthenticationAlgorithm = authAlgorithm
+	session.IntegrityAlgorithm = integrityAlgorithm
+	session.ConfidentialityAlgorithm = confidentialityAlgorithm
+	session.Save()
+
+	response.StatusCode = RMCP_PLUS_STATUS_NO_ERRORS
+	response.MaxPrivilegeLevel = privilege
+	response.ManagedSystemSessionID = session.SessionID
+	response.Authentication = request.Authentication
+	response.Integrity = request.Integrity
+	response.Confidentiality = request.Confidentiality
+	sendRMCPPlusOpenSessionResponse(addr, server, response)
+}
+
+func sendRMCPPlusRAKP2(addr *net.UDPAddr, server *net.UDPConn, response RMCPPlusRAKPMessage2, keyExchangeAuthCode []byte) {
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	payload := dataBuf.Bytes()
+	if response.StatusCode != RMCP_PLUS_STATUS_NO_ERRORS {
+		payload = payload[:8]
+	} else {
+		dataBuf.Write(keyExchangeAuthCode)
+		payload = dataBuf.Bytes()
+	}
+
+	wrapper := RMCPPlusSessionWrapper{}
+	wrapper.PayloadType = RMCP_PLUS_PAYLOAD_TYPE_RAKP_2
+	SendRMCPPlusPayload(addr, server, wrapper, payload)
+}
+
+func HandleRMCPPlusRAKP1(addr *net.UDPAddr, server *net.UDPConn, wrapper RMCPPlusSessionWrapper, payload []uint8) {
+	buf := bytes.NewBuffer(payload)
+	request := RMCPPlusRAKPMessage1{}
+	err := binary.Read(buf, binary.LittleEndian, &request)
+
+	response := RMCPPlusRAKPMessage2{}
+	response.MessageTag = request.MessageTag
+
+	if err != nil {
+		log.Println("      RMCP+ RAKP 1: Malformed request: ", err)
+		response.StatusCode = RMCP_PLUS_STATUS_ILLEGAL_PARAMETER
+		sendRMCPPlusRAKP2(addr, server, response, nil)
+		return
+	}
+
+	session, ok := GetSession(utils.GetLocalIP(server), request.ManagedSystemSessionID)
+	if ! ok || ! session.IsRMCPPlus() || session.Activated {
+		log.Printf("      RMCP+ RAKP 1: Unable to find pending session 0x%08x\n", request.ManagedSystemSessionID)
+		response.StatusCode = RMCP_PLUS_STATUS_INVALID_SESSION_ID
+		sendRMCPPlusRAKP2(addr, server, response, nil)
+		return
+	}
+	response.RemoteConsoleSessionID = session.RemoteConsoleSessionID
+
+	if request.UsernameLength > 16 || int(request.UsernameLength) > buf.Len() {
+		response.StatusCode = RMCP_PLUS_STATUS_INVALID_NAME_LENGTH
+		sendRMCPPlusRAKP2(addr, server, response, nil)
+		RemoveSession(session.BMCIP, session.SessionID)
+		return
+	}
+	username := string(buf.Next(int(request.UsernameLength)))
+
+	localBMC, _ := getLocalBMC(server)
+	user, found := localBMC.GetUser(username)
+	if found && ! user.CanLogin() {
+		log.Printf("      RMCP+ RAKP 1: User %s is disabled or has no access.\n", username)
+		found = false
+	}
+	if found && IsUserLockedOut(session.BMCIP, username) {
+		log.Printf("      RMCP+ RAKP 1: User %s is locked out.\n", username)
+		found = false
+	}
+	if ! found {
+		log.Printf("      RMCP+ RAKP 1: User %s is not found.\n", username)
+		response.StatusCode = RMCP_PLUS_STATUS_UNAUTHORIZED_NAME
+		sendRMCPPlusRAKP2(addr, server, response, nil)
+		RemoveSession(session.BMCIP, session.SessionID)
+		return
+	}
+
+	role := request.RequestedRole & RAKP_ROLE_BITMASK_PRIVILEGE_LEVEL
+	if role > PRIVILEGE_OEM {
+		response.StatusCode = RMCP_PLUS_STATUS_INVALID_ROLE
+		sendRMCPPlusRAKP2(addr, server, response, nil)
+		RemoveSession(session.BMCIP, session.SessionID)
+		return
+	}
+
+	if role > session.MaxPrivilegeLevel {
+		log.Printf("      RMCP+ RAKP 1: Role 0x%02x exceeds maximum privilege 0x%02x of session.\n", role, session.MaxPrivilegeLevel)
+		response.StatusCode = RMCP_PLUS_STATUS_UNAUTHORIZED_ROLE
+		sendRMCPPlusRAKP2(addr, server, response, nil)
+		RemoveSession(session.BMCIP, session.SessionID)
+		return
+	}
+
+	if ! HasUserSessionSlot(session.BMCIP, user.Username) {
+		log.Printf("      RMCP+ RAKP 1: No session slot is available for user %s.\n", username)
+		response.StatusCode = RMCP_PLUS_STATUS_INSUFFICIENT_RESOURCES
+		sendRMCPPlusRAKP2(addr, server, response, nil)
+		RemoveSession(session.BMCIP, session.SessionID)
+		return
+	}
+
+	if role > user.MaxPrivilege {
+		log.Printf("      RMCP+ RAKP 1: Role 0x%02x exceeds maximum privilege 0x%02x of user %s.\n", role, user.MaxPrivilege, username)
+		response.StatusCode = RMCP_PLUS_STATUS_UNAUTHORIZED_ROLE
+		sendRMCPPlusRAKP2(addr, server, response, nil)
+		RemoveSession(session.BMCIP, session.SessionID)
+		return
+	}
+
+	session.User = user
+	session.RequestedRole = request.RequestedRole
+	if role != PRIVILEGE_HIGHEST {
+		session.MaxPrivilegeLevel = role
+	}
+	session.MaxPrivilegeLevel = SessionPrivilegeLimit(session)
+	session.RemoteConsoleRandom = request.RemoteConsoleRandom
+	rand.Read(session.ManagedSystemRandom[:])
+	session.Save()
+
+	guid := getLocalBMCGUID(server)
+
+	response.StatusCode = RMCP_PLUS_STATUS_NO_ERRORS
+	response.ManagedSystemRandom = session.ManagedSystemRandom
+	response.ManagedSystemGUID = guid
+	sendRMCPPlusRAKP2(addr, server, response, rakp2KeyExchangeAuthCode(session, guid))
+}
+
+func sendRMCPPlusRAKP4(addr *net.UDPAddr, server *net.UDPConn, response RMCPPlusRAKPMessage4, integrityCheckValue []byte) {
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	if response.StatusCode == RMCP_PLUS_STATUS_NO_ERRORS {
+		dataBuf.Write(integrityCheckValue)
+	}
+
+	wrapper := RMCPPlusSessionWrapper{}
+	wrapper.PayloadType = RMCP_PLUS_PAYLOAD_TYPE_RAKP_4
+	SendRMCPPlusPayload(addr, server, wrapper, dataBuf.Bytes())
+}
+
+func HandleRMCPPlusRAKP3(addr *net.UDPAddr, server *net.UDPConn, wrapper RMCPPlusSessionWrapper, payload []uint8) {
+	buf := bytes.NewBuffer(payload)
+	request := RMCPPlusRAKPMessage3{}
+	err := binary.Read(buf, binary.LittleEndian, &request)
+
+	response := RMCPPlusRAKPMessage4{}
+	response.MessageTag = request.MessageTag
+
+	if err != nil {
+		log.Println("      RMCP+ RAKP 3: Malformed request: ", err)
+		response.StatusCode = RMCP_PLUS_STATUS_ILLEGAL_PARAMETER
+		sendRMCPPlusRAKP4(addr, server, response, nil)
+		return
+	}
+
+	session, ok := GetSession(utils.GetLocalIP(server), request.ManagedSystemSessionID)
+	if ! ok || ! session.IsRMCPPlus() || session.Activated {
+		log.Printf("      RMCP+ RAKP 3: Unable to find pending session 0x%08x\n", request.ManagedSystemSessionID)
+		response.StatusCode = RMCP_PLUS_STATUS_INVALID_SESSION_ID
+		sendRMCPPlusRAKP4(addr, server, response, nil)
+		return
+	}
+	response.RemoteConsoleSessionID = session.RemoteConsoleSessionID
+
+	if request.StatusCode != RMCP_PLUS_STATUS_NO_ERRORS {
+		log.Printf("      RMCP+ RAKP 3: Remote console aborts session 0x%08x with status 0x%02x\n", session.SessionID, request.StatusCode)
+		// The remote console rejects RAKP 2 when its password is wrong.
+		if request.StatusCode == RMCP_PLUS_STATUS_INVALID_INTEGRITY_CHECK_VALUE {
+			RecordLoginFailure(session.BMCIP, session.User.Username)
+		}
+		RemoveSession(session.BMCIP, session.SessionID)
+		return
+	}
+
+	if ! hmac.Equal(rakp3KeyExchangeAuthCode(session), buf.Bytes()) {
+		log.Println("      RMCP+ RAKP 3: IPMI Authentication Failed.")
+		RecordLoginFailure(session.BMCIP, session.User.Username)
+		response.StatusCode = RMCP_PLUS_STATUS_INVALID_INTEGRITY_CHECK_VALUE
+		sendRMCPPlusRAKP4(addr, server, response, nil)
+		RemoveSession(session.BMCIP, session.SessionID)
+		return
+	}
+	log.Println("      RMCP+ RAKP 3: IPMI Authentication Pass.")
+	ResetLoginFailures(session.BMCIP, session.User.Username)
+
+	session.SIK = GenerateSessionIntegrityKey(session, getLocalBMCKey(server, session))
+	GenerateSessionKeys(&session)
+	session.Activated = true
+	session.PrivilegeLevel = initialPrivilegeLevel(session.MaxPrivilegeLevel)
+	session.Save()
+
+	response.StatusCode = RMCP_PLUS_STATUS_NO_ERRORS
+	sendRMCPPlusRAKP4(addr, server, response, rakp4IntegrityCheckValue(session, getLocalBMCGUID(server)))
+}
@@ -0,0 +1,116 @@
+package ipmi
+
+import (
+	"encoding/hex"
+	"testing"
+)
+import (
+	"github.com/rmxymh/infra-ecosphere/bmc"
+)
+
+// Known answers of the key exchange, computed independently from the
+// formulas of IPMI v2.0 Section 13.31 and 13.32.
+var rakpTestVectors = []struct {
+	name string
+	authAlgorithm uint8
+	integrityAlgorithm uint8
+	rakp2 string
+	rakp3 string
+	sik string
+	k1 string
+	k2 string
+	rakp4 string
+	integrity string
+}{
+	{
+		"RAKP-HMAC-SHA1", AUTH_ALG_RAKP_HMAC_SHA1, INTEGRITY_ALG_HMAC_SHA1_96,
+		"35652e8370da8877584e79b2d1d4605c65a0b2df",
+		"5db4c85fa43a62da00259e259f49842730990c18",
+		"8443e6aa220894122518edf6d9078b650948ed27",
+		"89c197104b5ea182058ddecd4f0afac416974e20",
+		"408be27dbed3df6b15a0c28b4af9dfef3915dd85",
+		"db73532cec1e61ed48b1c143",
+		"660c1556a7d5c9b5b625fc5e",
+	},
+	{
+		"RAKP-HMAC-MD5", AUTH_ALG_RAKP_HMAC_MD5, INTEGRITY_ALG_HMAC_MD5_128,
+		"522f3e8e2191c9fc02139a3c60efbc87",
+		"d647bfd789d1abb1fa2cdcea90d8df6e",
+		"02a6d2291fc8b2c1074553a5e247e574",
+		"55a52f58ed278b76e9a5b22e874eb4b6",
+		"598328fea941aa56d9a1b8c8b466771f",
+		"cfc2398594dba2361d473c66146cda78",
+		"e3a27505f4d6e9ebe1a151812d51fea2",
+	},
+	{
+		"RAKP-HMAC-SHA256", AUTH_ALG_RAKP_HMAC_SHA256, INTEGRITY_ALG_HMAC_SHA256_128,
+		"f96532619acd8a9a3112b3520c5a66ee99a4a10f4ae4ed74f10067e7236eb6ce",
+		"21133d6d5aeb2b89e2b20724951de969e69bee0853a1cf9dcafd99471dacb53f",
+		"2bee32961c89af6b22542eda32789e169aa0c6ea298a2779df7a2c760c1b8e5b",
+		"05f47087e735306b930080e3af5000e8336b72383ffecb6a3b3c68d26b1e6dc1",
+		"5d94ec86303b9ad19ab75918c2e1ef80ff860a12d4752ae12bb88ec1b65373e6",
+		"72b902d4fcb324e482ff24068b715ec0",
+		"0e5628520215828c53cd8e1422b86759",
+	},
+}
+
+// Managed system GUID of the test session: 0x20 ~ 0x2f.
+var rakpTestGUID = [16]byte{
+	0x20, 0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27, 0x28, 0x29, 0x2a, 0x2b, 0x2c, 0x2d, 0x2e, 0x2f,
+}
+
+// newRAKPTestSession returns the session of user "admin" with password "admin"
+// after RAKP Message 1 asked for ADMINISTRATOR with name-only lookup. The
+// remote console random is 0x00 ~ 0x0f and the managed system random is
+// 0x10 ~ 0x1f.
+func newRAKPTestSession(authAlgorithm uint8, integrityAlgorithm uint8) IPMISession {
+	session := IPMISession{}
+	session.SessionID = 0x12345678
+	session.RemoteConsoleSessionID = 0xa0a1a2a3
+	session.User = bmc.BMCUser{ID: 2, Username: "admin", Password: "admin"}
+	session.RequestedRole = RAKP_ROLE_BITMASK_NAME_ONLY_LOOKUP | PRIVILEGE_ADMINISTRATOR
+	session.AuthenticationAlgorithm = authAlgorithm
+	session.IntegrityAlgorithm = integrityAlgorithm
+	for i := range session.RemoteConsoleRandom {
+		session.RemoteConsoleRandom[i] = uint8(i)
+		session.ManagedSystemRandom[i] = uint8(0x10 + i)
+	}
+	return session
+}
+
+func checkHex(t *testing.T, name string, got []byte, want string) {
+	if hex.EncodeToString(got) != want {
+		t.Errorf("%s = %x, want %s", name, got, want)
+	}
+}
+
+func TestRAKPKeyExchangeAuthCodes(t *testing.T) {
+	for _, vector := range rakpTestVectors {
+		session := newRAKPTestSession(vector.authAlgorithm, vector.integrityAlgorithm)
+
+		checkHex(t, vector.name + " RAKP 2 auth code", rakp2KeyExchangeAuthCode(session, rakpTestGUID), vector.rakp2)
+		checkHex(t, vector.name + " RAKP 3 auth code", rakp3KeyExchangeAuthCode(session), vector.rakp3)
+	}
+}
+
+func TestRAKPSessionKeys(t *testing.T) {
+	for _, vector := range rakpTestVectors {
+		session := newRAKPTestSession(vector.authAlgorithm, vector.integrityAlgorithm)
+		session.SIK = GenerateSessionIntegrityKey(session, []byte(session.User.Password))
+		GenerateSessionKeys(&session)
+
+		checkHex(t, vector.name + " SIK", session.SIK, vector.sik)
+		checkHex(t, vector.name + " K1", session.K1, vector.k1)
+		checkHex(t, vector.name + " K2", session.K2, vector.k2)
+		checkHex(t, vector.name + " RAKP 4 ICV", rakp4IntegrityCheckValue(session, rakpTestGUID), vector.rakp4)
+	}
+}
+
+func TestRAKPNoneSessionKeys(t *testing.T) {
+	session := newRAKPTestSession(AUTH_ALG_RAKP_NONE, INTEGRITY_ALG_NONE)
+	GenerateSessionKeys(&session)
+
+	checkHex(t, "RAKP-none RAKP 2 auth code", rakp2KeyExchangeAuthCode(session, rakpTestGUID), "")
+	checkHex(t, "RAKP-none K1", session.K1, hex.EncodeToString(rmcpPlusConst1))
+	checkHex(t, "RAKP-none K2", session.K2, hex.EncodeToString(rmcpPlusConst2))
+}
\ No newline at end of file
@@ -0,0 +1,73 @@
+package ipmi
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+import (
+	"github.com/rmxymh/infra-ecosphere/bmc"
+)
+
+const (
+	CHALLENGE_RATE_LIMIT_WINDOW =	1 * time.Minute
+)
+
+// Session challenges (Get Session Challenge and RMCP+ Open Session) received
+// by a BMC from a source address in the current window.
+type challengeRateKey struct {
+	BMCIP string
+	Source string
+}
+
+type challengeRate struct {
+	WindowStart time.Time
+	Count int
+}
+
+var challengeRates map[challengeRateKey]challengeRate
+var challengeRatesLock sync.Mutex
+
+func init() {
+	challengeRates = make(map[challengeRateKey]challengeRate)
+}
+
+// AllowSessionChallenge counts a session challenge from the source, and
+// reports whether it is within the rate limit of the BMC.
+func AllowSessionChallenge(bmcIP string, source net.IP) bool {
+	obj, ok := bmc.GetBMC(net.ParseIP(bmcIP))
+	if ! ok || obj.ChallengeRateLimit == 0 {
+		return true
+	}
+
+	challengeRatesLock.Lock()
+	defer challengeRatesLock.Unlock()
+
+	now := timeNow()
+	key := challengeRateKey{bmcIP, source.String()}
+	rate := challengeRates[key]
+	if now.Sub(rate.WindowStart) >= CHALLENGE_RATE_LIMIT_WINDOW {
+		rate = challengeRate{WindowStart: now}
+	}
+	rate.Count += 1
+	challengeRates[key] = rate
+
+	if rate.Count > obj.ChallengeRateLimit {
+		log.Printf("    Rate Limit: %s sends %d session challenges to BMC %s in a minute, reject.\n", key.Source, rate.Count, bmcIP)
+		return false
+	}
+	return true
+}
+
+// RemoveExpiredChallengeRates forgets the sources whose window is over.
+func RemoveExpiredChallengeRates(now time.Time) {
+	challengeRatesLock.Lock()
+	defer challengeRatesLock.Unlock()
+
+	for key, rate := range challengeRates {
+		if now.Sub(rate.WindowStart) >= CHALLENGE_RATE_LIMIT_WINDOW {
+			delete(challengeRates, key)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package ipmi
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rmxymh/infra-ecosphere/bmc"
+	"github.com/rmxymh/infra-ecosphere/vm"
+)
+
+func TestSessionChallengeRateLimit(t *testing.T) {
+	ip := "127.0.12.5"
+	clock := setTestClock(t)
+	obj := bmc.AddBMC(net.ParseIP(ip), vm.Instance{})
+	obj.ChallengeRateLimit = 3
+	obj.Save()
+	t.Cleanup(func() {
+		RemoveExpiredChallengeRates(clock.Add(CHALLENGE_RATE_LIMIT_WINDOW))
+		bmc.RemoveBMC(obj.Addr)
+	})
+	source := net.ParseIP("192.0.2.1")
+	other := net.ParseIP("192.0.2.2")
+
+	for i := 1; i <= 3; i += 1 {
+		if !AllowSessionChallenge(ip, source) {
+			t.Fatalf("challenge %d is rejected, the limit is 3", i)
+		}
+	}
+	if AllowSessionChallenge(ip, source) {
+		t.Fatal("challenge 4 is allowed, the limit is 3")
+	}
+	if !AllowSessionChallenge(ip, other) {
+		t.Error("challenge from another source is rejected")
+	}
+
+	*clock = clock.Add(CHALLENGE_RATE_LIMIT_WINDOW - time.Second)
+	if AllowSessionChallenge(ip, source) {
+		t.Error("challenge is allowed before the window is over")
+	}
+
+	*clock = clock.Add(time.Second)
+	for i := 1; i <= 3; i += 1 {
+		if !AllowSessionChallenge(ip, source) {
+			t.Fatalf("challenge %d of the next window is rejected", i)
+		}
+	}
+	if AllowSessionChallenge(ip, source) {
+		t.Error("challenge 4 of the next window is allowed")
+	}
+}
@@ -0,0 +1,199 @@
+package ipmi
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+)
+
+// IPMI_Handler handles an IPMI request of one command and sends the response
+// by itself. Use WrapIPMIHandler to register it.
+type IPMI_Handler func(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage)
+
+// Controller-specific OEM/Group network functions.
+const (
+	IPMI_NETFN_OEM_FIRST =		0x30
+	IPMI_NETFN_OEM_LAST =		0x3e
+)
+
+// IPMIHandlerKey identifies the handler of a command. Body is the group ID
+// (the first data byte) for Group Extension commands, and the IANA enterprise
+// number (the first 3 data bytes) for OEM/Group commands. Body is 0 for the
+// other network functions.
+type IPMIHandlerKey struct {
+	NetFunction uint8
+	Body uint32
+	Command uint8
+}
+
+// IPMIHandlerEntry is one handler in the registry. Name is only used in logs.
+type IPMIHandlerEntry struct {
+	Key IPMIHandlerKey
+	Name string
+	Handler IPMICommandHandler
+}
+
+func (key IPMIHandlerKey)String() string {
+	switch key.NetFunction {
+	case IPMI_NETFN_GROUP_EXTENSION:
+		return fmt.Sprintf("%s 0x%02x: Group 0x%02x, Command 0x%02x", IPMINetFunctionName(key.NetFunction), key.NetFunction, key.Body, key.Command)
+	case IPMI_NETFN_OEM_GROUP:
+		return fmt.Sprintf("%s 0x%02x: IANA %d, Command 0x%02x", IPMINetFunctionName(key.NetFunction), key.NetFunction, key.Body, key.Command)
+	}
+	return fmt.Sprintf("%s 0x%02x: Command 0x%02x", IPMINetFunctionName(key.NetFunction), key.NetFunction, key.Command)
+}
+
+// IPMICommandKey is the key of a command of a network function without a
+// group ID or an IANA, e.g. IPMI_NETFN_APP or a controller-specific OEM
+// network function.
+func IPMICommandKey(netFunction uint8, command uint8) IPMIHandlerKey {
+	return IPMIHandlerKey{NetFunction: netFunction, Command: command}
+}
+
+// IPMIGroupExtensionKey is the key of a Group Extension command of the group
+// defined by groupID, e.g. GROUP_EXT_PICMG.
+func IPMIGroupExtensionKey(groupID uint8, command uint8) IPMIHandlerKey {
+	return IPMIHandlerKey{NetFunction: IPMI_NETFN_GROUP_EXTENSION, Body: uint32(groupID), Command: command}
+}
+
+// IPMIOEMKey is the key of an OEM/Group command of the vendor with the IANA
+// enterprise number iana.
+func IPMIOEMKey(iana uint32, command uint8) IPMIHandlerKey {
+	return IPMIHandlerKey{NetFunction: IPMI_NETFN_OEM_GROUP, Body: iana & 0xffffff, Command: command}
+}
+
+var ipmiNetFunctionNames = map[uint8]string {
+	IPMI_NETFN_CHASSIS:		"CHASSIS",
+	IPMI_NETFN_BRIDGE:		"BRIDGE",
+	IPMI_NETFN_SENSOR_EVENT:	"SENSOR / EVENT",
+	IPMI_NETFN_APP:			"APP",
+	IPMI_NETFN_FIRMWARE:		"FIRMWARE",
+	IPMI_NETFN_STORAGE:		"STORAGE",
+	IPMI_NETFN_TRANSPORT:		"TRANSPORT",
+	IPMI_NETFN_GROUP_EXTENSION:	"GROUP EXTENSION",
+	IPMI_NETFN_OEM_GROUP:		"OEM GROUP",
+}
+
+func IPMINetFunctionName(netFunction uint8) string {
+	if name, ok := ipmiNetFunctionNames[netFunction]; ok {
+		return name
+	}
+	if netFunction >= IPMI_NETFN_OEM_FIRST && netFunction <= IPMI_NETFN_OEM_LAST {
+		return "OEM"
+	}
+	return "Unknown NetFunction"
+}
+
+// Names of the commands defined by this package, used when a handler is
+// registered without a name.
+var ipmiCommandNameTables = map[IPMIHandlerKey]map[uint8]string {
+	IPMICommandKey(IPMI_NETFN_APP, 0):		ipmiAppCommandNames,
+	IPMICommandKey(IPMI_NETFN_CHASSIS, 0):		ipmiChassisCommandNames,
+	IPMICommandKey(IPMI_NETFN_SENSOR_EVENT, 0):	ipmiSensorEventCommandNames,
+	IPMICommandKey(IPMI_NETFN_STORAGE, 0):		ipmiStorageCommandNames,
+	IPMICommandKey(IPMI_NETFN_TRANSPORT, 0):	ipmiTransportCommandNames,
+	IPMIGroupExtensionKey(GROUP_EXT_PICMG, 0):	ipmiGroupExtPICMGCommandNames,
+}
+
+func ipmiCommandName(key IPMIHandlerKey) string {
+	command := key.Command
+	key.Command = 0
+	return ipmiCommandNameTables[key][command]
+}
+
+var ipmiHandlers = map[IPMIHandlerKey]IPMIHandlerEntry{}
+var ipmiHandlersLock sync.RWMutex
+
+// RegisterIPMIHandler registers the handler of a command and returns the
+// handler it overrides, or nil. A nil handler unregisters the command. An
+// empty name keeps the name of the overridden handler, or the name of the
+// command when this package defines it.
+func RegisterIPMIHandler(key IPMIHandlerKey, name string, handler IPMICommandHandler) IPMICommandHandler {
+	ipmiHandlersLock.Lock()
+	defer ipmiHandlersLock.Unlock()
+
+	previous, ok := ipmiHandlers[key]
+	if name == "" && ok {
+		name = previous.Name
+	} else if name == "" {
+		name = ipmiCommandName(key)
+	}
+
+	if handler == nil {
+		delete(ipmiHandlers, key)
+	} else {
+		ipmiHandlers[key] = IPMIHandlerEntry{Key: key, Name: name, Handler: handler}
+	}
+	return previous.Handler
+}
+
+// UnregisterIPMIHandler removes the handler of a command, so that the command
+// is answered with COMPLETION_CODE_INVALID_COMMAND.
+func UnregisterIPMIHandler(key IPMIHandlerKey) {
+	RegisterIPMIHandler(key, "", nil)
+}
+
+func LookupIPMIHandler(key IPMIHandlerKey) (IPMIHandlerEntry, bool) {
+	ipmiHandlersLock.RLock()
+	defer ipmiHandlersLock.RUnlock()
+
+	entry, ok := ipmiHandlers[key]
+	return entry, ok
+}
+
+type ipmiHandlerEntryList []IPMIHandlerEntry
+
+func (list ipmiHandlerEntryList)Len() int {
+	return len(list)
+}
+
+func (list ipmiHandlerEntryList)Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+
+func (list ipmiHandlerEntryList)Less(i, j int) bool {
+	a, b := list[i].Key, list[j].Key
+	if a.NetFunction != b.NetFunction {
+		return a.NetFunction < b.NetFunction
+	}
+	if a.Body != b.Body {
+		return a.Body < b.Body
+	}
+	return a.Command < b.Command
+}
+
+// ListIPMIHandlers returns all registered handlers, ordered by network
+// function, group ID or IANA, and command.
+func ListIPMIHandlers() []IPMIHandlerEntry {
+	ipmiHandlersLock.RLock()
+	defer ipmiHandlersLock.RUnlock()
+
+	list := ipmiHandlerEntryList{}
+	for _, entry := range ipmiHandlers {
+		list = append(list, entry)
+	}
+	sort.Sort(list)
+	return list
+}
+
+// GetIPMIHandlerKey builds the registry key of a request. ok is false when a
+// Group Extension or OEM/Group request is too short to carry its group ID or
+// IANA.
+func GetIPMIHandlerKey(message IPMIMessage) (key IPMIHandlerKey, ok bool) {
+	netFunction := (message.TargetLun & 0xFC) >> 2
+	switch netFunction {
+	case IPMI_NETFN_GROUP_EXTENSION:
+		if len(message.Data) < 1 {
+			return key, false
+		}
+		return IPMIGroupExtensionKey(message.Data[0], message.Command), true
+	case IPMI_NETFN_OEM_GROUP:
+		if len(message.Data) < 3 {
+			return key, false
+		}
+		iana := uint32(message.Data[0]) | uint32(message.Data[1]) << 8 | uint32(message.Data[2]) << 16
+		return IPMIOEMKey(iana, message.Command), true
+	}
+	return IPMICommandKey(netFunction, message.Command), true
+}
@@ -0,0 +1,97 @@
+package ipmi
+
+import (
+	"log"
+)
+
+type ipmiRequestLength struct {
+	Min int
+	Max int
+}
+
+// Length of the request data accepted by each supported command. Commands
+// which are not listed here are not checked before dispatch, and requests of
+// variable length are checked by their handlers as well.
+var ipmiRequestLengths = map[ipmiCommandKey]ipmiRequestLength {
+	// App
+	{IPMI_NETFN_APP, IPMI_CMD_GET_DEVICE_ID}:			{0, 0},
+	{IPMI_NETFN_APP, IPMI_CMD_GET_DEVICE_GUID}:			{0, 0},
+	{IPMI_NETFN_APP, IPMI_CMD_GET_CHANNEL_AUTH_CAPABILITIES}:	{2, 2},
+	{IPMI_NETFN_APP, IPMI_CMD_GET_SESSION_CHALLENGE}:		{17, 17},
+	{IPMI_NETFN_APP, IPMI_CMD_ACTIVATE_SESSION}:			{22, 22},
+	{IPMI_NETFN_APP, IPMI_CMD_SET_SESSION_PRIVILEGE}:		{1, 1},
+	{IPMI_NETFN_APP, IPMI_CMD_CLOSE_SESSION}:			{4, 5},
+	{IPMI_NETFN_APP, IPMI_CMD_GET_SESSION_INFO}:			{1, 5},
+	{IPMI_NETFN_APP, IPMI_CMD_SET_USER_ACCESS}:			{3, 4},
+	{IPMI_NETFN_APP, IPMI_CMD_GET_USER_ACCESS}:			{2, 2},
+	{IPMI_NETFN_APP, IPMI_CMD_SET_USER_NAME}:			{17, 17},
+	{IPMI_NETFN_APP, IPMI_CMD_GET_USER_NAME}:			{1, 1},
+	{IPMI_NETFN_APP, IPMI_CMD_SET_USER_PASSWORD}:			{2, 22},
+	{IPMI_NETFN_APP, IPMI_CMD_ACTIVATE_PAYLOAD}:			{6, 6},
+	{IPMI_NETFN_APP, IPMI_CMD_DEACTIVATE_PAYLOAD}:			{6, 6},
+	{IPMI_NETFN_APP, IPMI_CMD_GET_PAYLOAD_ACTIVATION_STATUS}:	{1, 1},
+	{IPMI_NETFN_APP, IPMI_CMD_GET_CHANNEL_CIPHER_SUITES}:		{3, 3},
+
+	// Chassis
+	{IPMI_NETFN_CHASSIS, IPMI_CMD_GET_CHASSIS_STATUS}:		{0, 0},
+	{IPMI_NETFN_CHASSIS, IPMI_CMD_CHASSIS_CONTROL}:			{1, 1},
+	{IPMI_NETFN_CHASSIS, IPMI_CMD_SET_SYSTEM_BOOT_OPTIONS}:		{1, 18},
+	{IPMI_NETFN_CHASSIS, IPMI_CMD_GET_SYSTEM_BOOT_OPTIONS}:		{3, 3},
+
+	// Sensor/Event
+	{IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_GET_SENSOR_READING_FACTORS}:	{2, 2},
+	{IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_SET_SENSOR_HYSTERESIS}:	{4, 4},
+	{IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_GET_SENSOR_HYSTERESIS}:	{2, 2},
+	{IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_SET_SENSOR_THRESHOLD}:	{8, 8},
+	{IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_GET_SENSOR_THRESHOLD}:	{1, 1},
+	{IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_GET_SENSOR_EVENT_STATUS}:	{1, 1},
+	{IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_GET_SENSOR_READING}:		{1, 1},
+
+	// Storage
+	{IPMI_NETFN_STORAGE, IPMI_CMD_GET_FRU_INVENTORY_AREA_INFO}:	{1, 1},
+	{IPMI_NETFN_STORAGE, IPMI_CMD_READ_FRU_DATA}:			{4, 4},
+	{IPMI_NETFN_STORAGE, IPMI_CMD_WRITE_FRU_DATA}:			{4, 258},	// at most 255 bytes, the count of the response
+	{IPMI_NETFN_STORAGE, IPMI_CMD_GET_SDR_REPOSITORY_INFO}:		{0, 0},
+	{IPMI_NETFN_STORAGE, IPMI_CMD_RESERVE_SDR_REPOSITORY}:		{0, 0},
+	{IPMI_NETFN_STORAGE, IPMI_CMD_GET_SDR}:				{6, 6},
+	{IPMI_NETFN_STORAGE, IPMI_CMD_GET_SEL_INFO}:			{0, 0},
+	{IPMI_NETFN_STORAGE, IPMI_CMD_GET_SEL_ALLOCATION_INFO}:		{0, 0},
+	{IPMI_NETFN_STORAGE, IPMI_CMD_RESERVE_SEL}:			{0, 0},
+	{IPMI_NETFN_STORAGE, IPMI_CMD_GET_SEL_ENTRY}:			{6, 6},
+	{IPMI_NETFN_STORAGE, IPMI_CMD_ADD_SEL_ENTRY}:			{16, 16},
+	{IPMI_NETFN_STORAGE, IPMI_CMD_DELETE_SEL_ENTRY}:		{4, 4},
+	{IPMI_NETFN_STORAGE, IPMI_CMD_CLEAR_SEL}:			{6, 6},
+	{IPMI_NETFN_STORAGE, IPMI_CMD_GET_SEL_TIME}:			{0, 0},
+	{IPMI_NETFN_STORAGE, IPMI_CMD_SET_SEL_TIME}:			{4, 4},
+
+	// Transport
+	{IPMI_NETFN_TRANSPORT, IPMI_CMD_SET_SOL_CONFIGURATION_PARAMETERS}:	{3, 4},
+	{IPMI_NETFN_TRANSPORT, IPMI_CMD_GET_SOL_CONFIGURATION_PARAMETERS}:	{4, 4},
+
+	// Group Extension
+	{IPMI_NETFN_GROUP_EXTENSION, GROUP_EXT_CMD_ATCA_GET_PICMG_PROP}:	{1, 1},
+}
+
+// IPMIRequestLengthMiddleware checks the length of the request data against
+// the command. Rejected requests are answered with
+// COMPLETION_CODE_REQUEST_DATA_LENGTH_INVALID when the data is too short or
+// COMPLETION_CODE_REQUEST_DATA_LENGTH_EXCEEDED when it is too long.
+func IPMIRequestLengthMiddleware(next IPMICommandHandler) IPMICommandHandler {
+	return func(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+		length, ok := ipmiRequestLengths[ipmiCommandKey{request.NetFunction, request.Command}]
+		if ! ok {
+			return next(ctx, request)
+		}
+
+		if len(request.Data) < length.Min {
+			log.Printf("    IPMI: Command 0x%02x:0x%02x needs %d bytes of data, got %d, reject.\n", request.NetFunction, request.Command, length.Min, len(request.Data))
+			return COMPLETION_CODE_REQUEST_DATA_LENGTH_INVALID, nil
+		}
+		if len(request.Data) > length.Max {
+			log.Printf("    IPMI: Command 0x%02x:0x%02x accepts %d bytes of data, got %d, reject.\n", request.NetFunction, request.Command, length.Max, len(request.Data))
+			return COMPLETION_CODE_REQUEST_DATA_LENGTH_EXCEEDED, nil
+		}
+
+		return next(ctx, request)
+	}
+}
@@ -0,0 +1,100 @@
+package ipmi
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"sync"
+)
+
+// The last request of a session and the response packet sent to it, so that
+// a retransmitted request is answered again without running its handler.
+type retransmitEntry struct {
+	SequenceNumber uint32		// session sequence number of the request
+	NetFunction uint8
+	Command uint8
+	RequestSeq uint8		// rqSeq of the request
+	Data []uint8
+	Response []byte			// nil until the response is sent
+}
+
+var retransmitCache map[ipmiSessionKey]retransmitEntry
+var retransmitCacheLock sync.Mutex
+
+func init() {
+	retransmitCache = make(map[ipmiSessionKey]retransmitEntry)
+}
+
+func requestSeq(message IPMIMessage) uint8 {
+	return message.SourceLun >> 2
+}
+
+// matches reports whether the request is a retransmission of the entry: the
+// same packet, with the same session sequence number. A new sequence number
+// always means a new request, even when the command is repeated.
+func (entry *retransmitEntry)matches(sequenceNumber uint32, message IPMIMessage) bool {
+	netFunction := (message.TargetLun & 0xFC) >> 2
+	return sequenceNumber == entry.SequenceNumber && netFunction == entry.NetFunction && message.Command == entry.Command &&
+		requestSeq(message) == entry.RequestSeq && bytes.Equal(message.Data, entry.Data)
+}
+
+// ResendCachedResponse sends the cached response again when the request is a
+// retransmission of the last request of the session, and reports whether it
+// is.
+func ResendCachedResponse(addr *net.UDPAddr, server *net.UDPConn, session IPMISession, sequenceNumber uint32, message IPMIMessage) bool {
+	retransmitCacheLock.Lock()
+	entry, ok := retransmitCache[session.key()]
+	retransmitCacheLock.Unlock()
+
+	if ! ok || entry.Response == nil || ! entry.matches(sequenceNumber, message) {
+		return false
+	}
+
+	log.Printf("    IPMI: Request 0x%02x:0x%02x (rqSeq 0x%02x) of session 0x%08x is retransmitted, send the cached response.\n",
+		entry.NetFunction, entry.Command, entry.RequestSeq, session.SessionID)
+	server.WriteToUDP(entry.Response, addr)
+	return true
+}
+
+// CacheIPMIRequest remembers the request as the last request of the session
+// before it is executed.
+func CacheIPMIRequest(session IPMISession, sequenceNumber uint32, message IPMIMessage) {
+	entry := retransmitEntry{}
+	entry.SequenceNumber = sequenceNumber
+	entry.NetFunction = (message.TargetLun & 0xFC) >> 2
+	entry.Command = message.Command
+	entry.RequestSeq = requestSeq(message)
+	entry.Data = append([]uint8{}, message.Data...)
+
+	retransmitCacheLock.Lock()
+	defer retransmitCacheLock.Unlock()
+
+	retransmitCache[session.key()] = entry
+}
+
+// cacheIPMIResponse keeps the response packet when it answers the last request
+// of the session.
+func cacheIPMIResponse(wrapper IPMISessionWrapper, message IPMIMessage, packet []byte) {
+	if wrapper.SessionId == 0 {
+		return
+	}
+
+	retransmitCacheLock.Lock()
+	defer retransmitCacheLock.Unlock()
+
+	key := ipmiSessionKey{wrapper.BMCIP, wrapper.SessionId}
+	entry, ok := retransmitCache[key]
+	netFunction := (message.TargetLun & 0xFC) >> 2
+	if ! ok || entry.Response != nil || netFunction != (entry.NetFunction | IPMI_NETFN_RESPONSE) || message.Command != entry.Command || requestSeq(message) != entry.RequestSeq {
+		return
+	}
+	entry.Response = append([]byte{}, packet...)
+	retransmitCache[key] = entry
+}
+
+func removeCachedIPMIRequest(key ipmiSessionKey) {
+	retransmitCacheLock.Lock()
+	defer retransmitCacheLock.Unlock()
+
+	delete(retransmitCache, key)
+}
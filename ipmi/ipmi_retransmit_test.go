@@ -0,0 +1,167 @@
+package ipmi
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rmxymh/infra-ecosphere/bmc"
+)
+
+// The command answered by the handler of the retransmit tests.
+const retransmitTestCommand = 0x5f
+
+// retransmitTestConsole is a remote console and a BMC on the loopback
+// interface, with an activated session between them.
+type retransmitTestConsole struct {
+	t       *testing.T
+	server  *net.UDPConn
+	client  *net.UDPConn
+	session IPMISession
+	calls   int
+}
+
+func newRetransmitTestConsole(t *testing.T) *retransmitTestConsole {
+	t.Helper()
+
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	user := bmc.BMCUser{
+		ID:            2,
+		Username:      "admin",
+		Password:      "admin",
+		MaxPrivilege:  PRIVILEGE_ADMINISTRATOR,
+		Enabled:       true,
+		IPMIMessaging: true,
+	}
+	session, ok := GetNewSession("127.0.0.1", client.LocalAddr().(*net.UDPAddr), user)
+	if !ok {
+		t.Fatal("GetNewSession failed")
+	}
+	session.AuthenticationType = AUTH_MD5
+	session.Activated = true
+	session.PrivilegeLevel = PRIVILEGE_ADMINISTRATOR
+	session.MaxPrivilegeLevel = PRIVILEGE_ADMINISTRATOR
+	session.Save()
+	t.Cleanup(func() { RemoveSession("127.0.0.1", session.SessionID) })
+
+	console := &retransmitTestConsole{t: t, server: server, client: client, session: session}
+	key := IPMICommandKey(IPMI_NETFN_APP, retransmitTestCommand)
+	RegisterIPMIHandler(key, "Retransmit Test", func(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+		console.calls += 1
+		return COMPLETION_CODE_OK, []uint8{uint8(console.calls)}
+	})
+	t.Cleanup(func() { UnregisterIPMIHandler(key) })
+	return console
+}
+
+func retransmitTestMessage(rqSeq uint8, data []uint8) IPMIMessage {
+	return IPMIMessage{
+		TargetAddress: 0x20,
+		TargetLun:     IPMI_NETFN_APP << 2,
+		SourceAddress: 0x81,
+		SourceLun:     rqSeq << 2,
+		Command:       retransmitTestCommand,
+		Data:          data,
+	}
+}
+
+// send signs the request with the session sequence number, runs it through
+// IPMIDeserializeAndExecute and returns the response packet.
+func (console *retransmitTestConsole) send(sequenceNumber uint32, message IPMIMessage) []byte {
+	console.t.Helper()
+
+	wrapper := IPMISessionWrapper{
+		AuthenticationType: AUTH_MD5,
+		SequenceNumber:     sequenceNumber,
+		SessionId:          console.session.SessionID,
+	}
+	request := bytes.Buffer{}
+	SerializeIPMI(&request, wrapper, message, console.session.User.Password)
+	IPMIDeserializeAndExecute(&request, console.client.LocalAddr().(*net.UDPAddr), console.server)
+
+	response := make([]byte, 1024)
+	console.client.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := console.client.ReadFromUDP(response)
+	if err != nil {
+		console.t.Fatalf("no response to sequence number 0x%08x: %v", sequenceNumber, err)
+	}
+	return response[:n]
+}
+
+func TestRetransmitSameSequenceNumber(t *testing.T) {
+	console := newRetransmitTestConsole(t)
+	message := retransmitTestMessage(0x10, []uint8{0xaa})
+
+	first := console.send(1, message)
+	second := console.send(1, message)
+	if console.calls != 1 {
+		t.Errorf("handler runs %d times, want 1", console.calls)
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("retransmitted response = % x, want % x", second, first)
+	}
+}
+
+func TestRetransmitNewSequenceNumber(t *testing.T) {
+	console := newRetransmitTestConsole(t)
+	message := retransmitTestMessage(0x10, []uint8{0xaa})
+
+	first := console.send(1, message)
+	second := console.send(2, message)
+	if console.calls != 2 {
+		t.Errorf("handler runs %d times, want 2", console.calls)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("the request with a new sequence number is answered with the cached response")
+	}
+}
+
+func TestRetransmitResponseMismatch(t *testing.T) {
+	session := IPMISession{SessionID: 0x01020304, BMCIP: "127.0.9.2"}
+	t.Cleanup(func() { removeCachedIPMIRequest(session.key()) })
+	request := retransmitTestMessage(0x10, nil)
+	wrapper := IPMISessionWrapper{SessionId: session.SessionID, BMCIP: session.BMCIP}
+	packet := []byte{0x01, 0x02, 0x03}
+
+	matching := retransmitTestMessage(0x10, nil)
+	matching.TargetLun = (IPMI_NETFN_APP | IPMI_NETFN_RESPONSE) << 2
+	otherNetFunction := matching
+	otherNetFunction.TargetLun = (IPMI_NETFN_CHASSIS | IPMI_NETFN_RESPONSE) << 2
+	otherCommand := matching
+	otherCommand.Command = retransmitTestCommand + 1
+	otherRequestSeq := matching
+	otherRequestSeq.SourceLun = 0x11 << 2
+
+	tests := []struct {
+		name     string
+		response IPMIMessage
+	}{
+		{"netfn", otherNetFunction},
+		{"cmd", otherCommand},
+		{"rqSeq", otherRequestSeq},
+	}
+	for _, test := range tests {
+		CacheIPMIRequest(session, 1, request)
+		cacheIPMIResponse(wrapper, test.response, packet)
+		if entry := retransmitCache[session.key()]; entry.Response != nil {
+			t.Errorf("response of another %s is cached", test.name)
+		}
+	}
+
+	CacheIPMIRequest(session, 1, request)
+	cacheIPMIResponse(wrapper, matching, packet)
+	if entry := retransmitCache[session.key()]; !bytes.Equal(entry.Response, packet) {
+		t.Errorf("cached response = % x, want % x", entry.Response, packet)
+	}
+}
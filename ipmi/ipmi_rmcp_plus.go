@@ -0,0 +1,297 @@
+package ipmi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"unsafe"
+)
+import (
+	"github.com/rmxymh/infra-ecosphere/utils"
+)
+
+// RMCP+ Payload Types (IPMI v2.0 Table 13-16)
+const (
+	RMCP_PLUS_PAYLOAD_TYPE_IPMI =			0x00
+	RMCP_PLUS_PAYLOAD_TYPE_SOL =			0x01
+	RMCP_PLUS_PAYLOAD_TYPE_OEM_EXPLICIT =		0x02
+	RMCP_PLUS_PAYLOAD_TYPE_OPEN_SESSION_REQUEST =	0x10
+	RMCP_PLUS_PAYLOAD_TYPE_OPEN_SESSION_RESPONSE =	0x11
+	RMCP_PLUS_PAYLOAD_TYPE_RAKP_1 =			0x12
+	RMCP_PLUS_PAYLOAD_TYPE_RAKP_2 =			0x13
+	RMCP_PLUS_PAYLOAD_TYPE_RAKP_3 =			0x14
+	RMCP_PLUS_PAYLOAD_TYPE_RAKP_4 =			0x15
+)
+
+const (
+	RMCP_PLUS_PAYLOAD_BITMASK_ENCRYPTED =		0x80
+	RMCP_PLUS_PAYLOAD_BITMASK_AUTHENTICATED =	0x40
+	RMCP_PLUS_PAYLOAD_BITMASK_TYPE =		0x3f
+)
+
+type RMCPPlusSessionWrapper struct {
+	AuthenticationType uint8
+	PayloadType uint8
+	OEMIANA uint32			// Only present when PayloadType is OEM explicit
+	OEMPayloadID uint16		// Only present when PayloadType is OEM explicit
+	SessionId uint32
+	SequenceNumber uint32
+	PayloadLength uint16
+}
+
+func (wrapper *RMCPPlusSessionWrapper)Type() uint8 {
+	return wrapper.PayloadType & RMCP_PLUS_PAYLOAD_BITMASK_TYPE
+}
+
+func (wrapper *RMCPPlusSessionWrapper)IsEncrypted() bool {
+	return wrapper.PayloadType & RMCP_PLUS_PAYLOAD_BITMASK_ENCRYPTED != 0
+}
+
+func (wrapper *RMCPPlusSessionWrapper)IsAuthenticated() bool {
+	return wrapper.PayloadType & RMCP_PLUS_PAYLOAD_BITMASK_AUTHENTICATED != 0
+}
+
+func DeserializeRMCPPlusSessionWrapper(buf io.Reader) (length uint32, wrapper RMCPPlusSessionWrapper, err error) {
+	length = 0
+
+	if err = readField(buf, "RMCP+ session wrapper", "Authentication Type", &wrapper.AuthenticationType); err != nil {
+		return 0, wrapper, err
+	}
+	length += uint32(unsafe.Sizeof(wrapper.AuthenticationType))
+	if err = readField(buf, "RMCP+ session wrapper", "Payload Type", &wrapper.PayloadType); err != nil {
+		return 0, wrapper, err
+	}
+	length += uint32(unsafe.Sizeof(wrapper.PayloadType))
+	if wrapper.Type() == RMCP_PLUS_PAYLOAD_TYPE_OEM_EXPLICIT {
+		if err = readField(buf, "RMCP+ session wrapper", "OEM IANA", &wrapper.OEMIANA); err != nil {
+			return 0, wrapper, err
+		}
+		length += uint32(unsafe.Sizeof(wrapper.OEMIANA))
+		if err = readField(buf, "RMCP+ session wrapper", "OEM Payload ID", &wrapper.OEMPayloadID); err != nil {
+			return 0, wrapper, err
+		}
+		length += uint32(unsafe.Sizeof(wrapper.OEMPayloadID))
+	}
+	if err = readField(buf, "RMCP+ session wrapper", "Session ID", &wrapper.SessionId); err != nil {
+		return 0, wrapper, err
+	}
+	length += uint32(unsafe.Sizeof(wrapper.SessionId))
+	if err = readField(buf, "RMCP+ session wrapper", "Session Sequence Number", &wrapper.SequenceNumber); err != nil {
+		return 0, wrapper, err
+	}
+	length += uint32(unsafe.Sizeof(wrapper.SequenceNumber))
+	if err = readField(buf, "RMCP+ session wrapper", "Payload Length", &wrapper.PayloadLength); err != nil {
+		return 0, wrapper, err
+	}
+	length += uint32(unsafe.Sizeof(wrapper.PayloadLength))
+
+	return length, wrapper, nil
+}
+
+func SerializeRMCPPlusSessionWrapper(buf *bytes.Buffer, wrapper RMCPPlusSessionWrapper) {
+	binary.Write(buf, binary.LittleEndian, wrapper.AuthenticationType)
+	binary.Write(buf, binary.LittleEndian, wrapper.PayloadType)
+	if wrapper.Type() == RMCP_PLUS_PAYLOAD_TYPE_OEM_EXPLICIT {
+		binary.Write(buf, binary.LittleEndian, wrapper.OEMIANA)
+		binary.Write(buf, binary.LittleEndian, wrapper.OEMPayloadID)
+	}
+	binary.Write(buf, binary.LittleEndian, wrapper.SessionId)
+	binary.Write(buf, binary.LittleEndian, wrapper.SequenceNumber)
+	binary.Write(buf, binary.LittleEndian, wrapper.PayloadLength)
+}
+
+// SerializeRMCPPlusPayload encrypts and signs the payload with the algorithms
+// negotiated for session. session is nil for packets outside of a session.
+func SerializeRMCPPlusPayload(buf *bytes.Buffer, wrapper RMCPPlusSessionWrapper, session *IPMISession, payload []uint8) {
+	wrapper.AuthenticationType = AUTH_RMCP_PLUS
+	if session != nil && session.ConfidentialityAlgorithm != CONFIDENTIALITY_ALG_NONE {
+		payload = EncryptPayload(*session, payload)
+		wrapper.PayloadType |= RMCP_PLUS_PAYLOAD_BITMASK_ENCRYPTED
+	}
+	if session != nil && session.IntegrityAlgorithm != INTEGRITY_ALG_NONE {
+		wrapper.PayloadType |= RMCP_PLUS_PAYLOAD_BITMASK_AUTHENTICATED
+	}
+	wrapper.PayloadLength = uint16(len(payload))
+
+	packet := bytes.Buffer{}
+	SerializeRMCPPlusSessionWrapper(&packet, wrapper)
+	packet.Write(payload)
+	if wrapper.IsAuthenticated() {
+		AddIntegrityTrailer(*session, &packet)
+	}
+	buf.Write(packet.Bytes())
+}
+
+// UnprotectRMCPPlusPayload checks the session trailer and decrypts the payload
+// as required by the algorithms negotiated for session. packet starts from
+// the Authentication Type field.
+func UnprotectRMCPPlusPayload(session IPMISession, wrapper RMCPPlusSessionWrapper, packet []byte, payloadStart int, payloadEnd int) ([]byte, bool) {
+	if session.IntegrityAlgorithm != INTEGRITY_ALG_NONE {
+		if ! wrapper.IsAuthenticated() {
+			log.Printf("    RMCP+: Session 0x%08x requires integrity, ignore unauthenticated packet.\n", session.SessionID)
+			return nil, false
+		}
+		if ! CheckIntegrityTrailer(session, packet, payloadEnd) {
+			log.Printf("    RMCP+: Integrity check failed for session 0x%08x, ignore.\n", session.SessionID)
+			return nil, false
+		}
+	}
+
+	payload := packet[payloadStart:payloadEnd]
+	if session.ConfidentialityAlgorithm != CONFIDENTIALITY_ALG_NONE {
+		if ! wrapper.IsEncrypted() {
+			log.Printf("    RMCP+: Session 0x%08x requires confidentiality, ignore unencrypted packet.\n", session.SessionID)
+			return nil, false
+		}
+		plain, err := DecryptPayload(session, payload)
+		if err != nil {
+			log.Printf("    RMCP+: Failed to decrypt payload for session 0x%08x: %s\n", session.SessionID, err)
+			return nil, false
+		}
+		payload = plain
+	}
+
+	return payload, true
+}
+
+// SerializeRMCPPlusIPMI wraps an IPMI response message whose wrapper was built
+// from an RMCP+ request. wrapper.SessionId is the managed system session ID,
+// so it is translated to the ID the remote console expects here.
+func SerializeRMCPPlusIPMI(buf *bytes.Buffer, wrapper IPMISessionWrapper, message IPMIMessage) {
+	payload := bytes.Buffer{}
+	SerializeIPMIMessage(&payload, message)
+
+	plusWrapper := RMCPPlusSessionWrapper{}
+	plusWrapper.PayloadType = RMCP_PLUS_PAYLOAD_TYPE_IPMI
+	if wrapper.SessionId == 0 {
+		SerializeRMCPPlusPayload(buf, plusWrapper, nil, payload.Bytes())
+		return
+	}
+
+	session, ok := GetSession(wrapper.BMCIP, wrapper.SessionId)
+	if ! ok {
+		log.Printf("    RMCP+: Unable to find session 0x%08x for response\n", wrapper.SessionId)
+		return
+	}
+	plusWrapper.SessionId = session.RemoteConsoleSessionID
+	plusWrapper.SequenceNumber = wrapper.SequenceNumber
+
+	SerializeRMCPPlusPayload(buf, plusWrapper, &session, payload.Bytes())
+}
+
+func SendRMCPPlusPayload(addr *net.UDPAddr, server *net.UDPConn, wrapper RMCPPlusSessionWrapper, payload []uint8) {
+	obuf := bytes.Buffer{}
+	SerializeRMCP(&obuf, BuildUpRMCPForIPMI())
+	SerializeRMCPPlusPayload(&obuf, wrapper, nil, payload)
+	server.WriteToUDP(obuf.Bytes(), addr)
+}
+
+func RMCPPlusDeserializeAndExecute(buf io.Reader, addr *net.UDPAddr, server *net.UDPConn) {
+	obj, ok := getLocalBMC(server)
+	if ! ok || ! obj.IsRMCPPlusEnabled() {
+		log.Println("    RMCP+: RMCP+ is disabled on this BMC, ignore.")
+		return
+	}
+
+	packet, _ := ioutil.ReadAll(buf)
+	wrapperLength, wrapper, err := DeserializeRMCPPlusSessionWrapper(bytes.NewBuffer(packet))
+	if err != nil {
+		log.Printf("    RMCP+: Drop malformed packet, %s\n", err)
+		return
+	}
+
+	payloadEnd := int(wrapperLength) + int(wrapper.PayloadLength)
+	if payloadEnd > len(packet) {
+		err = &PacketLengthError{Layer: "RMCP+ session wrapper", Field: "Payload Length", Length: int(wrapper.PayloadLength), Min: 0, Max: len(packet) - int(wrapperLength)}
+		log.Printf("    RMCP+: Drop malformed packet, %s\n", err)
+		return
+	}
+	payload := packet[wrapperLength:payloadEnd]
+	if wrapper.IsEncrypted() || wrapper.IsAuthenticated() {
+		if (wrapper.Type() != RMCP_PLUS_PAYLOAD_TYPE_IPMI && wrapper.Type() != RMCP_PLUS_PAYLOAD_TYPE_SOL) || wrapper.SessionId == 0 {
+			log.Println("    RMCP+: Protected payload outside of a session, ignore.")
+			return
+		}
+	}
+
+	switch wrapper.Type() {
+	case RMCP_PLUS_PAYLOAD_TYPE_IPMI:
+		log.Println("    RMCP+: Payload Type = IPMI")
+		session := IPMISession{}
+		if wrapper.SessionId != 0 {
+			session, ok = GetSession(utils.GetLocalIP(server), wrapper.SessionId)
+			if ! ok || ! session.IsRMCPPlus() || ! session.Activated {
+				log.Printf("    RMCP+: Session 0x%08x is not active, ignore.\n", wrapper.SessionId)
+				return
+			}
+			payload, ok = UnprotectRMCPPlusPayload(session, wrapper, packet, int(wrapperLength), payloadEnd)
+			if ! ok {
+				return
+			}
+		}
+
+		_, message, err := DeserializeIPMIMessage(bytes.NewBuffer(payload), uint32(len(payload)))
+		checksumErr, badChecksum := err.(*ChecksumError)
+		if err != nil && ! badChecksum {
+			log.Printf("    RMCP+: Drop malformed packet, %s\n", err)
+			return
+		}
+		if wrapper.SessionId != 0 {
+			if ResendCachedResponse(addr, server, session, wrapper.SequenceNumber, message) {
+				return
+			}
+			if ! AcceptInboundSequenceNumber(session.BMCIP, session.SessionID, wrapper.SequenceNumber) {
+				log.Printf("    RMCP+: Sequence number 0x%08x is out of the window of session 0x%08x, ignore.\n", wrapper.SequenceNumber, wrapper.SessionId)
+				return
+			}
+			CacheIPMIRequest(session, wrapper.SequenceNumber, message)
+		}
+		ipmiWrapper := IPMISessionWrapper{}
+		ipmiWrapper.AuthenticationType = AUTH_RMCP_PLUS
+		ipmiWrapper.SequenceNumber = wrapper.SequenceNumber
+		ipmiWrapper.SessionId = wrapper.SessionId
+		ipmiWrapper.MessageLen = uint8(len(payload))
+		ipmiWrapper.BMCIP = utils.GetLocalIP(server)
+		if badChecksum {
+			log.Printf("    RMCP+: Reject request, %s\n", checksumErr)
+			RejectIPMIRequest(NewIPMIContext(addr, server, ipmiWrapper, message), COMPLETION_CODE_INVALID_DATA_FIELD)
+			return
+		}
+		IPMIExecute(addr, server, ipmiWrapper, message)
+
+	case RMCP_PLUS_PAYLOAD_TYPE_SOL:
+		log.Println("    RMCP+: Payload Type = SOL")
+		session, ok := GetSession(utils.GetLocalIP(server), wrapper.SessionId)
+		if ! ok || ! session.IsRMCPPlus() || ! session.Activated {
+			log.Printf("    RMCP+: Session 0x%08x is not active, ignore.\n", wrapper.SessionId)
+			return
+		}
+		payload, ok = UnprotectRMCPPlusPayload(session, wrapper, packet, int(wrapperLength), payloadEnd)
+		if ! ok {
+			return
+		}
+		if ! AcceptInboundSequenceNumber(session.BMCIP, session.SessionID, wrapper.SequenceNumber) {
+			log.Printf("    RMCP+: Sequence number 0x%08x is out of the window of session 0x%08x, ignore.\n", wrapper.SequenceNumber, wrapper.SessionId)
+			return
+		}
+		HandleSOLPayload(addr, server, session, payload)
+
+	case RMCP_PLUS_PAYLOAD_TYPE_OPEN_SESSION_REQUEST:
+		log.Println("    RMCP+: Payload Type = Open Session Request")
+		HandleRMCPPlusOpenSession(addr, server, wrapper, payload)
+
+	case RMCP_PLUS_PAYLOAD_TYPE_RAKP_1:
+		log.Println("    RMCP+: Payload Type = RAKP Message 1")
+		HandleRMCPPlusRAKP1(addr, server, wrapper, payload)
+
+	case RMCP_PLUS_PAYLOAD_TYPE_RAKP_3:
+		log.Println("    RMCP+: Payload Type = RAKP Message 3")
+		HandleRMCPPlusRAKP3(addr, server, wrapper, payload)
+
+	default:
+		log.Printf("    RMCP+: Payload Type 0x%02x is not supported currently, ignore.\n", wrapper.Type())
+	}
+}
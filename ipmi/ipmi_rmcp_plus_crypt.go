@@ -0,0 +1,182 @@
+package ipmi
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"log"
+)
+
+const (
+	RMCP_PLUS_INTEGRITY_PAD =	0xff
+	RMCP_PLUS_NEXT_HEADER =		0x07
+)
+
+const (
+	AES_CBC_128_BLOCK_SIZE =	16
+	AES_CBC_128_KEY_SIZE =		16
+)
+
+var (
+	rmcpPlusConst1 = bytes.Repeat([]byte{0x01}, 20)
+	rmcpPlusConst2 = bytes.Repeat([]byte{0x02}, 20)
+)
+
+func integrityHashFunction(integrityAlgorithm uint8) func() hash.Hash {
+	switch integrityAlgorithm {
+	case INTEGRITY_ALG_HMAC_SHA1_96:
+		return sha1.New
+	case INTEGRITY_ALG_HMAC_MD5_128:
+		return md5.New
+	case INTEGRITY_ALG_HMAC_SHA256_128:
+		return sha256.New
+	}
+	return nil
+}
+
+func IsIntegrityAlgorithmSupported(integrityAlgorithm uint8) bool {
+	return integrityAlgorithm == INTEGRITY_ALG_NONE || integrityHashFunction(integrityAlgorithm) != nil
+}
+
+func IsConfidentialityAlgorithmSupported(confidentialityAlgorithm uint8) bool {
+	return confidentialityAlgorithm == CONFIDENTIALITY_ALG_NONE || confidentialityAlgorithm == CONFIDENTIALITY_ALG_AES_CBC_128
+}
+
+// IntegrityAuthCodeLength is the length of the AuthCode in the session trailer.
+func IntegrityAuthCodeLength(integrityAlgorithm uint8) int {
+	switch integrityAlgorithm {
+	case INTEGRITY_ALG_HMAC_SHA1_96:
+		return 12
+	case INTEGRITY_ALG_HMAC_MD5_128:
+		return 16
+	case INTEGRITY_ALG_HMAC_SHA256_128:
+		return 16
+	}
+	return 0
+}
+
+// GenerateSessionKeys derives K1 (integrity) and K2 (confidentiality) from SIK.
+// With RAKP-none there is no SIK, and the constants are used as they are.
+func GenerateSessionKeys(session *IPMISession) {
+	if session.AuthenticationAlgorithm == AUTH_ALG_RAKP_NONE {
+		session.K1 = rmcpPlusConst1
+		session.K2 = rmcpPlusConst2
+		return
+	}
+
+	session.K1 = RAKPHMAC(session.AuthenticationAlgorithm, session.SIK, rmcpPlusConst1)
+	session.K2 = RAKPHMAC(session.AuthenticationAlgorithm, session.SIK, rmcpPlusConst2)
+}
+
+func GenerateIntegrityAuthCode(session IPMISession, data []byte) []byte {
+	hashFunc := integrityHashFunction(session.IntegrityAlgorithm)
+	if hashFunc == nil {
+		return []byte{}
+	}
+
+	mac := hmac.New(hashFunc, session.K1)
+	mac.Write(data)
+	return mac.Sum(nil)[:IntegrityAuthCodeLength(session.IntegrityAlgorithm)]
+}
+
+// AddIntegrityTrailer appends integrity pad, pad length, next header and the
+// AuthCode to a packet which starts from the Authentication Type field.
+func AddIntegrityTrailer(session IPMISession, packet *bytes.Buffer) {
+	padLength := (4 - (packet.Len() + 2) % 4) % 4
+	for i := 0; i < padLength; i += 1 {
+		packet.WriteByte(RMCP_PLUS_INTEGRITY_PAD)
+	}
+	packet.WriteByte(uint8(padLength))
+	packet.WriteByte(RMCP_PLUS_NEXT_HEADER)
+	packet.Write(GenerateIntegrityAuthCode(session, packet.Bytes()))
+}
+
+// CheckIntegrityTrailer verifies the session trailer behind payloadEnd. packet
+// starts from the Authentication Type field.
+func CheckIntegrityTrailer(session IPMISession, packet []byte, payloadEnd int) bool {
+	padLength := 0
+	for payloadEnd + padLength < len(packet) && packet[payloadEnd + padLength] == RMCP_PLUS_INTEGRITY_PAD {
+		padLength += 1
+	}
+
+	nextHeaderPos := payloadEnd + padLength + 1
+	authCodeLength := IntegrityAuthCodeLength(session.IntegrityAlgorithm)
+	if nextHeaderPos + 1 + authCodeLength > len(packet) {
+		log.Println("    RMCP+: Session trailer is truncated.")
+		return false
+	}
+	if int(packet[payloadEnd + padLength]) != padLength || packet[nextHeaderPos] != RMCP_PLUS_NEXT_HEADER {
+		log.Println("    RMCP+: Session trailer is malformed.")
+		return false
+	}
+
+	authCode := packet[nextHeaderPos + 1:nextHeaderPos + 1 + authCodeLength]
+	return hmac.Equal(authCode, GenerateIntegrityAuthCode(session, packet[:nextHeaderPos + 1]))
+}
+
+// EncryptPayload returns IV | AES-CBC-128(payload | pad | pad length) keyed by
+// K2, with a random IV.
+func EncryptPayload(session IPMISession, payload []byte) []byte {
+	var iv [AES_CBC_128_BLOCK_SIZE]byte
+	rand.Read(iv[:])
+	return encryptPayloadWithIV(session, iv, payload)
+}
+
+func encryptPayloadWithIV(session IPMISession, iv [AES_CBC_128_BLOCK_SIZE]byte, payload []byte) []byte {
+	block, err := aes.NewCipher(session.K2[:AES_CBC_128_KEY_SIZE])
+	if err != nil {
+		log.Println("    RMCP+: Failed to create AES cipher: ", err)
+		return payload
+	}
+
+	plain := bytes.Buffer{}
+	plain.Write(payload)
+	padLength := (AES_CBC_128_BLOCK_SIZE - (len(payload) + 1) % AES_CBC_128_BLOCK_SIZE) % AES_CBC_128_BLOCK_SIZE
+	for i := 1; i <= padLength; i += 1 {
+		plain.WriteByte(uint8(i))
+	}
+	plain.WriteByte(uint8(padLength))
+
+	encrypted := make([]byte, AES_CBC_128_BLOCK_SIZE + plain.Len())
+	copy(encrypted, iv[:])
+	cipher.NewCBCEncrypter(block, iv[:]).CryptBlocks(encrypted[AES_CBC_128_BLOCK_SIZE:], plain.Bytes())
+
+	return encrypted
+}
+
+func DecryptPayload(session IPMISession, payload []byte) ([]byte, error) {
+	if session.ConfidentialityAlgorithm != CONFIDENTIALITY_ALG_AES_CBC_128 {
+		return nil, errors.New("confidentiality algorithm is not negotiated")
+	}
+	if len(payload) < 2 * AES_CBC_128_BLOCK_SIZE || len(payload) % AES_CBC_128_BLOCK_SIZE != 0 {
+		return nil, errors.New("encrypted payload has invalid length")
+	}
+
+	block, err := aes.NewCipher(session.K2[:AES_CBC_128_KEY_SIZE])
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]byte, len(payload) - AES_CBC_128_BLOCK_SIZE)
+	cipher.NewCBCDecrypter(block, payload[:AES_CBC_128_BLOCK_SIZE]).CryptBlocks(plain, payload[AES_CBC_128_BLOCK_SIZE:])
+
+	padLength := int(plain[len(plain) - 1])
+	if padLength >= AES_CBC_128_BLOCK_SIZE {
+		return nil, errors.New("confidentiality pad is invalid")
+	}
+	dataLength := len(plain) - 1 - padLength
+	for i := 0; i < padLength; i += 1 {
+		if plain[dataLength + i] != uint8(i + 1) {
+			return nil, errors.New("confidentiality pad is invalid")
+		}
+	}
+
+	return plain[:dataLength], nil
+}
@@ -0,0 +1,76 @@
+package ipmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// rakpTestSessionWithKeys returns the test session of the vector with SIK, K1
+// and K2 generated.
+func rakpTestSessionWithKeys(authAlgorithm uint8, integrityAlgorithm uint8) IPMISession {
+	session := newRAKPTestSession(authAlgorithm, integrityAlgorithm)
+	session.SIK = GenerateSessionIntegrityKey(session, []byte(session.User.Password))
+	GenerateSessionKeys(&session)
+	return session
+}
+
+func TestGenerateIntegrityAuthCode(t *testing.T) {
+	// The integrity of the test packet 0x00 ~ 0x1f, keyed by K1.
+	data := make([]byte, 32)
+	for i := range data {
+		data[i] = uint8(i)
+	}
+
+	for _, vector := range rakpTestVectors {
+		session := rakpTestSessionWithKeys(vector.authAlgorithm, vector.integrityAlgorithm)
+		checkHex(t, vector.name + " integrity", GenerateIntegrityAuthCode(session, data), vector.integrity)
+	}
+}
+
+func TestIntegrityTrailer(t *testing.T) {
+	session := rakpTestSessionWithKeys(AUTH_ALG_RAKP_HMAC_SHA1, INTEGRITY_ALG_HMAC_SHA1_96)
+	packet := bytes.NewBuffer([]byte{0x06, 0xc0, 0x78, 0x56, 0x34, 0x12, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00})
+	payloadEnd := packet.Len()
+	AddIntegrityTrailer(session, packet)
+
+	if (packet.Len() - IntegrityAuthCodeLength(session.IntegrityAlgorithm)) % 4 != 0 {
+		t.Errorf("integrity pad of packet %x is wrong", packet.Bytes())
+	}
+	if ! CheckIntegrityTrailer(session, packet.Bytes(), payloadEnd) {
+		t.Errorf("integrity trailer of packet %x is rejected", packet.Bytes())
+	}
+
+	tampered := append([]byte{}, packet.Bytes()...)
+	tampered[payloadEnd - 1] ^= 0x01
+	if CheckIntegrityTrailer(session, tampered, payloadEnd) {
+		t.Errorf("integrity trailer of tampered packet %x is accepted", tampered)
+	}
+}
+
+func TestAESCBC128(t *testing.T) {
+	session := rakpTestSessionWithKeys(AUTH_ALG_RAKP_HMAC_SHA1, INTEGRITY_ALG_HMAC_SHA1_96)
+	session.ConfidentialityAlgorithm = CONFIDENTIALITY_ALG_AES_CBC_128
+
+	// Get Device ID with the IV 0x30 ~ 0x3f, keyed by the first 16 bytes of
+	// K2. The payload is padded by 0x01 ~ 0x08 and the pad length 0x08.
+	var iv [AES_CBC_128_BLOCK_SIZE]byte
+	for i := range iv {
+		iv[i] = uint8(0x30 + i)
+	}
+	payload := []byte{0x20, 0x18, 0xc8, 0x81, 0x04, 0x01, 0x7a}
+	encrypted := "303132333435363738393a3b3c3d3e3f7f781c3e148acf8868c0e90c9433423a"
+
+	checkHex(t, "AES-CBC-128", encryptPayloadWithIV(session, iv, payload), encrypted)
+
+	data, _ := hex.DecodeString(encrypted)
+	decrypted, err := DecryptPayload(session, data)
+	if err != nil || ! bytes.Equal(decrypted, payload) {
+		t.Errorf("DecryptPayload(%s) = %x, %v, want %x", encrypted, decrypted, err, payload)
+	}
+
+	data[len(data) - 1] ^= 0x01
+	if _, err := DecryptPayload(session, data); err == nil {
+		t.Errorf("DecryptPayload accepts a broken confidentiality pad")
+	}
+}
\ No newline at end of file
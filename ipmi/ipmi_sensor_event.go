@@ -1,5 +1,14 @@
 package ipmi
 
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+)
+import (
+	"github.com/rmxymh/infra-ecosphere/bmc"
+)
+
 // port from OpenIPMI
 // Sensor/Event Network Function
 const (
@@ -32,3 +41,232 @@ const (
 	IPMI_CMD_SET_SENSOR_TYPE =			0x2e
 	IPMI_CMD_GET_SENSOR_TYPE =			0x2f
 )
+
+var ipmiSensorEventCommandNames = map[uint8]string {
+	IPMI_CMD_GET_SENSOR_READING_FACTORS:	"IPMI_CMD_GET_SENSOR_READING_FACTORS",
+	IPMI_CMD_SET_SENSOR_HYSTERESIS:		"IPMI_CMD_SET_SENSOR_HYSTERESIS",
+	IPMI_CMD_GET_SENSOR_HYSTERESIS:		"IPMI_CMD_GET_SENSOR_HYSTERESIS",
+	IPMI_CMD_SET_SENSOR_THRESHOLD:		"IPMI_CMD_SET_SENSOR_THRESHOLD",
+	IPMI_CMD_GET_SENSOR_THRESHOLD:		"IPMI_CMD_GET_SENSOR_THRESHOLD",
+	IPMI_CMD_GET_SENSOR_EVENT_STATUS:	"IPMI_CMD_GET_SENSOR_EVENT_STATUS",
+	IPMI_CMD_GET_SENSOR_READING:		"IPMI_CMD_GET_SENSOR_READING",
+}
+
+// Get Sensor Reading Response byte 2 and 4
+const (
+	SENSOR_READING_EVENT_MESSAGES_ENABLED =	0x80
+	SENSOR_READING_SCANNING_ENABLED =	0x40
+	SENSOR_READING_UNAVAILABLE =		0x20
+
+	SENSOR_READING_STATES_RESERVED =	0x80
+)
+
+type IPMIGetSensorReadingResponse struct {
+	Reading uint8
+	Flags uint8
+	Status uint8		// threshold comparison status, or states [7:0] of a discrete sensor
+	States uint8		// states [14:8] of a discrete sensor
+}
+
+type IPMIGetSensorEventStatusResponse struct {
+	Flags uint8
+	AssertionEvents uint16
+	DeassertionEvents uint16
+}
+
+type IPMIGetSensorReadingFactorsRequest struct {
+	SensorNumber uint8
+	Reading uint8
+}
+
+type IPMIGetSensorReadingFactorsResponse struct {
+	NextReading uint8
+	Factors [6]uint8
+}
+
+type IPMIGetSensorThresholdResponse struct {
+	ReadableMask uint8
+	Thresholds [bmc.THRESHOLD_COUNT]uint8
+}
+
+type IPMISetSensorThresholdRequest struct {
+	SensorNumber uint8
+	SetMask uint8
+	Thresholds [bmc.THRESHOLD_COUNT]uint8
+}
+
+type IPMIGetSensorHysteresisResponse struct {
+	PositiveHysteresis uint8
+	NegativeHysteresis uint8
+}
+
+type IPMISetSensorHysteresisRequest struct {
+	SensorNumber uint8
+	Reserved uint8
+	PositiveHysteresis uint8
+	NegativeHysteresis uint8
+}
+
+func init() {
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_GET_SENSOR_READING_FACTORS), "", HandleIPMIGetSensorReadingFactors)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_SET_SENSOR_HYSTERESIS), "", HandleIPMISetSensorHysteresis)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_GET_SENSOR_HYSTERESIS), "", HandleIPMIGetSensorHysteresis)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_SET_SENSOR_THRESHOLD), "", HandleIPMISetSensorThreshold)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_GET_SENSOR_THRESHOLD), "", HandleIPMIGetSensorThreshold)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_GET_SENSOR_EVENT_STATUS), "", HandleIPMIGetSensorEventStatus)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_SENSOR_EVENT, IPMI_CMD_GET_SENSOR_READING), "", HandleIPMIGetSensorReading)
+}
+
+// getSensor returns the BMC and the sensor of the sensor number in the first
+// byte of the request, or the completion code to answer with.
+func getSensor(ctx *IPMIContext, request IPMIRequest) (bmc.BMC, bmc.Sensor, uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return localBMC, bmc.Sensor{}, COMPLETION_CODE_NOT_SUPPORTED_IN_STATE
+	}
+
+	sensor, ok := localBMC.GetSensor(request.Data[0])
+	if ! ok {
+		log.Printf("      IPMI Sensor: Sensor 0x%02x is not present.\n", request.Data[0])
+		return localBMC, sensor, COMPLETION_CODE_NOT_PRESENT
+	}
+	return localBMC, sensor, COMPLETION_CODE_OK
+}
+
+func HandleIPMIGetSensorReading(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	_, sensor, code := getSensor(ctx, request)
+	if code != COMPLETION_CODE_OK {
+		return code, nil
+	}
+
+	response := IPMIGetSensorReadingResponse{}
+	response.Flags = SENSOR_READING_EVENT_MESSAGES_ENABLED | SENSOR_READING_SCANNING_ENABLED
+	response.States = SENSOR_READING_STATES_RESERVED
+	if sensor.IsThreshold() {
+		response.Reading = sensor.Reading
+		response.Status = sensor.ThresholdStatus()
+	} else {
+		response.Status = uint8(sensor.States)
+		response.States |= uint8(sensor.States >> 8) & 0x7F
+	}
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+// HandleIPMIGetSensorEventStatus reports the threshold events in effect, or
+// the asserted states of a discrete sensor, which are enabled by its record.
+func HandleIPMIGetSensorEventStatus(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	_, sensor, code := getSensor(ctx, request)
+	if code != COMPLETION_CODE_OK {
+		return code, nil
+	}
+
+	response := IPMIGetSensorEventStatusResponse{}
+	response.Flags = SENSOR_READING_EVENT_MESSAGES_ENABLED | SENSOR_READING_SCANNING_ENABLED
+	response.AssertionEvents, response.DeassertionEvents = sensor.EventStatus()
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+// HandleIPMIGetSensorReadingFactors only supports linear sensors of full
+// sensor records, whose factors are the same for every reading.
+func HandleIPMIGetSensorReadingFactors(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	_, sensor, code := getSensor(ctx, request)
+	if code != COMPLETION_CODE_OK {
+		return code, nil
+	}
+	if sensor.RecordType != bmc.SDR_RECORD_TYPE_FULL_SENSOR {
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+
+	buf := bytes.NewBuffer(request.Data)
+	factorsRequest := IPMIGetSensorReadingFactorsRequest{}
+	binary.Read(buf, binary.LittleEndian, &factorsRequest)
+
+	response := IPMIGetSensorReadingFactorsResponse{}
+	response.NextReading = factorsRequest.Reading
+	response.Factors = sensor.Factors
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+func HandleIPMIGetSensorThreshold(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	_, sensor, code := getSensor(ctx, request)
+	if code != COMPLETION_CODE_OK {
+		return code, nil
+	}
+	if ! sensor.IsThreshold() {
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+
+	response := IPMIGetSensorThresholdResponse{}
+	response.ReadableMask = sensor.ReadableThresholds
+	for i := 0; i < bmc.THRESHOLD_COUNT; i++ {
+		if sensor.ReadableThresholds & (1 << uint(i)) != 0 {
+			response.Thresholds[i] = sensor.Thresholds[i]
+		}
+	}
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+// HandleIPMISetSensorThreshold rejects the whole request if any threshold of
+// the mask is not settable.
+func HandleIPMISetSensorThreshold(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, _, code := getSensor(ctx, request)
+	if code != COMPLETION_CODE_OK {
+		return code, nil
+	}
+
+	buf := bytes.NewBuffer(request.Data)
+	setRequest := IPMISetSensorThresholdRequest{}
+	binary.Read(buf, binary.LittleEndian, &setRequest)
+
+	if ! localBMC.SetSensorThresholds(setRequest.SensorNumber, setRequest.SetMask, setRequest.Thresholds) {
+		log.Printf("      IPMI Sensor: Thresholds 0x%02x of sensor 0x%02x are not settable.\n", setRequest.SetMask, setRequest.SensorNumber)
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+	log.Printf("      IPMI Sensor: Set thresholds 0x%02x of sensor 0x%02x to %v\n", setRequest.SetMask, setRequest.SensorNumber, setRequest.Thresholds)
+
+	return COMPLETION_CODE_OK, nil
+}
+
+func HandleIPMIGetSensorHysteresis(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	_, sensor, code := getSensor(ctx, request)
+	if code != COMPLETION_CODE_OK {
+		return code, nil
+	}
+
+	response := IPMIGetSensorHysteresisResponse{}
+	response.PositiveHysteresis = sensor.PositiveHysteresis
+	response.NegativeHysteresis = sensor.NegativeHysteresis
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+func HandleIPMISetSensorHysteresis(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, _, code := getSensor(ctx, request)
+	if code != COMPLETION_CODE_OK {
+		return code, nil
+	}
+
+	buf := bytes.NewBuffer(request.Data)
+	setRequest := IPMISetSensorHysteresisRequest{}
+	binary.Read(buf, binary.LittleEndian, &setRequest)
+
+	localBMC.SetSensorHysteresis(setRequest.SensorNumber, setRequest.PositiveHysteresis, setRequest.NegativeHysteresis)
+	log.Printf("      IPMI Sensor: Set hysteresis of sensor 0x%02x to +%d/-%d\n", setRequest.SensorNumber, setRequest.PositiveHysteresis, setRequest.NegativeHysteresis)
+
+	return COMPLETION_CODE_OK, nil
+}
\ No newline at end of file
@@ -35,10 +35,18 @@ func IPMIServerHandler(BMCIP string) {
 
 	buf := make([]byte, 1024)
 	for running {
-		_, addr, _ := server.ReadFromUDP(buf)
+		n, addr, err := server.ReadFromUDP(buf)
+		if err != nil {
+			log.Println("Failed to receive a UDP packet: ", err)
+			continue
+		}
 		log.Println("Receive a UDP packet from ", addr.IP.String(), ":", addr.Port)
+		if obj, ok := bmc.GetBMC(net.ParseIP(BMCIP)); ok && ! obj.IsSourceAllowed(addr.IP) {
+			log.Println("  Source ", addr.IP.String(), " is not allowed by BMC ", BMCIP, ", ignore.")
+			continue
+		}
 
-		bytebuf := bytes.NewBuffer(buf)
+		bytebuf := bytes.NewBuffer(buf[:n])
 		DeserializeAndExecute(bytebuf, addr, server)
 	}
 }
@@ -55,12 +63,13 @@ func IPMIServerServiceRun() {
 	}()
 
 	running = true
-	for ip, _ := range bmc.BMCs {
+	go RunSessionReaper()
+	for _, obj := range bmc.GetBMCs() {
 		go func(ip string) {
 			log.Println("Start BMC Listener for BMC ", ip)
 			IPMIServerHandler(ip)
 			log.Println("BMC Listener ", ip, " is terminated.")
-		}(ip)
+		}(obj.Addr.String())
 	}
 
 	<- exitChan
@@ -3,29 +3,156 @@ package ipmi
 import (
 	"math/rand"
 	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
 )
 import (
 	"github.com/rmxymh/infra-ecosphere/bmc"
 )
 
+const (
+	DEFAULT_SESSION_TIMEOUT =	60 * time.Second
+	SESSION_REAPER_INTERVAL =	1 * time.Second
+
+	// Session handles are reported in 6-bit fields by Get Session Info.
+	MAX_SESSION_HANDLE =		0x3f
+)
+
 type IPMISession struct {
 	SessionID uint32
-	RemoteSessionSequenceNumber uint32
-	LocalSessionSequenceNumber uint32
+	RemoteSessionSequenceNumber uint32	// last sequence number sent to the remote console
+	LocalSessionSequenceNumber uint32	// highest sequence number received from the remote console
+	InboundSequenceWindow uint32		// bit n: LocalSessionSequenceNumber - n - 1 has been received
 	User bmc.BMCUser
+	AuthenticationType uint8		// IPMI v1.5: chosen in Get Session Challenge
+	Challenge [16]byte			// IPMI v1.5: sent by Get Session Challenge, returned by Activate Session
+	PrivilegeLevel uint8			// current operating privilege level
+	BMCIP string
+	Handle uint8				// 1 ~ MAX_SESSION_HANDLE, unique within the BMC
+	RemoteAddr *net.UDPAddr
+	IdleTimeout time.Duration
+	LastActivity time.Time
+	Activated bool
+
+	// RMCP+ (IPMI v2.0) session parameters
+	RemoteConsoleSessionID uint32
+	MaxPrivilegeLevel uint8
+	RequestedRole uint8
+	AuthenticationAlgorithm uint8
+	IntegrityAlgorithm uint8
+	ConfidentialityAlgorithm uint8
+	RemoteConsoleRandom [16]byte
+	ManagedSystemRandom [16]byte
+	SIK []byte
+	K1 []byte
+	K2 []byte
+}
+
+// Sessions belong to the BMC they are created on, so session IDs are only
+// unique within a BMC.
+type ipmiSessionKey struct {
+	BMCIP string
+	SessionID uint32
 }
 
-var ipmiSessions map[uint32]IPMISession
+var ipmiSessions map[ipmiSessionKey]IPMISession
+var ipmiSessionsLock sync.Mutex
 
 func init() {
 	log.Println("Initialize IPMI Session Map...")
-	ipmiSessions = make(map[uint32]IPMISession)
+	ipmiSessions = make(map[ipmiSessionKey]IPMISession)
+}
+
+func (session *IPMISession)key() ipmiSessionKey {
+	return ipmiSessionKey{session.BMCIP, session.SessionID}
 }
 
-func GetNewSession(user bmc.BMCUser) IPMISession {
+// countSessions counts the sessions of the BMC. The caller holds
+// ipmiSessionsLock.
+func countSessions(bmcIP string) int {
+	count := 0
+	for _, session := range ipmiSessions {
+		if session.BMCIP == bmcIP {
+			count += 1
+		}
+	}
+	return count
+}
+
+// countUserSessions counts the activated sessions of the user on the BMC. The
+// null user has an empty username. The caller holds ipmiSessionsLock.
+func countUserSessions(bmcIP string, username string) int {
+	count := 0
+	for _, session := range ipmiSessions {
+		if session.BMCIP == bmcIP && session.Activated && session.User.Username == username {
+			count += 1
+		}
+	}
+	return count
+}
+
+// HasUserSessionSlot reports whether the user can activate another session on
+// the BMC.
+func HasUserSessionSlot(bmcIP string, username string) bool {
+	obj, ok := bmc.GetBMC(net.ParseIP(bmcIP))
+	if ! ok || obj.MaxUserSessions == 0 {
+		return true
+	}
+
+	ipmiSessionsLock.Lock()
+	defer ipmiSessionsLock.Unlock()
+
+	return countUserSessions(bmcIP, username) < obj.MaxUserSessions
+}
+
+// nextSessionHandle returns the lowest session handle which is not used on
+// the BMC, or 0 when all of them are used. The caller holds ipmiSessionsLock.
+func nextSessionHandle(bmcIP string) uint8 {
+	used := make(map[uint8]bool)
+	for _, session := range ipmiSessions {
+		if session.BMCIP == bmcIP {
+			used[session.Handle] = true
+		}
+	}
+	for handle := uint8(1); handle <= MAX_SESSION_HANDLE; handle++ {
+		if ! used[handle] {
+			return handle
+		}
+	}
+	return 0
+}
+
+// GetNewSession allocates a session on the BMC for the remote console. It
+// fails when all session slots of the BMC are taken, by both pending and
+// activated sessions.
+func GetNewSession(bmcIP string, addr *net.UDPAddr, user bmc.BMCUser) (IPMISession, bool) {
+	timeout := DEFAULT_SESSION_TIMEOUT
+	maxSessions := 0
+	if obj, ok := bmc.GetBMC(net.ParseIP(bmcIP)); ok {
+		if obj.SessionTimeout > 0 {
+			timeout = obj.SessionTimeout
+		}
+		maxSessions = obj.MaxSessions
+	}
+
+	ipmiSessionsLock.Lock()
+	defer ipmiSessionsLock.Unlock()
+
+	if maxSessions > 0 && countSessions(bmcIP) >= maxSessions {
+		log.Printf("    Session: No session slot is available on BMC %s.\n", bmcIP)
+		return IPMISession{}, false
+	}
+	handle := nextSessionHandle(bmcIP)
+	if handle == 0 {
+		log.Printf("    Session: No session handle is available on BMC %s.\n", bmcIP)
+		return IPMISession{}, false
+	}
+
 	sessionId := rand.Uint32()
 	for {
-		if _, ok := ipmiSessions[sessionId]; ok {
+		if _, ok := ipmiSessions[ipmiSessionKey{bmcIP, sessionId}]; ok || sessionId == 0 {
 			sessionId = rand.Uint32()
 		} else {
 			break
@@ -35,30 +162,220 @@ func GetNewSession(user bmc.BMCUser) IPMISession {
 	session := IPMISession{}
 	session.SessionID = sessionId
 	session.User = user
+	session.BMCIP = bmcIP
+	session.Handle = handle
+	session.RemoteAddr = addr
+	session.IdleTimeout = timeout
+	session.LastActivity = time.Now()
 
-	ipmiSessions[sessionId] = session
-	return session
+	ipmiSessions[session.key()] = session
+	return session, true
 }
 
-func GetSession(id uint32) (IPMISession, bool) {
-	obj, ok := ipmiSessions[id]
+func GetSession(bmcIP string, id uint32) (IPMISession, bool) {
+	ipmiSessionsLock.Lock()
+	defer ipmiSessionsLock.Unlock()
+
+	obj, ok := ipmiSessions[ipmiSessionKey{bmcIP, id}]
 
 	return obj, ok
 }
 
-func RemoveSession(id uint32) {
-	_, ok := ipmiSessions[id]
+type sessionsByHandle []IPMISession
+
+func (s sessionsByHandle) Len() int { return len(s) }
+func (s sessionsByHandle) Less(i, j int) bool { return s[i].Handle < s[j].Handle }
+func (s sessionsByHandle) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// GetSessions returns the sessions of the BMC in the order of their handles.
+func GetSessions(bmcIP string) []IPMISession {
+	ipmiSessionsLock.Lock()
+	defer ipmiSessionsLock.Unlock()
+
+	sessions := []IPMISession{}
+	for _, session := range ipmiSessions {
+		if session.BMCIP == bmcIP {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Sort(sessionsByHandle(sessions))
+	return sessions
+}
+
+func RemoveSession(bmcIP string, id uint32) {
+	ipmiSessionsLock.Lock()
+	defer ipmiSessionsLock.Unlock()
+
+	key := ipmiSessionKey{bmcIP, id}
+	_, ok := ipmiSessions[key]
 	if ok {
-		delete(ipmiSessions, id)
+		delete(ipmiSessions, key)
+		removeCachedIPMIRequest(key)
+	}
+}
+
+// IsRMCPPlus reports whether the session was opened with RMCP+ (Open Session
+// Request) rather than the IPMI v1.5 Get Session Challenge.
+func (session *IPMISession)IsRMCPPlus() bool {
+	return session.RemoteConsoleSessionID != 0
+}
+
+// Sliding window of inbound session sequence numbers (IPMI v2.0 Section 6.12.13)
+const (
+	IPMI_SEQUENCE_WINDOW_ABOVE =		8
+	IPMI_SEQUENCE_WINDOW_BELOW =		8
+	RMCP_PLUS_SEQUENCE_WINDOW_ABOVE =	15
+	RMCP_PLUS_SEQUENCE_WINDOW_BELOW =	16
+)
+
+// nextSequenceNumber skips 0, which is only used outside of a session.
+func nextSequenceNumber(sequence uint32) uint32 {
+	sequence += 1
+	if sequence == 0 {
+		sequence = 1
 	}
+	return sequence
 }
 
+// sequenceDistance counts the sequence numbers from one to another, without 0.
+func sequenceDistance(from uint32, to uint32) uint32 {
+	distance := to - from
+	if to < from && distance > 0 {
+		distance -= 1
+	}
+	return distance
+}
+
+// Inc takes the next outbound sequence number for the response to a request.
 func (session *IPMISession)Inc() {
-	session.LocalSessionSequenceNumber += 1
-	session.RemoteSessionSequenceNumber += 1
-	session.Save()
+	ipmiSessionsLock.Lock()
+	defer ipmiSessionsLock.Unlock()
+
+	// Packets sent without a request, e.g. SOL, may have taken sequence numbers.
+	if stored, ok := ipmiSessions[session.key()]; ok {
+		session.RemoteSessionSequenceNumber = stored.RemoteSessionSequenceNumber
+	}
+	session.RemoteSessionSequenceNumber = nextSequenceNumber(session.RemoteSessionSequenceNumber)
+	session.LastActivity = time.Now()
+	ipmiSessions[session.key()] = *session
+}
+
+// NextOutboundSequenceNumber increases the outbound sequence number of the
+// stored session, for packets which are sent without a request, e.g. SOL.
+func NextOutboundSequenceNumber(bmcIP string, id uint32) (uint32, bool) {
+	ipmiSessionsLock.Lock()
+	defer ipmiSessionsLock.Unlock()
+
+	session, ok := ipmiSessions[ipmiSessionKey{bmcIP, id}]
+	if ! ok {
+		return 0, false
+	}
+	session.RemoteSessionSequenceNumber = nextSequenceNumber(session.RemoteSessionSequenceNumber)
+	ipmiSessions[session.key()] = session
+	return session.RemoteSessionSequenceNumber, true
+}
+
+// AcceptInboundSequenceNumber checks the sequence number of a packet received
+// in an active session against the sliding window, and records it. Replayed
+// packets and packets outside of the window are rejected.
+func AcceptInboundSequenceNumber(bmcIP string, id uint32, sequence uint32) bool {
+	ipmiSessionsLock.Lock()
+	defer ipmiSessionsLock.Unlock()
+
+	session, ok := ipmiSessions[ipmiSessionKey{bmcIP, id}]
+	if ! ok || sequence == 0 {
+		return false
+	}
+
+	above := uint32(IPMI_SEQUENCE_WINDOW_ABOVE)
+	below := uint32(IPMI_SEQUENCE_WINDOW_BELOW)
+	if session.IsRMCPPlus() {
+		above = RMCP_PLUS_SEQUENCE_WINDOW_ABOVE
+		below = RMCP_PLUS_SEQUENCE_WINDOW_BELOW
+	}
+
+	last := session.LocalSessionSequenceNumber
+	if ahead := sequenceDistance(last, sequence); ahead >= 1 && ahead <= above {
+		// Move the window: the last sequence number is now behind by "ahead".
+		if ahead < 32 {
+			session.InboundSequenceWindow = (session.InboundSequenceWindow << ahead) | (1 << (ahead - 1))
+		} else {
+			session.InboundSequenceWindow = 0
+		}
+		session.LocalSessionSequenceNumber = sequence
+		session.LastActivity = time.Now()
+		ipmiSessions[session.key()] = session
+		return true
+	}
+
+	if behind := sequenceDistance(sequence, last); behind >= 1 && behind <= below {
+		bit := uint32(1) << (behind - 1)
+		if session.InboundSequenceWindow & bit != 0 {
+			return false
+		}
+		session.InboundSequenceWindow |= bit
+		session.LastActivity = time.Now()
+		ipmiSessions[session.key()] = session
+		return true
+	}
+
+	return false
 }
 
 func (session *IPMISession)Save() {
-	ipmiSessions[session.SessionID] = *session
+	ipmiSessionsLock.Lock()
+	defer ipmiSessionsLock.Unlock()
+
+	session.LastActivity = time.Now()
+	ipmiSessions[session.key()] = *session
+}
+
+// RemoveIdleSessions removes the sessions which have been idle longer than
+// their timeout, including those which are never activated, and returns them.
+func RemoveIdleSessions(now time.Time) []IPMISession {
+	ipmiSessionsLock.Lock()
+	defer ipmiSessionsLock.Unlock()
+
+	removed := []IPMISession{}
+	for key, session := range ipmiSessions {
+		if now.Sub(session.LastActivity) > session.IdleTimeout {
+			delete(ipmiSessions, key)
+			removeCachedIPMIRequest(key)
+			removed = append(removed, session)
+		}
+	}
+	return removed
+}
+
+// RemoveUserSessions removes the sessions of the user on the BMC, including
+// those which are not activated yet, and returns them.
+func RemoveUserSessions(bmcIP string, userID uint8) []IPMISession {
+	ipmiSessionsLock.Lock()
+	defer ipmiSessionsLock.Unlock()
+
+	removed := []IPMISession{}
+	for key, session := range ipmiSessions {
+		if session.BMCIP == bmcIP && session.User.ID == userID {
+			delete(ipmiSessions, key)
+			removeCachedIPMIRequest(key)
+			removed = append(removed, session)
+		}
+	}
+	return removed
+}
+
+// RunSessionReaper closes idle sessions periodically until the server stops.
+// It also forgets expired login failures and session challenge counts.
+func RunSessionReaper() {
+	for running {
+		time.Sleep(SESSION_REAPER_INTERVAL)
+
+		now := time.Now()
+		for _, session := range RemoveIdleSessions(now) {
+			log.Printf("    Session: Session 0x%08x of BMC %s is idle for %s, close it.\n", session.SessionID, session.BMCIP, session.IdleTimeout.String())
+			DeactivateSOLBySession(session.BMCIP, session.SessionID)
+		}
+		RemoveExpiredLoginFailures(now)
+		RemoveExpiredChallengeRates(now)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,78 @@
+package ipmi
+
+import (
+	"testing"
+)
+
+func TestSequenceDistance(t *testing.T) {
+	tests := []struct {
+		from uint32
+		to   uint32
+		want uint32
+	}{
+		{1, 1, 0},
+		{1, 2, 1},
+		{100, 108, 8},
+		{0xFFFFFFFF, 1, 1}, // 0 is skipped
+		{0xFFFFFFFE, 1, 2},
+		{0xFFFFFFF8, 8, 15},
+		{2, 1, 0xFFFFFFFE}, // all the way round, without 0
+	}
+	for _, test := range tests {
+		if got := sequenceDistance(test.from, test.to); got != test.want {
+			t.Errorf("sequenceDistance(0x%08x, 0x%08x) = 0x%08x, want 0x%08x", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestAcceptInboundSequenceNumber(t *testing.T) {
+	type step struct {
+		sequence uint32
+		want     bool
+	}
+	tests := []struct {
+		name     string
+		rmcpPlus bool
+		last     uint32
+		steps    []step
+		wantLast uint32
+	}{
+		{"zero", false, 10, []step{{0, false}}, 10},
+		{"wrap", false, 0xFFFFFFFF, []step{{1, true}, {1, false}}, 1},
+		{"wrap by two", false, 0xFFFFFFFE, []step{{1, true}, {0xFFFFFFFF, true}}, 1},
+		{"behind wrap", false, 1, []step{{0xFFFFFFFF, true}, {0xFFFFFFFF, false}}, 1},
+		{"replay of last", false, 10, []step{{11, true}, {11, false}}, 11},
+		{"replay in window", false, 10, []step{{13, true}, {12, true}, {12, false}, {11, true}, {11, false}, {10, false}}, 13},
+		{"out of order", false, 10, []step{{12, true}, {11, true}, {14, true}, {13, true}, {12, false}, {13, false}}, 14},
+
+		// IPMI v1.5: 8 above, 8 below.
+		{"v1.5 above", false, 100, []step{{108, true}}, 108},
+		{"v1.5 beyond above", false, 100, []step{{109, false}}, 100},
+		{"v1.5 below", false, 100, []step{{92, true}}, 100},
+		{"v1.5 beyond below", false, 100, []step{{91, false}}, 100},
+
+		// RMCP+: 15 above, 16 below.
+		{"RMCP+ above", true, 100, []step{{115, true}}, 115},
+		{"RMCP+ beyond above", true, 100, []step{{116, false}}, 100},
+		{"RMCP+ below", true, 100, []step{{84, true}}, 100},
+		{"RMCP+ beyond below", true, 100, []step{{83, false}}, 100},
+	}
+	for i, test := range tests {
+		session := IPMISession{SessionID: uint32(0x5e000001 + i), BMCIP: "127.0.9.3"}
+		session.LocalSessionSequenceNumber = test.last
+		if test.rmcpPlus {
+			session.RemoteConsoleSessionID = 0xa0a0a0a0
+		}
+		session.Save()
+
+		for _, step := range test.steps {
+			if got := AcceptInboundSequenceNumber(session.BMCIP, session.SessionID, step.sequence); got != step.want {
+				t.Errorf("%s: sequence number 0x%08x accepted = %v, want %v", test.name, step.sequence, got, step.want)
+			}
+		}
+		if stored, _ := GetSession(session.BMCIP, session.SessionID); stored.LocalSessionSequenceNumber != test.wantLast {
+			t.Errorf("%s: last sequence number = 0x%08x, want 0x%08x", test.name, stored.LocalSessionSequenceNumber, test.wantLast)
+		}
+		RemoveSession(session.BMCIP, session.SessionID)
+	}
+}
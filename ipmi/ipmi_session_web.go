@@ -0,0 +1,67 @@
+package ipmi
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+import (
+	"github.com/rmxymh/infra-ecosphere/bmc"
+	"github.com/rmxymh/infra-ecosphere/web"
+)
+
+var authenticationTypeNames = map[uint8]string {
+	AUTH_NONE: "NONE",
+	AUTH_MD2: "MD2",
+	AUTH_MD5: "MD5",
+	AUTH_STRAIGHT_KEY: "PASSWORD",
+	AUTH_RMCP_PLUS: "RMCP+",
+}
+
+func init() {
+	web.SessionManager.GetSessions = webGetSessions
+	web.SessionManager.CloseSession = webCloseSession
+}
+
+func webGetSessions(bmcIP string) ([]web.WebRespSession, bool) {
+	if _, ok := bmc.GetBMC(net.ParseIP(bmcIP)); ! ok {
+		return nil, false
+	}
+
+	now := time.Now()
+	sessions := []web.WebRespSession{}
+	for _, session := range GetSessions(bmcIP) {
+		resp := web.WebRespSession{
+			SessionID: fmt.Sprintf("0x%08x", session.SessionID),
+			Handle: session.Handle,
+			Username: session.User.Username,
+			AuthenticationType: authenticationTypeNames[session.AuthenticationType],
+			Activated: session.Activated,
+			IdleSeconds: int(now.Sub(session.LastActivity).Seconds()),
+		}
+		if session.Activated {
+			resp.Privilege = bmc.PrivilegeLevelName(session.PrivilegeLevel)
+		}
+		if session.IsRMCPPlus() {
+			resp.AuthenticationType = authenticationTypeNames[AUTH_RMCP_PLUS]
+		}
+		if session.RemoteAddr != nil {
+			resp.RemoteAddress = session.RemoteAddr.String()
+		}
+		sessions = append(sessions, resp)
+	}
+	return sessions, true
+}
+
+func webCloseSession(bmcIP string, id uint32) bool {
+	if _, ok := GetSession(bmcIP, id); ! ok {
+		return false
+	}
+
+	log.Printf("    Session: Session 0x%08x of BMC %s is closed by Web API.\n", id, bmcIP)
+	DeactivateSOLBySession(bmcIP, id)
+	RemoveSession(bmcIP, id)
+	return true
+}
@@ -0,0 +1,423 @@
+package ipmi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/rmxymh/infra-ecosphere/utils"
+)
+
+// Activate / Deactivate Payload completion codes (IPMI v2.0 Section 24.1, 24.2)
+const (
+	COMPLETION_CODE_PAYLOAD_ALREADY_ACTIVE =	0x80
+	COMPLETION_CODE_PAYLOAD_TYPE_DISABLED =		0x81
+	COMPLETION_CODE_PAYLOAD_ACTIVATION_LIMIT =	0x82
+	COMPLETION_CODE_PAYLOAD_WITH_ENCRYPTION =	0x83
+	COMPLETION_CODE_PAYLOAD_WITHOUT_ENCRYPTION =	0x84
+	COMPLETION_CODE_PAYLOAD_ALREADY_DEACTIVATED =	0x80
+)
+
+const (
+	SOL_PAYLOAD_INSTANCE =		1
+	SOL_PAYLOAD_SIZE =		0x00ff		// including the 4 bytes SOL header
+	SOL_PAYLOAD_HEADER_SIZE =	4
+	SOL_PAYLOAD_VLAN_NONE =		0xffff
+	SOL_PAYLOAD_PORT =		623
+)
+
+const (
+	SOL_ACTIVATE_AUX_BITMASK_ENCRYPTION =		0x80
+	SOL_ACTIVATE_AUX_BITMASK_AUTHENTICATION =	0x40
+)
+
+// SOL packet (IPMI v2.0 Section 15.9)
+const (
+	SOL_OPERATION_NACK =			0x40
+	SOL_OPERATION_RING_WOR =		0x20
+	SOL_OPERATION_GENERATE_BREAK =		0x10
+	SOL_OPERATION_CTS_PAUSE =		0x08
+	SOL_OPERATION_DROP_DCD_DSR =		0x04
+	SOL_OPERATION_FLUSH_INBOUND =		0x02
+	SOL_OPERATION_FLUSH_OUTBOUND =		0x01
+
+	SOL_STATUS_NACK =			0x40
+	SOL_STATUS_TRANSFER_UNAVAILABLE =	0x20
+	SOL_STATUS_DEACTIVATED =		0x10
+	SOL_STATUS_TRANSMIT_OVERRUN =		0x08
+	SOL_STATUS_BREAK =			0x04
+
+	SOL_SEQUENCE_MAX =			0x0f
+)
+
+type IPMIActivatePayloadRequest struct {
+	PayloadType uint8
+	PayloadInstance uint8
+	AuxData [4]uint8
+}
+
+type IPMIActivatePayloadResponse struct {
+	AuxData [4]uint8
+	InboundPayloadSize uint16
+	OutboundPayloadSize uint16
+	PayloadUDPPort uint16
+	PayloadVLAN uint16
+}
+
+type IPMIDeactivatePayloadRequest struct {
+	PayloadType uint8
+	PayloadInstance uint8
+	AuxData [4]uint8
+}
+
+type IPMIGetPayloadActivationStatusResponse struct {
+	InstanceCapacity uint8
+	ActivationStatus uint16
+}
+
+// SOLSession relays bytes between the serial console of a VM and the remote
+// console which activates the SOL payload. Only one instance per BMC.
+type SOLSession struct {
+	BMCIP string
+	Session IPMISession
+	Config SOLConfiguration
+
+	addr *net.UDPAddr
+	server *net.UDPConn
+	console io.ReadWriteCloser
+
+	lock sync.Mutex
+	output bytes.Buffer		// console output which is not sent yet
+	pending []byte			// sent but not acknowledged yet
+	pendingSeq uint8
+	pendingSentAt time.Time
+	retries uint8
+	sendSeq uint8
+	lastRecvSeq uint8
+	stopped chan bool
+}
+
+var solSessions map[string]*SOLSession
+var solSessionsLock sync.Mutex
+
+func init() {
+	solSessions = make(map[string]*SOLSession)
+}
+
+func GetSOLSession(bmcIP string) (*SOLSession, bool) {
+	solSessionsLock.Lock()
+	defer solSessionsLock.Unlock()
+
+	sol, ok := solSessions[bmcIP]
+	return sol, ok
+}
+
+func ActivateSOL(bmcIP string, session IPMISession, addr *net.UDPAddr, server *net.UDPConn, console io.ReadWriteCloser) *SOLSession {
+	sol := &SOLSession{
+		BMCIP: bmcIP,
+		Session: session,
+		Config: GetSOLConfiguration(bmcIP),
+		addr: addr,
+		server: server,
+		console: console,
+		stopped: make(chan bool),
+	}
+
+	solSessionsLock.Lock()
+	solSessions[bmcIP] = sol
+	solSessionsLock.Unlock()
+
+	go sol.readConsole()
+	go sol.relay()
+	log.Printf("      SOL: Activated on BMC %s for session 0x%08x\n", bmcIP, session.SessionID)
+
+	return sol
+}
+
+func DeactivateSOL(bmcIP string) bool {
+	solSessionsLock.Lock()
+	sol, ok := solSessions[bmcIP]
+	if ok {
+		delete(solSessions, bmcIP)
+	}
+	solSessionsLock.Unlock()
+
+	if ok {
+		close(sol.stopped)
+		sol.console.Close()
+		log.Printf("      SOL: Deactivated on BMC %s for session 0x%08x\n", bmcIP, sol.Session.SessionID)
+	}
+	return ok
+}
+
+// DeactivateSOLBySession stops the SOL payload activated by a closing session.
+func DeactivateSOLBySession(bmcIP string, sessionID uint32) {
+	solSessionsLock.Lock()
+	sol, ok := solSessions[bmcIP]
+	solSessionsLock.Unlock()
+
+	if ok && sol.Session.SessionID == sessionID {
+		DeactivateSOL(bmcIP)
+	}
+}
+
+func (sol *SOLSession)readConsole() {
+	buf := make([]byte, 1024)
+	for {
+		n, err := sol.console.Read(buf)
+		if n > 0 {
+			sol.lock.Lock()
+			sol.output.Write(buf[:n])
+			sol.lock.Unlock()
+		}
+		if err != nil {
+			select {
+			case <- sol.stopped:
+			default:
+				log.Printf("      SOL: Console of BMC %s is closed: %s\n", sol.BMCIP, err)
+			}
+			return
+		}
+	}
+}
+
+func (sol *SOLSession)nextSendSequence() uint8 {
+	sol.sendSeq += 1
+	if sol.sendSeq > SOL_SEQUENCE_MAX {
+		sol.sendSeq = 1
+	}
+	return sol.sendSeq
+}
+
+func (sol *SOLSession)sendPacket(seq uint8, ackSeq uint8, acceptedCount uint8, status uint8, data []byte) {
+	payload := bytes.Buffer{}
+	payload.WriteByte(seq)
+	payload.WriteByte(ackSeq)
+	payload.WriteByte(acceptedCount)
+	payload.WriteByte(status)
+	payload.Write(data)
+
+	sequence, ok := NextOutboundSequenceNumber(sol.BMCIP, sol.Session.SessionID)
+	if ! ok {
+		log.Printf("      SOL: Session 0x%08x is gone, stop SOL on BMC %s\n", sol.Session.SessionID, sol.BMCIP)
+		go DeactivateSOL(sol.BMCIP)
+		return
+	}
+
+	wrapper := RMCPPlusSessionWrapper{}
+	wrapper.PayloadType = RMCP_PLUS_PAYLOAD_TYPE_SOL
+	wrapper.SessionId = sol.Session.RemoteConsoleSessionID
+	wrapper.SequenceNumber = sequence
+
+	obuf := bytes.Buffer{}
+	SerializeRMCP(&obuf, BuildUpRMCPForIPMI())
+	SerializeRMCPPlusPayload(&obuf, wrapper, &sol.Session, payload.Bytes())
+	sol.server.WriteToUDP(obuf.Bytes(), sol.addr)
+}
+
+// relay sends console output every character accumulate interval, and retries
+// unacknowledged packets as configured by SOL retry parameters.
+func (sol *SOLSession)relay() {
+	interval := time.Duration(sol.Config.CharAccumulateInterval) * 5 * time.Millisecond
+	if interval <= 0 {
+		interval = 5 * time.Millisecond
+	}
+	retryInterval := time.Duration(sol.Config.RetryInterval) * 10 * time.Millisecond
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <- sol.stopped:
+			return
+		case <- ticker.C:
+		}
+
+		sol.lock.Lock()
+		if sol.pending != nil && time.Since(sol.pendingSentAt) >= retryInterval {
+			if sol.retries < sol.Config.RetryCount {
+				sol.retries += 1
+				sol.pendingSentAt = time.Now()
+				sol.sendPacket(sol.pendingSeq, 0, 0, 0, sol.pending)
+			} else {
+				log.Printf("      SOL: Packet %d is not acknowledged after %d retries, drop it.\n", sol.pendingSeq, sol.retries)
+				sol.pending = nil
+			}
+		}
+		if sol.pending == nil && sol.output.Len() > 0 {
+			data := sol.output.Next(SOL_PAYLOAD_SIZE - SOL_PAYLOAD_HEADER_SIZE)
+			sol.pending = append([]byte{}, data...)
+			sol.pendingSeq = sol.nextSendSequence()
+			sol.pendingSentAt = time.Now()
+			sol.retries = 0
+			sol.sendPacket(sol.pendingSeq, 0, 0, 0, sol.pending)
+		}
+		sol.lock.Unlock()
+	}
+}
+
+// HandleSOLPayload processes an inbound SOL packet: it acknowledges console
+// output sent by the BMC, and writes the character data to the VM console.
+func HandleSOLPayload(addr *net.UDPAddr, server *net.UDPConn, session IPMISession, payload []uint8) {
+	sol, ok := GetSOLSession(utils.GetLocalIP(server))
+	if ! ok || sol.Session.SessionID != session.SessionID {
+		log.Printf("      SOL: Payload is not activated by session 0x%08x, ignore.\n", session.SessionID)
+		return
+	}
+	if len(payload) < SOL_PAYLOAD_HEADER_SIZE {
+		log.Println("      SOL: Packet is too short, ignore.")
+		return
+	}
+
+	seq := payload[0] & SOL_SEQUENCE_MAX
+	ackSeq := payload[1] & SOL_SEQUENCE_MAX
+	acceptedCount := int(payload[2])
+	operation := payload[3]
+	data := payload[SOL_PAYLOAD_HEADER_SIZE:]
+
+	sol.lock.Lock()
+	sol.addr = addr
+	if ackSeq != 0 && sol.pending != nil && ackSeq == sol.pendingSeq {
+		if operation & SOL_OPERATION_NACK != 0 {
+			// The remote console cannot accept data now, retry later.
+			sol.pendingSentAt = time.Now()
+		} else {
+			if acceptedCount < len(sol.pending) {
+				rest := append([]byte{}, sol.pending[acceptedCount:]...)
+				rest = append(rest, sol.output.Bytes()...)
+				sol.output.Reset()
+				sol.output.Write(rest)
+			}
+			sol.pending = nil
+		}
+	}
+
+	duplicated := seq == sol.lastRecvSeq
+	if seq != 0 {
+		// Duplicated packets are acknowledged again without writing data.
+		sol.lastRecvSeq = seq
+		sol.sendPacket(0, seq, uint8(len(data)), 0, nil)
+	}
+	sol.lock.Unlock()
+
+	// The console is written without the lock, since its output is read back
+	// by readConsole.
+	if seq != 0 && ! duplicated && len(data) > 0 {
+		if _, err := sol.console.Write(data); err != nil {
+			log.Printf("      SOL: Failed to write console of BMC %s: %s\n", sol.BMCIP, err)
+		}
+	}
+}
+
+func HandleIPMIActivatePayload(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	buf := bytes.NewBuffer(request.Data)
+	activateRequest := IPMIActivatePayloadRequest{}
+	binary.Read(buf, binary.LittleEndian, &activateRequest)
+
+	if ! ctx.HasSession {
+		log.Println("      SOL: Activate Payload needs a session.")
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	session := ctx.Session
+	config := GetSOLConfiguration(ctx.BMCIP)
+	payloadType := activateRequest.PayloadType & RMCP_PLUS_PAYLOAD_BITMASK_TYPE
+	encryption := activateRequest.AuxData[0] & SOL_ACTIVATE_AUX_BITMASK_ENCRYPTION != 0
+
+	if payloadType != RMCP_PLUS_PAYLOAD_TYPE_SOL || ! session.IsRMCPPlus() || ! config.Enabled {
+		log.Printf("      SOL: Payload type 0x%02x is disabled.\n", payloadType)
+		return COMPLETION_CODE_PAYLOAD_TYPE_DISABLED, nil
+	} else if activateRequest.PayloadInstance != SOL_PAYLOAD_INSTANCE {
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	} else if session.PrivilegeLevel < config.PrivilegeLevel {
+		return COMPLETION_CODE_INSUFFICIENT_PRIVILEGE, nil
+	} else if encryption && session.ConfidentialityAlgorithm == CONFIDENTIALITY_ALG_NONE {
+		return COMPLETION_CODE_PAYLOAD_WITH_ENCRYPTION, nil
+	} else if config.ForceEncryption && session.ConfidentialityAlgorithm == CONFIDENTIALITY_ALG_NONE {
+		return COMPLETION_CODE_PAYLOAD_WITHOUT_ENCRYPTION, nil
+	} else if _, active := GetSOLSession(ctx.BMCIP); active {
+		return COMPLETION_CODE_PAYLOAD_ALREADY_ACTIVE, nil
+	}
+
+	obj, found := ctx.GetBMC()
+	var console io.ReadWriteCloser
+	var err error
+	if found {
+		console, err = obj.OpenConsole()
+	}
+	if ! found || err != nil {
+		log.Printf("      SOL: Unable to open console of BMC %s: %v\n", ctx.BMCIP, err)
+		return COMPLETION_CODE_PAYLOAD_TYPE_DISABLED, nil
+	}
+	// SOL data may only follow the response, so the payload is started once
+	// the response has been sent.
+	ctx.AfterResponse = func() {
+		ActivateSOL(ctx.BMCIP, session, ctx.Addr, ctx.Server, console)
+	}
+
+	response := IPMIActivatePayloadResponse{}
+	response.InboundPayloadSize = SOL_PAYLOAD_SIZE
+	response.OutboundPayloadSize = SOL_PAYLOAD_SIZE
+	response.PayloadUDPPort = SOL_PAYLOAD_PORT
+	response.PayloadVLAN = SOL_PAYLOAD_VLAN_NONE
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+func HandleIPMIDeactivatePayload(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	buf := bytes.NewBuffer(request.Data)
+	deactivateRequest := IPMIDeactivatePayloadRequest{}
+	binary.Read(buf, binary.LittleEndian, &deactivateRequest)
+
+	if ! ctx.HasSession {
+		log.Println("      SOL: Deactivate Payload needs a session.")
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	payloadType := deactivateRequest.PayloadType & RMCP_PLUS_PAYLOAD_BITMASK_TYPE
+	if payloadType != RMCP_PLUS_PAYLOAD_TYPE_SOL {
+		return COMPLETION_CODE_PAYLOAD_TYPE_DISABLED, nil
+	} else if deactivateRequest.PayloadInstance != SOL_PAYLOAD_INSTANCE {
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+
+	sol, active := GetSOLSession(ctx.BMCIP)
+	if ! active {
+		return COMPLETION_CODE_PAYLOAD_ALREADY_DEACTIVATED, nil
+	}
+	// The session which activated the payload may deactivate it, any other
+	// session needs the administrator privilege.
+	if sol.Session.SessionID != ctx.Session.SessionID && ctx.Session.PrivilegeLevel < PRIVILEGE_ADMINISTRATOR {
+		log.Printf("      SOL: Payload of session 0x%08x can only be deactivated by an administrator.\n", sol.Session.SessionID)
+		return COMPLETION_CODE_INSUFFICIENT_PRIVILEGE, nil
+	}
+	if ! DeactivateSOL(ctx.BMCIP) {
+		return COMPLETION_CODE_PAYLOAD_ALREADY_DEACTIVATED, nil
+	}
+	return COMPLETION_CODE_OK, nil
+}
+
+func HandleIPMIGetPayloadActivationStatus(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	if len(request.Data) < 1 || request.Data[0] & RMCP_PLUS_PAYLOAD_BITMASK_TYPE != RMCP_PLUS_PAYLOAD_TYPE_SOL {
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+
+	response := IPMIGetPayloadActivationStatusResponse{}
+	response.InstanceCapacity = SOL_PAYLOAD_INSTANCE
+	if _, active := GetSOLSession(ctx.BMCIP); active {
+		response.ActivationStatus = 1 << (SOL_PAYLOAD_INSTANCE - 1)
+	}
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
\ No newline at end of file
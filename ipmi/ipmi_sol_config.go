@@ -0,0 +1,211 @@
+package ipmi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"sync"
+)
+
+// SOL Configuration Parameters (IPMI v2.0 Table 26-5)
+const (
+	SOL_PARAM_SET_IN_PROGRESS =		0x00
+	SOL_PARAM_ENABLE =			0x01
+	SOL_PARAM_AUTHENTICATION =		0x02
+	SOL_PARAM_CHAR_ACCUMULATE =		0x03
+	SOL_PARAM_RETRY =			0x04
+	SOL_PARAM_NON_VOLATILE_BIT_RATE =	0x05
+	SOL_PARAM_VOLATILE_BIT_RATE =		0x06
+	SOL_PARAM_PAYLOAD_CHANNEL =		0x07
+	SOL_PARAM_PAYLOAD_PORT =		0x08
+)
+
+const (
+	SOL_PARAM_REVISION =			0x11
+	SOL_PARAM_BITMASK_GET_REVISION_ONLY =	0x80
+)
+
+const (
+	SOL_AUTH_BITMASK_FORCE_ENCRYPTION =	0x80
+	SOL_AUTH_BITMASK_FORCE_AUTHENTICATION =	0x40
+	SOL_AUTH_BITMASK_PRIVILEGE_LEVEL =	0x0f
+)
+
+const (
+	SOL_BIT_RATE_SERIAL =	0x00
+	SOL_BIT_RATE_9600 =	0x06
+	SOL_BIT_RATE_19200 =	0x07
+	SOL_BIT_RATE_38400 =	0x08
+	SOL_BIT_RATE_57600 =	0x09
+	SOL_BIT_RATE_115200 =	0x0a
+)
+
+const (
+	COMPLETION_CODE_PARAMETER_NOT_SUPPORTED =	0x80
+	COMPLETION_CODE_PARAMETER_SET_IN_PROGRESS =	0x81
+	COMPLETION_CODE_PARAMETER_READ_ONLY =		0x82
+)
+
+type SOLConfiguration struct {
+	SetInProgress uint8
+	Enabled bool
+	ForceEncryption bool
+	ForceAuthentication bool
+	PrivilegeLevel uint8
+	CharAccumulateInterval uint8		// in 5 ms
+	CharSendThreshold uint8
+	RetryCount uint8
+	RetryInterval uint8			// in 10 ms
+	NonVolatileBitRate uint8
+	VolatileBitRate uint8
+}
+
+var solConfigurations map[string]SOLConfiguration
+var solConfigurationsLock sync.Mutex
+
+func init() {
+	solConfigurations = make(map[string]SOLConfiguration)
+}
+
+func defaultSOLConfiguration() SOLConfiguration {
+	return SOLConfiguration{
+		Enabled: true,
+		PrivilegeLevel: PRIVILEGE_USER,
+		CharAccumulateInterval: 12,
+		CharSendThreshold: 96,
+		RetryCount: 7,
+		RetryInterval: 50,
+		NonVolatileBitRate: SOL_BIT_RATE_115200,
+		VolatileBitRate: SOL_BIT_RATE_115200,
+	}
+}
+
+func GetSOLConfiguration(bmcIP string) SOLConfiguration {
+	solConfigurationsLock.Lock()
+	defer solConfigurationsLock.Unlock()
+
+	config, ok := solConfigurations[bmcIP]
+	if ! ok {
+		return defaultSOLConfiguration()
+	}
+	return config
+}
+
+func SetSOLConfiguration(bmcIP string, config SOLConfiguration) {
+	solConfigurationsLock.Lock()
+	defer solConfigurationsLock.Unlock()
+
+	solConfigurations[bmcIP] = config
+}
+
+type IPMIGetSOLConfigurationParametersRequest struct {
+	Channel uint8
+	ParameterSelector uint8
+	SetSelector uint8
+	BlockSelector uint8
+}
+
+func HandleIPMIGetSOLConfigurationParameters(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	buf := bytes.NewBuffer(request.Data)
+	parameterRequest := IPMIGetSOLConfigurationParametersRequest{}
+	binary.Read(buf, binary.LittleEndian, &parameterRequest.Channel)
+	binary.Read(buf, binary.LittleEndian, &parameterRequest.ParameterSelector)
+	binary.Read(buf, binary.LittleEndian, &parameterRequest.SetSelector)
+	binary.Read(buf, binary.LittleEndian, &parameterRequest.BlockSelector)
+
+	config := GetSOLConfiguration(ctx.BMCIP)
+	dataBuf := bytes.Buffer{}
+	dataBuf.WriteByte(SOL_PARAM_REVISION)
+
+	parameter := bytes.Buffer{}
+	switch parameterRequest.ParameterSelector {
+	case SOL_PARAM_SET_IN_PROGRESS:
+		parameter.WriteByte(config.SetInProgress)
+	case SOL_PARAM_ENABLE:
+		if config.Enabled {
+			parameter.WriteByte(0x01)
+		} else {
+			parameter.WriteByte(0x00)
+		}
+	case SOL_PARAM_AUTHENTICATION:
+		auth := config.PrivilegeLevel & SOL_AUTH_BITMASK_PRIVILEGE_LEVEL
+		if config.ForceEncryption {
+			auth |= SOL_AUTH_BITMASK_FORCE_ENCRYPTION
+		}
+		if config.ForceAuthentication {
+			auth |= SOL_AUTH_BITMASK_FORCE_AUTHENTICATION
+		}
+		parameter.WriteByte(auth)
+	case SOL_PARAM_CHAR_ACCUMULATE:
+		parameter.WriteByte(config.CharAccumulateInterval)
+		parameter.WriteByte(config.CharSendThreshold)
+	case SOL_PARAM_RETRY:
+		parameter.WriteByte(config.RetryCount)
+		parameter.WriteByte(config.RetryInterval)
+	case SOL_PARAM_NON_VOLATILE_BIT_RATE:
+		parameter.WriteByte(config.NonVolatileBitRate)
+	case SOL_PARAM_VOLATILE_BIT_RATE:
+		parameter.WriteByte(config.VolatileBitRate)
+	case SOL_PARAM_PAYLOAD_CHANNEL:
+		parameter.WriteByte(IPMI_CHANNEL_LAN)
+	case SOL_PARAM_PAYLOAD_PORT:
+		binary.Write(&parameter, binary.LittleEndian, uint16(SOL_PAYLOAD_PORT))
+	default:
+		log.Printf("      SOL: Parameter 0x%02x is not supported.\n", parameterRequest.ParameterSelector)
+		return COMPLETION_CODE_PARAMETER_NOT_SUPPORTED, nil
+	}
+
+	if parameterRequest.Channel & SOL_PARAM_BITMASK_GET_REVISION_ONLY == 0 {
+		dataBuf.Write(parameter.Bytes())
+	}
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+func HandleIPMISetSOLConfigurationParameters(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	config := GetSOLConfiguration(ctx.BMCIP)
+
+	if len(request.Data) < 3 {
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+	selector := request.Data[1]
+	data := request.Data[2:]
+
+	switch selector {
+	case SOL_PARAM_SET_IN_PROGRESS:
+		config.SetInProgress = data[0] & 0x03
+	case SOL_PARAM_ENABLE:
+		config.Enabled = data[0] & 0x01 != 0
+	case SOL_PARAM_AUTHENTICATION:
+		privilege := data[0] & SOL_AUTH_BITMASK_PRIVILEGE_LEVEL
+		if privilege < PRIVILEGE_USER || privilege > PRIVILEGE_OEM {
+			return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+		}
+		config.PrivilegeLevel = privilege
+		config.ForceEncryption = data[0] & SOL_AUTH_BITMASK_FORCE_ENCRYPTION != 0
+		config.ForceAuthentication = data[0] & SOL_AUTH_BITMASK_FORCE_AUTHENTICATION != 0
+	case SOL_PARAM_CHAR_ACCUMULATE:
+		if len(data) < 2 || data[0] == 0 {
+			return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+		}
+		config.CharAccumulateInterval = data[0]
+		config.CharSendThreshold = data[1]
+	case SOL_PARAM_RETRY:
+		if len(data) < 2 {
+			return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+		}
+		config.RetryCount = data[0] & 0x07
+		config.RetryInterval = data[1]
+	case SOL_PARAM_NON_VOLATILE_BIT_RATE:
+		config.NonVolatileBitRate = data[0] & 0x0f
+	case SOL_PARAM_VOLATILE_BIT_RATE:
+		config.VolatileBitRate = data[0] & 0x0f
+	case SOL_PARAM_PAYLOAD_CHANNEL, SOL_PARAM_PAYLOAD_PORT:
+		return COMPLETION_CODE_PARAMETER_READ_ONLY, nil
+	default:
+		log.Printf("      SOL: Parameter 0x%02x is not supported.\n", selector)
+		return COMPLETION_CODE_PARAMETER_NOT_SUPPORTED, nil
+	}
+
+	SetSOLConfiguration(ctx.BMCIP, config)
+	return COMPLETION_CODE_OK, nil
+}
\ No newline at end of file
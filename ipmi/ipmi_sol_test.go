@@ -0,0 +1,156 @@
+package ipmi
+
+import (
+	"bytes"
+	"testing"
+)
+
+const solTestBMCIP = "127.0.12.7"
+
+// solTestConsole stands for the serial console of a VM.
+type solTestConsole struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (console *solTestConsole) Close() error {
+	console.closed = true
+	return nil
+}
+
+// solTestSession is an RMCP+ session of the remote console.
+func solTestSession(sessionID uint32, privilege uint8) IPMISession {
+	return IPMISession{
+		BMCIP:                  solTestBMCIP,
+		SessionID:              sessionID,
+		RemoteConsoleSessionID: sessionID + 0x100,
+		Activated:              true,
+		PrivilegeLevel:         privilege,
+	}
+}
+
+// activateTestSOL makes the session the owner of the SOL payload, without
+// the relay of a real console.
+func activateTestSOL(t *testing.T, session IPMISession) *solTestConsole {
+	t.Helper()
+
+	console := &solTestConsole{}
+	sol := &SOLSession{
+		BMCIP:   solTestBMCIP,
+		Session: session,
+		Config:  GetSOLConfiguration(solTestBMCIP),
+		console: console,
+		stopped: make(chan bool),
+	}
+	solSessionsLock.Lock()
+	solSessions[solTestBMCIP] = sol
+	solSessionsLock.Unlock()
+	t.Cleanup(func() { DeactivateSOL(solTestBMCIP) })
+	return console
+}
+
+func solTestPayloadRequest(command uint8) IPMIRequest {
+	return IPMIRequest{
+		NetFunction: IPMI_NETFN_APP,
+		Command:     command,
+		Data:        []uint8{RMCP_PLUS_PAYLOAD_TYPE_SOL, SOL_PAYLOAD_INSTANCE, 0, 0, 0, 0},
+	}
+}
+
+func solTestActive(t *testing.T) bool {
+	t.Helper()
+
+	_, active := GetSOLSession(solTestBMCIP)
+	return active
+}
+
+func TestActivatePayloadRejected(t *testing.T) {
+	owner := solTestSession(0x101, PRIVILEGE_USER)
+
+	v15 := solTestSession(0x102, PRIVILEGE_ADMINISTRATOR)
+	v15.RemoteConsoleSessionID = 0
+	config := GetSOLConfiguration(solTestBMCIP)
+	config.PrivilegeLevel = PRIVILEGE_OPERATOR
+	SetSOLConfiguration(solTestBMCIP, config)
+	t.Cleanup(func() {
+		solConfigurationsLock.Lock()
+		delete(solConfigurations, solTestBMCIP)
+		solConfigurationsLock.Unlock()
+	})
+
+	tests := []struct {
+		name    string
+		session IPMISession
+		active  bool
+		want    uint8
+	}{
+		{"IPMI v1.5 session", v15, false, COMPLETION_CODE_PAYLOAD_TYPE_DISABLED},
+		{"below the SOL privilege level", owner, false, COMPLETION_CODE_INSUFFICIENT_PRIVILEGE},
+		{"already active for the session", solTestSession(0x101, PRIVILEGE_OPERATOR), true, COMPLETION_CODE_PAYLOAD_ALREADY_ACTIVE},
+		{"already active for another session", solTestSession(0x103, PRIVILEGE_ADMINISTRATOR), true, COMPLETION_CODE_PAYLOAD_ALREADY_ACTIVE},
+	}
+	for _, test := range tests {
+		if test.active && !solTestActive(t) {
+			activateTestSOL(t, owner)
+		}
+		ctx := &IPMIContext{BMCIP: solTestBMCIP, Session: test.session, HasSession: true}
+		if code, _ := HandleIPMIActivatePayload(ctx, solTestPayloadRequest(IPMI_CMD_ACTIVATE_PAYLOAD)); code != test.want {
+			t.Errorf("%s: Activate Payload completion code = 0x%02x, want 0x%02x", test.name, code, test.want)
+		}
+		if ctx.AfterResponse != nil {
+			t.Errorf("%s: the payload is started after a rejected activation", test.name)
+		}
+		if sol, active := GetSOLSession(solTestBMCIP); active != test.active || (active && sol.Session.SessionID != owner.SessionID) {
+			t.Errorf("%s: SOL payload is taken over", test.name)
+		}
+	}
+}
+
+func TestDeactivatePayloadOwner(t *testing.T) {
+	owner := solTestSession(0x111, PRIVILEGE_USER)
+
+	tests := []struct {
+		name    string
+		session IPMISession
+		want    uint8
+		active  bool
+	}{
+		{"another user", solTestSession(0x112, PRIVILEGE_USER), COMPLETION_CODE_INSUFFICIENT_PRIVILEGE, true},
+		{"another operator", solTestSession(0x113, PRIVILEGE_OPERATOR), COMPLETION_CODE_INSUFFICIENT_PRIVILEGE, true},
+		{"owner", owner, COMPLETION_CODE_OK, false},
+		{"administrator", solTestSession(0x114, PRIVILEGE_ADMINISTRATOR), COMPLETION_CODE_OK, false},
+	}
+	for _, test := range tests {
+		console := activateTestSOL(t, owner)
+		ctx := &IPMIContext{BMCIP: solTestBMCIP, Session: test.session, HasSession: true}
+		if code, _ := HandleIPMIDeactivatePayload(ctx, solTestPayloadRequest(IPMI_CMD_DEACTIVATE_PAYLOAD)); code != test.want {
+			t.Errorf("%s: Deactivate Payload completion code = 0x%02x, want 0x%02x", test.name, code, test.want)
+		}
+		if active := solTestActive(t); active != test.active {
+			t.Errorf("%s: SOL payload active = %v, want %v", test.name, active, test.active)
+		}
+		if console.closed == test.active {
+			t.Errorf("%s: console closed = %v, want %v", test.name, console.closed, !test.active)
+		}
+		DeactivateSOL(solTestBMCIP)
+	}
+
+	ctx := &IPMIContext{BMCIP: solTestBMCIP, Session: owner, HasSession: true}
+	if code, _ := HandleIPMIDeactivatePayload(ctx, solTestPayloadRequest(IPMI_CMD_DEACTIVATE_PAYLOAD)); code != COMPLETION_CODE_PAYLOAD_ALREADY_DEACTIVATED {
+		t.Errorf("Deactivate Payload of an inactive payload completion code = 0x%02x, want 0x%02x", code, COMPLETION_CODE_PAYLOAD_ALREADY_DEACTIVATED)
+	}
+}
+
+func TestDeactivateSOLBySession(t *testing.T) {
+	owner := solTestSession(0x121, PRIVILEGE_USER)
+	activateTestSOL(t, owner)
+
+	DeactivateSOLBySession(solTestBMCIP, 0x122)
+	if !solTestActive(t) {
+		t.Fatal("SOL payload is deactivated by closing another session")
+	}
+	DeactivateSOLBySession(solTestBMCIP, owner.SessionID)
+	if solTestActive(t) {
+		t.Error("SOL payload is still active after its session is closed")
+	}
+}
@@ -1,5 +1,14 @@
 package ipmi
 
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+)
+import (
+	"github.com/rmxymh/infra-ecosphere/bmc"
+)
+
 // port from OpenIPMI
 // Storage Network Function
 const (
@@ -34,3 +43,546 @@ const (
 	IPMI_CMD_GET_AUXILIARY_LOG_STATUS =		0x5a
 	IPMI_CMD_SET_AUXILIARY_LOG_STATUS =		0x5b
 )
+
+var ipmiStorageCommandNames = map[uint8]string {
+	IPMI_CMD_GET_FRU_INVENTORY_AREA_INFO:	"IPMI_CMD_GET_FRU_INVENTORY_AREA_INFO",
+	IPMI_CMD_READ_FRU_DATA:			"IPMI_CMD_READ_FRU_DATA",
+	IPMI_CMD_WRITE_FRU_DATA:		"IPMI_CMD_WRITE_FRU_DATA",
+	IPMI_CMD_GET_SDR_REPOSITORY_INFO:	"IPMI_CMD_GET_SDR_REPOSITORY_INFO",
+	IPMI_CMD_GET_SDR_REPOSITORY_ALLOC_INFO:	"IPMI_CMD_GET_SDR_REPOSITORY_ALLOC_INFO",
+	IPMI_CMD_RESERVE_SDR_REPOSITORY:	"IPMI_CMD_RESERVE_SDR_REPOSITORY",
+	IPMI_CMD_GET_SDR:			"IPMI_CMD_GET_SDR",
+	IPMI_CMD_ADD_SDR:			"IPMI_CMD_ADD_SDR",
+	IPMI_CMD_PARTIAL_ADD_SDR:		"IPMI_CMD_PARTIAL_ADD_SDR",
+	IPMI_CMD_DELETE_SDR:			"IPMI_CMD_DELETE_SDR",
+	IPMI_CMD_CLEAR_SDR_REPOSITORY:		"IPMI_CMD_CLEAR_SDR_REPOSITORY",
+	IPMI_CMD_GET_SDR_REPOSITORY_TIME:	"IPMI_CMD_GET_SDR_REPOSITORY_TIME",
+	IPMI_CMD_GET_SEL_INFO:			"IPMI_CMD_GET_SEL_INFO",
+	IPMI_CMD_GET_SEL_ALLOCATION_INFO:	"IPMI_CMD_GET_SEL_ALLOCATION_INFO",
+	IPMI_CMD_RESERVE_SEL:			"IPMI_CMD_RESERVE_SEL",
+	IPMI_CMD_GET_SEL_ENTRY:			"IPMI_CMD_GET_SEL_ENTRY",
+	IPMI_CMD_ADD_SEL_ENTRY:			"IPMI_CMD_ADD_SEL_ENTRY",
+	IPMI_CMD_PARTIAL_ADD_SEL_ENTRY:		"IPMI_CMD_PARTIAL_ADD_SEL_ENTRY",
+	IPMI_CMD_DELETE_SEL_ENTRY:		"IPMI_CMD_DELETE_SEL_ENTRY",
+	IPMI_CMD_CLEAR_SEL:			"IPMI_CMD_CLEAR_SEL",
+	IPMI_CMD_GET_SEL_TIME:			"IPMI_CMD_GET_SEL_TIME",
+	IPMI_CMD_SET_SEL_TIME:			"IPMI_CMD_SET_SEL_TIME",
+}
+
+const (
+	COMPLETION_CODE_SEL_RECORD_TYPE_NOT_SUPPORTED =	0x80	// Add SEL Entry
+)
+
+// Get FRU Inventory Area Info Response byte 4
+const (
+	FRU_ACCESS_BY_BYTES =		0x00
+)
+
+// SDR repository operation support (Get SDR Repository Info Response byte 14)
+const (
+	SDR_SUPPORT_NON_MODAL_UPDATE =	0x20
+	SDR_SUPPORT_RESERVE =		0x02
+)
+
+// Get SDR Request byte 6
+const (
+	SDR_READ_ENTIRE_RECORD =	0xFF
+)
+
+// SEL version and operation support (Get SEL Info Response byte 1 and 14)
+const (
+	SEL_VERSION_IPMI_V2 =		0x51
+
+	SEL_SUPPORT_OVERFLOW =		0x80
+	SEL_SUPPORT_DELETE =		0x08
+	SEL_SUPPORT_PARTIAL_ADD =	0x04
+	SEL_SUPPORT_RESERVE =		0x02
+	SEL_SUPPORT_ALLOCATION_INFO =	0x01
+)
+
+// Get SEL Entry Request byte 6
+const (
+	SEL_READ_ENTIRE_RECORD =	0xFF
+)
+
+// Clear SEL Request byte 6 and Response byte 1
+const (
+	SEL_CLEAR_GET_STATUS =		0x00
+	SEL_CLEAR_INITIATE =		0xAA
+
+	SEL_ERASURE_COMPLETED =		0x01
+)
+
+var selClearSignature = [3]uint8{'C', 'L', 'R'}
+
+type IPMIGetFRUInventoryAreaInfoResponse struct {
+	AreaSize uint16
+	Access uint8
+}
+
+type IPMIReadFRUDataRequest struct {
+	DeviceID uint8
+	Offset uint16
+	Count uint8
+}
+
+type IPMIWriteFRUDataRequest struct {
+	DeviceID uint8
+	Offset uint16
+}
+
+type IPMIGetSDRRepositoryInfoResponse struct {
+	SDRVersion uint8
+	RecordCount uint16
+	FreeSpace uint16		// in bytes
+	LastAddition uint32
+	LastErase uint32
+	OperationSupport uint8
+}
+
+type IPMIGetSDRRequest struct {
+	ReservationID uint16
+	RecordID uint16
+	Offset uint8
+	BytesToRead uint8
+}
+
+type IPMIGetSELInfoResponse struct {
+	SELVersion uint8
+	Entries uint16
+	FreeSpace uint16		// in bytes
+	LastAddition uint32
+	LastErase uint32
+	OperationSupport uint8
+}
+
+type IPMIGetSELAllocationInfoResponse struct {
+	PossibleUnits uint16
+	UnitSize uint16
+	FreeUnits uint16
+	LargestFreeBlock uint16
+	MaxRecordSize uint8		// in allocation units
+}
+
+type IPMIGetSELEntryRequest struct {
+	ReservationID uint16
+	RecordID uint16
+	Offset uint8
+	BytesToRead uint8
+}
+
+type IPMIDeleteSELEntryRequest struct {
+	ReservationID uint16
+	RecordID uint16
+}
+
+type IPMIClearSELRequest struct {
+	ReservationID uint16
+	Signature [3]uint8
+	Action uint8
+}
+
+func init() {
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_STORAGE, IPMI_CMD_GET_FRU_INVENTORY_AREA_INFO), "", HandleIPMIGetFRUInventoryAreaInfo)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_STORAGE, IPMI_CMD_READ_FRU_DATA), "", HandleIPMIReadFRUData)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_STORAGE, IPMI_CMD_WRITE_FRU_DATA), "", HandleIPMIWriteFRUData)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_STORAGE, IPMI_CMD_GET_SDR_REPOSITORY_INFO), "", HandleIPMIGetSDRRepositoryInfo)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_STORAGE, IPMI_CMD_RESERVE_SDR_REPOSITORY), "", HandleIPMIReserveSDRRepository)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_STORAGE, IPMI_CMD_GET_SDR), "", HandleIPMIGetSDR)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_STORAGE, IPMI_CMD_GET_SEL_INFO), "", HandleIPMIGetSELInfo)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_STORAGE, IPMI_CMD_GET_SEL_ALLOCATION_INFO), "", HandleIPMIGetSELAllocationInfo)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_STORAGE, IPMI_CMD_RESERVE_SEL), "", HandleIPMIReserveSEL)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_STORAGE, IPMI_CMD_GET_SEL_ENTRY), "", HandleIPMIGetSELEntry)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_STORAGE, IPMI_CMD_ADD_SEL_ENTRY), "", HandleIPMIAddSELEntry)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_STORAGE, IPMI_CMD_DELETE_SEL_ENTRY), "", HandleIPMIDeleteSELEntry)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_STORAGE, IPMI_CMD_CLEAR_SEL), "", HandleIPMIClearSEL)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_STORAGE, IPMI_CMD_GET_SEL_TIME), "", HandleIPMIGetSELTime)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_STORAGE, IPMI_CMD_SET_SEL_TIME), "", HandleIPMISetSELTime)
+}
+
+// HandleIPMIGetFRUInventoryAreaInfo answers FRU device ID 0, the FRU device
+// of the BMC.
+func HandleIPMIGetFRUInventoryAreaInfo(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	size, ok := localBMC.GetFRUSize()
+	if request.Data[0] != bmc.FRU_DEVICE_ID_BMC || ! ok {
+		log.Printf("      IPMI Storage: FRU device %d is not present.\n", request.Data[0])
+		return COMPLETION_CODE_NOT_PRESENT, nil
+	}
+
+	response := IPMIGetFRUInventoryAreaInfoResponse{}
+	response.AreaSize = uint16(size)
+	response.Access = FRU_ACCESS_BY_BYTES
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+// HandleIPMIReadFRUData returns fewer bytes than requested at the end of the
+// FRU image.
+func HandleIPMIReadFRUData(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	buf := bytes.NewBuffer(request.Data)
+	readRequest := IPMIReadFRUDataRequest{}
+	binary.Read(buf, binary.LittleEndian, &readRequest)
+
+	size, ok := localBMC.GetFRUSize()
+	if readRequest.DeviceID != bmc.FRU_DEVICE_ID_BMC || ! ok {
+		log.Printf("      IPMI Storage: FRU device %d is not present.\n", readRequest.DeviceID)
+		return COMPLETION_CODE_NOT_PRESENT, nil
+	}
+	data, ok := localBMC.ReadFRUData(int(readRequest.Offset), int(readRequest.Count))
+	if ! ok {
+		log.Printf("      IPMI Storage: FRU offset 0x%04x is out of the FRU area of %d bytes.\n", readRequest.Offset, size)
+		return COMPLETION_CODE_PARAMETER_OUT_OF_RANGE, nil
+	}
+
+	dataBuf := bytes.Buffer{}
+	dataBuf.WriteByte(uint8(len(data)))
+	dataBuf.Write(data)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+// HandleIPMIWriteFRUData writes within the FRU image only, which is saved so
+// that it survives a restart.
+func HandleIPMIWriteFRUData(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	buf := bytes.NewBuffer(request.Data)
+	writeRequest := IPMIWriteFRUDataRequest{}
+	binary.Read(buf, binary.LittleEndian, &writeRequest)
+	data := buf.Bytes()
+
+	size, ok := localBMC.GetFRUSize()
+	if writeRequest.DeviceID != bmc.FRU_DEVICE_ID_BMC || ! ok {
+		log.Printf("      IPMI Storage: FRU device %d is not present.\n", writeRequest.DeviceID)
+		return COMPLETION_CODE_NOT_PRESENT, nil
+	}
+	if ! localBMC.WriteFRUData(int(writeRequest.Offset), data) {
+		log.Printf("      IPMI Storage: FRU write of %d bytes at 0x%04x is out of the FRU area of %d bytes.\n", len(data), writeRequest.Offset, size)
+		return COMPLETION_CODE_PARAMETER_OUT_OF_RANGE, nil
+	}
+	log.Printf("      IPMI Storage: Write %d bytes of FRU at 0x%04x\n", len(data), writeRequest.Offset)
+
+	return COMPLETION_CODE_OK, []uint8{uint8(len(data))}
+}
+
+// HandleIPMIGetSDRRepositoryInfo reports a read-only repository without free
+// space, whose records are the default records or a configured SDR file.
+func HandleIPMIGetSDRRepositoryInfo(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+	repository := localBMC.GetSDRRepository()
+
+	response := IPMIGetSDRRepositoryInfoResponse{}
+	response.SDRVersion = bmc.SDR_VERSION_IPMI_V2
+	response.RecordCount = uint16(len(repository.Records))
+	response.FreeSpace = 0
+	response.LastAddition = repository.LastAddition
+	response.LastErase = repository.LastErase
+	response.OperationSupport = SDR_SUPPORT_NON_MODAL_UPDATE | SDR_SUPPORT_RESERVE
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+func HandleIPMIReserveSDRRepository(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	reservationID := localBMC.ReserveSDRRepository()
+	log.Printf("      IPMI Storage: SDR reservation ID = 0x%04x\n", reservationID)
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, reservationID)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+// HandleIPMIGetSDR reads a record, or a part of it. Like Get SEL Entry, a
+// reservation is only needed to read a part of a record. Reading past the end
+// of the record returns the rest of it.
+func HandleIPMIGetSDR(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	buf := bytes.NewBuffer(request.Data)
+	getRequest := IPMIGetSDRRequest{}
+	binary.Read(buf, binary.LittleEndian, &getRequest)
+
+	if (getRequest.ReservationID != 0 || getRequest.Offset != 0) && ! localBMC.IsSDRReservationValid(getRequest.ReservationID) {
+		log.Printf("      IPMI Storage: SDR reservation ID 0x%04x is cancelled.\n", getRequest.ReservationID)
+		return COMPLETION_CODE_RESERVATION_CANCELLED, nil
+	}
+
+	record, next, ok := localBMC.GetSDRRecord(getRequest.RecordID)
+	if ! ok {
+		log.Printf("      IPMI Storage: SDR 0x%04x is not present.\n", getRequest.RecordID)
+		return COMPLETION_CODE_NOT_PRESENT, nil
+	}
+	if int(getRequest.Offset) >= len(record) {
+		return COMPLETION_CODE_PARAMETER_OUT_OF_RANGE, nil
+	}
+
+	data := record[getRequest.Offset:]
+	if getRequest.BytesToRead != SDR_READ_ENTIRE_RECORD && int(getRequest.BytesToRead) < len(data) {
+		data = data[:getRequest.BytesToRead]
+	}
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, next)
+	dataBuf.Write(data)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+// selFreeEntries is 0 when the SEL keeps more entries than the BMC can hold,
+// e.g. after its SELCapacity is reduced.
+func selFreeEntries(obj bmc.BMC, sel bmc.SEL) int {
+	free := obj.MaxSELEntries() - len(sel.Entries)
+	if free < 0 {
+		return 0
+	}
+	return free
+}
+
+func HandleIPMIGetSELInfo(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+	sel := localBMC.GetSEL()
+	free := selFreeEntries(localBMC, sel)
+
+	response := IPMIGetSELInfoResponse{}
+	response.SELVersion = SEL_VERSION_IPMI_V2
+	response.Entries = uint16(len(sel.Entries))
+	response.FreeSpace = uint16(free * bmc.SEL_ENTRY_SIZE)
+	response.LastAddition = sel.LastAddition
+	response.LastErase = sel.LastErase
+	response.OperationSupport = SEL_SUPPORT_DELETE | SEL_SUPPORT_RESERVE | SEL_SUPPORT_ALLOCATION_INFO
+	if sel.Overflow {
+		response.OperationSupport |= SEL_SUPPORT_OVERFLOW
+	}
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+// HandleIPMIGetSELAllocationInfo reports one allocation unit per entry.
+func HandleIPMIGetSELAllocationInfo(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+	sel := localBMC.GetSEL()
+
+	response := IPMIGetSELAllocationInfoResponse{}
+	response.PossibleUnits = uint16(localBMC.MaxSELEntries())
+	response.UnitSize = bmc.SEL_ENTRY_SIZE
+	response.FreeUnits = uint16(selFreeEntries(localBMC, sel))
+	response.LargestFreeBlock = response.FreeUnits
+	response.MaxRecordSize = 1
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+func HandleIPMIReserveSEL(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	reservationID := localBMC.ReserveSEL()
+	log.Printf("      IPMI Storage: SEL reservation ID = 0x%04x\n", reservationID)
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, reservationID)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+// HandleIPMIGetSELEntry reads an entry, or a part of it. A reservation is only
+// needed to read a part of an entry.
+func HandleIPMIGetSELEntry(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	buf := bytes.NewBuffer(request.Data)
+	getRequest := IPMIGetSELEntryRequest{}
+	binary.Read(buf, binary.LittleEndian, &getRequest)
+
+	if (getRequest.ReservationID != 0 || getRequest.Offset != 0) && ! localBMC.IsSELReservationValid(getRequest.ReservationID) {
+		log.Printf("      IPMI Storage: SEL reservation ID 0x%04x is cancelled.\n", getRequest.ReservationID)
+		return COMPLETION_CODE_RESERVATION_CANCELLED, nil
+	}
+	if getRequest.Offset >= bmc.SEL_ENTRY_SIZE {
+		return COMPLETION_CODE_PARAMETER_OUT_OF_RANGE, nil
+	}
+
+	entry, next, ok := localBMC.GetSELEntry(getRequest.RecordID)
+	if ! ok {
+		log.Printf("      IPMI Storage: SEL entry 0x%04x is not present.\n", getRequest.RecordID)
+		return COMPLETION_CODE_NOT_PRESENT, nil
+	}
+
+	recordBuf := bytes.Buffer{}
+	binary.Write(&recordBuf, binary.LittleEndian, entry)
+	record := recordBuf.Bytes()[getRequest.Offset:]
+	if getRequest.BytesToRead != SEL_READ_ENTIRE_RECORD && int(getRequest.BytesToRead) < len(record) {
+		record = record[:getRequest.BytesToRead]
+	}
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, next)
+	dataBuf.Write(record)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+// HandleIPMIAddSELEntry adds a system event record or an OEM record. The
+// record ID in the request is ignored, and the timestamp of a timestamped
+// record is set by the BMC.
+func HandleIPMIAddSELEntry(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	buf := bytes.NewBuffer(request.Data)
+	entry := bmc.SELEntry{}
+	binary.Read(buf, binary.LittleEndian, &entry)
+
+	if entry.RecordType != bmc.SEL_RECORD_TYPE_SYSTEM_EVENT && entry.RecordType < bmc.SEL_RECORD_TYPE_OEM_TIMESTAMPED_FIRST {
+		log.Printf("      IPMI Storage: SEL record type 0x%02x is not supported.\n", entry.RecordType)
+		return COMPLETION_CODE_SEL_RECORD_TYPE_NOT_SUPPORTED, nil
+	}
+
+	entry, ok = localBMC.AddSELEntry(entry)
+	if ! ok {
+		return COMPLETION_CODE_OUT_OF_SPACE, nil
+	}
+	log.Printf("      IPMI Storage: Add SEL entry 0x%04x, record type 0x%02x\n", entry.RecordID, entry.RecordType)
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, entry.RecordID)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+func HandleIPMIDeleteSELEntry(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	buf := bytes.NewBuffer(request.Data)
+	deleteRequest := IPMIDeleteSELEntryRequest{}
+	binary.Read(buf, binary.LittleEndian, &deleteRequest)
+
+	if ! localBMC.IsSELReservationValid(deleteRequest.ReservationID) {
+		log.Printf("      IPMI Storage: SEL reservation ID 0x%04x is cancelled.\n", deleteRequest.ReservationID)
+		return COMPLETION_CODE_RESERVATION_CANCELLED, nil
+	}
+
+	recordID, ok := localBMC.DeleteSELEntry(deleteRequest.RecordID)
+	if ! ok {
+		log.Printf("      IPMI Storage: SEL entry 0x%04x is not present.\n", deleteRequest.RecordID)
+		return COMPLETION_CODE_NOT_PRESENT, nil
+	}
+	log.Printf("      IPMI Storage: Delete SEL entry 0x%04x\n", recordID)
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, recordID)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+// HandleIPMIClearSEL erases the SEL at once, so the erasure is always reported
+// as completed. The erasure cancels the reservation, so asking for its status
+// afterwards needs a new reservation.
+func HandleIPMIClearSEL(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	buf := bytes.NewBuffer(request.Data)
+	clearRequest := IPMIClearSELRequest{}
+	binary.Read(buf, binary.LittleEndian, &clearRequest)
+
+	if ! localBMC.IsSELReservationValid(clearRequest.ReservationID) {
+		log.Printf("      IPMI Storage: SEL reservation ID 0x%04x is cancelled.\n", clearRequest.ReservationID)
+		return COMPLETION_CODE_RESERVATION_CANCELLED, nil
+	}
+	if clearRequest.Signature != selClearSignature {
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+
+	switch clearRequest.Action {
+	case SEL_CLEAR_INITIATE:
+		localBMC.ClearSEL()
+	case SEL_CLEAR_GET_STATUS:
+	default:
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+
+	return COMPLETION_CODE_OK, []uint8{SEL_ERASURE_COMPLETED}
+}
+
+func HandleIPMIGetSELTime(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, localBMC.GetSELTime())
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+func HandleIPMISetSELTime(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	timestamp := binary.LittleEndian.Uint32(request.Data)
+	localBMC.SetSELTime(timestamp)
+	log.Printf("      IPMI Storage: Set SEL time to %d\n", timestamp)
+
+	return COMPLETION_CODE_OK, nil
+}
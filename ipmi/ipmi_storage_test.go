@@ -0,0 +1,118 @@
+package ipmi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/rmxymh/infra-ecosphere/bmc"
+	"github.com/rmxymh/infra-ecosphere/vm"
+)
+
+// newStorageTestBMC registers a BMC whose SEL is kept in memory only.
+func newStorageTestBMC(t *testing.T, ip string) bmc.BMC {
+	t.Helper()
+
+	savedSELFile := bmc.SELFile
+	bmc.SELFile = ""
+	obj := bmc.AddBMC(net.ParseIP(ip), vm.Instance{})
+	t.Cleanup(func() {
+		obj.ClearSEL()
+		bmc.RemoveBMC(obj.Addr)
+		bmc.SELFile = savedSELFile
+	})
+	return obj
+}
+
+func storageTestRequest(t *testing.T, request interface{}) IPMIRequest {
+	t.Helper()
+
+	buf := bytes.Buffer{}
+	if err := binary.Write(&buf, binary.LittleEndian, request); err != nil {
+		t.Fatalf("binary.Write: %v", err)
+	}
+	return IPMIRequest{NetFunction: IPMI_NETFN_STORAGE, Data: buf.Bytes()}
+}
+
+func TestSELEntryReservationCancelled(t *testing.T) {
+	obj := newStorageTestBMC(t, "127.0.10.5")
+	ctx := &IPMIContext{BMCIP: obj.Addr.String()}
+	entry := bmc.SELEntry{RecordType: bmc.SEL_RECORD_TYPE_SYSTEM_EVENT}
+	first, _ := obj.AddSELEntry(entry)
+
+	// Adding an entry cancels the reservation.
+	cancelled := obj.ReserveSEL()
+	obj.AddSELEntry(entry)
+
+	getRequest := storageTestRequest(t, IPMIGetSELEntryRequest{ReservationID: cancelled, RecordID: first.RecordID, Offset: 2, BytesToRead: 4})
+	if code, _ := HandleIPMIGetSELEntry(ctx, getRequest); code != COMPLETION_CODE_RESERVATION_CANCELLED {
+		t.Errorf("Get SEL Entry completion code = 0x%02x, want 0x%02x", code, COMPLETION_CODE_RESERVATION_CANCELLED)
+	}
+	deleteRequest := storageTestRequest(t, IPMIDeleteSELEntryRequest{ReservationID: cancelled, RecordID: first.RecordID})
+	if code, _ := HandleIPMIDeleteSELEntry(ctx, deleteRequest); code != COMPLETION_CODE_RESERVATION_CANCELLED {
+		t.Errorf("Delete SEL Entry completion code = 0x%02x, want 0x%02x", code, COMPLETION_CODE_RESERVATION_CANCELLED)
+	}
+
+	reservation := obj.ReserveSEL()
+	getRequest = storageTestRequest(t, IPMIGetSELEntryRequest{ReservationID: reservation, RecordID: first.RecordID, Offset: 2, BytesToRead: 4})
+	if code, _ := HandleIPMIGetSELEntry(ctx, getRequest); code != COMPLETION_CODE_OK {
+		t.Errorf("Get SEL Entry completion code = 0x%02x, want 0x%02x", code, COMPLETION_CODE_OK)
+	}
+	deleteRequest = storageTestRequest(t, IPMIDeleteSELEntryRequest{ReservationID: reservation, RecordID: first.RecordID})
+	if code, _ := HandleIPMIDeleteSELEntry(ctx, deleteRequest); code != COMPLETION_CODE_OK {
+		t.Errorf("Delete SEL Entry completion code = 0x%02x, want 0x%02x", code, COMPLETION_CODE_OK)
+	}
+
+	// Deleting the entry has cancelled the reservation in turn.
+	if code, _ := HandleIPMIDeleteSELEntry(ctx, deleteRequest); code != COMPLETION_CODE_RESERVATION_CANCELLED {
+		t.Errorf("second Delete SEL Entry completion code = 0x%02x, want 0x%02x", code, COMPLETION_CODE_RESERVATION_CANCELLED)
+	}
+}
+
+// readTestFRU reads the whole FRU image of the BMC by Read FRU Data.
+func readTestFRU(t *testing.T, ctx *IPMIContext) []uint8 {
+	t.Helper()
+
+	image := []uint8{}
+	for {
+		request := storageTestRequest(t, IPMIReadFRUDataRequest{DeviceID: bmc.FRU_DEVICE_ID_BMC, Offset: uint16(len(image)), Count: 32})
+		code, data := HandleIPMIReadFRUData(ctx, request)
+		if code == COMPLETION_CODE_PARAMETER_OUT_OF_RANGE {
+			return image
+		}
+		if code != COMPLETION_CODE_OK || len(data) < 1 || int(data[0]) != len(data)-1 {
+			t.Fatalf("Read FRU Data at %d = 0x%02x % x", len(image), code, data)
+		}
+		image = append(image, data[1:]...)
+	}
+}
+
+func TestDeviceGUIDMatchesFRUUUID(t *testing.T) {
+	obj := newStorageTestBMC(t, "127.0.10.9")
+	image, err := obj.DefaultFRUInfo().Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	obj.SetFRUData(image)
+	ctx := &IPMIContext{BMCIP: obj.Addr.String()}
+
+	code, guid := HandleIPMIGetDeviceGUID(ctx, IPMIRequest{NetFunction: IPMI_NETFN_APP})
+	if code != COMPLETION_CODE_OK || len(guid) != 16 {
+		t.Fatalf("Get Device GUID = 0x%02x % x", code, guid)
+	}
+
+	// The System Unique ID is in the first record of the MultiRecord Area.
+	fru := readTestFRU(t, ctx)
+	offset := int(fru[5]) * bmc.FRU_AREA_UNIT
+	if offset == 0 || offset+5+17 > len(fru) {
+		t.Fatalf("FRU of %d bytes has no multirecord area at %d", len(fru), offset)
+	}
+	record := fru[offset : offset+5+17]
+	if record[0] != bmc.FRU_MULTIRECORD_TYPE_MANAGEMENT_ACCESS || record[5] != bmc.FRU_MANAGEMENT_ACCESS_SYSTEM_UNIQUE_ID {
+		t.Fatalf("first multirecord % x is not the system unique ID", record)
+	}
+	if uuid := record[6:]; !bytes.Equal(uuid, guid) {
+		t.Errorf("FRU system unique ID = % x, want the device GUID % x", uuid, guid)
+	}
+}
@@ -1,5 +1,9 @@
 package ipmi
 
+import (
+	"net"
+)
+
 // port from OpenIPMI
 
 // Transport Network Function
@@ -26,3 +30,38 @@ const (
 	IPMI_CMD_SET_SOL_CONFIGURATION_PARAMETERS =	0x21
 	IPMI_CMD_GET_SOL_CONFIGURATION_PARAMETERS =	0x22
 )
+
+var ipmiTransportCommandNames = map[uint8]string {
+	IPMI_CMD_SET_LAN_CONFIG_PARMS:			"IPMI_CMD_SET_LAN_CONFIG_PARMS",
+	IPMI_CMD_GET_LAN_CONFIG_PARMS:			"IPMI_CMD_GET_LAN_CONFIG_PARMS",
+	IPMI_CMD_SUSPEND_BMC_ARPS:			"IPMI_CMD_SUSPEND_BMC_ARPS",
+	IPMI_CMD_GET_IP_UDP_RMCP_STATS:			"IPMI_CMD_GET_IP_UDP_RMCP_STATS",
+	IPMI_CMD_SET_SERIAL_MODEM_CONFIG:		"IPMI_CMD_SET_SERIAL_MODEM_CONFIG",
+	IPMI_CMD_GET_SERIAL_MODEM_CONFIG:		"IPMI_CMD_GET_SERIAL_MODEM_CONFIG",
+	IPMI_CMD_SET_SERIAL_MODEM_MUX:			"IPMI_CMD_SET_SERIAL_MODEM_MUX",
+	IPMI_CMD_GET_TAP_RESPONSE_CODES:		"IPMI_CMD_GET_TAP_RESPONSE_CODES",
+	IPMI_CMD_SET_PPP_UDP_PROXY_XMIT_DATA:		"IPMI_CMD_SET_PPP_UDP_PROXY_XMIT_DATA",
+	IPMI_CMD_GET_PPP_UDP_PROXY_XMIT_DATA:		"IPMI_CMD_GET_PPP_UDP_PROXY_XMIT_DATA",
+	IPMI_CMD_SEND_PPP_UDP_PROXY_PACKET:		"IPMI_CMD_SEND_PPP_UDP_PROXY_PACKET",
+	IPMI_CMD_GET_PPP_UDP_PROXY_RECV_DATA:		"IPMI_CMD_GET_PPP_UDP_PROXY_RECV_DATA",
+	IPMI_CMD_SERIAL_MODEM_CONN_ACTIVE:		"IPMI_CMD_SERIAL_MODEM_CONN_ACTIVE",
+	IPMI_CMD_CALLBACK:				"IPMI_CMD_CALLBACK",
+	IPMI_CMD_SET_USER_CALLBACK_OPTIONS:		"IPMI_CMD_SET_USER_CALLBACK_OPTIONS",
+	IPMI_CMD_GET_USER_CALLBACK_OPTIONS:		"IPMI_CMD_GET_USER_CALLBACK_OPTIONS",
+	IPMI_CMD_SOL_ACTIVATING:			"IPMI_CMD_SOL_ACTIVATING",
+	IPMI_CMD_SET_SOL_CONFIGURATION_PARAMETERS:	"IPMI_CMD_SET_SOL_CONFIGURATION_PARAMETERS",
+	IPMI_CMD_GET_SOL_CONFIGURATION_PARAMETERS:	"IPMI_CMD_GET_SOL_CONFIGURATION_PARAMETERS",
+}
+
+type IPMI_Transport_Handler func(addr *net.UDPAddr, server *net.UDPConn, wrapper IPMISessionWrapper, message IPMIMessage)
+
+// IPMI_TRANSPORT_SetHandler registers the handler of a Transport command
+// which sends its response by itself, see RegisterIPMIHandler.
+func IPMI_TRANSPORT_SetHandler(command int, handler IPMI_Transport_Handler) {
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_TRANSPORT, uint8(command)), "", WrapIPMIHandler(IPMI_Handler(handler)))
+}
+
+func init() {
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_TRANSPORT, IPMI_CMD_SET_SOL_CONFIGURATION_PARAMETERS), "", HandleIPMISetSOLConfigurationParameters)
+	RegisterIPMIHandler(IPMICommandKey(IPMI_NETFN_TRANSPORT, IPMI_CMD_GET_SOL_CONFIGURATION_PARAMETERS), "", HandleIPMIGetSOLConfigurationParameters)
+}
@@ -0,0 +1,310 @@
+package ipmi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+)
+import (
+	"github.com/rmxymh/infra-ecosphere/bmc"
+	"github.com/rmxymh/infra-ecosphere/utils"
+)
+
+// User management commands (IPMI v2.0 Section 22.26 ~ 22.30). The simulated
+// BMC has only one channel, the LAN channel, so the channel access of a user
+// is its access on the LAN channel.
+
+const (
+	CHANNEL_CURRENT =		0x0E
+
+	USER_ID_BITMASK =		0x3F
+	USER_NAME_LENGTH =		16
+	USER_PASSWORD_LENGTH_16 =	16
+	USER_PASSWORD_LENGTH_20 =	20
+)
+
+const (
+	COMPLETION_CODE_PASSWORD_TEST_FAILED =		0x80	// Set User Password
+	COMPLETION_CODE_PASSWORD_TEST_WRONG_SIZE =	0x81	// Set User Password
+)
+
+// Set User Access Request byte 1 and Get User Access Response byte 4
+const (
+	USER_ACCESS_CHANGE_BITS =		0x80
+	USER_ACCESS_CALLBACK_ONLY =		0x40
+	USER_ACCESS_LINK_AUTHENTICATION =	0x20
+	USER_ACCESS_IPMI_MESSAGING =		0x10
+	USER_ACCESS_CHANNEL_BITMASK =		0x0F
+)
+
+// Get User Access Response byte 2 [7:6]
+const (
+	USER_ID_STATUS_ENABLED =	0x40
+	USER_ID_STATUS_DISABLED =	0x80
+)
+
+// Set User Password Request byte 2 [1:0]
+const (
+	USER_PASSWORD_DISABLE_USER =	0x00
+	USER_PASSWORD_ENABLE_USER =	0x01
+	USER_PASSWORD_SET_PASSWORD =	0x02
+	USER_PASSWORD_TEST_PASSWORD =	0x03
+
+	USER_PASSWORD_SIZE_20 =		0x80
+)
+
+type IPMIGetUserAccessResponse struct {
+	MaxUserIDs uint8
+	EnabledUserIDs uint8		// [7:6] status of the requested user ID, [5:0] count of enabled user IDs
+	FixedNameUserIDs uint8
+	ChannelAccess uint8
+}
+
+func isLANChannel(channel uint8) bool {
+	channel &= USER_ACCESS_CHANNEL_BITMASK
+	return channel == LAN_CHANNEL_NUMBER || channel == CHANNEL_CURRENT
+}
+
+func isValidUserID(id uint8) bool {
+	return id >= bmc.NULL_USER_ID && id <= bmc.MAX_USER_ID
+}
+
+// trimZero cuts a null-padded name or password field at the first 0.
+func trimZero(data []uint8) string {
+	length := bytes.IndexByte(data, 0)
+	if length < 0 {
+		length = len(data)
+	}
+	return string(data[:length])
+}
+
+// getUserSlot returns the user with the user ID. The slot of the null user is
+// never empty: it holds a disabled null user without access until it is set up.
+func getUserSlot(obj bmc.BMC, id uint8) (bmc.BMCUser, bool) {
+	if user, found := obj.GetUserByID(id); found {
+		return user, true
+	}
+	if id == bmc.NULL_USER_ID {
+		user := bmc.NewNullUser("", PRIVILEGE_NO_ACCESS)
+		user.Enabled = false
+		return user, true
+	}
+	return bmc.BMCUser{}, false
+}
+
+// saveLocalBMCUsers keeps a change of the users of the BMC, including across
+// restarts.
+func saveLocalBMCUsers(obj bmc.BMC) {
+	obj.Save()
+	utils.SaveBMCUsers(obj)
+}
+
+// closeUserSessions closes the sessions of a user who is disabled or whose
+// password is changed, so that the user has to log in again.
+func closeUserSessions(bmcIP string, user bmc.BMCUser) {
+	for _, session := range RemoveUserSessions(bmcIP, user.ID) {
+		log.Printf("      IPMI User: Close session 0x%08x of user %d (%s).\n", session.SessionID, user.ID, user.Username)
+		DeactivateSOLBySession(bmcIP, session.SessionID)
+	}
+}
+
+func HandleIPMISetUserAccess(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	access := request.Data[0]
+	id := request.Data[1] & USER_ID_BITMASK
+	privilege := request.Data[2] & 0x0F
+
+	user, found := getUserSlot(localBMC, id)
+	if ! isLANChannel(access) || ! found {
+		log.Printf("      IPMI User: Channel 0x%02x or user ID %d is invalid.\n", access & USER_ACCESS_CHANNEL_BITMASK, id)
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+	if (privilege < PRIVILEGE_CALLBACK || privilege > PRIVILEGE_OEM) && privilege != PRIVILEGE_NO_ACCESS {
+		log.Printf("      IPMI User: Privilege limit 0x%02x is invalid.\n", privilege)
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+
+	user.MaxPrivilege = privilege
+	if access & USER_ACCESS_CHANGE_BITS != 0 {
+		user.CallbackOnly = access & USER_ACCESS_CALLBACK_ONLY != 0
+		user.LinkAuthentication = access & USER_ACCESS_LINK_AUTHENTICATION != 0
+		user.IPMIMessaging = access & USER_ACCESS_IPMI_MESSAGING != 0
+	}
+	localBMC.SetUser(user)
+	saveLocalBMCUsers(localBMC)
+	log.Printf("      IPMI User: Set access of user %d (%s): privilege limit 0x%02x, IPMI messaging %t\n", id, user.Username, user.MaxPrivilege, user.IPMIMessaging)
+
+	return COMPLETION_CODE_OK, nil
+}
+
+func HandleIPMIGetUserAccess(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	id := request.Data[1] & USER_ID_BITMASK
+	if ! isLANChannel(request.Data[0]) || ! isValidUserID(id) {
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+
+	response := IPMIGetUserAccessResponse{}
+	response.MaxUserIDs = bmc.MAX_USER_ID
+	response.FixedNameUserIDs = 1		// the null user
+	for _, user := range localBMC.GetUsers() {
+		if user.Enabled {
+			response.EnabledUserIDs += 1
+		}
+	}
+
+	// Empty user ID slots are reported as disabled users without access.
+	response.EnabledUserIDs |= USER_ID_STATUS_DISABLED
+	response.ChannelAccess = PRIVILEGE_NO_ACCESS
+	if user, found := getUserSlot(localBMC, id); found {
+		if user.Enabled {
+			response.EnabledUserIDs = (response.EnabledUserIDs & USER_ID_BITMASK) | USER_ID_STATUS_ENABLED
+		}
+		response.ChannelAccess = user.MaxPrivilege
+		if user.CallbackOnly {
+			response.ChannelAccess |= USER_ACCESS_CALLBACK_ONLY
+		}
+		if user.LinkAuthentication {
+			response.ChannelAccess |= USER_ACCESS_LINK_AUTHENTICATION
+		}
+		if user.IPMIMessaging {
+			response.ChannelAccess |= USER_ACCESS_IPMI_MESSAGING
+		}
+	}
+
+	dataBuf := bytes.Buffer{}
+	binary.Write(&dataBuf, binary.LittleEndian, response)
+	return COMPLETION_CODE_OK, dataBuf.Bytes()
+}
+
+func HandleIPMISetUserName(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	id := request.Data[0] & USER_ID_BITMASK
+	name := trimZero(request.Data[1:1 + USER_NAME_LENGTH])
+
+	// The name of the null user is fixed.
+	if ! isValidUserID(id) || id == bmc.NULL_USER_ID {
+		log.Printf("      IPMI User: Name of user ID %d can not be set.\n", id)
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+	if other, found := localBMC.GetUser(name); found && len(name) > 0 && other.ID != id {
+		log.Printf("      IPMI User: Name %s is used by user ID %d.\n", name, other.ID)
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+
+	user, found := localBMC.GetUserByID(id)
+	if len(name) == 0 {
+		localBMC.RemoveUserByID(id)
+		log.Printf("      IPMI User: Remove user %d (%s)\n", id, user.Username)
+	} else {
+		if ! found {
+			// A new user can not log in until it is enabled and given a privilege limit.
+			user = bmc.BMCUser{
+				ID: id,
+				MaxPrivilege: PRIVILEGE_NO_ACCESS,
+				IPMIMessaging: true,
+			}
+		}
+		user.Username = name
+		localBMC.SetUser(user)
+		log.Printf("      IPMI User: Set name of user %d to %s\n", id, name)
+	}
+	saveLocalBMCUsers(localBMC)
+
+	return COMPLETION_CODE_OK, nil
+}
+
+func HandleIPMIGetUserName(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	id := request.Data[0] & USER_ID_BITMASK
+	if ! isValidUserID(id) {
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+
+	var name [USER_NAME_LENGTH]uint8
+	if user, found := localBMC.GetUserByID(id); found {
+		copy(name[:], user.Username)
+	}
+	return COMPLETION_CODE_OK, name[:]
+}
+
+func HandleIPMISetUserPassword(ctx *IPMIContext, request IPMIRequest) (uint8, []uint8) {
+	localBMC, ok := ctx.GetBMC()
+	if ! ok {
+		log.Printf("BMC %s is not found\n", ctx.BMCIP)
+		return COMPLETION_CODE_NOT_SUPPORTED_IN_STATE, nil
+	}
+
+	id := request.Data[0] & USER_ID_BITMASK
+	operation := request.Data[1] & 0x03
+	size := USER_PASSWORD_LENGTH_16
+	if request.Data[0] & USER_PASSWORD_SIZE_20 != 0 {
+		size = USER_PASSWORD_LENGTH_20
+	}
+
+	password := ""
+	if operation == USER_PASSWORD_SET_PASSWORD || operation == USER_PASSWORD_TEST_PASSWORD {
+		// The length of the password depends on the size bit, which the
+		// request length check can not know.
+		if len(request.Data) < 2 + size {
+			return COMPLETION_CODE_REQUEST_DATA_LENGTH_INVALID, nil
+		}
+		password = trimZero(request.Data[2:2 + size])
+	}
+
+	user, found := getUserSlot(localBMC, id)
+	if ! found {
+		log.Printf("      IPMI User: User ID %d is not found.\n", id)
+		return COMPLETION_CODE_INVALID_DATA_FIELD, nil
+	}
+
+	switch operation {
+	case USER_PASSWORD_DISABLE_USER:
+		user.Enabled = false
+		log.Printf("      IPMI User: Disable user %d (%s)\n", id, user.Username)
+	case USER_PASSWORD_ENABLE_USER:
+		user.Enabled = true
+		ResetLoginFailures(ctx.BMCIP, user.Username)
+		log.Printf("      IPMI User: Enable user %d (%s)\n", id, user.Username)
+	case USER_PASSWORD_SET_PASSWORD:
+		user.Password = password
+		ResetLoginFailures(ctx.BMCIP, user.Username)
+		log.Printf("      IPMI User: Set password of user %d (%s)\n", id, user.Username)
+	case USER_PASSWORD_TEST_PASSWORD:
+		// A password longer than 16 bytes can only be tested in the 20-byte form.
+		if len(user.Password) > size {
+			return COMPLETION_CODE_PASSWORD_TEST_WRONG_SIZE, nil
+		}
+		if user.Password != password {
+			return COMPLETION_CODE_PASSWORD_TEST_FAILED, nil
+		}
+		return COMPLETION_CODE_OK, nil
+	}
+	localBMC.SetUser(user)
+	saveLocalBMCUsers(localBMC)
+	if operation == USER_PASSWORD_DISABLE_USER || operation == USER_PASSWORD_SET_PASSWORD {
+		closeUserSessions(ctx.BMCIP, user)
+	}
+
+	return COMPLETION_CODE_OK, nil
+}
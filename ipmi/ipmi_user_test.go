@@ -0,0 +1,205 @@
+package ipmi
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/rmxymh/infra-ecosphere/bmc"
+	"github.com/rmxymh/infra-ecosphere/utils"
+	"github.com/rmxymh/infra-ecosphere/vm"
+)
+
+// newUserTestBMC registers a BMC with its own user admin (user ID 2), whose
+// users are saved into a temporary file.
+func newUserTestBMC(t *testing.T, ip string) *IPMIContext {
+	t.Helper()
+
+	savedUserFile := utils.BMCUserFile
+	utils.BMCUserFile = filepath.Join(t.TempDir(), "users.json")
+	obj := bmc.AddBMC(net.ParseIP(ip), vm.Instance{})
+	obj.AddUser("admin", "admin", PRIVILEGE_ADMINISTRATOR)
+	obj.Save()
+	t.Cleanup(func() {
+		bmc.RemoveBMC(obj.Addr)
+		utils.BMCUserFile = savedUserFile
+	})
+	return &IPMIContext{BMCIP: ip}
+}
+
+func getTestUser(t *testing.T, ctx *IPMIContext, id uint8) (bmc.BMCUser, bool) {
+	t.Helper()
+
+	obj, ok := ctx.GetBMC()
+	if !ok {
+		t.Fatalf("BMC %s is not found", ctx.BMCIP)
+	}
+	return obj.GetUserByID(id)
+}
+
+func userNameRequest(id uint8, name string) IPMIRequest {
+	data := make([]uint8, 1+USER_NAME_LENGTH)
+	data[0] = id
+	copy(data[1:], name)
+	return IPMIRequest{NetFunction: IPMI_NETFN_APP, Command: IPMI_CMD_SET_USER_NAME, Data: data}
+}
+
+func userPasswordRequest(id uint8, operation uint8, size int, password string) IPMIRequest {
+	data := []uint8{id, operation}
+	if size == USER_PASSWORD_LENGTH_20 {
+		data[0] |= USER_PASSWORD_SIZE_20
+	}
+	if operation == USER_PASSWORD_SET_PASSWORD || operation == USER_PASSWORD_TEST_PASSWORD {
+		field := make([]uint8, size)
+		copy(field, password)
+		data = append(data, field...)
+	}
+	return IPMIRequest{NetFunction: IPMI_NETFN_APP, Command: IPMI_CMD_SET_USER_PASSWORD, Data: data}
+}
+
+func TestSetGetUserName(t *testing.T) {
+	ctx := newUserTestBMC(t, "127.0.11.1")
+
+	tests := []struct {
+		name string
+		id   uint8
+		user string
+		want uint8
+	}{
+		{"new user", 3, "operator", COMPLETION_CODE_OK},
+		{"rename", 3, "oper", COMPLETION_CODE_OK},
+		{"name of another user", 4, "admin", COMPLETION_CODE_INVALID_DATA_FIELD},
+		{"null user", bmc.NULL_USER_ID, "anonymous", COMPLETION_CODE_INVALID_DATA_FIELD},
+		{"user ID out of range", bmc.MAX_USER_ID + 1, "nobody", COMPLETION_CODE_INVALID_DATA_FIELD},
+	}
+	for _, test := range tests {
+		if code, _ := HandleIPMISetUserName(ctx, userNameRequest(test.id, test.user)); code != test.want {
+			t.Errorf("%s: Set User Name completion code = 0x%02x, want 0x%02x", test.name, code, test.want)
+		}
+	}
+
+	user, found := getTestUser(t, ctx, 3)
+	if !found || user.Username != "oper" || user.MaxPrivilege != PRIVILEGE_NO_ACCESS {
+		t.Errorf("user 3 = %+v, %v, want oper without access", user, found)
+	}
+	code, data := HandleIPMIGetUserName(ctx, IPMIRequest{Data: []uint8{3}})
+	if want := append([]uint8("oper"), make([]uint8, USER_NAME_LENGTH-4)...); code != COMPLETION_CODE_OK || !bytes.Equal(data, want) {
+		t.Errorf("Get User Name = 0x%02x % x, want % x", code, data, want)
+	}
+
+	// An empty name removes the user.
+	if code, _ := HandleIPMISetUserName(ctx, userNameRequest(3, "")); code != COMPLETION_CODE_OK {
+		t.Errorf("Set User Name to an empty name completion code = 0x%02x", code)
+	}
+	if _, found := getTestUser(t, ctx, 3); found {
+		t.Error("user 3 is not removed")
+	}
+	code, data = HandleIPMIGetUserName(ctx, IPMIRequest{Data: []uint8{3}})
+	if want := make([]uint8, USER_NAME_LENGTH); code != COMPLETION_CODE_OK || !bytes.Equal(data, want) {
+		t.Errorf("Get User Name of an empty slot = 0x%02x % x, want % x", code, data, want)
+	}
+}
+
+func TestSetUserPassword(t *testing.T) {
+	ctx := newUserTestBMC(t, "127.0.11.2")
+	password20 := "0123456789abcdefghij"
+
+	tests := []struct {
+		name      string
+		operation uint8
+		size      int
+		password  string
+		want      uint8
+	}{
+		{"set", USER_PASSWORD_SET_PASSWORD, USER_PASSWORD_LENGTH_16, "secret", COMPLETION_CODE_OK},
+		{"test", USER_PASSWORD_TEST_PASSWORD, USER_PASSWORD_LENGTH_16, "secret", COMPLETION_CODE_OK},
+		{"test 20-byte form", USER_PASSWORD_TEST_PASSWORD, USER_PASSWORD_LENGTH_20, "secret", COMPLETION_CODE_OK},
+		{"test mismatch", USER_PASSWORD_TEST_PASSWORD, USER_PASSWORD_LENGTH_16, "wrong", COMPLETION_CODE_PASSWORD_TEST_FAILED},
+		{"set 20-byte", USER_PASSWORD_SET_PASSWORD, USER_PASSWORD_LENGTH_20, password20, COMPLETION_CODE_OK},
+		{"test 20-byte", USER_PASSWORD_TEST_PASSWORD, USER_PASSWORD_LENGTH_20, password20, COMPLETION_CODE_OK},
+		{"test 20-byte in 16-byte form", USER_PASSWORD_TEST_PASSWORD, USER_PASSWORD_LENGTH_16, password20[:16], COMPLETION_CODE_PASSWORD_TEST_WRONG_SIZE},
+		{"test 20-byte mismatch", USER_PASSWORD_TEST_PASSWORD, USER_PASSWORD_LENGTH_20, "0123456789abcdefghiX", COMPLETION_CODE_PASSWORD_TEST_FAILED},
+	}
+	for _, test := range tests {
+		request := userPasswordRequest(2, test.operation, test.size, test.password)
+		if code, _ := HandleIPMISetUserPassword(ctx, request); code != test.want {
+			t.Errorf("%s: Set User Password completion code = 0x%02x, want 0x%02x", test.name, code, test.want)
+		}
+	}
+	if user, _ := getTestUser(t, ctx, 2); user.Password != password20 {
+		t.Errorf("password = %q, want %q", user.Password, password20)
+	}
+
+	// The 20-byte form needs 20 bytes of password.
+	request := userPasswordRequest(2, USER_PASSWORD_SET_PASSWORD, USER_PASSWORD_LENGTH_16, "short")
+	request.Data[0] |= USER_PASSWORD_SIZE_20
+	if code, _ := HandleIPMISetUserPassword(ctx, request); code != COMPLETION_CODE_REQUEST_DATA_LENGTH_INVALID {
+		t.Errorf("16 bytes in the 20-byte form: completion code = 0x%02x, want 0x%02x", code, COMPLETION_CODE_REQUEST_DATA_LENGTH_INVALID)
+	}
+}
+
+func TestSetUserPasswordEnable(t *testing.T) {
+	ctx := newUserTestBMC(t, "127.0.11.3")
+	user, _ := getTestUser(t, ctx, 2)
+	session, ok := GetNewSession(ctx.BMCIP, nil, user)
+	if !ok {
+		t.Fatal("GetNewSession failed")
+	}
+	session.Activated = true
+	session.Save()
+	t.Cleanup(func() { RemoveSession(ctx.BMCIP, session.SessionID) })
+
+	if code, _ := HandleIPMISetUserPassword(ctx, userPasswordRequest(2, USER_PASSWORD_DISABLE_USER, USER_PASSWORD_LENGTH_16, "")); code != COMPLETION_CODE_OK {
+		t.Fatalf("disable user: completion code = 0x%02x", code)
+	}
+	if user, _ := getTestUser(t, ctx, 2); user.Enabled {
+		t.Error("user is enabled after disable")
+	}
+	if _, found := GetSession(ctx.BMCIP, session.SessionID); found {
+		t.Error("session of the disabled user is not closed")
+	}
+
+	if code, _ := HandleIPMISetUserPassword(ctx, userPasswordRequest(2, USER_PASSWORD_ENABLE_USER, USER_PASSWORD_LENGTH_16, "")); code != COMPLETION_CODE_OK {
+		t.Fatalf("enable user: completion code = 0x%02x", code)
+	}
+	if user, _ := getTestUser(t, ctx, 2); !user.Enabled || user.Password != "admin" {
+		t.Errorf("user after enable = %+v, want enabled with the same password", user)
+	}
+}
+
+func TestSetGetUserAccess(t *testing.T) {
+	ctx := newUserTestBMC(t, "127.0.11.4")
+
+	tests := []struct {
+		name string
+		data []uint8
+		want uint8
+	}{
+		{"change bits", []uint8{USER_ACCESS_CHANGE_BITS | USER_ACCESS_LINK_AUTHENTICATION | LAN_CHANNEL_NUMBER, 2, PRIVILEGE_OPERATOR}, COMPLETION_CODE_OK},
+		{"privilege only", []uint8{USER_ACCESS_IPMI_MESSAGING | CHANNEL_CURRENT, 2, PRIVILEGE_USER}, COMPLETION_CODE_OK},
+		{"other channel", []uint8{0x05, 2, PRIVILEGE_USER}, COMPLETION_CODE_INVALID_DATA_FIELD},
+		{"invalid privilege", []uint8{LAN_CHANNEL_NUMBER, 2, PRIVILEGE_OEM + 1}, COMPLETION_CODE_INVALID_DATA_FIELD},
+		{"empty slot", []uint8{LAN_CHANNEL_NUMBER, 5, PRIVILEGE_USER}, COMPLETION_CODE_INVALID_DATA_FIELD},
+	}
+	for _, test := range tests {
+		request := IPMIRequest{NetFunction: IPMI_NETFN_APP, Command: IPMI_CMD_SET_USER_ACCESS, Data: test.data}
+		if code, _ := HandleIPMISetUserAccess(ctx, request); code != test.want {
+			t.Errorf("%s: Set User Access completion code = 0x%02x, want 0x%02x", test.name, code, test.want)
+		}
+	}
+
+	// The change bits have turned IPMI messaging off, and the second request
+	// has only changed the privilege limit.
+	code, data := HandleIPMIGetUserAccess(ctx, IPMIRequest{Data: []uint8{LAN_CHANNEL_NUMBER, 2}})
+	want := []uint8{bmc.MAX_USER_ID, USER_ID_STATUS_ENABLED | 1, 1, USER_ACCESS_LINK_AUTHENTICATION | PRIVILEGE_USER}
+	if code != COMPLETION_CODE_OK || !bytes.Equal(data, want) {
+		t.Errorf("Get User Access of user 2 = 0x%02x % x, want % x", code, data, want)
+	}
+
+	code, data = HandleIPMIGetUserAccess(ctx, IPMIRequest{Data: []uint8{LAN_CHANNEL_NUMBER, 5}})
+	want = []uint8{bmc.MAX_USER_ID, USER_ID_STATUS_DISABLED | 1, 1, PRIVILEGE_NO_ACCESS}
+	if code != COMPLETION_CODE_OK || !bytes.Equal(data, want) {
+		t.Errorf("Get User Access of an empty slot = 0x%02x % x, want % x", code, data, want)
+	}
+}
@@ -26,11 +26,13 @@ const (
 	RMCP_CLASS_OEM	= 0x08
 )
 
-func DeserializeRMCP(buf io.Reader)  (length uint32, header RemoteManagementControlProtocol) {
-	binary.Read(buf, binary.LittleEndian, &header)
+func DeserializeRMCP(buf io.Reader)  (length uint32, header RemoteManagementControlProtocol, err error) {
+	if err = readField(buf, "RMCP", "RMCP Header", &header); err != nil {
+		return 0, header, err
+	}
 	length += uint32(unsafe.Sizeof(header))
 
-	return length, header
+	return length, header, nil
 }
 
 func SerializeRMCP(buf *bytes.Buffer, header RemoteManagementControlProtocol) {
@@ -38,7 +40,11 @@ func SerializeRMCP(buf *bytes.Buffer, header RemoteManagementControlProtocol) {
 }
 
 func RMCPDeserializeAndExecute(buf io.Reader, addr *net.UDPAddr, server *net.UDPConn) {
-	_, rmcp := DeserializeRMCP(buf)
+	_, rmcp, err := DeserializeRMCP(buf)
+	if err != nil {
+		log.Printf("  RMCP: Drop malformed packet, %s\n", err)
+		return
+	}
 
 	switch rmcp.Class {
 	case RMCP_CLASS_ASF:
@@ -2,9 +2,13 @@ package utils
 
 import (
 	"os"
+	"encoding/hex"
 	"encoding/json"
+	"io/ioutil"
 	"log"
 	"net"
+	"strings"
+	"time"
 	"github.com/rmxymh/infra-ecosphere/vm"
 	"github.com/rmxymh/infra-ecosphere/bmc"
 	"github.com/rmxymh/infra-ecosphere/web"
@@ -13,17 +17,258 @@ import (
 type ConfigNode struct {
 	BMCIP string
 	VMName string
+	BMCKey string
+	DisableRMCPPlus bool
+	DisablePerMessageAuth bool
+	DisableUserLevelAuth bool
+	SerialMode string
+	SerialAddress string
+	CipherSuites []ConfigCipherSuite
+	AuthTypes map[string][]string	// IPMI v1.5 authentication types enabled at each privilege level
+	SessionTimeout int		// in seconds
+	MaxSessions int
+	MaxUserSessions int
+	BMCUsers []ConfigBMCUser	// overrides the default BMCUsers
+	NullUser *ConfigNullUser	// overrides the default NullUser
+	AllowedSources []string		// CIDRs or IP addresses
+	ChallengeRateLimit int		// per source per minute
+	BadPasswordThreshold int
+	AttemptCountResetInterval int	// in seconds
+	UserLockoutInterval int		// in seconds
+	SELCapacity int			// entries, at most bmc.MAX_SEL_CAPACITY
+	SELOverwrite bool
+	SDRFile string			// `ipmitool sdr dump` of a real BMC, overrides the default sensors
+	FRUFile string			// `ipmitool fru read` of a real BMC
+	FRU *ConfigFRU			// overrides the default FRU content, unless FRUFile is set
+}
+
+// ConfigFRU overrides the fields of the default FRU content of a BMC. Empty
+// fields keep their defaults.
+type ConfigFRU struct {
+	ChassisType int
+	ChassisPartNumber string
+	ChassisSerial string
+	BoardManufacturer string
+	BoardProductName string
+	BoardSerial string
+	BoardPartNumber string
+	ProductManufacturer string
+	ProductName string
+	ProductPartNumber string
+	ProductVersion string
+	ProductSerial string
+	AssetTag string
+	UUID string			// e.g. 4c4c4544-0042-3510-8052-b7c04f4e3232
+	MultiRecords []ConfigFRUMultiRecord
+}
+
+type ConfigFRUMultiRecord struct {
+	Type int
+	Data string			// in hexadecimal
+}
+
+type ConfigCipherSuite struct {
+	ID int
+	MaxPrivilege string
 }
 
 type ConfigBMCUser struct {
 	Username string
 	Password string
+	MaxPrivilege string
+}
+
+// ConfigNullUser is the user with the empty username (user ID 1). An empty
+// password means anonymous login.
+type ConfigNullUser struct {
+	Password string
+	MaxPrivilege string
 }
 
 type Configuration struct {
 	Nodes		[]ConfigNode
 	BMCUsers	[]ConfigBMCUser
+	NullUser	*ConfigNullUser
 	WebAPIPort	int
+	BMCUserFile	*string		// users changed by IPMI commands are saved here, "" keeps them in memory only
+	SELFile		*string		// the SEL of the BMCs is saved here, "" keeps it in memory only
+	SavedFRUFile	*string		// FRU data written by IPMI commands is saved here, "" keeps it in memory only
+}
+
+func loadCipherSuites(node ConfigNode) []bmc.CipherSuite {
+	suites := []bmc.CipherSuite{}
+	for _, suite := range node.CipherSuites {
+		if suite.ID < 0 || suite.ID > 0xff {
+			log.Fatalf("Config: Cipher suite %d of BMC %s is invalid.\n", suite.ID, node.BMCIP)
+		}
+
+		privilege := uint8(bmc.PRIVILEGE_ADMINISTRATOR)
+		if len(suite.MaxPrivilege) > 0 {
+			level, ok := bmc.ParsePrivilegeLevel(suite.MaxPrivilege)
+			if ! ok || level > bmc.PRIVILEGE_OEM {
+				log.Fatalf("Config: MaxPrivilege %s of cipher suite %d is invalid.\n", suite.MaxPrivilege, suite.ID)
+			}
+			privilege = level
+		}
+
+		log.Printf("Config: BMC %s allows cipher suite %d with max privilege 0x%02x\n", node.BMCIP, suite.ID, privilege)
+		suites = append(suites, bmc.CipherSuite{ID: uint8(suite.ID), MaxPrivilege: privilege})
+	}
+	return suites
+}
+
+func loadAuthTypes(node ConfigNode) map[uint8][]uint8 {
+	authTypes := make(map[uint8][]uint8)
+	for levelName, names := range node.AuthTypes {
+		privilege, ok := bmc.ParsePrivilegeLevel(levelName)
+		if ! ok || privilege > bmc.PRIVILEGE_OEM {
+			log.Fatalf("Config: AuthTypes of BMC %s has an invalid privilege level %s.\n", node.BMCIP, levelName)
+		}
+
+		types := []uint8{}
+		for _, name := range names {
+			authType, ok := bmc.ParseAuthenticationType(name)
+			if ! ok || authType == bmc.AUTH_TYPE_OEM {
+				log.Fatalf("Config: Authentication type %s of BMC %s should be NONE, MD2, MD5 or PASSWORD.\n", name, node.BMCIP)
+			}
+			types = append(types, authType)
+		}
+
+		log.Printf("Config: BMC %s enables authentication types %v at privilege level 0x%02x\n", node.BMCIP, names, privilege)
+		authTypes[privilege] = types
+	}
+	return authTypes
+}
+
+func loadAllowedSources(node ConfigNode) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, source := range node.AllowedSources {
+		_, network, err := net.ParseCIDR(source)
+		if err != nil {
+			ip := net.ParseIP(source)
+			if ip == nil {
+				log.Fatalf("Config: AllowedSources %s of BMC %s should be a CIDR or an IP address.\n", source, node.BMCIP)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+
+		log.Printf("Config: BMC %s allows source %s\n", node.BMCIP, network.String())
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func loadSDRFile(node ConfigNode) []bmc.SDRRecord {
+	data, err := ioutil.ReadFile(node.SDRFile)
+	if err != nil {
+		log.Fatalf("Config: Failed to read SDRFile of BMC %s: %s\n", node.BMCIP, err.Error())
+	}
+	records, err := bmc.ParseSDRRecords(data)
+	if err != nil {
+		log.Fatalf("Config: SDRFile %s of BMC %s is invalid: %s\n", node.SDRFile, node.BMCIP, err.Error())
+	}
+
+	log.Printf("Config: BMC %s serves %d SDRs of %s\n", node.BMCIP, len(records), node.SDRFile)
+	return records
+}
+
+func loadFRUFile(node ConfigNode) []uint8 {
+	data, err := ioutil.ReadFile(node.FRUFile)
+	if err != nil {
+		log.Fatalf("Config: Failed to read FRUFile of BMC %s: %s\n", node.BMCIP, err.Error())
+	}
+	if len(data) > bmc.MAX_FRU_SIZE {
+		log.Fatalf("Config: FRUFile %s of BMC %s should not be larger than %d bytes.\n", node.FRUFile, node.BMCIP, bmc.MAX_FRU_SIZE)
+	}
+
+	log.Printf("Config: BMC %s serves FRU %s\n", node.BMCIP, node.FRUFile)
+	return data
+}
+
+// overrideFRUField replaces field with value unless value is empty.
+func overrideFRUField(field *string, value string) {
+	if len(value) > 0 {
+		*field = value
+	}
+}
+
+func loadFRU(node ConfigNode, info bmc.FRUInfo) []uint8 {
+	if node.FRU != nil {
+		fru := node.FRU
+		if fru.ChassisType < 0 || fru.ChassisType > 0xff {
+			log.Fatalf("Config: ChassisType %d of BMC %s is invalid.\n", fru.ChassisType, node.BMCIP)
+		}
+		if fru.ChassisType > 0 {
+			info.ChassisType = uint8(fru.ChassisType)
+		}
+		overrideFRUField(&info.ChassisPartNumber, fru.ChassisPartNumber)
+		overrideFRUField(&info.ChassisSerial, fru.ChassisSerial)
+		overrideFRUField(&info.BoardManufacturer, fru.BoardManufacturer)
+		overrideFRUField(&info.BoardProductName, fru.BoardProductName)
+		overrideFRUField(&info.BoardSerial, fru.BoardSerial)
+		overrideFRUField(&info.BoardPartNumber, fru.BoardPartNumber)
+		overrideFRUField(&info.ProductManufacturer, fru.ProductManufacturer)
+		overrideFRUField(&info.ProductName, fru.ProductName)
+		overrideFRUField(&info.ProductPartNumber, fru.ProductPartNumber)
+		overrideFRUField(&info.ProductVersion, fru.ProductVersion)
+		overrideFRUField(&info.ProductSerial, fru.ProductSerial)
+		overrideFRUField(&info.AssetTag, fru.AssetTag)
+
+		if len(fru.UUID) > 0 {
+			uuid, err := hex.DecodeString(strings.Replace(fru.UUID, "-", "", -1))
+			if err != nil || len(uuid) != len(info.UUID) {
+				log.Fatalf("Config: UUID %s of BMC %s is invalid.\n", fru.UUID, node.BMCIP)
+			}
+			copy(info.UUID[:], uuid)
+		}
+
+		for _, record := range fru.MultiRecords {
+			data, err := hex.DecodeString(record.Data)
+			if err != nil || record.Type < 0 || record.Type > 0xff {
+				log.Fatalf("Config: MultiRecord of type %d of BMC %s is invalid.\n", record.Type, node.BMCIP)
+			}
+			info.MultiRecords = append(info.MultiRecords, bmc.FRUMultiRecord{Type: uint8(record.Type), Data: data})
+		}
+	}
+
+	data, err := info.Encode()
+	if err != nil {
+		log.Fatalf("Config: FRU of BMC %s is invalid: %s\n", node.BMCIP, err.Error())
+	}
+	log.Printf("Config: BMC %s has FRU with product serial %s\n", node.BMCIP, info.ProductSerial)
+	return data
+}
+
+func validateUser(user ConfigBMCUser) {
+	if len(user.Username) == 0 || len(user.Username) > 16 {
+		log.Fatalf("Config: Username %s should have 1 ~ 16 characters.\n", user.Username)
+	}
+	if len(user.Password) > 20 {
+		log.Fatalf("Config: Password of user %s should not be longer than 20 characters.\n", user.Username)
+	}
+}
+
+func loadNullUser(nullUser ConfigNullUser) bmc.BMCUser {
+	if len(nullUser.Password) > 20 {
+		log.Fatalln("Config: Password of the null user should not be longer than 20 characters.")
+	}
+	return bmc.NewNullUser(nullUser.Password, loadUserPrivilege(ConfigBMCUser{MaxPrivilege: nullUser.MaxPrivilege}))
+}
+
+func loadUserPrivilege(user ConfigBMCUser) uint8 {
+	if len(user.MaxPrivilege) == 0 {
+		return bmc.PRIVILEGE_ADMINISTRATOR
+	}
+
+	level, ok := bmc.ParsePrivilegeLevel(user.MaxPrivilege)
+	if ! ok || level > bmc.PRIVILEGE_ADMINISTRATOR {
+		log.Fatalf("Config: MaxPrivilege %s of user %s should be CALLBACK, USER, OPERATOR or ADMINISTRATOR.\n", user.MaxPrivilege, user.Username)
+	}
+	return level
 }
 
 func LoadConfig(configFile string) Configuration {
@@ -42,6 +287,18 @@ func LoadConfig(configFile string) Configuration {
 		log.Fatalln("Config: Error: ", err)
 	}
 
+	for _, user := range configuration.BMCUsers {
+		log.Printf("Config: Add BMC User %s\n", user.Username)
+		validateUser(user)
+		if ! bmc.AddBMCUser(user.Username, user.Password, loadUserPrivilege(user)) {
+			log.Fatalf("Config: There should not be more than %d BMC users.\n", bmc.MAX_USER_ID - 1)
+		}
+	}
+	if configuration.NullUser != nil {
+		log.Println("Config: Set null user")
+		bmc.SetBMCNullUser(loadNullUser(*configuration.NullUser))
+	}
+
 	// initialize BMCs and Instances
 	for _, node := range configuration.Nodes {
 		fakeNode := false
@@ -49,13 +306,90 @@ func LoadConfig(configFile string) Configuration {
 			fakeNode = true
 		}
 		instance := vm.AddInstnace(node.VMName, fakeNode)
-		bmc.AddBMC(net.ParseIP(node.BMCIP), instance)
+		switch node.SerialMode {
+		case vm.SERIAL_MODE_NONE:
+		case vm.SERIAL_MODE_PIPE, vm.SERIAL_MODE_TCP:
+			instance.SetSerialPort(node.SerialMode, node.SerialAddress)
+		default:
+			log.Fatalf("Config: SerialMode %s of BMC %s should be pipe or tcp.\n", node.SerialMode, node.BMCIP)
+		}
+		newBMC := bmc.AddBMC(net.ParseIP(node.BMCIP), instance)
+		if len(node.BMCKey) > 20 {
+			log.Fatalf("Config: BMCKey of BMC %s should not be longer than 20 characters.\n", node.BMCIP)
+		}
+		newBMC.Kg = []byte(node.BMCKey)
+		newBMC.RMCPPlusDisabled = node.DisableRMCPPlus
+		newBMC.PerMessageAuthDisabled = node.DisablePerMessageAuth
+		newBMC.UserLevelAuthDisabled = node.DisableUserLevelAuth
+		if node.CipherSuites != nil {
+			newBMC.CipherSuites = loadCipherSuites(node)
+		}
+		if node.AuthTypes != nil {
+			newBMC.AuthTypes = loadAuthTypes(node)
+		}
+		if node.SessionTimeout < 0 || node.MaxSessions < 0 || node.MaxUserSessions < 0 {
+			log.Fatalf("Config: SessionTimeout, MaxSessions and MaxUserSessions of BMC %s should not be negative.\n", node.BMCIP)
+		}
+		newBMC.SessionTimeout = time.Duration(node.SessionTimeout) * time.Second
+		newBMC.MaxSessions = node.MaxSessions
+		newBMC.MaxUserSessions = node.MaxUserSessions
+		if node.AllowedSources != nil {
+			newBMC.AllowedSources = loadAllowedSources(node)
+		}
+		if node.ChallengeRateLimit < 0 || node.BadPasswordThreshold < 0 || node.BadPasswordThreshold > 0xff || node.AttemptCountResetInterval < 0 || node.UserLockoutInterval < 0 {
+			log.Fatalf("Config: ChallengeRateLimit, BadPasswordThreshold, AttemptCountResetInterval and UserLockoutInterval of BMC %s should not be negative, and BadPasswordThreshold should not be larger than 255.\n", node.BMCIP)
+		}
+		newBMC.ChallengeRateLimit = node.ChallengeRateLimit
+		newBMC.BadPasswordThreshold = node.BadPasswordThreshold
+		newBMC.AttemptCountResetInterval = time.Duration(node.AttemptCountResetInterval) * time.Second
+		newBMC.UserLockoutInterval = time.Duration(node.UserLockoutInterval) * time.Second
+		if node.SELCapacity < 0 || node.SELCapacity > bmc.MAX_SEL_CAPACITY {
+			log.Fatalf("Config: SELCapacity of BMC %s should be 0 ~ %d.\n", node.BMCIP, bmc.MAX_SEL_CAPACITY)
+		}
+		newBMC.SELCapacity = node.SELCapacity
+		newBMC.SELOverwrite = node.SELOverwrite
+		if len(node.SDRFile) > 0 {
+			newBMC.SetSDRRecords(loadSDRFile(node))
+		}
+		if len(node.FRUFile) > 0 {
+			if node.FRU != nil {
+				log.Fatalf("Config: FRU and FRUFile of BMC %s should not be both set.\n", node.BMCIP)
+			}
+			newBMC.SetFRUData(loadFRUFile(node))
+		} else {
+			newBMC.SetFRUData(loadFRU(node, newBMC.DefaultFRUInfo()))
+		}
+		if node.BMCUsers != nil {
+			newBMC.Users = make(map[string]bmc.BMCUser)
+		}
+		for _, user := range node.BMCUsers {
+			log.Printf("Config: Add BMC User %s to BMC %s\n", user.Username, node.BMCIP)
+			validateUser(user)
+			if ! newBMC.AddUser(user.Username, user.Password, loadUserPrivilege(user)) {
+				log.Fatalf("Config: BMC %s should not have more than %d users.\n", node.BMCIP, bmc.MAX_USER_ID - 1)
+			}
+		}
+		if node.NullUser != nil {
+			log.Printf("Config: Set null user of BMC %s\n", node.BMCIP)
+			newBMC.SetUser(loadNullUser(*node.NullUser))
+		}
+		newBMC.Save()
 	}
 
-	for _, user := range configuration.BMCUsers {
-		log.Printf("Config: Add BMC User %s\n", user.Username)
-		bmc.AddBMCUser(user.Username, user.Password)
+	if configuration.BMCUserFile != nil {
+		BMCUserFile = *configuration.BMCUserFile
+	}
+	loadSavedBMCUsers()
+
+	if configuration.SELFile != nil {
+		bmc.SELFile = *configuration.SELFile
+	}
+	bmc.LoadSEL()
+
+	if configuration.SavedFRUFile != nil {
+		bmc.SavedFRUFile = *configuration.SavedFRUFile
 	}
+	bmc.LoadFRU()
 
 	if configuration.WebAPIPort <= 1024 || configuration.WebAPIPort > 65535 {
 		log.Fatalln("Web API Port value should be larger than 1024 and less than 65536.")
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"github.com/rmxymh/infra-ecosphere/bmc"
+)
+
+// Users of a BMC which are changed by IPMI commands, e.g. Set User Password,
+// are saved into BMCUserFile, and they replace the users given in the config
+// file when the program starts again. Remove the BMC from BMCUserFile to go
+// back to the configured users. An empty BMCUserFile keeps the changes in
+// memory only.
+var BMCUserFile string = "infra-ecosphere-users.json"
+var bmcUserFileLock sync.Mutex
+
+type SavedBMCUsers struct {
+	BMCIP	string
+	Users	[]bmc.BMCUser
+}
+
+type SavedUserDatabase struct {
+	BMCs	[]SavedBMCUsers
+}
+
+func readSavedUserDatabase() (SavedUserDatabase, error) {
+	database := SavedUserDatabase{}
+
+	data, err := ioutil.ReadFile(BMCUserFile)
+	if err != nil {
+		return database, err
+	}
+	err = json.Unmarshal(data, &database)
+	return database, err
+}
+
+func loadSavedBMCUsers() {
+	if len(BMCUserFile) == 0 {
+		return
+	}
+
+	database, err := readSavedUserDatabase()
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Fatalf("Config: Failed to load BMC users from %s: %s\n", BMCUserFile, err.Error())
+	}
+
+	for _, saved := range database.BMCs {
+		obj, ok := bmc.GetBMC(net.ParseIP(saved.BMCIP))
+		if ! ok {
+			log.Printf("Config: BMC %s in %s is not found, ignore.\n", saved.BMCIP, BMCUserFile)
+			continue
+		}
+
+		obj.Users = make(map[string]bmc.BMCUser)
+		for _, user := range saved.Users {
+			obj.Users[user.Username] = user
+		}
+		obj.Save()
+		log.Printf("Config: Load %d saved users of BMC %s\n", len(saved.Users), saved.BMCIP)
+	}
+}
+
+// SaveBMCUsers saves the users of the BMC into BMCUserFile, and keeps the
+// users saved for the other BMCs.
+func SaveBMCUsers(obj bmc.BMC) error {
+	if len(BMCUserFile) == 0 {
+		return nil
+	}
+
+	bmcUserFileLock.Lock()
+	defer bmcUserFileLock.Unlock()
+
+	database, err := readSavedUserDatabase()
+	if err != nil && ! os.IsNotExist(err) {
+		log.Printf("Config: Failed to read %s: %s\n", BMCUserFile, err.Error())
+		return err
+	}
+
+	saved := SavedBMCUsers{
+		BMCIP: obj.Addr.String(),
+		Users: obj.GetUsers(),
+	}
+	found := false
+	for i := range database.BMCs {
+		if database.BMCs[i].BMCIP == saved.BMCIP {
+			database.BMCs[i] = saved
+			found = true
+		}
+	}
+	if ! found {
+		database.BMCs = append(database.BMCs, saved)
+	}
+
+	data, err := json.MarshalIndent(database, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	err = bmc.WriteFileAtomic(BMCUserFile, data, 0600)
+	if err != nil {
+		log.Printf("Config: Failed to save users of BMC %s: %s\n", saved.BMCIP, err.Error())
+	}
+	return err
+}
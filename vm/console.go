@@ -0,0 +1,159 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const (
+	SERIAL_MODE_NONE =	""
+	SERIAL_MODE_PIPE =	"pipe"
+	SERIAL_MODE_TCP =	"tcp"
+)
+
+const (
+	SERIAL_UART1_IO_BASE =	"0x3F8"
+	SERIAL_UART1_IRQ =	"4"
+)
+
+// SetSerialPort records how the UART1 of the VM is exposed on the host: a
+// host pipe (address is the socket path) or a TCP socket (address is the port).
+func (instance *Instance)SetSerialPort(mode string, address string) {
+	instance.SerialMode = mode
+	instance.SerialAddress = address
+	instances[instance.Name] = *instance
+
+	instance.SerialInitialize()
+}
+
+// SerialInitialize configures UART1 of the VM in server mode, so that the BMC
+// can attach to it as a client. VirtualBox refuses to modify running VMs.
+func (instance *Instance)SerialInitialize() {
+	if instance.FakeNode || instance.SerialMode == SERIAL_MODE_NONE {
+		return
+	}
+
+	uartMode := []string{}
+	switch instance.SerialMode {
+	case SERIAL_MODE_PIPE:
+		uartMode = []string{"server", instance.SerialAddress}
+	case SERIAL_MODE_TCP:
+		uartMode = []string{"tcpserver", instance.SerialAddress}
+	default:
+		log.Printf("    Instance: Serial mode %s of VM %s is not supported.\n", instance.SerialMode, instance.Name)
+		return
+	}
+
+	args := []string{"modifyvm", instance.Name, "--uart1", SERIAL_UART1_IO_BASE, SERIAL_UART1_IRQ, "--uartmode1"}
+	args = append(args, uartMode...)
+	output, err := exec.Command("VBoxManage", args...).CombinedOutput()
+	if err != nil {
+		log.Printf("    Instance: Failed to configure UART1 of VM %s: %s %s\n", instance.Name, err.Error(), string(output))
+	}
+}
+
+// OpenConsole attaches to the serial console of the VM. Fake nodes get a
+// synthetic console which prints boot messages.
+func (instance *Instance)OpenConsole() (io.ReadWriteCloser, error) {
+	if instance.FakeNode {
+		return newFakeConsole(instance.Name), nil
+	}
+
+	switch instance.SerialMode {
+	case SERIAL_MODE_PIPE:
+		return net.Dial("unix", instance.SerialAddress)
+	case SERIAL_MODE_TCP:
+		port, err := strconv.Atoi(instance.SerialAddress)
+		if err != nil {
+			return nil, err
+		}
+		return net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	}
+
+	return nil, errors.New("serial port of VM " + instance.Name + " is not configured")
+}
+
+var fakeBootMessages = []string {
+	"BIOS Version 1.0 - Fake Node",
+	"Memory Test: 4194304K OK",
+	"Booting from Hard Disk...",
+	"[    0.000000] Linux version 4.4.0-fake (infra-ecosphere)",
+	"[    0.000000] Command line: console=ttyS0,115200n8",
+	"[    1.024000] Freeing unused kernel memory",
+	"Starting system logger ... [ OK ]",
+	"Starting OpenSSH server ... [ OK ]",
+	"",
+}
+
+type fakeConsole struct {
+	name string
+	reader *io.PipeReader
+	writer *io.PipeWriter
+	line []byte
+}
+
+func newFakeConsole(name string) io.ReadWriteCloser {
+	reader, writer := io.Pipe()
+	console := &fakeConsole{
+		name: name,
+		reader: reader,
+		writer: writer,
+	}
+
+	go func() {
+		for _, message := range fakeBootMessages {
+			if _, err := console.writer.Write([]byte(message + "\r\n")); err != nil {
+				return
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+		console.writer.Write([]byte(console.prompt()))
+	}()
+
+	return console
+}
+
+func (console *fakeConsole)prompt() string {
+	if len(console.name) == 0 {
+		return "localhost login: "
+	}
+	return console.name + " login: "
+}
+
+func (console *fakeConsole)Read(p []byte) (int, error) {
+	return console.reader.Read(p)
+}
+
+// Write echoes input like a terminal, and rejects every login.
+func (console *fakeConsole)Write(p []byte) (int, error) {
+	for _, c := range p {
+		switch c {
+		case '\r', '\n':
+			output := "\r\n"
+			if len(console.line) > 0 {
+				output += "\r\nLogin incorrect\r\n"
+			}
+			console.line = console.line[:0]
+			if _, err := console.writer.Write([]byte(output + console.prompt())); err != nil {
+				return 0, err
+			}
+		default:
+			console.line = append(console.line, c)
+			if _, err := console.writer.Write([]byte{c}); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func (console *fakeConsole)Close() error {
+	console.writer.Close()
+	return console.reader.Close()
+}
@@ -15,6 +15,8 @@ const (
 type Instance struct {
 	Name string
 	FakeNode		bool
+	SerialMode		string
+	SerialAddress		string
 
 	defaultBootOrder	[]string
 	nextBootOrder		[]string
@@ -25,7 +25,7 @@ type WebRespBMCList struct {
 
 func GetAllBMCs(writer http.ResponseWriter, request *http.Request) {
 	RespBMCs := make([]WebRespBMC, 0)
-	for _, b := range bmc.BMCs {
+	for _, b := range bmc.GetBMCs() {
 		status := "OFF"
 		if b.IsPowerOn() {
 			status = "ON"
@@ -40,6 +40,30 @@ var routes = []Route {
 		"/api/BMCs/{bmcip}/bootdev",
 		SetBootDevice,
 	},
+	Route {
+		"GetSessions",
+		"GET",
+		"/api/BMCs/{bmcip}/sessions",
+		GetSessions,
+	},
+	Route {
+		"CloseSession",
+		"DELETE",
+		"/api/BMCs/{bmcip}/sessions/{id}",
+		CloseSession,
+	},
+	Route {
+		"GetSensors",
+		"GET",
+		"/api/BMCs/{bmcip}/sensors",
+		GetSensors,
+	},
+	Route {
+		"SetSensor",
+		"PUT",
+		"/api/BMCs/{bmcip}/sensors/{sensor}",
+		SetSensor,
+	},
 }
 
 
@@ -0,0 +1,176 @@
+package web
+
+import (
+	"net/http"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/rmxymh/infra-ecosphere/bmc"
+)
+
+type WebRespSensor struct {
+	Number		string
+	Name		string
+	Threshold	bool
+	Reading		float64			// in Unit, threshold sensors only
+	RawReading	uint8
+	Unit		string
+	States		uint16			// discrete sensors only
+	Thresholds	map[string]float64	// readable thresholds in Unit
+	Status		string			// ok / nc / cr / nr like ipmitool
+}
+
+type WebRespSensorList struct {
+	IP		string
+	Sensors		[]WebRespSensor
+	Status		string
+}
+
+type WebReqSensor struct {
+	Value		*float64
+	Duration	float64			// in seconds, 0 sets the value at once
+}
+
+type WebRespSetSensor struct {
+	IP		string
+	Sensor		string
+	Value		float64
+	Duration	float64
+	Status		string
+}
+
+var sensorThresholdNames = [bmc.THRESHOLD_COUNT]string{"LNC", "LC", "LNR", "UNC", "UC", "UNR"}
+
+var sensorUnitNames = map[uint8]string {
+	0:				"unspecified",
+	bmc.SENSOR_UNIT_DEGREES_C:	"degrees C",
+	2:				"degrees F",
+	3:				"degrees K",
+	bmc.SENSOR_UNIT_VOLTS:		"Volts",
+	5:				"Amps",
+	6:				"Watts",
+	bmc.SENSOR_UNIT_RPM:		"RPM",
+}
+
+func newWebRespSensor(sensor bmc.Sensor) WebRespSensor {
+	resp := WebRespSensor{}
+	resp.Number = fmt.Sprintf("0x%02x", sensor.Number)
+	resp.Name = sensor.Name
+	resp.Threshold = sensor.IsThreshold()
+	resp.Unit = sensorUnitNames[sensor.BaseUnit]
+	if len(resp.Unit) == 0 {
+		resp.Unit = fmt.Sprintf("unit %d", sensor.BaseUnit)
+	}
+	resp.Thresholds = make(map[string]float64)
+	resp.Status = "ok"
+
+	if ! sensor.IsThreshold() {
+		resp.States = sensor.States
+		return resp
+	}
+
+	resp.Reading = sensor.Convert(sensor.Reading)
+	resp.RawReading = sensor.Reading
+	for i, name := range sensorThresholdNames {
+		if sensor.ReadableThresholds & (1 << uint(i)) != 0 {
+			resp.Thresholds[name] = sensor.Convert(sensor.Thresholds[i])
+		}
+	}
+
+	status := sensor.ThresholdStatus()
+	switch {
+	case status & (1 << bmc.THRESHOLD_LOWER_NON_RECOVERABLE | 1 << bmc.THRESHOLD_UPPER_NON_RECOVERABLE) != 0:
+		resp.Status = "nr"
+	case status & (1 << bmc.THRESHOLD_LOWER_CRITICAL | 1 << bmc.THRESHOLD_UPPER_CRITICAL) != 0:
+		resp.Status = "cr"
+	case status & (1 << bmc.THRESHOLD_LOWER_NON_CRITICAL | 1 << bmc.THRESHOLD_UPPER_NON_CRITICAL) != 0:
+		resp.Status = "nc"
+	}
+	return resp
+}
+
+// findSensor looks up a sensor by its number, e.g. 0x21 or 33, or by its name,
+// e.g. "Fan2", ignoring the case.
+func findSensor(bmcobj bmc.BMC, id string) (bmc.Sensor, bool) {
+	if number, err := strconv.ParseUint(id, 0, 8); err == nil {
+		return bmcobj.GetSensor(uint8(number))
+	}
+	for _, sensor := range bmcobj.GetSensors() {
+		if strings.EqualFold(sensor.Name, id) {
+			return sensor, true
+		}
+	}
+	return bmc.Sensor{}, false
+}
+
+func GetSensors(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	resp := WebRespSensorList{}
+	resp.IP = vars["bmcip"]
+	resp.Sensors = make([]WebRespSensor, 0)
+
+	bmcobj, ok := bmc.GetBMC(net.ParseIP(resp.IP))
+	if ! ok {
+		resp.Status = fmt.Sprintf("BMC %s does not exist.", resp.IP)
+	} else {
+		for _, sensor := range bmcobj.GetSensors() {
+			resp.Sensors = append(resp.Sensors, newWebRespSensor(sensor))
+		}
+		resp.Status = "OK"
+	}
+
+	json.NewEncoder(writer).Encode(resp)
+}
+
+// SetSensor sets the reading of a threshold sensor, or ramps it linearly to
+// the value over Duration seconds. Crossing a threshold logs a SEL event.
+func SetSensor(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	resp := WebRespSetSensor{}
+	resp.IP = vars["bmcip"]
+	resp.Sensor = vars["sensor"]
+
+	bmcobj, ok := bmc.GetBMC(net.ParseIP(resp.IP))
+	if ! ok {
+		resp.Status = fmt.Sprintf("BMC %s does not exist.", resp.IP)
+		json.NewEncoder(writer).Encode(resp)
+		return
+	}
+
+	sensor, ok := findSensor(bmcobj, resp.Sensor)
+	sensorReq := WebReqSensor{}
+	err := json.NewDecoder(request.Body).Decode(&sensorReq)
+	switch {
+	case ! ok:
+		resp.Status = fmt.Sprintf("Sensor %s of BMC %s does not exist.", resp.Sensor, resp.IP)
+	case err != nil:
+		resp.Status = err.Error()
+	case sensorReq.Value == nil:
+		resp.Status = "Value is missing."
+	case sensorReq.Duration < 0:
+		resp.Status = "Duration should not be negative."
+	case ! sensor.IsThreshold():
+		resp.Status = fmt.Sprintf("Sensor %s is not a threshold sensor.", resp.Sensor)
+	case sensor.Linearization & 0x7F != 0:
+		resp.Status = fmt.Sprintf("Sensor %s is not linear.", resp.Sensor)
+	default:
+		resp.Duration = sensorReq.Duration
+		raw := sensor.RawValue(*sensorReq.Value)
+		resp.Value = sensor.Convert(raw)
+		duration := time.Duration(sensorReq.Duration * float64(time.Second))
+		if bmcobj.RampSensorReading(sensor.Number, raw, duration) {
+			resp.Status = "OK"
+		} else {
+			resp.Status = fmt.Sprintf("Sensor %s of BMC %s does not exist.", resp.Sensor, resp.IP)
+		}
+	}
+
+	json.NewEncoder(writer).Encode(resp)
+}
\ No newline at end of file
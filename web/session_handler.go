@@ -0,0 +1,83 @@
+package web
+
+import (
+	"net/http"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+import (
+	"github.com/gorilla/mux"
+)
+
+type WebRespSession struct {
+	SessionID		string
+	Handle			uint8
+	Username		string
+	Privilege		string
+	RemoteAddress		string
+	AuthenticationType	string
+	Activated		bool
+	IdleSeconds		int
+}
+
+type WebRespSessionList struct {
+	IP		string
+	Sessions	[]WebRespSession
+	Status		string
+}
+
+type WebRespCloseSession struct {
+	IP		string
+	SessionID	string
+	Status		string
+}
+
+// Sessions are kept by the IPMI server, which registers these functions
+// because this package cannot import it.
+type SessionManagerSet struct {
+	GetSessions	func(bmcIP string) ([]WebRespSession, bool)
+	CloseSession	func(bmcIP string, id uint32) bool
+}
+
+var SessionManager SessionManagerSet = SessionManagerSet{}
+
+func GetSessions(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	resp := WebRespSessionList{}
+	resp.IP = vars["bmcip"]
+	resp.Sessions = make([]WebRespSession, 0)
+
+	if SessionManager.GetSessions == nil {
+		resp.Status = "IPMI server is not running."
+	} else if sessions, ok := SessionManager.GetSessions(resp.IP); ! ok {
+		resp.Status = fmt.Sprintf("BMC %s does not exist.", resp.IP)
+	} else {
+		resp.Sessions = sessions
+		resp.Status = "OK"
+	}
+
+	json.NewEncoder(writer).Encode(resp)
+}
+
+func CloseSession(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	resp := WebRespCloseSession{}
+	resp.IP = vars["bmcip"]
+	resp.SessionID = vars["id"]
+
+	// Session IDs are shown in hexadecimal, e.g. 0x1a2b3c4d, and decimal works too.
+	id, err := strconv.ParseUint(resp.SessionID, 0, 32)
+	if err != nil {
+		resp.Status = fmt.Sprintf("Session ID %s is invalid.", resp.SessionID)
+	} else if SessionManager.CloseSession == nil {
+		resp.Status = "IPMI server is not running."
+	} else if ! SessionManager.CloseSession(resp.IP, uint32(id)) {
+		resp.Status = fmt.Sprintf("Session %s of BMC %s does not exist.", resp.SessionID, resp.IP)
+	} else {
+		resp.Status = "OK"
+	}
+
+	json.NewEncoder(writer).Encode(resp)
+}